@@ -24,7 +24,10 @@ func main () {
 	}
 
 	um := app.NewUserManager(db)
-	um.CreateUser(user)
+	err = um.CreateUser(user, nil)
+	if err != nil {
+		panic(err)
+	}
 	
 	someUser, exists := um.ReadUserByName(os.Args[1])
 	if !exists {
@@ -0,0 +1,410 @@
+package app
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// abcClass is the class of a stock item in the ABC classification by consumption value
+type abcClass string
+
+// Class A items make up the first 80% of the consumption value, class B items the next 15%
+// and class C items the last 5%, including the items that were not consumed at all.
+const (
+	CLASS_A abcClass = "A"
+	CLASS_B abcClass = "B"
+	CLASS_C abcClass = "C"
+)
+
+var (
+	classALimit = decimal.New(80, -2)
+	classBLimit = decimal.New(95, -2)
+)
+
+// The analytics queries value stock at the unit costs of the lots. Movements of stock
+// without lots, or of lots without a unit cost, are valued at the item's average receipt cost.
+const (
+	// unitCostsCTE is the average unit cost of the received lots of every stock item
+	unitCostsCTE = `
+	unit_costs AS (
+		SELECT
+			r.stock_id,
+			SUM(r.quantity * l.unit_cost) / SUM(r.quantity) AS unit_cost
+		FROM
+			stock_movements r
+		JOIN
+			stock_lots l ON l.id = r.lot_id
+		WHERE
+			r.kind = '` + string(RECEIPT) + `' AND l.unit_cost > 0
+		GROUP BY
+			r.stock_id
+	)`
+
+	// isConsumption is true for the movements that use up stock: dispenses and components taken for kits
+	isConsumption = `(m.kind = '` + string(DISPENSE) + `' OR (m.kind = '` + string(ASSEMBLY) + `' AND m.quantity < 0))`
+
+	// movementDelta is the signed change of the item's quantity caused by the movement
+	movementDelta = `CASE WHEN m.kind IN ('` + string(DISPENSE) + `', '` + string(WRITE_OFF) + `') THEN -m.quantity ELSE m.quantity END`
+)
+
+// ABCItem is a stock item with its consumption in the period of the ABC classification
+type ABCItem struct {
+	StockID string
+	Name    string
+	Type    stockType
+
+	Quantity decimal.Decimal
+	Value    decimal.Decimal
+	// Share is the item's part of the total consumption value and
+	// CumulativeShare is the part of the items up to and including this one
+	Share           decimal.Decimal
+	CumulativeShare decimal.Decimal
+
+	Class abcClass
+}
+
+// ABCClassification classifies the stock items by their consumption value in the period [from, to).
+// The items with the highest value are first.
+func (wh *dafaultWarehouse) ABCClassification(from, to time.Time) []*ABCItem {
+	rows, err := wh.database.Query(`
+		WITH `+unitCostsCTE+`,
+		consumption AS (
+			SELECT
+				m.stock_id,
+				SUM(ABS(m.quantity)) AS quantity,
+				SUM(ABS(m.quantity) * COALESCE(NULLIF(l.unit_cost, 0), c.unit_cost, 0)) AS value
+			FROM
+				stock_movements m
+			LEFT JOIN
+				stock_lots l ON l.id = m.lot_id
+			LEFT JOIN
+				unit_costs c ON c.stock_id = m.stock_id
+			WHERE
+				`+isConsumption+` AND m.time >= ? AND m.time < ?
+			GROUP BY
+				m.stock_id
+		)
+		SELECT
+			w.id,
+			COALESCE(w.name, ''),
+			w.type,
+			COALESCE(s.quantity, 0),
+			COALESCE(s.value, 0)
+		FROM
+			warehouse w
+		LEFT JOIN
+			consumption s ON s.stock_id = w.id
+		ORDER BY
+			COALESCE(s.value, 0) DESC, w.name, w.id
+	`, from.UTC(), to.UTC())
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var (
+		items = make([]*ABCItem, 0)
+		total = decimal.Zero
+	)
+	for rows.Next() {
+		item := &ABCItem{}
+		if err = rows.Scan(&item.StockID, &item.Name, &item.Type, &item.Quantity, &item.Value); err != nil {
+			panic(err)
+		}
+		item.Value = item.Value.Round(2)
+		total = total.Add(item.Value)
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	cumulative := decimal.Zero
+	for _, item := range items {
+		item.Class = CLASS_C
+		if item.Value.Sign() == 0 {
+			// the items that were not consumed come last
+			if total.Sign() > 0 {
+				item.CumulativeShare = decimal.New(1, 0)
+			}
+			continue
+		}
+
+		// an item is in the class where its consumption value starts
+		switch start := cumulative.Div(total); {
+		case start.LessThan(classALimit):
+			item.Class = CLASS_A
+		case start.LessThan(classBLimit):
+			item.Class = CLASS_B
+		}
+
+		cumulative = cumulative.Add(item.Value)
+		item.Share = item.Value.Div(total).Round(4)
+		item.CumulativeShare = cumulative.Div(total).Round(4)
+	}
+
+	return items
+}
+
+// Turnover is the inventory turnover of a stock item or of all items of a stock type in a period.
+// The average inventory is the mean of the opening and the closing inventory.
+type Turnover struct {
+	// StockID and Name are empty for the turnover of a stock type
+	StockID string
+	Name    string
+	Type    stockType
+	// TypeName is only set for the turnover of a stock type
+	TypeName string
+
+	// ConsumedValue and AverageValue are valued at the average unit costs of the items
+	Consumed      decimal.Decimal
+	ConsumedValue decimal.Decimal
+	AverageValue  decimal.Decimal
+	AverageOnHand decimal.Decimal
+	ClosingOnHand decimal.Decimal
+	ClosingValue  decimal.Decimal
+	Turnover      decimal.Decimal
+	// DaysOnHand is the number of days the average inventory lasts, or nil if nothing was consumed
+	DaysOnHand *decimal.Decimal
+}
+
+// turnoverCTE has a row for every stock item with its consumption in the period [from, to),
+// its opening and closing quantities and its average unit cost. Its parameters are from, to, from, to.
+const turnoverCTE = `
+	item_turnover AS (
+		SELECT
+			w.id,
+			COALESCE(w.name, '') AS name,
+			w.type,
+			COALESCE(d.consumed, 0) AS consumed,
+			w.quantity - COALESCE(d.since_from, 0) AS opening,
+			w.quantity - COALESCE(d.since_to, 0) AS closing,
+			COALESCE(c.unit_cost, 0) AS unit_cost
+		FROM
+			warehouse w
+		LEFT JOIN (
+			SELECT
+				m.stock_id,
+				SUM(CASE WHEN ` + isConsumption + ` AND m.time >= ? AND m.time < ? THEN ABS(m.quantity) ELSE 0 END) AS consumed,
+				SUM(CASE WHEN m.time >= ? THEN ` + movementDelta + ` ELSE 0 END) AS since_from,
+				SUM(CASE WHEN m.time >= ? THEN ` + movementDelta + ` ELSE 0 END) AS since_to
+			FROM
+				stock_movements m
+			GROUP BY
+				m.stock_id
+		) d ON d.stock_id = w.id
+		LEFT JOIN
+			unit_costs c ON c.stock_id = w.id
+	)`
+
+// ItemTurnover returns the inventory turnover of every stock item in the period [from, to), ordered by name
+func (wh *dafaultWarehouse) ItemTurnover(from, to time.Time) []*Turnover {
+	return wh.queryTurnover(from, to, `
+		SELECT
+			id,
+			name,
+			type,
+			'',
+			consumed,
+			consumed * unit_cost,
+			(opening + closing) / 2.0 * unit_cost,
+			(opening + closing) / 2.0,
+			closing,
+			closing * unit_cost
+		FROM
+			item_turnover
+		ORDER BY
+			name, id
+	`)
+}
+
+// StockTypeTurnover returns the inventory turnover of the stock types in the period [from, to).
+// The quantities of the items of a stock type are only added up by value.
+func (wh *dafaultWarehouse) StockTypeTurnover(from, to time.Time) []*Turnover {
+	return wh.queryTurnover(from, to, `
+		SELECT
+			'',
+			'',
+			i.type,
+			COALESCE(t.name, ''),
+			SUM(i.consumed),
+			SUM(i.consumed * i.unit_cost),
+			SUM((i.opening + i.closing) / 2.0 * i.unit_cost),
+			SUM((i.opening + i.closing) / 2.0),
+			SUM(i.closing),
+			SUM(i.closing * i.unit_cost)
+		FROM
+			item_turnover i
+		LEFT JOIN
+			stock_types t ON t.id = CAST(i.type AS INTEGER)
+		GROUP BY
+			i.type
+		ORDER BY
+			t.name, i.type
+	`)
+}
+
+func (wh *dafaultWarehouse) queryTurnover(from, to time.Time, query string) []*Turnover {
+	rows, err := wh.database.Query(`WITH `+unitCostsCTE+`, `+turnoverCTE+query,
+		from.UTC(), to.UTC(), from.UTC(), to.UTC())
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	days := decimal.NewFromFloat(to.Sub(from).Hours() / 24)
+
+	turnovers := make([]*Turnover, 0)
+	for rows.Next() {
+		t := &Turnover{}
+		err = rows.Scan(
+			&t.StockID,
+			&t.Name,
+			&t.Type,
+			&t.TypeName,
+			&t.Consumed,
+			&t.ConsumedValue,
+			&t.AverageValue,
+			&t.AverageOnHand,
+			&t.ClosingOnHand,
+			&t.ClosingValue)
+		if err != nil {
+			panic(err)
+		}
+
+		// items without a unit cost turn over by quantity
+		consumed, average := t.ConsumedValue, t.AverageValue
+		if t.StockID != "" && average.Sign() == 0 {
+			consumed, average = t.Consumed, t.AverageOnHand
+		}
+		if consumed.Sign() > 0 && average.Sign() > 0 {
+			t.Turnover = consumed.Div(average).Round(2)
+			daysOnHand := average.Mul(days).Div(consumed).Round(1)
+			t.DaysOnHand = &daysOnHand
+		}
+
+		t.ConsumedValue = t.ConsumedValue.Round(2)
+		t.AverageValue = t.AverageValue.Round(2)
+		t.AverageOnHand = t.AverageOnHand.Round(3)
+		t.ClosingValue = t.ClosingValue.Round(2)
+		turnovers = append(turnovers, t)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return turnovers
+}
+
+// DeadStockItem is a stock item in stock that has not moved since LastMovement
+type DeadStockItem struct {
+	StockID  string
+	Name     string
+	Quantity decimal.Decimal
+	Value    decimal.Decimal
+	// LastMovement is nil for items without any movements
+	LastMovement *time.Time
+}
+
+// DeadStock returns the stock items in stock without any movement since the given time.
+// The opening balances of the items are not movements of their stock.
+// The items with the highest value are first.
+func (wh *dafaultWarehouse) DeadStock(since time.Time) []*DeadStockItem {
+	rows, err := wh.database.Query(`
+		WITH `+unitCostsCTE+`
+		SELECT
+			w.id,
+			COALESCE(w.name, ''),
+			w.quantity,
+			w.quantity * COALESCE(c.unit_cost, 0),
+			m.time
+		FROM
+			warehouse w
+		LEFT JOIN
+			stock_movements m ON m.rowid = (
+				SELECT rowid FROM stock_movements WHERE stock_id = w.id AND reference != ? ORDER BY time DESC, rowid DESC LIMIT 1
+			)
+		LEFT JOIN
+			unit_costs c ON c.stock_id = w.id
+		WHERE
+			w.quantity > 0 AND (m.time IS NULL OR m.time < ?)
+		ORDER BY
+			w.quantity * COALESCE(c.unit_cost, 0) DESC, w.name, w.id
+	`, openingBalanceReference, since.UTC())
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	items := make([]*DeadStockItem, 0)
+	for rows.Next() {
+		item := &DeadStockItem{}
+		if err = rows.Scan(&item.StockID, &item.Name, &item.Quantity, &item.Value, &item.LastMovement); err != nil {
+			panic(err)
+		}
+		item.Value = item.Value.Round(2)
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return items
+}
+
+// ExpiryLoss is the value of the expired stock written off in a month
+type ExpiryLoss struct {
+	// Month is formatted as YYYY-MM
+	Month     string
+	WriteOffs int
+	Value     decimal.Decimal
+}
+
+// ExpiryLosses returns the monthly value of the write-offs of expired lots in the period [from, to)
+func (wh *dafaultWarehouse) ExpiryLosses(from, to time.Time) []*ExpiryLoss {
+	// the movement times are kept in UTC and start with the date
+	rows, err := wh.database.Query(`
+		SELECT
+			substr(m.time, 1, 7) AS month,
+			COUNT(*),
+			SUM(m.quantity * l.unit_cost)
+		FROM
+			stock_movements m
+		JOIN
+			stock_lots l ON l.id = m.lot_id
+		WHERE
+			m.kind = ? AND l.expiration_date IS NOT NULL AND l.expiration_date <= m.time AND m.time >= ? AND m.time < ?
+		GROUP BY
+			month
+		ORDER BY
+			month
+	`, WRITE_OFF, from.UTC(), to.UTC())
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	losses := make([]*ExpiryLoss, 0)
+	for rows.Next() {
+		var (
+			loss  = &ExpiryLoss{}
+			value sql.NullFloat64
+		)
+		if err = rows.Scan(&loss.Month, &loss.WriteOffs, &value); err != nil {
+			panic(err)
+		}
+		loss.Value = decimal.NewFromFloat(value.Float64).Round(2)
+		losses = append(losses, loss)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return losses
+}
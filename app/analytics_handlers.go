@@ -0,0 +1,119 @@
+package app
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// csvReport is a report that can also be exported as a CSV document
+type csvReport interface {
+	csvHeader() []string
+	csvRows() [][]string
+}
+
+func writeReportCSV(w io.Writer, report csvReport) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(report.csvHeader()); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(report.csvRows()); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// respondReport sends the report as JSON or, with ?format=csv, as a CSV document named after the report
+func respondReport(w http.ResponseWriter, r *http.Request, name string, report csvReport) {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		respondJSON(w, http.StatusOK, report)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.csv\"", name))
+		if err := writeReportCSV(w, report); err != nil {
+			log.Printf("Error while writing response: %s", err)
+		}
+	default:
+		respondBadRequest(w, ValidationErrors{{"format", "supported formats are json and csv"}})
+	}
+}
+
+// Handler for GET /reports/abc?from=<date>&to=<date>&format=json|csv
+//
+// Classifies the stock items by their consumption value in the period:
+// class A makes up 80% of the value, class B the next 15% and class C the rest.
+func (m *madminHandler) abcReportHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondReport(w, r, "abc", newABCReportDTO(from, to, m.warehouse.ABCClassification(from, to)))
+}
+
+// Handler for GET /reports/turnover?from=<date>&to=<date>&by=item|stockType&format=json|csv
+//
+// Returns the inventory turnover and days on hand of every stock item or,
+// with by=stockType, of every stock type in the period.
+func (m *madminHandler) turnoverReportHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	var (
+		by        = r.URL.Query().Get("by")
+		turnovers []*Turnover
+	)
+	switch by {
+	case "", "item":
+		by, turnovers = "item", m.warehouse.ItemTurnover(from, to)
+	case "stockType":
+		turnovers = m.warehouse.StockTypeTurnover(from, to)
+	default:
+		respondBadRequest(w, ValidationErrors{{"by", "turnover is reported by item or stockType"}})
+		return
+	}
+
+	respondReport(w, r, "turnover", newTurnoverReportDTO(from, to, by, turnovers))
+}
+
+// Handler for GET /reports/dead-stock?days=<n>&format=json|csv
+//
+// Returns the stock items in stock that have not moved for <n> days, 90 by default.
+func (m *madminHandler) deadStockReportHandler(w http.ResponseWriter, r *http.Request) {
+	days := 90
+	if s := r.URL.Query().Get("days"); s != "" {
+		var err error
+		if days, err = strconv.Atoi(s); err != nil || days <= 0 {
+			respondBadRequest(w, ValidationErrors{{"days", "days must be a positive integer"}})
+			return
+		}
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -days)
+	respondReport(w, r, "dead-stock", newDeadStockReportDTO(days, since, m.warehouse.DeadStock(since)))
+}
+
+// Handler for GET /reports/expiry-losses?from=<date>&to=<date>&format=json|csv
+//
+// Returns the value of the expired lots written off in every month of the period.
+func (m *madminHandler) expiryLossReportHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondReport(w, r, "expiry-losses", newExpiryLossReportDTO(from, to, m.warehouse.ExpiryLosses(from, to)))
+}
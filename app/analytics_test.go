@@ -0,0 +1,134 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestAnalyticsReports(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+		wh            = madminHandler.warehouse
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	medicine, _ := defaultExpirableStockItem(MEDICINE)
+	medicine.SetName("Amoxicillin")
+	medicine.SetQuantity(decimal.Zero)
+	wh.CreateStock(medicine, nil)
+
+	collar, _ := defaultUnexpirableStockItem(ACCESSORY)
+	collar.SetName("Collar")
+	collar.SetQuantity(decimal.Zero)
+	wh.CreateStock(collar, nil)
+
+	idle, _ := defaultUnexpirableStockItem(ACCESSORY)
+	idle.SetName("Leash")
+	wh.CreateStock(idle, nil)
+
+	var (
+		expired  = time.Now().UTC().AddDate(0, 0, -1)
+		fresh    = time.Now().UTC().AddDate(1, 0, 0)
+		receipts = []*Movement{
+			{StockID: medicine.ID(), Kind: RECEIPT, Quantity: decimal.New(3, 0), Lot: &Lot{Number: "A", ExpirationDate: &expired, UnitCost: decimal.New(2, 0)}},
+			{StockID: medicine.ID(), Kind: RECEIPT, Quantity: decimal.New(10, 0), Lot: &Lot{Number: "B", ExpirationDate: &fresh, UnitCost: decimal.New(2, 0)}},
+			{StockID: collar.ID(), Kind: RECEIPT, Quantity: decimal.New(5, 0), Lot: &Lot{Number: "C", UnitCost: decimal.New(1, 0)}},
+		}
+	)
+	for _, mv := range receipts {
+		if err := wh.RecordMovement(mv); err != nil {
+			t.Fatalf(`RecordMovement returns an error for a receipt: %s`, err)
+		}
+	}
+	wh.RecordMovement(&Movement{StockID: medicine.ID(), Kind: DISPENSE, Quantity: decimal.New(4, 0), LotID: receipts[1].LotID})
+	wh.RecordMovement(&Movement{StockID: collar.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0)})
+	if err := wh.ChangeLotState(receipts[0].LotID, WRITTEN_OFF, "", "", "expired"); err != nil {
+		t.Fatalf(`ChangeLotState returns an error for an expired lot: %s`, err)
+	}
+
+	from, to := time.Now().UTC().Add(-time.Hour), time.Now().UTC().Add(time.Hour)
+
+	expected := map[string]abcClass{medicine.ID(): CLASS_A, collar.ID(): CLASS_B, idle.ID(): CLASS_C}
+	items := wh.ABCClassification(from, to)
+	if len(items) != 3 || items[0].StockID != medicine.ID() || !items[0].Value.Equal(decimal.New(8, 0)) {
+		t.Fatalf(`Unexpected ABC classification %+v`, items)
+	}
+	for _, item := range items {
+		if item.Class != expected[item.StockID] {
+			t.Errorf(`Expected class %s for %s, got %s`, expected[item.StockID], item.Name, item.Class)
+		}
+	}
+
+	for _, turnover := range wh.ItemTurnover(from, to) {
+		if turnover.StockID == medicine.ID() && (!turnover.AverageOnHand.Equal(decimal.New(3, 0)) || !turnover.Turnover.Equal(decimal.New(133, -2))) {
+			t.Fatalf(`Unexpected turnover %+v`, turnover)
+		}
+		if turnover.StockID == idle.ID() && turnover.DaysOnHand != nil {
+			t.Fatalf(`Expected no days on hand for an item without consumption, got %s`, turnover.DaysOnHand)
+		}
+	}
+	for _, turnover := range wh.StockTypeTurnover(from, to) {
+		if turnover.Type == ACCESSORY && (turnover.TypeName != "ACCESSORY" || !turnover.ConsumedValue.Equal(decimal.New(1, 0))) {
+			t.Fatalf(`Unexpected turnover of accessories %+v`, turnover)
+		}
+	}
+
+	if dead := wh.DeadStock(from); len(dead) != 1 || dead[0].StockID != idle.ID() || dead[0].LastMovement != nil {
+		t.Fatalf(`Expected only the leash as dead stock, got %+v`, dead)
+	}
+	if dead := wh.DeadStock(to); len(dead) != 3 {
+		t.Fatalf(`Expected all stock items in stock as dead stock, got %d`, len(dead))
+	}
+
+	losses := wh.ExpiryLosses(from, to)
+	if len(losses) != 1 || losses[0].WriteOffs != 1 || !losses[0].Value.Equal(decimal.New(6, 0)) || losses[0].Month != time.Now().UTC().Format("2006-01") {
+		t.Fatalf(`Unexpected expiry losses %+v`, losses)
+	}
+
+	query := url.Values{}
+	query.Set("from", from.Format(dateLayout))
+	query.Set("to", to.Format(dateLayout))
+	resp, err := http.Get(buildURL(s.URL, fmt.Sprintf("/data/reports/abc?%s", query.Encode())))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	report := &ABCReportDTO{}
+	json.NewDecoder(resp.Body).Decode(report)
+	resp.Body.Close()
+	if report.TotalValue != "9" || len(report.Items) != 3 || report.Items[1].Class != "B" {
+		t.Fatalf("Unexpected ABC report %+v", report)
+	}
+
+	query.Set("format", "csv")
+	query.Set("by", "stockType")
+	resp, err = http.Get(buildURL(s.URL, fmt.Sprintf("/data/reports/turnover?%s", query.Encode())))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	resp.Body.Close()
+	if err != nil || resp.Header.Get("Content-Type") != "text/csv" || len(records) != 1+len(wh.StockTypeTurnover(from, to)) {
+		t.Fatalf("Unexpected CSV turnover report %v (%v)", records, err)
+	}
+
+	resp, err = http.Get(buildURL(s.URL, "/data/reports/dead-stock?days=0"))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for a dead stock report of 0 days, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
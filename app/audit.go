@@ -0,0 +1,480 @@
+package app
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+type auditAction string
+
+// AUDIT_CREATE, AUDIT_UPDATE and AUDIT_DELETE are the changes recorded in the audit log
+const (
+	AUDIT_CREATE auditAction = "create"
+	AUDIT_UPDATE auditAction = "update"
+	AUDIT_DELETE auditAction = "delete"
+)
+
+// The types of the entities whose changes are recorded in the audit log.
+// Stock types, job schedules, notification preferences and webhooks are the settings of madmin.
+// The minimum quantities of stock items in storage locations are recorded as stock levels
+// with the id <location id>/<stock item id>.
+const (
+	AUDIT_STOCK                    = "stock"
+	AUDIT_STOCK_LEVEL              = "stock-level"
+	AUDIT_DISTRIBUTOR              = "distributor"
+	AUDIT_USER                     = "user"
+	AUDIT_STOCK_TYPE               = "stock-type"
+	AUDIT_JOB                      = "job"
+	AUDIT_NOTIFICATION_PREFERENCES = "notification-preferences"
+	AUDIT_WEBHOOK                  = "webhook"
+)
+
+// AuditEntry is a change of an entity in the audit log. Each entry holds the hash
+// of the entry before it, so changing or removing an entry breaks the chain of hashes.
+type AuditEntry struct {
+	// Sequence is the position of the entry in the audit log, starting from 1
+	Sequence int64
+	Time     time.Time
+
+	EntityType string
+	EntityID   string
+	Action     auditAction
+
+	// Before and After are JSON objects with the changed fields of the entity.
+	// Before is null for created entities and After is null for deleted ones.
+	Before json.RawMessage
+	After  json.RawMessage
+
+	// UserID, ClientIP and RequestID identify who made the change and with which request
+	UserID    string
+	ClientIP  string
+	RequestID string
+
+	PreviousHash string
+	Hash         string
+}
+
+// computeHash returns the hash of the entry's fields and the hash of the entry before it
+func (e *AuditEntry) computeHash() string {
+	// the fields are hashed in a fixed order, so the hash does not depend on the DB
+	fields, err := json.Marshal([]interface{}{
+		e.Sequence,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		e.EntityType,
+		e.EntityID,
+		e.Action,
+		string(e.Before),
+		string(e.After),
+		e.UserID,
+		e.ClientIP,
+		e.RequestID,
+		e.PreviousHash,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditFilter selects entries of the audit log. Empty fields match all entries.
+type AuditFilter struct {
+	EntityType string
+	EntityID   string
+	UserID     string
+	Action     auditAction
+
+	// From and To limit the entries to the period [From, To) if they are not zero
+	From time.Time
+	To   time.Time
+
+	// Limit is the maximum number of entries, all entries are returned if it is not positive
+	Limit int
+}
+
+// AuditLog is an append-only log of the changes made to the entities of madmin
+type AuditLog interface {
+	// Record() adds an entry to the log and sets its sequence, time and hashes
+	Record(*AuditEntry) error
+	// RecordTx() adds an entry to the log inside the transaction of the change it records,
+	// so the change and its entry are committed or rolled back together
+	RecordTx(*sql.Tx, *AuditEntry) error
+	// Entries() returns the entries that match the filter, the latest first
+	Entries(AuditFilter) []*AuditEntry
+	// Verify() checks the chain of hashes and returns the sequence
+	// of the first entry that was changed or removed, or 0 if the log is intact
+	Verify() int64
+}
+
+type defaultAuditLog struct {
+	database *sql.DB
+}
+
+// NewAuditLog creates an audit log that keeps its entries
+// in a sqlite3 table inside the db that is passed as an argument
+func NewAuditLog(db *sql.DB) AuditLog {
+	al := &defaultAuditLog{database: db}
+
+	al.initAuditTable()
+
+	return al
+}
+
+func (al *defaultAuditLog) initAuditTable() {
+	auditTable := `
+	CREATE TABLE IF NOT EXISTS
+		audit_log (
+			sequence INTEGER NOT NULL PRIMARY KEY,
+			time DATETIME NOT NULL,
+			entity_type TEXT NOT NULL,
+			entity_id TEXT NOT NULL,
+			action TEXT NOT NULL,
+			before TEXT NOT NULL,
+			after TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			client_ip TEXT NOT NULL,
+			request_id TEXT NOT NULL,
+			previous_hash TEXT NOT NULL,
+			hash TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS
+		audit_log_entity ON audit_log (entity_type, entity_id);
+	CREATE INDEX IF NOT EXISTS
+		audit_log_user_id ON audit_log (user_id);
+	CREATE TRIGGER IF NOT EXISTS
+		audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log entries cannot be changed');
+	END;
+	CREATE TRIGGER IF NOT EXISTS
+		audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log entries cannot be removed');
+	END;
+	`
+	_, err := al.database.Exec(auditTable)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// auditColumns are the columns of the audit_log table in the order scanAuditEntry expects them
+const auditColumns = `
+	sequence,
+	time,
+	entity_type,
+	entity_id,
+	action,
+	before,
+	after,
+	user_id,
+	client_ip,
+	request_id,
+	previous_hash,
+	hash`
+
+func scanAuditEntry(row rowScanner) (*AuditEntry, error) {
+	var (
+		e             = &AuditEntry{}
+		before, after string
+	)
+	err := row.Scan(
+		&e.Sequence,
+		&e.Time,
+		&e.EntityType,
+		&e.EntityID,
+		&e.Action,
+		&before,
+		&after,
+		&e.UserID,
+		&e.ClientIP,
+		&e.RequestID,
+		&e.PreviousHash,
+		&e.Hash)
+	e.Before, e.After = json.RawMessage(before), json.RawMessage(after)
+	return e, err
+}
+
+// validate checks the fields of a new entry and sets the missing states to null
+func (e *AuditEntry) validate() error {
+	errs := ValidationErrors{}
+	if e.EntityType == "" {
+		errs = append(errs, ValidationError{"entityType", "no entity type set"})
+	}
+	if e.EntityID == "" {
+		errs = append(errs, ValidationError{"entityID", "no entity id set"})
+	}
+	switch e.Action {
+	case AUDIT_CREATE, AUDIT_UPDATE, AUDIT_DELETE:
+	default:
+		errs = append(errs, ValidationError{"action", "invalid audit action"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if e.Before == nil {
+		e.Before = json.RawMessage("null")
+	}
+	if e.After == nil {
+		e.After = json.RawMessage("null")
+	}
+	return nil
+}
+
+func (al *defaultAuditLog) Record(e *AuditEntry) error {
+	tx, err := al.database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = al.RecordTx(tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The transactions take the write lock when they begin,
+// so two entries cannot be chained to the same previous entry.
+func (al *defaultAuditLog) RecordTx(tx *sql.Tx, e *AuditEntry) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	err := tx.QueryRow(`SELECT sequence, hash FROM audit_log ORDER BY sequence DESC LIMIT 1`).Scan(&e.Sequence, &e.PreviousHash)
+	switch {
+	case err == sql.ErrNoRows:
+		e.Sequence, e.PreviousHash = 0, ""
+	case err != nil:
+		return err
+	}
+
+	e.Sequence++
+	e.Time = time.Now().UTC()
+	e.Hash = e.computeHash()
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			audit_log (`+auditColumns+`)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		e.Sequence,
+		e.Time,
+		e.EntityType,
+		e.EntityID,
+		e.Action,
+		string(e.Before),
+		string(e.After),
+		e.UserID,
+		e.ClientIP,
+		e.RequestID,
+		e.PreviousHash,
+		e.Hash)
+	return err
+}
+
+func (al *defaultAuditLog) Entries(f AuditFilter) []*AuditEntry {
+	var (
+		query = `SELECT ` + auditColumns + ` FROM audit_log WHERE 1 = 1`
+		args  = make([]interface{}, 0)
+	)
+	conditions := []struct {
+		column string
+		value  string
+	}{
+		{"entity_type", f.EntityType},
+		{"entity_id", f.EntityID},
+		{"user_id", f.UserID},
+		{"action", string(f.Action)},
+	}
+	for _, c := range conditions {
+		if c.value != "" {
+			query += ` AND ` + c.column + ` = ?`
+			args = append(args, c.value)
+		}
+	}
+	if !f.From.IsZero() {
+		query += ` AND time >= ?`
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		query += ` AND time < ?`
+		args = append(args, f.To.UTC())
+	}
+	query += ` ORDER BY sequence DESC`
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+
+	rows, err := al.database.Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	entries := make([]*AuditEntry, 0)
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			panic(err)
+		}
+		entries = append(entries, e)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return entries
+}
+
+func (al *defaultAuditLog) Verify() int64 {
+	rows, err := al.database.Query(`SELECT ` + auditColumns + ` FROM audit_log ORDER BY sequence`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var (
+		sequence     int64
+		previousHash string
+	)
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			panic(err)
+		}
+
+		sequence++
+		if e.Sequence != sequence || e.PreviousHash != previousHash || e.Hash != e.computeHash() {
+			return sequence
+		}
+		previousHash = e.Hash
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return 0
+}
+
+// auditDiff returns the fields of the entity that differ before and after a change
+// as JSON objects and the action of the change. before is nil for created entities
+// and after is nil for deleted ones. Both are marshaled to JSON, so they should be DTOs.
+// ok is false if nothing changed.
+func auditDiff(before, after interface{}) (action auditAction, b, a json.RawMessage, ok bool) {
+	var fieldsBefore, fieldsAfter map[string]interface{}
+	if before != nil {
+		fieldsBefore = jsonFields(before)
+	}
+	if after != nil {
+		fieldsAfter = jsonFields(after)
+	}
+
+	switch {
+	case fieldsBefore == nil && fieldsAfter == nil:
+		return "", nil, nil, false
+	case fieldsBefore == nil:
+		return AUDIT_CREATE, nil, mustMarshal(fieldsAfter), true
+	case fieldsAfter == nil:
+		return AUDIT_DELETE, mustMarshal(fieldsBefore), nil, true
+	}
+
+	changedBefore, changedAfter := make(map[string]interface{}), make(map[string]interface{})
+	for k, v := range fieldsBefore {
+		if w, ok := fieldsAfter[k]; !ok || !reflect.DeepEqual(v, w) {
+			changedBefore[k] = v
+			changedAfter[k] = fieldsAfter[k]
+		}
+	}
+	for k, w := range fieldsAfter {
+		if _, ok := fieldsBefore[k]; !ok {
+			changedBefore[k] = nil
+			changedAfter[k] = w
+		}
+	}
+	if len(changedAfter) == 0 {
+		return "", nil, nil, false
+	}
+	return AUDIT_UPDATE, mustMarshal(changedBefore), mustMarshal(changedAfter), true
+}
+
+// jsonFields returns the fields of v as it is marshaled to a JSON object
+func jsonFields(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(mustMarshal(v), &fields); err != nil {
+		panic(err)
+	}
+	return fields
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// auditTrail records the changes made by a request in the audit log.
+// The changes made without a request have a nil trail and are not recorded.
+type auditTrail struct {
+	log AuditLog
+
+	// userID, clientIP and requestID identify who made the changes and with which request
+	userID    string
+	clientIP  string
+	requestID string
+}
+
+// recordTx records the change of an entity inside the transaction of the change.
+// before is nil for created entities and after is nil for deleted ones, otherwise
+// only the fields that differ are recorded. Unchanged entities are not recorded.
+// The error is an *auditError, the transaction should be rolled back with it.
+func (at *auditTrail) recordTx(tx *sql.Tx, entityType, entityID string, before, after interface{}) error {
+	if at == nil {
+		return nil
+	}
+	action, b, a, ok := auditDiff(before, after)
+	if !ok {
+		return nil
+	}
+
+	err := at.log.RecordTx(tx, &AuditEntry{
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Before:     b,
+		After:      a,
+		UserID:     at.userID,
+		ClientIP:   at.clientIP,
+		RequestID:  at.requestID,
+	})
+	if err != nil {
+		return &auditError{entityType: entityType, entityID: entityID, err: err}
+	}
+	return nil
+}
+
+// auditError is returned by the changes that could not be recorded in the audit log
+type auditError struct {
+	entityType string
+	entityID   string
+	err        error
+}
+
+func (e *auditError) Error() string {
+	return fmt.Sprintf("cannot record the change of %s %s in the audit log: %s", e.entityType, e.entityID, e.err)
+}
+
+func (e *auditError) Unwrap() error {
+	return e.err
+}
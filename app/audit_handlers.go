@@ -0,0 +1,126 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxAuditEntries is the maximum number of audit log entries returned at once
+const maxAuditEntries = 1000
+
+// requestID returns the id of the request set by madminHandler.ServeHTTP
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// clientIP returns the IP address of the client that sent the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditTrail returns the trail that records the changes made by the request in the audit log
+func (m *madminHandler) auditTrail(r *http.Request) *auditTrail {
+	return &auditTrail{
+		log:       m.audit,
+		userID:    requestUserID(r),
+		clientIP:  clientIP(r),
+		requestID: requestID(r),
+	}
+}
+
+// respondChangeError responds to a request whose change failed. The change is rolled back
+// with its audit entry, so a change that cannot be recorded fails with an internal server error.
+// Other errors are reported as bad requests.
+func respondChangeError(w http.ResponseWriter, err error) {
+	var auditErr *auditError
+	if errors.As(err, &auditErr) {
+		log.Printf("Error in changing %s %s: %s", auditErr.entityType, auditErr.entityID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Error in recording the change in the audit log: %s", auditErr.err)
+		return
+	}
+	respondBadRequest(w, err)
+}
+
+// auditFilter reads the filter of the audit log from the query parameters.
+// At most 100 entries are returned by default.
+func auditFilter(r *http.Request) (AuditFilter, error) {
+	var (
+		query = r.URL.Query()
+		errs  = ValidationErrors{}
+		f     = AuditFilter{
+			EntityType: query.Get("entityType"),
+			EntityID:   query.Get("entityID"),
+			UserID:     query.Get("userID"),
+			Action:     auditAction(query.Get("action")),
+			Limit:      100,
+		}
+		err error
+	)
+
+	switch f.Action {
+	case "", AUDIT_CREATE, AUDIT_UPDATE, AUDIT_DELETE:
+	default:
+		errs = append(errs, ValidationError{"action", "action must be create, update or delete"})
+	}
+	for _, p := range []struct {
+		name  string
+		value *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if s := query.Get(p.name); s != "" {
+			if *p.value, err = time.Parse(dateLayout, s); err != nil {
+				errs = append(errs, ValidationError{p.name, err.Error()})
+			}
+		}
+	}
+	if s := query.Get("limit"); s != "" {
+		if f.Limit, err = strconv.Atoi(s); err != nil || f.Limit <= 0 || f.Limit > maxAuditEntries {
+			errs = append(errs, ValidationError{"limit", "limit must be between 1 and " + strconv.Itoa(maxAuditEntries)})
+		}
+	}
+
+	if len(errs) > 0 {
+		return f, errs
+	}
+	return f, nil
+}
+
+// Handler for GET /audit?entityType=<type>&entityID=<id>&userID=<id>&action=<action>&from=<date>&to=<date>&limit=<n>
+//
+// Lists the entries of the audit log that match the filters, the latest first.
+func (m *madminHandler) auditHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilter(r)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	entries := m.audit.Entries(f)
+
+	resp := make([]*AuditEntryDTO, 0, len(entries))
+	for _, e := range entries {
+		resp = append(resp, newAuditEntryDTO(e))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for GET /audit/verify
+//
+// Checks the chain of hashes of the audit log and returns the sequence
+// of the first entry that was changed or removed if the log is not intact.
+func (m *madminHandler) verifyAuditHandler(w http.ResponseWriter, r *http.Request) {
+	brokenAt := m.audit.Verify()
+
+	respondJSON(w, http.StatusOK, &AuditVerificationDTO{Intact: brokenAt == 0, BrokenAt: brokenAt})
+}
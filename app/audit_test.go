@@ -0,0 +1,161 @@
+package app
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestAuditLog(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(authMiddleware(madminHandler, madminHandler.userManager))
+		wh            = madminHandler.warehouse
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	admin, _ := NewUser("admin", "secret")
+	madminHandler.userManager.CreateUser(admin, nil)
+
+	send := func(method, path, requestID string, body interface{}) (*http.Response, string) {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, buildURL(s.URL, path), bytes.NewReader(data))
+		req.SetBasicAuth("admin", "secret")
+		req.Header.Set("X-Request-ID", requestID)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending %s request: %s", method, err)
+		}
+		defer resp.Body.Close()
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(respBody)
+	}
+
+	resp, distributorID := send("POST", "/data/distributors/", "req-1", &NewDistributorDTO{Name: "Vetpharm"})
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("X-Request-ID") != "req-1" {
+		t.Fatalf("Unexpected response to a new distributor: %d %s", resp.StatusCode, distributorID)
+	}
+	send("PUT", fmt.Sprintf("/data/distributors/%s", distributorID), "req-2", &NewDistributorDTO{Name: "VetPharm Ltd"})
+	if d, _ := wh.ReadDistributor(distributorID); d.Name() != "VetPharm Ltd" {
+		t.Fatalf("Expected the distributor to be renamed, got %s", d.Name())
+	}
+
+	_, userID := send("POST", "/data/users/", "req-3", &NewUserDTO{Name: "bob", Password: "pw"})
+	if resp, _ := send("DELETE", fmt.Sprintf("/data/users/%s", admin.ID()), "req-4", nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected status %d when users remove themselves, got %d", http.StatusConflict, resp.StatusCode)
+	}
+	send("DELETE", fmt.Sprintf("/data/users/%s", userID), "req-5", nil)
+
+	item, _ := defaultUnexpirableStockItem(ACCESSORY)
+	wh.CreateStock(item, nil)
+	update := newStockDTO(item)
+	update.MinQuantity = "5"
+	send("PUT", fmt.Sprintf("/data/stock/%s", item.ID()), "req-6", update)
+	// saving an unchanged item is not a change
+	send("PUT", fmt.Sprintf("/data/stock/%s", item.ID()), "req-7", update)
+
+	entries := madminHandler.audit.Entries(AuditFilter{EntityType: AUDIT_DISTRIBUTOR})
+	if len(entries) != 2 || entries[0].Action != AUDIT_UPDATE || entries[1].Action != AUDIT_CREATE {
+		t.Fatalf("Unexpected distributor entries %+v", entries)
+	}
+	if e := entries[0]; string(e.Before) != `{"name":"Vetpharm"}` || string(e.After) != `{"name":"VetPharm Ltd"}` ||
+		e.UserID != admin.ID() || e.RequestID != "req-2" || e.ClientIP != "127.0.0.1" || e.PreviousHash != entries[1].Hash {
+		t.Fatalf("Unexpected entry of the renamed distributor %+v", e)
+	}
+
+	entries = madminHandler.audit.Entries(AuditFilter{EntityID: userID, Action: AUDIT_DELETE})
+	if len(entries) != 1 || bytes.Contains(entries[0].Before, []byte("pw")) {
+		t.Fatalf("Unexpected entries of the removed user %+v", entries)
+	}
+
+	resp, body := send("GET", fmt.Sprintf("/data/audit?entityType=%s&entityID=%s", AUDIT_STOCK, item.ID()), "", nil)
+	stockEntries := make([]*AuditEntryDTO, 0)
+	json.Unmarshal([]byte(body), &stockEntries)
+	if resp.StatusCode != http.StatusOK || len(stockEntries) != 1 || string(stockEntries[0].After) != `{"minQuantity":"5"}` {
+		t.Fatalf("Unexpected audit entries of the stock item: %d %s", resp.StatusCode, body)
+	}
+
+	// minimum quantities set by the reorder points and for storage locations are changes too
+	wh.RecordMovement(&Movement{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0)})
+	if resp, body := send("POST", "/data/reports/reorder-points/apply", "req-8", &ApplyReorderPointsDTO{StockIDs: []string{item.ID()}}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected response to applying the reorder points: %d %s", resp.StatusCode, body)
+	}
+	entries = madminHandler.audit.Entries(AuditFilter{EntityID: item.ID(), Action: AUDIT_UPDATE})
+	if len(entries) != 2 || entries[0].RequestID != "req-8" || string(entries[0].Before) != `{"minQuantity":"5"}` {
+		t.Fatalf("Unexpected entries of the applied reorder point %+v", entries)
+	}
+
+	fridge := &StorageLocation{Name: "Fridge"}
+	wh.Locations().CreateLocation(fridge)
+	send("PUT", fmt.Sprintf("/data/locations/%s/stock/%s", fridge.ID, item.ID()), "req-9", &MinQuantityDTO{MinQuantity: "2"})
+	entries = madminHandler.audit.Entries(AuditFilter{EntityType: AUDIT_STOCK_LEVEL})
+	if len(entries) != 1 || entries[0].EntityID != fridge.ID+"/"+item.ID() ||
+		string(entries[0].Before) != `{"minQuantity":""}` || string(entries[0].After) != `{"minQuantity":"2"}` {
+		t.Fatalf("Unexpected entries of the minimum quantity in the location %+v", entries)
+	}
+
+	if resp, _ := send("GET", "/data/audit?action=rename", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for an invalid action, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	verification := &AuditVerificationDTO{}
+	_, body = send("GET", "/data/audit/verify", "", nil)
+	json.Unmarshal([]byte(body), verification)
+	if !verification.Intact {
+		t.Fatalf("Expected an intact audit log, got %s", body)
+	}
+
+	if _, err := database.Exec(`DELETE FROM audit_log WHERE sequence = 1`); err == nil {
+		t.Fatalf("An audit log entry can be removed")
+	}
+	// a change made past the triggers is found by the chain of hashes
+	database.Exec(`DROP TRIGGER audit_log_no_update`)
+	database.Exec(`UPDATE audit_log SET after = '{"name":"Other"}' WHERE sequence = 2`)
+	if brokenAt := madminHandler.audit.Verify(); brokenAt != 2 {
+		t.Fatalf("Expected the audit log to be broken at entry 2, got %d", brokenAt)
+	}
+
+	// a change that cannot be recorded fails the request and is rolled back
+	madminHandler.audit = failingAuditLog{madminHandler.audit}
+	if resp, _ := send("PUT", fmt.Sprintf("/data/distributors/%s", distributorID), "req-10", &NewDistributorDTO{Name: "Vetpharm"}); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d for an unrecorded change, got %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+	if d, _ := wh.ReadDistributor(distributorID); d.Name() != "VetPharm Ltd" {
+		t.Fatalf("Expected the unrecorded change of the distributor to be rolled back, got %s", d.Name())
+	}
+
+	// none of the reorder points is applied if one cannot be recorded
+	other, _ := defaultUnexpirableStockItem(ACCESSORY)
+	wh.CreateStock(other, nil)
+	wh.RecordMovement(&Movement{StockID: other.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0)})
+	if resp, _ := send("POST", "/data/reports/reorder-points/apply", "req-11", &ApplyReorderPointsDTO{StockIDs: []string{item.ID(), other.ID()}}); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d for unrecorded reorder points, got %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+	if stored, _ := wh.ReadStock(other.ID()); !stored.MinQuantity().IsZero() {
+		t.Fatalf("Expected the unrecorded reorder point to be rolled back, got %s", stored.MinQuantity())
+	}
+}
+
+// failingAuditLog is an audit log that cannot record changes
+type failingAuditLog struct {
+	AuditLog
+}
+
+func (failingAuditLog) Record(*AuditEntry) error {
+	return errors.New("audit log is not writable")
+}
+
+func (failingAuditLog) RecordTx(*sql.Tx, *AuditEntry) error {
+	return errors.New("audit log is not writable")
+}
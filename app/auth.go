@@ -1,12 +1,22 @@
 package app
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"net/http"
 	"strings"
 )
 
+type contextKey int
+
+// userContextKey is the key of the authenticated user in the request context
+// and requestIDContextKey is the key of the id of the request
+const (
+	userContextKey contextKey = iota
+	requestIDContextKey
+)
+
 func authMiddleware(handler http.Handler, um UserManager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -17,11 +27,13 @@ func authMiddleware(handler http.Handler, um UserManager) http.Handler {
 			name, password, err := decodeAuthHeader(authString)
 			if err != nil {
 				respondStatusUnauthorized(w, r)
+				return
 			}
 
 			isValidUser := um.ValidateUser(name, password)
 			if isValidUser {
-				handler.ServeHTTP(w, r)
+				u, _ := um.ReadUserByName(name)
+				handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, u)))
 			} else {
 				respondStatusUnauthorized(w, r)
 			}
@@ -53,3 +65,18 @@ func decodeAuthHeader(authString string) (name string, password string, err erro
 
 	return
 }
+
+// requestUser returns the authenticated user that sent the request
+func requestUser(r *http.Request) (User, bool) {
+	u, ok := r.Context().Value(userContextKey).(User)
+	return u, ok
+}
+
+// requestUserID returns the id of the authenticated user that sent the request
+// or an empty string if the request was not authenticated
+func requestUserID(r *http.Request) string {
+	if u, ok := requestUser(r); ok {
+		return u.ID()
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"database/sql"
+)
+
+func (wh *dafaultWarehouse) initBarcodesTable() {
+	barcodesTable := `
+	CREATE TABLE IF NOT EXISTS
+		stock_barcodes (
+			code TEXT NOT NULL PRIMARY KEY,
+			stock_id TEXT NOT NULL,
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id)
+	);
+	CREATE INDEX IF NOT EXISTS
+		stock_barcodes_stock_id ON stock_barcodes (stock_id);
+	`
+	_, err := wh.database.Exec(barcodesTable)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// AddBarcode assigns a GTIN/EAN barcode to the stock item with the given id.
+// A barcode can belong to a single stock item only.
+func (wh *dafaultWarehouse) AddBarcode(stockID, code string) error {
+	gtin, err := normalizeGTIN(code)
+	if err != nil {
+		return ValidationErrors{{"code", err.Error()}}
+	}
+
+	var owner string
+	err = wh.database.QueryRow(`SELECT stock_id FROM stock_barcodes WHERE code = ?`, gtin).Scan(&owner)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		panic(err)
+	case owner == stockID:
+		return nil
+	default:
+		return ValidationErrors{{"code", "the barcode belongs to another stock item"}}
+	}
+
+	_, err = wh.database.Exec(`
+		INSERT INTO
+			stock_barcodes (
+				code,
+				stock_id)
+		VALUES(?, ?)
+	`, gtin, stockID)
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+func (wh *dafaultWarehouse) RemoveBarcode(stockID, code string) {
+	gtin, err := normalizeGTIN(code)
+	if err != nil {
+		return
+	}
+
+	_, err = wh.database.Exec(`DELETE FROM stock_barcodes WHERE code = ? AND stock_id = ?`, gtin, stockID)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Barcodes returns the barcodes of the stock item as 14 digit GTINs
+func (wh *dafaultWarehouse) Barcodes(stockID string) []string {
+	rows, err := wh.database.Query(`SELECT code FROM stock_barcodes WHERE stock_id = ? ORDER BY code`, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	codes := make([]string, 0)
+	for rows.Next() {
+		var code string
+		if err = rows.Scan(&code); err != nil {
+			panic(err)
+		}
+		codes = append(codes, code)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return codes
+}
+
+// ReadStockByBarcode returns the stock item with the given GTIN/EAN barcode
+func (wh *dafaultWarehouse) ReadStockByBarcode(code string) (Stock, bool) {
+	gtin, err := normalizeGTIN(code)
+	if err != nil {
+		return nil, false
+	}
+
+	var stockID string
+	err = wh.database.QueryRow(`SELECT stock_id FROM stock_barcodes WHERE code = ?`, gtin).Scan(&stockID)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	return wh.ReadStock(stockID)
+}
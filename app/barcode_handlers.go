@@ -0,0 +1,133 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (m *madminHandler) barcodesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listBarcodesHandler(w, r)
+	case "POST":
+		m.addBarcodeHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /stock/<id>/barcodes
+//
+// Lists the barcodes of the stock item with <id> as 14 digit GTINs.
+func (m *madminHandler) listBarcodesHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, m.warehouse.Barcodes(id))
+}
+
+// Handler for POST /stock/<id>/barcodes
+//
+// Assigns a GTIN/EAN barcode to the stock item with <id>.
+func (m *madminHandler) addBarcodeHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &BarcodeDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err := m.warehouse.AddBarcode(id, dto.Code)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Handler for DELETE /stock/<id>/barcodes/<code>
+//
+// Removes a barcode from the stock item with <id>.
+func (m *madminHandler) removeBarcodeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m.warehouse.RemoveBarcode(vars["id"], vars["code"])
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for GET /stock/by-barcode/<code>
+//
+// Returns JSON with data for the stock item with the given barcode.
+// The code can be a GTIN/EAN or a GS1 code with a GTIN.
+func (m *madminHandler) stockByBarcodeHandler(w http.ResponseWriter, r *http.Request) {
+	code, err := ParseGS1(mux.Vars(r)["code"])
+	if err != nil {
+		respondBadRequest(w, ValidationErrors{{"code", err.Error()}})
+		return
+	}
+
+	item, ok := m.warehouse.ReadStockByBarcode(code.GTIN)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	resp := newStockDTO(item)
+	resp.Barcodes = m.warehouse.Barcodes(item.ID())
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /stock/scan
+//
+// Parses a scanned GS1 or GTIN/EAN code. If a stock item has the scanned GTIN,
+// the response contains the item and a receipt of the scanned lot that only needs a quantity.
+func (m *madminHandler) scanHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &BarcodeDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	code, err := ParseGS1(dto.Code)
+	if err != nil {
+		respondBadRequest(w, ValidationErrors{{"code", err.Error()}})
+		return
+	}
+
+	resp := &ScanDTO{
+		GTIN:         code.GTIN,
+		LotNumber:    code.LotNumber,
+		SerialNumber: code.SerialNumber,
+	}
+	if code.ExpirationDate != nil {
+		resp.ExpirationDate = code.ExpirationDate.Format(dateLayout)
+	}
+
+	if item, ok := m.warehouse.ReadStockByBarcode(code.GTIN); ok {
+		resp.Stock = newStockDTO(item)
+		resp.Receipt = &NewMovementDTO{Kind: RECEIPT}
+
+		if code.LotNumber != "" {
+			// further packs of a lot that is already in stock are added to the lot
+			for _, lot := range m.warehouse.Lots(item.ID()) {
+				if lot.Number == code.LotNumber {
+					resp.Receipt.LotID = lot.ID
+				}
+			}
+			if resp.Receipt.LotID == "" {
+				resp.Receipt.Lot = &NewLotDTO{Number: code.LotNumber, ExpirationDate: resp.ExpirationDate}
+			}
+		}
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
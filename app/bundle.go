@@ -0,0 +1,324 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Bundle is the definition of a kit, a BUNDLE stock item made of other stock items.
+// Kits can be assembled in advance into the bundle's own quantity. Dispensing more
+// kits than are assembled takes the rest from the components.
+type Bundle struct {
+	StockID    string
+	Components []BundleComponent
+}
+
+// BundleComponent is a stock item and its quantity in a single kit
+type BundleComponent struct {
+	StockID  string
+	Quantity decimal.Decimal
+}
+
+// BundleAvailability is the quantity of a bundle that can be dispensed
+type BundleAvailability struct {
+	// Assembled is the available quantity of kits assembled in advance
+	Assembled decimal.Decimal
+	// Buildable is the number of kits that can be assembled from the available components
+	Buildable decimal.Decimal
+}
+
+func (ba *BundleAvailability) Available() decimal.Decimal {
+	return ba.Assembled.Add(ba.Buildable)
+}
+
+func (wh *dafaultWarehouse) initBundlesTable() {
+	bundlesTable := `
+	CREATE TABLE IF NOT EXISTS
+		bundle_components (
+			bundle_id TEXT NOT NULL,
+			stock_id TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			PRIMARY KEY (bundle_id, stock_id),
+			FOREIGN KEY (bundle_id) REFERENCES warehouse (id),
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id)
+	);
+	`
+	_, err := wh.database.Exec(bundlesTable)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// validate checks the definition of a bundle. Bundles cannot contain other bundles,
+// controlled substances or items that need a prescription.
+func (b *Bundle) validate(wh *dafaultWarehouse) error {
+	errs := ValidationErrors{}
+
+	if item, ok := wh.ReadStock(b.StockID); !ok {
+		errs = append(errs, ValidationError{"stockID", "no such stock item"})
+	} else if item.Type() != BUNDLE {
+		errs = append(errs, ValidationError{"stockID", "the stock item is not a bundle"})
+	}
+	if len(b.Components) == 0 {
+		errs = append(errs, ValidationError{"components", "no components set"})
+	}
+
+	seen := make(map[string]bool)
+	for i, c := range b.Components {
+		field := func(name string) string { return fmt.Sprintf("components[%d].%s", i, name) }
+
+		item, ok := wh.ReadStock(c.StockID)
+		switch {
+		case !ok:
+			errs = append(errs, ValidationError{field("stockID"), "no such stock item"})
+			continue
+		case item.Type() == BUNDLE:
+			errs = append(errs, ValidationError{field("stockID"), "bundles cannot contain other bundles"})
+		case item.IsControlled():
+			errs = append(errs, ValidationError{field("stockID"), "bundles cannot contain controlled substances"})
+		case item.PrescriptionRequired():
+			errs = append(errs, ValidationError{field("stockID"), "bundles cannot contain items that need a prescription"})
+		case seen[c.StockID]:
+			errs = append(errs, ValidationError{field("stockID"), "the stock item is already a component"})
+		}
+		seen[c.StockID] = true
+
+		if c.Quantity.Sign() <= 0 {
+			errs = append(errs, ValidationError{field("quantity"), "quantity must be positive"})
+		} else if err := item.QuantityRule().Validate(field("quantity"), c.Quantity); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SetBundle replaces the components of a bundle
+func (wh *dafaultWarehouse) SetBundle(b *Bundle) error {
+	if err := b.validate(wh); err != nil {
+		return err
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM bundle_components WHERE bundle_id = ?`, b.StockID)
+	if err != nil {
+		panic(err)
+	}
+	for _, c := range b.Components {
+		_, err = tx.Exec(`
+			INSERT INTO
+				bundle_components (
+					bundle_id,
+					stock_id,
+					quantity)
+			VALUES(?, ?, ?)
+		`, b.StockID, c.StockID, c.Quantity.String())
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// ReadBundle returns the definition of the bundle with the given id if it has components
+func (wh *dafaultWarehouse) ReadBundle(stockID string) (*Bundle, bool) {
+	b := &Bundle{StockID: stockID, Components: bundleComponents(wh.database, stockID)}
+	if len(b.Components) == 0 {
+		return nil, false
+	}
+	return b, true
+}
+
+// bundleComponents returns the components of a bundle in the order they were added
+func bundleComponents(q querier, bundleID string) []BundleComponent {
+	rows, err := q.Query(`
+		SELECT
+			stock_id,
+			quantity
+		FROM
+			bundle_components
+		WHERE
+			bundle_id = ?
+		ORDER BY
+			rowid
+	`, bundleID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	components := make([]BundleComponent, 0)
+	for rows.Next() {
+		c := BundleComponent{}
+		if err = rows.Scan(&c.StockID, &c.Quantity); err != nil {
+			panic(err)
+		}
+		components = append(components, c)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return components
+}
+
+// BundleAvailability returns the assembled kits of a bundle
+// and the kits that can be assembled from the available components
+func (wh *dafaultWarehouse) BundleAvailability(stockID string) *BundleAvailability {
+	assembled := wh.availableQuantity(stockID)
+	return &BundleAvailability{
+		Assembled: assembled,
+		Buildable: wh.AvailableQuantities()[stockID].Sub(decimal.Max(assembled, decimal.Zero)),
+	}
+}
+
+// availableQuantity returns the quantity of the stock item that can be dispensed without assembling kits
+func (wh *dafaultWarehouse) availableQuantity(stockID string) decimal.Decimal {
+	item, ok := wh.ReadStock(stockID)
+	if !ok {
+		return decimal.Zero
+	}
+
+	available, now := item.Quantity(), time.Now()
+	for _, lot := range wh.Lots(stockID) {
+		if !lot.isAvailable(now) {
+			available = available.Sub(lot.Quantity)
+		}
+	}
+	return available
+}
+
+// buildableBundles adds the kits that can be assembled from the available components
+// to the available quantities of the bundles
+func (wh *dafaultWarehouse) buildableBundles(available map[string]decimal.Decimal) {
+	rows, err := wh.database.Query(`SELECT bundle_id, stock_id, quantity FROM bundle_components ORDER BY bundle_id`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	buildable := make(map[string]decimal.Decimal)
+	for rows.Next() {
+		var (
+			bundleID string
+			c        BundleComponent
+		)
+		if err = rows.Scan(&bundleID, &c.StockID, &c.Quantity); err != nil {
+			panic(err)
+		}
+
+		kits := decimal.Max(available[c.StockID], decimal.Zero).Div(c.Quantity).Floor()
+		if current, ok := buildable[bundleID]; !ok || kits.LessThan(current) {
+			buildable[bundleID] = kits
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	for bundleID, kits := range buildable {
+		if quantity, ok := available[bundleID]; ok {
+			available[bundleID] = decimal.Max(quantity, decimal.Zero).Add(kits)
+		}
+	}
+}
+
+// AssembleBundle assembles kits of a bundle from its components in advance.
+// A negative quantity takes assembled kits apart and returns their components to stock.
+// The movements of the components are returned with the movement of the bundle last.
+func (wh *dafaultWarehouse) AssembleBundle(stockID string, quantity decimal.Decimal, userID, locationID string) ([]*Movement, error) {
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	movements, err := wh.assembleBundleTx(tx, stockID, quantity, userID, locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, mv := range movements {
+		wh.publishMovement(mv)
+	}
+	return movements, nil
+}
+
+// assembleBundleTx records the assembly of kits of a bundle as part of a bigger transaction
+func (wh *dafaultWarehouse) assembleBundleTx(tx *sql.Tx, stockID string, quantity decimal.Decimal, userID, locationID string) ([]*Movement, error) {
+	components := bundleComponents(tx, stockID)
+	if len(components) == 0 {
+		return nil, ValidationErrors{{"stockID", "the stock item is not a bundle with components"}}
+	}
+
+	reference := fmt.Sprintf("assembly of %s kits of bundle %s", quantity, stockID)
+	if quantity.Sign() < 0 {
+		reference = fmt.Sprintf("disassembly of %s kits of bundle %s", quantity.Neg(), stockID)
+	}
+
+	movements := make([]*Movement, 0, len(components)+1)
+	for _, c := range components {
+		movements = append(movements, &Movement{
+			StockID:    c.StockID,
+			Kind:       ASSEMBLY,
+			Quantity:   quantity.Mul(c.Quantity).Neg(),
+			UserID:     userID,
+			Reference:  reference,
+			LocationID: locationID,
+		})
+	}
+	kits := &Movement{
+		StockID:    stockID,
+		Kind:       ASSEMBLY,
+		Quantity:   quantity,
+		UserID:     userID,
+		Reference:  reference,
+		LocationID: locationID,
+	}
+	if quantity.Sign() < 0 {
+		// the kits must be taken apart before their components are returned
+		movements = append([]*Movement{kits}, movements...)
+	} else {
+		movements = append(movements, kits)
+	}
+
+	for _, mv := range movements {
+		if err := wh.recordMovementTx(tx, mv); err != nil {
+			if errs, ok := err.(ValidationErrors); ok && mv != kits {
+				for j := range errs {
+					errs[j].Field = fmt.Sprintf("components.%s.%s", mv.StockID, errs[j].Field)
+				}
+			}
+			return nil, err
+		}
+	}
+
+	if quantity.Sign() < 0 {
+		// the bundle's movement is returned last in both directions
+		movements = append(movements[1:], kits)
+	}
+	return movements, nil
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (m *madminHandler) bundleHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getBundleHandler(w, r)
+	case "PUT":
+		m.setBundleHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /stock/<id>/bundle
+//
+// Returns the components of the bundle with <id> with the assembled kits
+// and the kits that can be assembled from the available components.
+func (m *madminHandler) getBundleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	b, ok := m.warehouse.ReadBundle(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newBundleDTO(b, m.warehouse.BundleAvailability(id)))
+}
+
+// Handler for PUT /stock/<id>/bundle
+//
+// Replaces the components of the bundle with <id>.
+func (m *madminHandler) setBundleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &BundleDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+	dto.StockID = id
+
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	b, err := dto.bundle()
+	if err == nil {
+		err = m.warehouse.SetBundle(b)
+	}
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	b, _ = m.warehouse.ReadBundle(id)
+	respondJSON(w, http.StatusOK, newBundleDTO(b, m.warehouse.BundleAvailability(id)))
+}
+
+// Handler for POST /stock/<id>/assemble
+//
+// Assembles kits of the bundle with <id> from its components in advance,
+// or takes assembled kits apart for a negative quantity.
+// Returns the recorded movements with the movement of the bundle last.
+func (m *madminHandler) assembleBundleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &AssemblyDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	quantity, err := validQuantityFromString(dto.Quantity)
+	if err != nil {
+		respondBadRequest(w, ValidationErrors{{"quantity", err.Error()}})
+		return
+	}
+
+	movements, err := m.warehouse.AssembleBundle(id, quantity, requestUserID(r), dto.LocationID)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	resp := make([]*MovementDTO, 0, len(movements))
+	for _, mv := range movements {
+		resp = append(resp, newMovementDTO(mv))
+	}
+	respondJSON(w, http.StatusCreated, resp)
+}
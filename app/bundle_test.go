@@ -0,0 +1,97 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestBundle(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+		wh            = madminHandler.warehouse
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	feed, _ := defaultExpirableStockItem(FEED)
+	feed.SetQuantity(decimal.New(10, 0))
+	wh.CreateStock(feed, nil)
+
+	collar, _ := defaultUnexpirableStockItem(ACCESSORY)
+	collar.SetQuantity(decimal.New(3, 0))
+	wh.CreateStock(collar, nil)
+
+	pack, _ := defaultUnexpirableStockItem(BUNDLE)
+	pack.SetName("Puppy starter pack")
+	pack.SetQuantity(decimal.Zero)
+	wh.CreateStock(pack, nil)
+
+	if err := wh.SetBundle(&Bundle{StockID: feed.ID(), Components: []BundleComponent{{collar.ID(), decimal.New(1, 0)}}}); err == nil {
+		t.Fatalf(`SetBundle accepts components for a stock item that is not a bundle`)
+	}
+	if err := wh.SetBundle(&Bundle{StockID: pack.ID(), Components: []BundleComponent{{pack.ID(), decimal.New(1, 0)}}}); err == nil {
+		t.Fatalf(`SetBundle accepts a bundle as a component`)
+	}
+	if err := wh.SetBundle(&Bundle{StockID: pack.ID(), Components: []BundleComponent{
+		{feed.ID(), decimal.New(2, 0)},
+		{collar.ID(), decimal.New(1, 0)},
+	}}); err != nil {
+		t.Fatalf(`SetBundle returns an error for a valid bundle: %s`, err)
+	}
+
+	if available := wh.AvailableQuantities()[pack.ID()]; !available.Equal(decimal.New(3, 0)) {
+		t.Fatalf(`Expected 3 packs from the components, got %s`, available)
+	}
+
+	movements, err := wh.AssembleBundle(pack.ID(), decimal.New(2, 0), "", "")
+	if err != nil {
+		t.Fatalf(`AssembleBundle returns an error for available components: %s`, err)
+	}
+	if len(movements) != 3 || movements[2].StockID != pack.ID() || !movements[0].Quantity.Equal(decimal.New(-4, 0)) {
+		t.Fatalf(`Unexpected assembly movements %+v`, movements)
+	}
+	if err := wh.RecordMovement(&Movement{StockID: pack.ID(), Kind: ASSEMBLY, Quantity: decimal.New(1, 0)}); err == nil {
+		t.Fatalf(`RecordMovement assembles a bundle without its components`)
+	}
+
+	resp, err := http.Get(buildURL(s.URL, fmt.Sprintf("/data/stock/%s/bundle", pack.ID())))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	bundle := &BundleDTO{}
+	json.NewDecoder(resp.Body).Decode(bundle)
+	resp.Body.Close()
+	if len(bundle.Components) != 2 || bundle.Assembled != "2" || bundle.Buildable != "1" || bundle.Available != "3" {
+		t.Fatalf("Unexpected bundle %+v", bundle)
+	}
+
+	// the third pack is assembled from the components when it is sold
+	sale := &Sale{PaymentMethod: CASH, Lines: []*SaleLine{{StockID: pack.ID(), Quantity: decimal.New(3, 0), UnitPrice: decimal.New(30, 0)}}}
+	if err := wh.CreateSale(sale); err != nil {
+		t.Fatalf(`CreateSale returns an error for available packs: %s`, err)
+	}
+	expected := map[string]decimal.Decimal{pack.ID(): decimal.Zero, feed.ID(): decimal.New(4, 0), collar.ID(): decimal.Zero}
+	for id, quantity := range expected {
+		if item, _ := wh.ReadStock(id); !item.Quantity().Equal(quantity) {
+			t.Errorf(`Expected quantity %s of %s after the sale, got %s`, quantity, id, item.Quantity())
+		}
+	}
+
+	if err := wh.RecordMovement(&Movement{StockID: pack.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0)}); err == nil {
+		t.Fatalf(`RecordMovement dispenses a pack without collars`)
+	}
+	if item, _ := wh.ReadStock(feed.ID()); !item.Quantity().Equal(decimal.New(4, 0)) {
+		t.Fatalf(`A failed dispense of a pack took its components, feed quantity is %s`, item.Quantity())
+	}
+	if _, err := wh.AssembleBundle(pack.ID(), decimal.New(-1, 0), "", ""); err == nil {
+		t.Fatalf(`AssembleBundle takes apart kits that are not assembled`)
+	}
+}
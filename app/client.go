@@ -0,0 +1,545 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Owner is a client of the practice who owns one or more patients
+type Owner struct {
+	ID        string
+	FirstName string
+	LastName  string
+
+	Email   string
+	Phone   string
+	Address string
+
+	// MarketingConsent, ReminderConsent and DataSharingConsent are the owner's consents
+	// to marketing messages, to reminders of treatments and to sharing data with third parties
+	MarketingConsent   bool
+	ReminderConsent    bool
+	DataSharingConsent bool
+
+	Created time.Time
+}
+
+// Name returns the full name of the owner
+func (o *Owner) Name() string {
+	return strings.TrimSpace(o.FirstName + " " + o.LastName)
+}
+
+func (o *Owner) validate() error {
+	errs := ValidationErrors{}
+
+	if strings.TrimSpace(o.LastName) == "" {
+		errs = append(errs, ValidationError{"lastName", "cannot set empty string as last name"})
+	}
+	if o.Email != "" {
+		if _, err := mail.ParseAddress(o.Email); err != nil {
+			errs = append(errs, ValidationError{"email", "invalid email address"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Patient is an animal treated by the practice
+type Patient struct {
+	ID      string
+	OwnerID string
+	Name    string
+
+	Species string
+	Breed   string
+	// DateOfBirth is nil if it is not known
+	DateOfBirth *time.Time
+	// Weight is the last known weight in kilograms or zero if it is not known
+	Weight decimal.Decimal
+	// MicrochipNumber is the 15 digit ISO 11784 code of the patient's microchip or an empty string
+	MicrochipNumber string
+
+	Created time.Time
+}
+
+func (p *Patient) validate() error {
+	errs := ValidationErrors{}
+
+	if strings.TrimSpace(p.Name) == "" {
+		errs = append(errs, ValidationError{"name", "cannot set empty string as name"})
+	}
+	if strings.TrimSpace(p.Species) == "" {
+		errs = append(errs, ValidationError{"species", "cannot set empty string as species"})
+	}
+	if p.DateOfBirth != nil && p.DateOfBirth.After(time.Now()) {
+		errs = append(errs, ValidationError{"dateOfBirth", "date of birth is in the future"})
+	}
+	if p.Weight.Sign() < 0 {
+		errs = append(errs, ValidationError{"weight", "weight must not be negative"})
+	}
+	if p.MicrochipNumber != "" && (len(p.MicrochipNumber) != 15 || !isDigits(p.MicrochipNumber)) {
+		errs = append(errs, ValidationError{"microchipNumber", "microchip numbers have 15 digits"})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ClientManager manages the records of the owners and their patients
+type ClientManager interface {
+	CreateOwner(*Owner) error
+	ReadOwner(string) (*Owner, bool)
+	UpdateOwner(*Owner) error
+	// DeleteOwner() removes an owner without patients
+	DeleteOwner(string) error
+	// SearchOwners() returns the owners whose name, email or phone contains the query, sorted by name.
+	// All owners are returned for an empty query.
+	SearchOwners(string) []*Owner
+
+	CreatePatient(*Patient) error
+	ReadPatient(string) (*Patient, bool)
+	UpdatePatient(*Patient) error
+	// DeletePatient() removes a patient without medication history
+	DeletePatient(string) error
+	// SearchPatients() returns the patients whose name, microchip number or owner's name
+	// contains the query, sorted by name. If ownerID is set, only the owner's patients are returned.
+	SearchPatients(query, ownerID string) []*Patient
+}
+
+type defaultClientManager struct {
+	database *sql.DB
+}
+
+// NewClientManager creates a client manager that keeps the owners and patients
+// in sqlite3 tables inside the db that is passed as an argument.
+func NewClientManager(db *sql.DB) ClientManager {
+	cm := &defaultClientManager{database: db}
+
+	cm.initClientsTables()
+
+	return cm
+}
+
+func (cm *defaultClientManager) initClientsTables() {
+	clientsTables := `
+	CREATE TABLE IF NOT EXISTS
+		owners (
+			id TEXT NOT NULL PRIMARY KEY,
+			first_name TEXT NOT NULL,
+			last_name TEXT NOT NULL,
+			email TEXT NOT NULL,
+			phone TEXT NOT NULL,
+			address TEXT NOT NULL,
+			marketing_consent BOOLEAN NOT NULL,
+			reminder_consent BOOLEAN NOT NULL,
+			data_sharing_consent BOOLEAN NOT NULL,
+			created DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS
+		patients (
+			id TEXT NOT NULL PRIMARY KEY,
+			owner_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			species TEXT NOT NULL,
+			breed TEXT NOT NULL,
+			date_of_birth DATETIME,
+			weight NUMERIC NOT NULL,
+			microchip_number TEXT NOT NULL,
+			created DATETIME NOT NULL,
+			FOREIGN KEY (owner_id) REFERENCES owners (id)
+	);
+	CREATE INDEX IF NOT EXISTS
+		patients_owner_id ON patients (owner_id);
+	`
+	_, err := cm.database.Exec(clientsTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// likePattern returns a LIKE pattern that matches strings containing s
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+func (cm *defaultClientManager) CreateOwner(o *Owner) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	o.ID = id
+	o.Created = time.Now().UTC()
+
+	_, err = cm.database.Exec(`
+		INSERT INTO
+			owners (`+ownerColumns+`)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		o.ID,
+		o.FirstName,
+		o.LastName,
+		o.Email,
+		o.Phone,
+		o.Address,
+		o.MarketingConsent,
+		o.ReminderConsent,
+		o.DataSharingConsent,
+		o.Created)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// ownerColumns are the columns of the owners table, in the order expected by scanOwner
+const ownerColumns = `
+	id,
+	first_name,
+	last_name,
+	email,
+	phone,
+	address,
+	marketing_consent,
+	reminder_consent,
+	data_sharing_consent,
+	created
+`
+
+func scanOwner(row rowScanner) (*Owner, error) {
+	o := &Owner{}
+	err := row.Scan(
+		&o.ID,
+		&o.FirstName,
+		&o.LastName,
+		&o.Email,
+		&o.Phone,
+		&o.Address,
+		&o.MarketingConsent,
+		&o.ReminderConsent,
+		&o.DataSharingConsent,
+		&o.Created)
+	return o, err
+}
+
+func (cm *defaultClientManager) ReadOwner(id string) (*Owner, bool) {
+	o, err := scanOwner(cm.database.QueryRow(`SELECT `+ownerColumns+` FROM owners WHERE id = ?`, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+	return o, true
+}
+
+func (cm *defaultClientManager) UpdateOwner(o *Owner) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	_, err := cm.database.Exec(`
+		UPDATE
+			owners
+		SET
+			first_name = ?,
+			last_name = ?,
+			email = ?,
+			phone = ?,
+			address = ?,
+			marketing_consent = ?,
+			reminder_consent = ?,
+			data_sharing_consent = ?
+		WHERE
+			id = ?
+	`,
+		o.FirstName,
+		o.LastName,
+		o.Email,
+		o.Phone,
+		o.Address,
+		o.MarketingConsent,
+		o.ReminderConsent,
+		o.DataSharingConsent,
+		o.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+func (cm *defaultClientManager) DeleteOwner(id string) error {
+	var patients int
+	err := cm.database.QueryRow(`SELECT COUNT(*) FROM patients WHERE owner_id = ?`, id).Scan(&patients)
+	if err != nil {
+		panic(err)
+	}
+	if patients > 0 {
+		return errors.New("owner still has patients")
+	}
+
+	_, err = cm.database.Exec(`
+		DELETE FROM
+			owners
+		WHERE
+			id = ?
+	`, id)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+func (cm *defaultClientManager) SearchOwners(query string) []*Owner {
+	pattern := likePattern(strings.TrimSpace(query))
+
+	rows, err := cm.database.Query(`
+		SELECT `+ownerColumns+`
+		FROM
+			owners
+		WHERE
+			first_name || ' ' || last_name LIKE ? ESCAPE '\' OR
+			email LIKE ? ESCAPE '\' OR
+			phone LIKE ? ESCAPE '\'
+		ORDER BY
+			last_name, first_name
+	`, pattern, pattern, pattern)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	owners := make([]*Owner, 0)
+	for rows.Next() {
+		o, err := scanOwner(rows)
+		if err != nil {
+			panic(err)
+		}
+		owners = append(owners, o)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return owners
+}
+
+// validatePatient checks the patient's fields, its owner and that its microchip number is not used by another patient
+func (cm *defaultClientManager) validatePatient(p *Patient) error {
+	errs := ValidationErrors{}
+	if err := p.validate(); err != nil {
+		errs = append(errs, err.(ValidationErrors)...)
+	}
+
+	if _, ok := cm.ReadOwner(p.OwnerID); !ok {
+		errs = append(errs, ValidationError{"ownerID", "no such owner"})
+	}
+	if p.MicrochipNumber != "" {
+		var chipped int
+		err := cm.database.QueryRow(`
+			SELECT
+				COUNT(*)
+			FROM
+				patients
+			WHERE
+				microchip_number = ? AND id != ?
+		`, p.MicrochipNumber, p.ID).Scan(&chipped)
+		if err != nil {
+			panic(err)
+		}
+		if chipped > 0 {
+			errs = append(errs, ValidationError{"microchipNumber", "the microchip number belongs to another patient"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (cm *defaultClientManager) CreatePatient(p *Patient) error {
+	if err := cm.validatePatient(p); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	p.ID = id
+	p.Created = time.Now().UTC()
+
+	_, err = cm.database.Exec(`
+		INSERT INTO
+			patients (`+patientColumns+`)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		p.ID,
+		p.OwnerID,
+		p.Name,
+		p.Species,
+		p.Breed,
+		p.DateOfBirth,
+		p.Weight.String(),
+		p.MicrochipNumber,
+		p.Created)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// patientColumns are the columns of the patients table, in the order expected by scanPatient
+const patientColumns = `
+	id,
+	owner_id,
+	name,
+	species,
+	breed,
+	date_of_birth,
+	weight,
+	microchip_number,
+	created
+`
+
+func scanPatient(row rowScanner) (*Patient, error) {
+	p := &Patient{}
+	err := row.Scan(
+		&p.ID,
+		&p.OwnerID,
+		&p.Name,
+		&p.Species,
+		&p.Breed,
+		&p.DateOfBirth,
+		&p.Weight,
+		&p.MicrochipNumber,
+		&p.Created)
+	return p, err
+}
+
+func (cm *defaultClientManager) ReadPatient(id string) (*Patient, bool) {
+	p, err := scanPatient(cm.database.QueryRow(`SELECT `+patientColumns+` FROM patients WHERE id = ?`, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+	return p, true
+}
+
+func (cm *defaultClientManager) UpdatePatient(p *Patient) error {
+	if err := cm.validatePatient(p); err != nil {
+		return err
+	}
+
+	_, err := cm.database.Exec(`
+		UPDATE
+			patients
+		SET
+			owner_id = ?,
+			name = ?,
+			species = ?,
+			breed = ?,
+			date_of_birth = ?,
+			weight = ?,
+			microchip_number = ?
+		WHERE
+			id = ?
+	`,
+		p.OwnerID,
+		p.Name,
+		p.Species,
+		p.Breed,
+		p.DateOfBirth,
+		p.Weight.String(),
+		p.MicrochipNumber,
+		p.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// DeletePatient removes the patient with the given id.
+// Patients that were dispensed stock to are kept for the medication history.
+func (cm *defaultClientManager) DeletePatient(id string) error {
+	var dispenses int
+	err := cm.database.QueryRow(`SELECT COUNT(*) FROM stock_movements WHERE patient_id = ?`, id).Scan(&dispenses)
+	if err != nil {
+		panic(err)
+	}
+	if dispenses > 0 {
+		return errors.New("patient has medication history")
+	}
+
+	_, err = cm.database.Exec(`
+		DELETE FROM
+			patients
+		WHERE
+			id = ?
+	`, id)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+func (cm *defaultClientManager) SearchPatients(query, ownerID string) []*Patient {
+	pattern := likePattern(strings.TrimSpace(query))
+
+	rows, err := cm.database.Query(`
+		SELECT `+patientColumns+`
+		FROM
+			patients
+		WHERE
+			(? = '' OR owner_id = ?) AND (
+				name LIKE ? ESCAPE '\' OR
+				microchip_number LIKE ? ESCAPE '\' OR
+				owner_id IN (
+					SELECT id FROM owners WHERE first_name || ' ' || last_name LIKE ? ESCAPE '\'))
+		ORDER BY
+			name, created
+	`, ownerID, ownerID, pattern, pattern, pattern)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	patients := make([]*Patient, 0)
+	for rows.Next() {
+		p, err := scanPatient(rows)
+		if err != nil {
+			panic(err)
+		}
+		patients = append(patients, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return patients
+}
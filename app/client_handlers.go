@@ -0,0 +1,274 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (m *madminHandler) ownersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listOwnersHandler(w, r)
+	case "POST":
+		m.addOwnerHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+func (m *madminHandler) ownerHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getOwnerHandler(w, r)
+	case "DELETE":
+		m.removeOwnerHandler(w, r)
+	case "PUT":
+		m.updateOwnerHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /owners/
+//
+// Lists the owners whose name, email or phone contains the query parameter q.
+func (m *madminHandler) listOwnersHandler(w http.ResponseWriter, r *http.Request) {
+	owners := m.clients.SearchOwners(r.URL.Query().Get("q"))
+
+	resp := make([]*OwnerDTO, 0, len(owners))
+	for _, o := range owners {
+		resp = append(resp, newOwnerDTO(o))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for GET /owners/<id>
+//
+// Returns JSON with data for the owner with the given id.
+func (m *madminHandler) getOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	o, ok := m.clients.ReadOwner(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newOwnerDTO(o))
+}
+
+// Handler for POST /owners/
+//
+// Adds an owner and returns its id.
+func (m *madminHandler) addOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &OwnerDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	o := dto.owner()
+	if err := m.clients.CreateOwner(o); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if _, err := w.Write([]byte(o.ID)); err != nil {
+		log.Printf("Error while writing response: %s", err)
+	}
+}
+
+// Handler for PUT /owners/<id>
+//
+// Updates the contact details and consents of the owner with <id>.
+func (m *madminHandler) updateOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &OwnerDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+	dto.ID = id
+
+	if _, ok := m.clients.ReadOwner(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := m.clients.UpdateOwner(dto.owner()); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for DELETE /owners/<id>
+//
+// Removes the owner with <id> if they have no patients.
+func (m *madminHandler) removeOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	err := m.clients.DeleteOwner(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Error in removing owner: %s", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for GET /owners/<id>/patients
+//
+// Lists the patients of the owner with <id>.
+func (m *madminHandler) ownerPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.clients.ReadOwner(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newPatientDTOs(m.clients.SearchPatients("", id)))
+}
+
+func newPatientDTOs(patients []*Patient) []*PatientDTO {
+	dtos := make([]*PatientDTO, 0, len(patients))
+	for _, p := range patients {
+		dtos = append(dtos, newPatientDTO(p))
+	}
+	return dtos
+}
+
+func (m *madminHandler) patientsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listPatientsHandler(w, r)
+	case "POST":
+		m.addPatientHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+func (m *madminHandler) patientHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getPatientHandler(w, r)
+	case "DELETE":
+		m.removePatientHandler(w, r)
+	case "PUT":
+		m.updatePatientHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /patients/
+//
+// Lists the patients whose name, microchip number or owner's name contains
+// the query parameter q. The parameter ownerID restricts the list to the owner's patients.
+func (m *madminHandler) listPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	respondJSON(w, http.StatusOK, newPatientDTOs(m.clients.SearchPatients(query.Get("q"), query.Get("ownerID"))))
+}
+
+// Handler for GET /patients/<id>
+//
+// Returns JSON with data for the patient with the given id.
+func (m *madminHandler) getPatientHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.clients.ReadPatient(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newPatientDTO(p))
+}
+
+// Handler for POST /patients/
+//
+// Adds a patient and returns its id.
+func (m *madminHandler) addPatientHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &PatientDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	p, err := dto.patient()
+	if err == nil {
+		err = m.clients.CreatePatient(p)
+	}
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if _, err := w.Write([]byte(p.ID)); err != nil {
+		log.Printf("Error while writing response: %s", err)
+	}
+}
+
+// Handler for PUT /patients/<id>
+//
+// Updates the patient with <id>. Patients can be moved to another owner.
+func (m *madminHandler) updatePatientHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &PatientDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+	dto.ID = id
+
+	if _, ok := m.clients.ReadPatient(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	p, err := dto.patient()
+	if err == nil {
+		err = m.clients.UpdatePatient(p)
+	}
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for DELETE /patients/<id>
+//
+// Removes the patient with <id> if nothing was dispensed to them.
+func (m *madminHandler) removePatientHandler(w http.ResponseWriter, r *http.Request) {
+	err := m.clients.DeletePatient(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Error in removing patient: %s", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for GET /patients/<id>/medications
+//
+// Lists the dispenses to the patient with <id> in chronological order.
+func (m *madminHandler) patientMedicationsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.clients.ReadPatient(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	dispenses := m.warehouse.PatientDispenses(id)
+
+	resp := make([]*MovementDTO, 0, len(dispenses))
+	for i := range dispenses {
+		resp = append(resp, newMovementDTO(&dispenses[i]))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestClients(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	NewWarehouse(db)
+	cm := NewClientManager(db)
+
+	if err := cm.CreateOwner(&Owner{FirstName: "Jane", Email: "not an email"}); err == nil {
+		t.Fatalf(`CreateOwner accepts an owner without last name and with an invalid email`)
+	}
+
+	jane := &Owner{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Phone: "+44 20 7946 0000", ReminderConsent: true}
+	john := &Owner{FirstName: "John", LastName: "Smith", Phone: "+44 20 7946 0001"}
+	for _, o := range []*Owner{jane, john} {
+		if err := cm.CreateOwner(o); err != nil {
+			t.Fatalf(`CreateOwner returns an error for a valid owner: %s`, err)
+		}
+	}
+
+	born := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	rex := &Patient{OwnerID: jane.ID, Name: "Rex", Species: "dog", Breed: "Labrador", DateOfBirth: &born, Weight: decimal.New(325, -1), MicrochipNumber: "985112345678901"}
+	if err := cm.CreatePatient(rex); err != nil {
+		t.Fatalf(`CreatePatient returns an error for a valid patient: %s`, err)
+	}
+
+	invalid := []*Patient{
+		{OwnerID: "missing", Name: "Tom", Species: "cat"},
+		{OwnerID: john.ID, Name: "Tom", Species: "cat", MicrochipNumber: "12345"},
+		{OwnerID: john.ID, Name: "Tom", Species: "cat", MicrochipNumber: rex.MicrochipNumber},
+		{OwnerID: john.ID, Name: "Tom", Species: "cat", Weight: decimal.New(-1, 0)},
+	}
+	for _, p := range invalid {
+		if err := cm.CreatePatient(p); err == nil {
+			t.Errorf(`CreatePatient accepts the invalid patient %+v`, p)
+		}
+	}
+
+	tom := &Patient{OwnerID: john.ID, Name: "Tom", Species: "cat"}
+	if err := cm.CreatePatient(tom); err != nil {
+		t.Fatalf(`CreatePatient returns an error for a valid patient: %s`, err)
+	}
+
+	searches := []struct {
+		query, ownerID string
+		want           []string
+	}{
+		{"", "", []string{rex.ID, tom.ID}},
+		{"re", "", []string{rex.ID}},
+		{"smith", "", []string{tom.ID}},
+		{"98511", "", []string{rex.ID}},
+		{"", jane.ID, []string{rex.ID}},
+		{"tom", jane.ID, []string{}},
+		{"%", "", []string{}},
+	}
+	for _, test := range searches {
+		patients := cm.SearchPatients(test.query, test.ownerID)
+		ids := make([]string, 0, len(patients))
+		for _, p := range patients {
+			ids = append(ids, p.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.want) {
+			t.Errorf(`SearchPatients(%q, %q) = %v, expected %v`, test.query, test.ownerID, ids, test.want)
+		}
+	}
+
+	if owners := cm.SearchOwners("7946 0001"); len(owners) != 1 || owners[0].ID != john.ID {
+		t.Errorf(`SearchOwners by phone returns %v`, owners)
+	}
+
+	if err := cm.DeleteOwner(john.ID); err == nil {
+		t.Fatalf(`DeleteOwner removes an owner with patients`)
+	}
+	tom.OwnerID = jane.ID
+	if err := cm.UpdatePatient(tom); err != nil {
+		t.Fatalf(`UpdatePatient returns an error when moving a patient to another owner: %s`, err)
+	}
+	if err := cm.DeleteOwner(john.ID); err != nil {
+		t.Fatalf(`DeleteOwner returns an error for an owner without patients: %s`, err)
+	}
+	if _, ok := cm.ReadOwner(john.ID); ok {
+		t.Fatalf(`DeleteOwner does not remove the owner`)
+	}
+}
+
+func TestPatientMedications(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	item, _ := defaultUnexpirableStockItem(ACCESSORY)
+	item.SetQuantity(decimal.New(10, 0))
+	madminHandler.warehouse.CreateStock(item, nil)
+
+	owner := &Owner{FirstName: "Jane", LastName: "Doe"}
+	madminHandler.clients.CreateOwner(owner)
+	rex := &Patient{OwnerID: owner.ID, Name: "Rex", Species: "dog"}
+	madminHandler.clients.CreatePatient(rex)
+
+	movementsURL := buildURL(s.URL, fmt.Sprintf("/data/stock/%s/movements", item.ID()))
+	requests := []struct {
+		dto  NewMovementDTO
+		want int
+	}{
+		{NewMovementDTO{Kind: DISPENSE, Quantity: "2", PatientID: rex.ID}, http.StatusCreated},
+		{NewMovementDTO{Kind: DISPENSE, Quantity: "1", PatientID: "missing"}, http.StatusBadRequest},
+		{NewMovementDTO{Kind: RECEIPT, Quantity: "1", PatientID: rex.ID}, http.StatusBadRequest},
+		{NewMovementDTO{Kind: DISPENSE, Quantity: "1"}, http.StatusCreated},
+	}
+	for _, req := range requests {
+		body, _ := json.Marshal(&req.dto)
+		resp, err := http.Post(movementsURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Error sending POST request: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != req.want {
+			t.Errorf("Expected %d but got %d for %+v", req.want, resp.StatusCode, req.dto)
+		}
+	}
+
+	resp, err := http.Get(buildURL(s.URL, fmt.Sprintf("/data/patients/%s/medications", rex.ID)))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	medications := make([]*MovementDTO, 0)
+	json.NewDecoder(resp.Body).Decode(&medications)
+	resp.Body.Close()
+	if len(medications) != 1 || medications[0].Quantity != "2" || medications[0].PatientRef != "Rex (Jane Doe)" {
+		t.Fatalf("Unexpected medication history %+v", medications)
+	}
+
+	req, _ := http.NewRequest("DELETE", buildURL(s.URL, fmt.Sprintf("/data/patients/%s", rex.ID)), nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending DELETE request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected %d but got %d when removing a patient with medication history", http.StatusConflict, resp.StatusCode)
+	}
+}
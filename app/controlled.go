@@ -0,0 +1,204 @@
+package app
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// registerColumns are the columns of the controlled drugs register in the order required by law
+var registerColumns = []string{
+	"Date",
+	"Entry",
+	"Received from / supplied to",
+	"Prescribing vet",
+	"Reference",
+	"Quantity received",
+	"Quantity supplied",
+	"Balance",
+	"Signed by",
+	"Witnessed by",
+}
+
+// registerEntry is a row of the controlled drugs register
+type registerEntry struct {
+	Date        string `json:"date"`
+	Entry       string `json:"entry"`
+	Party       string `json:"party"`
+	Prescriber  string `json:"prescriber"`
+	Reference   string `json:"reference"`
+	Received    string `json:"received"`
+	Supplied    string `json:"supplied"`
+	Balance     string `json:"balance"`
+	SignedBy    string `json:"signedBy"`
+	WitnessedBy string `json:"witnessedBy"`
+}
+
+func (re *registerEntry) columns() []string {
+	return []string{
+		re.Date,
+		re.Entry,
+		re.Party,
+		re.Prescriber,
+		re.Reference,
+		re.Received,
+		re.Supplied,
+		re.Balance,
+		re.SignedBy,
+		re.WitnessedBy,
+	}
+}
+
+// controlledRegister is the register of a single controlled substance
+type controlledRegister struct {
+	StockID  string          `json:"stockID"`
+	Name     string          `json:"name"`
+	Schedule string          `json:"schedule"`
+	Entries  []registerEntry `json:"entries"`
+}
+
+// newControlledRegister builds the register of the controlled item from its movements
+func (m *madminHandler) newControlledRegister(item Stock) *controlledRegister {
+	var (
+		movements = m.warehouse.Movements(item.ID())
+		register  = &controlledRegister{
+			StockID:  item.ID(),
+			Name:     item.Name(),
+			Schedule: item.ControlledSchedule(),
+			Entries:  make([]registerEntry, 0, len(movements)),
+		}
+	)
+
+	supplier := ""
+	if d, ok := m.warehouse.ReadDistributor(item.DistributorID()); ok {
+		supplier = d.Name()
+	}
+
+	for _, mv := range movements {
+		entry := registerEntry{
+			Date:        mv.Time.UTC().Format(dateLayout),
+			Entry:       string(mv.Kind),
+			Party:       mv.PatientRef,
+			Prescriber:  mv.Prescriber,
+			Reference:   mv.Reference,
+			Balance:     mv.Balance.String(),
+			SignedBy:    m.userName(mv.UserID),
+			WitnessedBy: m.userName(mv.WitnessID),
+		}
+
+		switch {
+		case mv.Kind == RECEIPT:
+			entry.Received = mv.Quantity.String()
+			if entry.Party == "" {
+				entry.Party = supplier
+			}
+		case mv.delta().Sign() < 0:
+			entry.Supplied = mv.delta().Neg().String()
+		default:
+			entry.Received = mv.delta().String()
+		}
+
+		register.Entries = append(register.Entries, entry)
+	}
+
+	return register
+}
+
+// userName returns the name of the user with the given id or the id itself for removed users
+func (m *madminHandler) userName(id string) string {
+	if id == "" {
+		return ""
+	}
+	if u, ok := m.userManager.ReadUserById(id); ok {
+		return u.Name()
+	}
+	return id
+}
+
+func (cr *controlledRegister) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(registerColumns); err != nil {
+		return err
+	}
+	for i := range cr.Entries {
+		if err := cw.Write(cr.Entries[i].columns()); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (cr *controlledRegister) writePDF(w io.Writer) error {
+	var (
+		pdf       = gofpdf.New("L", "mm", "A4", "")
+		tr        = pdf.UnicodeTranslatorFromDescriptor("")
+		widths    = []float64{32, 20, 38, 30, 30, 22, 22, 22, 25, 25}
+		rowHeight = 6.0
+	)
+
+	pdf.SetTitle(fmt.Sprintf("Controlled drugs register - %s", cr.Name), true)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 10, tr(fmt.Sprintf("Controlled drugs register - %s (schedule %s)", cr.Name, cr.Schedule)), "", 1, "L", false, 0, "")
+
+	pdf.SetFont("Helvetica", "B", 7)
+	for i, column := range registerColumns {
+		pdf.CellFormat(widths[i], rowHeight, column, "1", 0, "C", false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 7)
+	for i := range cr.Entries {
+		for j, value := range cr.Entries[i].columns() {
+			pdf.CellFormat(widths[j], rowHeight, tr(value), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	return pdf.Output(w)
+}
+
+// Handler for GET /controlled/<id>/register
+//
+// Returns the controlled drugs register of the stock item with <id>
+// as JSON or, with ?format=csv or ?format=pdf, as a CSV or PDF document.
+func (m *madminHandler) controlledRegisterHandler(w http.ResponseWriter, r *http.Request) {
+	item, ok := m.warehouse.ReadStock(mux.Vars(r)["id"])
+	if !ok || !item.IsControlled() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	register := m.newControlledRegister(item)
+
+	var err error
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		respondJSON(w, http.StatusOK, register)
+		return
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"register-%s.csv\"", item.ID()))
+		err = register.writeCSV(w)
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"register-%s.pdf\"", item.ID()))
+		err = register.writePDF(w)
+	default:
+		respondBadRequest(w, ValidationErrors{{"format", "supported formats are json, csv and pdf"}})
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error while writing response: %s", err)
+	}
+}
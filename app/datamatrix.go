@@ -0,0 +1,286 @@
+package app
+
+import (
+	"errors"
+	"image"
+	"image/color"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/utils"
+)
+
+// The DataMatrix encoder of the barcode package cannot encode FNC1, which GS1 DataMatrix codes
+// start with and use as the separator of their fields, so the labels' DataMatrix codes are
+// encoded here. Only ASCII encodation and the square ECC 200 sizes with a single
+// Reed-Solomon block are supported, which are up to 48x48 modules.
+
+const (
+	dmFNC1       = 232
+	dmUpperShift = 235
+	dmPad        = 129
+)
+
+// dmSize is a square ECC 200 symbol size
+type dmSize struct {
+	// modules is the width and height of the symbol including its finder patterns
+	modules int
+	// regions is the number of data regions in each row and column of the symbol
+	regions int
+
+	dataCodewords int
+	eccCodewords  int
+}
+
+// regionModules returns the width and height of a data region without its finder patterns
+func (s dmSize) regionModules() int {
+	return s.modules/s.regions - 2
+}
+
+var dmSizes = []dmSize{
+	{10, 1, 3, 5},
+	{12, 1, 5, 7},
+	{14, 1, 8, 10},
+	{16, 1, 12, 12},
+	{18, 1, 18, 14},
+	{20, 1, 22, 18},
+	{22, 1, 30, 20},
+	{24, 1, 36, 24},
+	{26, 1, 44, 28},
+	{32, 2, 62, 36},
+	{36, 2, 86, 42},
+	{40, 2, 114, 48},
+	{44, 2, 144, 56},
+	{48, 2, 174, 68},
+}
+
+var dmReedSolomon = utils.NewReedSolomonEncoder(utils.NewGaloisField(301, 256, 1))
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// dmCodewords encodes the content in ASCII encodation. GS1 codes start with FNC1
+// and their group separators are encoded as FNC1.
+func dmCodewords(content string, gs1 bool) ([]byte, error) {
+	codewords := make([]byte, 0, len(content)+1)
+	if gs1 {
+		codewords = append(codewords, dmFNC1)
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case isDigit(c) && i+1 < len(content) && isDigit(content[i+1]):
+			// two digits share a codeword
+			codewords = append(codewords, 130+(c-'0')*10+(content[i+1]-'0'))
+			i++
+		case gs1 && c == gs1GroupSeparator:
+			codewords = append(codewords, dmFNC1)
+		case gs1 && c > 127:
+			return nil, errors.New("GS1 codes can only contain ASCII characters")
+		case c > 127:
+			codewords = append(codewords, dmUpperShift, c-127)
+		default:
+			codewords = append(codewords, c+1)
+		}
+	}
+	return codewords, nil
+}
+
+// encodeDataMatrix encodes the content in the smallest DataMatrix symbol that fits it
+func encodeDataMatrix(content string, gs1 bool) (barcode.Barcode, error) {
+	data, err := dmCodewords(content, gs1)
+	if err != nil {
+		return nil, err
+	}
+
+	var size dmSize
+	for _, s := range dmSizes {
+		if s.dataCodewords >= len(data) {
+			size = s
+			break
+		}
+	}
+	if size.modules == 0 {
+		return nil, errBarcodeTooLarge
+	}
+
+	// the first pad codeword is 129, the following ones are randomized by their position
+	if len(data) < size.dataCodewords {
+		data = append(data, dmPad)
+	}
+	for len(data) < size.dataCodewords {
+		pad := (149*(len(data)+1))%253 + 1 + dmPad
+		if pad > 254 {
+			pad -= 254
+		}
+		data = append(data, byte(pad))
+	}
+
+	ints := make([]int, len(data))
+	for i, c := range data {
+		ints[i] = int(c)
+	}
+	for _, c := range dmReedSolomon.Encode(ints, size.eccCodewords) {
+		data = append(data, byte(c))
+	}
+
+	return newDataMatrixCode(content, size, placeCodewords(data, size.regions*size.regionModules())), nil
+}
+
+// dmPlacement places the bits of the codewords in the mapping matrix of a symbol,
+// which is the symbol without its finder patterns
+type dmPlacement struct {
+	size      int
+	dark, set []bool
+	codewords []byte
+	next      int
+}
+
+// placeCodewords returns the modules of the mapping matrix with the given size, row by row
+func placeCodewords(codewords []byte, size int) []bool {
+	p := &dmPlacement{
+		size:      size,
+		dark:      make([]bool, size*size),
+		set:       make([]bool, size*size),
+		codewords: codewords,
+	}
+
+	// the codewords are placed in diagonal zigzags starting from the top left corner
+	row, col := 4, 0
+	for row < size || col < size {
+		if row == size && col == 0 {
+			p.corner([8][2]int{{size - 1, 0}, {size - 1, 1}, {size - 1, 2}, {0, size - 2}, {0, size - 1}, {1, size - 1}, {2, size - 1}, {3, size - 1}})
+		}
+		if row == size-2 && col == 0 && size%4 != 0 {
+			p.corner([8][2]int{{size - 3, 0}, {size - 2, 0}, {size - 1, 0}, {0, size - 4}, {0, size - 3}, {0, size - 2}, {0, size - 1}, {1, size - 1}})
+		}
+		if row == size-2 && col == 0 && size%8 == 4 {
+			p.corner([8][2]int{{size - 3, 0}, {size - 2, 0}, {size - 1, 0}, {0, size - 2}, {0, size - 1}, {1, size - 1}, {2, size - 1}, {3, size - 1}})
+		}
+		if row == size+4 && col == 2 && size%8 == 0 {
+			p.corner([8][2]int{{size - 1, 0}, {size - 1, size - 1}, {0, size - 3}, {0, size - 2}, {0, size - 1}, {1, size - 3}, {1, size - 2}, {1, size - 1}})
+		}
+
+		for {
+			if row < size && col >= 0 && !p.set[row*size+col] {
+				p.utah(row, col)
+			}
+			if row, col = row-2, col+2; row < 0 || col >= size {
+				break
+			}
+		}
+		row, col = row+1, col+3
+
+		for {
+			if row >= 0 && col < size && !p.set[row*size+col] {
+				p.utah(row, col)
+			}
+			if row, col = row+2, col-2; row >= size || col < 0 {
+				break
+			}
+		}
+		row, col = row+3, col+1
+	}
+
+	// the bottom right corner of some sizes is not used by the codewords
+	if !p.set[size*size-1] {
+		p.dark[size*size-1] = true
+		p.dark[(size-1)*size-2] = true
+	}
+	return p.dark
+}
+
+// module sets a bit of the current codeword, the first bit is the most significant one.
+// Positions outside of the matrix wrap around to the other side.
+func (p *dmPlacement) module(row, col, bit int) {
+	if row < 0 {
+		row += p.size
+		col += 4 - (p.size+4)%8
+	}
+	if col < 0 {
+		col += p.size
+		row += 4 - (p.size+4)%8
+	}
+
+	i := row*p.size + col
+	p.set[i] = true
+	p.dark[i] = p.codewords[p.next]&(0x80>>uint(bit)) != 0
+}
+
+// utah places the current codeword in the standard shape with its last bit at row and col
+func (p *dmPlacement) utah(row, col int) {
+	shape := [8][2]int{{row - 2, col - 2}, {row - 2, col - 1}, {row - 1, col - 2}, {row - 1, col - 1}, {row - 1, col}, {row, col - 2}, {row, col - 1}, {row, col}}
+	p.corner(shape)
+}
+
+// corner places the current codeword in the given positions
+func (p *dmPlacement) corner(positions [8][2]int) {
+	for bit, pos := range positions {
+		p.module(pos[0], pos[1], bit)
+	}
+	p.next++
+}
+
+// dataMatrixCode is a DataMatrix symbol
+type dataMatrixCode struct {
+	content string
+	modules int
+	dark    []bool
+}
+
+// newDataMatrixCode adds the finder patterns of the data regions to the mapping matrix
+func newDataMatrixCode(content string, size dmSize, mapping []bool) *dataMatrixCode {
+	var (
+		code = &dataMatrixCode{content: content, modules: size.modules, dark: make([]bool, size.modules*size.modules)}
+		n    = size.regionModules()
+		m    = size.regions * n
+	)
+
+	for vr := 0; vr < size.regions; vr++ {
+		for hr := 0; hr < size.regions; hr++ {
+			top, left := vr*(n+2), hr*(n+2)
+
+			// solid left and bottom edges and alternating top and right edges
+			for i := 0; i < n+2; i++ {
+				code.set(left+i, top, i%2 == 0)
+				code.set(left+i, top+n+1, true)
+				code.set(left, top+i, true)
+				code.set(left+n+1, top+i, i%2 == 1)
+			}
+			for y := 0; y < n; y++ {
+				for x := 0; x < n; x++ {
+					code.set(left+1+x, top+1+y, mapping[(vr*n+y)*m+hr*n+x])
+				}
+			}
+		}
+	}
+	return code
+}
+
+func (c *dataMatrixCode) set(x, y int, dark bool) {
+	c.dark[y*c.modules+x] = dark
+}
+
+func (c *dataMatrixCode) Content() string {
+	return c.content
+}
+
+func (c *dataMatrixCode) Metadata() barcode.Metadata {
+	return barcode.Metadata{CodeKind: barcode.TypeDataMatrix, Dimensions: 2}
+}
+
+func (c *dataMatrixCode) ColorModel() color.Model {
+	return color.Gray16Model
+}
+
+func (c *dataMatrixCode) Bounds() image.Rectangle {
+	return image.Rect(0, 0, c.modules, c.modules)
+}
+
+func (c *dataMatrixCode) At(x, y int) color.Color {
+	if c.dark[y*c.modules+x] {
+		return color.Black
+	}
+	return color.White
+}
@@ -2,12 +2,16 @@ package app
 
 import (
 	"database/sql"
+	"fmt"
 
 	_ "github.com/mattn/go-sqlite3" // needed for for working with sqlite3
 )
 
+// newDB opens the SQLite database at dbPath. Transactions take the write lock when they begin,
+// so concurrent transactions that read before they write wait for each other instead of
+// failing to upgrade their locks with "database is locked".
 func newDB(dbPath string) (db *sql.DB) {
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", dbPath+"?_txlock=immediate")
 	if err != nil {
 		panic(err)
 	}
@@ -19,3 +23,37 @@ func newDB(dbPath string) (db *sql.DB) {
 
 	return
 }
+
+// addColumnIfMissing adds a column to a table that was created by an older version of madmin
+func addColumnIfMissing(db *sql.DB, table, column, definition string) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name, ctype  string
+			notNull, pk  int
+			defaultValue interface{}
+		)
+		err = rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk)
+		if err != nil {
+			panic(err)
+		}
+		if name == column {
+			return
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		panic(err)
+	}
+}
@@ -23,14 +23,14 @@ func NewDistributor(name string) (Distributor, error) {
 	return &defaultDistributor{id: id, name: name}, nil
 }
 
-func (d defaultDistributor) ID() string {
+func (d *defaultDistributor) ID() string {
 	return d.id
 }
 
-func (d defaultDistributor) Name() string {
+func (d *defaultDistributor) Name() string {
 	return d.name
 }
-func (d defaultDistributor) SetName(name string) {
+func (d *defaultDistributor) SetName(name string) {
 	d.name = name
 }
 
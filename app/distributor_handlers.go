@@ -0,0 +1,140 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (m *madminHandler) distributorsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listDistributorsHandler(w, r)
+	case "POST":
+		m.addDistributorHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+func (m *madminHandler) distributorHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getDistributorHandler(w, r)
+	case "DELETE":
+		m.removeDistributorHandler(w, r)
+	case "PUT":
+		m.updateDistributorHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /distributors/
+//
+// Lists the distributors ordered by name.
+func (m *madminHandler) listDistributorsHandler(w http.ResponseWriter, r *http.Request) {
+	distributors := m.warehouse.Distributors()
+
+	resp := make([]*DistributorDTO, 0, len(distributors))
+	for _, d := range distributors {
+		resp = append(resp, newDistributorDTO(d))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /distributors/
+//
+// Adds a distributor and returns its id.
+func (m *madminHandler) addDistributorHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewDistributorDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+	if dto.Name == "" {
+		respondBadRequest(w, ValidationErrors{{"name", "no name set"}})
+		return
+	}
+
+	d, err := NewDistributor(dto.Name)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+	if err := m.warehouse.CreateDistributor(d, m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, d.ID())
+}
+
+// Handler for GET /distributors/<id>
+//
+// Returns JSON with data for the distributor with the given id.
+func (m *madminHandler) getDistributorHandler(w http.ResponseWriter, r *http.Request) {
+	d, ok := m.warehouse.ReadDistributor(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newDistributorDTO(d))
+}
+
+// Handler for PUT /distributors/<id>
+//
+// Renames the distributor with <id>.
+func (m *madminHandler) updateDistributorHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewDistributorDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	d, ok := m.warehouse.ReadDistributor(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if dto.Name == "" {
+		respondBadRequest(w, ValidationErrors{{"name", "no name set"}})
+		return
+	}
+
+	d.SetName(dto.Name)
+	if err := m.warehouse.UpdateDistributor(d, m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for DELETE /distributors/<id>
+//
+// Removes the distributor with <id> if no stock items are supplied by it.
+func (m *madminHandler) removeDistributorHandler(w http.ResponseWriter, r *http.Request) {
+	d, ok := m.warehouse.ReadDistributor(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	for _, item := range m.warehouse.Stock() {
+		if item.DistributorID() == d.ID() {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, "Error in removing distributor: stock items are supplied by the distributor")
+			return
+		}
+	}
+
+	if err := m.warehouse.DeleteDistributor(d.ID(), m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,282 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Dosing holds the dosing rules from the label of a medicine
+type Dosing struct {
+	StockID string
+	// Concentration is the amount of the active substance in mg per unit of the stock item
+	Concentration decimal.Decimal
+	// Increment is the smallest quantity of the item that can be dispensed,
+	// e.g. 0.5 for tablets that can be halved. Doses are rounded to it.
+	Increment decimal.Decimal
+
+	Ranges []DoseRange
+}
+
+// DoseRange is the labelled dose of a medicine for a species in mg per kg of body weight
+type DoseRange struct {
+	Species    string
+	MinMgPerKg decimal.Decimal
+	MaxMgPerKg decimal.Decimal
+}
+
+// Dose is a dose of a medicine calculated for a patient's species and weight
+type Dose struct {
+	StockID string
+	Species string
+	// Weight is the body weight in kg
+	Weight decimal.Decimal
+	// MgPerKg is the dose that was aimed for, the middle of the labelled range if none was asked for
+	MgPerKg decimal.Decimal
+	Range   DoseRange
+
+	// Quantity is the recommended quantity in the units of the stock item
+	Quantity decimal.Decimal
+	// Mg and ActualMgPerKg is what the recommended quantity contains after rounding
+	Mg            decimal.Decimal
+	ActualMgPerKg decimal.Decimal
+
+	Warnings []string
+}
+
+func (wh *dafaultWarehouse) initDosingTables() {
+	dosingTables := `
+	CREATE TABLE IF NOT EXISTS
+		dosings (
+			stock_id TEXT NOT NULL PRIMARY KEY,
+			concentration NUMERIC NOT NULL,
+			increment NUMERIC NOT NULL,
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id)
+	);
+	CREATE TABLE IF NOT EXISTS
+		dose_ranges (
+			stock_id TEXT NOT NULL,
+			species TEXT NOT NULL,
+			min_mg_per_kg NUMERIC NOT NULL,
+			max_mg_per_kg NUMERIC NOT NULL,
+			PRIMARY KEY (stock_id, species),
+			FOREIGN KEY (stock_id) REFERENCES dosings (stock_id)
+	);
+	`
+	_, err := wh.database.Exec(dosingTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// normalizeSpecies makes species names comparable, "Dog " and "dog" are the same species
+func normalizeSpecies(species string) string {
+	return strings.ToLower(strings.TrimSpace(species))
+}
+
+func (d *Dosing) validate(item Stock) error {
+	errs := ValidationErrors{}
+
+	if d.Concentration.Sign() <= 0 {
+		errs = append(errs, ValidationError{"concentration", "concentration must be positive"})
+	}
+	if d.Increment.Sign() <= 0 {
+		errs = append(errs, ValidationError{"increment", "increment must be positive"})
+	} else if err := item.QuantityRule().Validate("increment", d.Increment); err != nil {
+		errs = append(errs, err.(ValidationErrors)...)
+	}
+	if len(d.Ranges) == 0 {
+		errs = append(errs, ValidationError{"ranges", "no labelled doses"})
+	}
+
+	species := make(map[string]bool)
+	for i, r := range d.Ranges {
+		field := func(name string) string { return fmt.Sprintf("ranges[%d].%s", i, name) }
+
+		name := normalizeSpecies(r.Species)
+		switch {
+		case name == "":
+			errs = append(errs, ValidationError{field("species"), "no species set"})
+		case species[name]:
+			errs = append(errs, ValidationError{field("species"), "the species has another range"})
+		}
+		species[name] = true
+
+		if r.MinMgPerKg.Sign() <= 0 {
+			errs = append(errs, ValidationError{field("minMgPerKg"), "dose must be positive"})
+		}
+		if r.MaxMgPerKg.LessThan(r.MinMgPerKg) {
+			errs = append(errs, ValidationError{field("maxMgPerKg"), "maximum dose is less than the minimum"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SetDosing replaces the dosing rules of a stock item
+func (wh *dafaultWarehouse) SetDosing(d *Dosing) error {
+	item, ok := wh.ReadStock(d.StockID)
+	if !ok {
+		return ValidationErrors{{"stockID", "no such stock item"}}
+	}
+	if err := d.validate(item); err != nil {
+		return err
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO
+			dosings (
+				stock_id,
+				concentration,
+				increment)
+		VALUES(?, ?, ?)
+	`, d.StockID, d.Concentration.String(), d.Increment.String())
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM dose_ranges WHERE stock_id = ?`, d.StockID)
+	if err != nil {
+		panic(err)
+	}
+	for i := range d.Ranges {
+		r := &d.Ranges[i]
+		r.Species = normalizeSpecies(r.Species)
+
+		_, err = tx.Exec(`
+			INSERT INTO
+				dose_ranges (
+					stock_id,
+					species,
+					min_mg_per_kg,
+					max_mg_per_kg)
+			VALUES(?, ?, ?, ?)
+		`, d.StockID, r.Species, r.MinMgPerKg.String(), r.MaxMgPerKg.String())
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// ReadDosing returns the dosing rules of a stock item, the ranges are ordered by species
+func (wh *dafaultWarehouse) ReadDosing(stockID string) (*Dosing, bool) {
+	d := &Dosing{StockID: stockID}
+	err := wh.database.QueryRow(`SELECT concentration, increment FROM dosings WHERE stock_id = ?`, stockID).Scan(&d.Concentration, &d.Increment)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	rows, err := wh.database.Query(`
+		SELECT
+			species,
+			min_mg_per_kg,
+			max_mg_per_kg
+		FROM
+			dose_ranges
+		WHERE
+			stock_id = ?
+		ORDER BY
+			species
+	`, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	d.Ranges = make([]DoseRange, 0)
+	for rows.Next() {
+		r := DoseRange{}
+		if err = rows.Scan(&r.Species, &r.MinMgPerKg, &r.MaxMgPerKg); err != nil {
+			panic(err)
+		}
+		d.Ranges = append(d.Ranges, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return d, true
+}
+
+// CalculateDose returns the quantity of a stock item for a patient of the species with the weight in kg.
+// The dose aims for mgPerKg, or for the middle of the labelled range if mgPerKg is nil, and
+// is rounded to the nearest dispensable quantity. The dose has warnings if it is outside the labelled range.
+func (wh *dafaultWarehouse) CalculateDose(stockID, species string, weight decimal.Decimal, mgPerKg *decimal.Decimal) (*Dose, error) {
+	d, ok := wh.ReadDosing(stockID)
+	if !ok {
+		return nil, ValidationErrors{{"stockID", "the stock item has no dosing rules"}}
+	}
+
+	dose := &Dose{StockID: stockID, Species: normalizeSpecies(species), Weight: weight}
+
+	errs := ValidationErrors{}
+	found := false
+	for _, r := range d.Ranges {
+		if r.Species == dose.Species {
+			dose.Range, found = r, true
+		}
+	}
+	if !found {
+		errs = append(errs, ValidationError{"species", "no labelled dose for the species"})
+	}
+	if weight.Sign() <= 0 {
+		errs = append(errs, ValidationError{"weight", "weight must be positive"})
+	}
+	if mgPerKg != nil && mgPerKg.Sign() <= 0 {
+		errs = append(errs, ValidationError{"mgPerKg", "dose must be positive"})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	if mgPerKg != nil {
+		dose.MgPerKg = *mgPerKg
+	} else {
+		dose.MgPerKg = dose.Range.MinMgPerKg.Add(dose.Range.MaxMgPerKg).Div(decimal.New(2, 0))
+	}
+
+	exact := weight.Mul(dose.MgPerKg).Div(d.Concentration)
+	dose.Quantity = exact.Div(d.Increment).Round(0).Mul(d.Increment)
+	if dose.Quantity.Sign() == 0 {
+		dose.Quantity = d.Increment
+		dose.Warnings = append(dose.Warnings, fmt.Sprintf("the dose is less than the smallest dispensable quantity of %s", d.Increment))
+	}
+	dose.Mg = dose.Quantity.Mul(d.Concentration)
+	dose.ActualMgPerKg = dose.Mg.Div(weight).Round(2)
+
+	if dose.MgPerKg.LessThan(dose.Range.MinMgPerKg) || dose.MgPerKg.GreaterThan(dose.Range.MaxMgPerKg) {
+		dose.Warnings = append(dose.Warnings, fmt.Sprintf("the requested %s mg/kg is outside the labelled range of %s-%s mg/kg",
+			dose.MgPerKg, dose.Range.MinMgPerKg, dose.Range.MaxMgPerKg))
+	}
+	switch {
+	case dose.ActualMgPerKg.LessThan(dose.Range.MinMgPerKg):
+		dose.Warnings = append(dose.Warnings, fmt.Sprintf("%s mg/kg is below the labelled range of %s-%s mg/kg",
+			dose.ActualMgPerKg, dose.Range.MinMgPerKg, dose.Range.MaxMgPerKg))
+	case dose.ActualMgPerKg.GreaterThan(dose.Range.MaxMgPerKg):
+		dose.Warnings = append(dose.Warnings, fmt.Sprintf("%s mg/kg is above the labelled range of %s-%s mg/kg",
+			dose.ActualMgPerKg, dose.Range.MinMgPerKg, dose.Range.MaxMgPerKg))
+	}
+
+	return dose, nil
+}
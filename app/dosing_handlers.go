@@ -0,0 +1,106 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+func (m *madminHandler) dosingHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getDosingHandler(w, r)
+	case "PUT":
+		m.setDosingHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /stock/<id>/dosing
+//
+// Returns the dosing rules of the stock item with <id>.
+func (m *madminHandler) getDosingHandler(w http.ResponseWriter, r *http.Request) {
+	d, ok := m.warehouse.ReadDosing(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newDosingDTO(d))
+}
+
+// Handler for PUT /stock/<id>/dosing
+//
+// Replaces the dosing rules of the stock item with <id>.
+func (m *madminHandler) setDosingHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &DosingDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+	dto.StockID = id
+
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	d, err := dto.dosing()
+	if err == nil {
+		err = m.warehouse.SetDosing(d)
+	}
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	d, _ = m.warehouse.ReadDosing(id)
+	respondJSON(w, http.StatusOK, newDosingDTO(d))
+}
+
+// Handler for POST /stock/<id>/dose
+//
+// Calculates the quantity of the stock item with <id> for a patient's species and weight.
+// The response has warnings if the dose is outside the labelled range.
+func (m *madminHandler) doseHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &NewDoseDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	errs := ValidationErrors{}
+	weight, err := decimal.NewFromString(dto.Weight)
+	if err != nil {
+		errs = append(errs, ValidationError{"weight", "invalid weight"})
+	}
+	var mgPerKg *decimal.Decimal
+	if dto.MgPerKg != "" {
+		dose, err := decimal.NewFromString(dto.MgPerKg)
+		if err != nil {
+			errs = append(errs, ValidationError{"mgPerKg", "invalid dose"})
+		}
+		mgPerKg = &dose
+	}
+	if len(errs) > 0 {
+		respondBadRequest(w, errs)
+		return
+	}
+
+	dose, err := m.warehouse.CalculateDose(id, dto.Species, weight, mgPerKg)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newDoseDTO(dose))
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestDose(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+		wh            = madminHandler.warehouse
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	wh.CreateStock(item, nil)
+
+	if _, err := wh.CalculateDose(item.ID(), "dog", decimal.New(20, 0), nil); err == nil {
+		t.Fatalf(`CalculateDose returns a dose for an item without dosing rules`)
+	}
+
+	invalid := &Dosing{StockID: item.ID(), Concentration: decimal.New(50, 0), Increment: decimal.New(5, -1), Ranges: []DoseRange{
+		{Species: "Dog", MinMgPerKg: decimal.New(5, 0), MaxMgPerKg: decimal.New(10, 0)},
+		{Species: "dog ", MinMgPerKg: decimal.New(5, 0), MaxMgPerKg: decimal.New(4, 0)},
+	}}
+	if err := wh.SetDosing(invalid); err == nil {
+		t.Fatalf(`SetDosing accepts two ranges for a species and a maximum below the minimum`)
+	}
+
+	// 50 mg tablets that can be halved
+	body, _ := json.Marshal(&DosingDTO{Concentration: "50", Increment: "0.5", Ranges: []*DoseRangeDTO{
+		{Species: "Dog", MinMgPerKg: "5", MaxMgPerKg: "10"},
+		{Species: "Cat", MinMgPerKg: "2", MaxMgPerKg: "3"},
+	}})
+	req, _ := http.NewRequest("PUT", buildURL(s.URL, fmt.Sprintf("/data/stock/%s/dosing", item.ID())), bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending PUT request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	mgPerKg := decimal.New(12, 0)
+	tests := []struct {
+		species  string
+		weight   decimal.Decimal
+		mgPerKg  *decimal.Decimal
+		quantity decimal.Decimal
+		warnings int
+	}{
+		{"dog", decimal.New(20, 0), nil, decimal.New(3, 0), 0},
+		{"Dog", decimal.New(3, 0), nil, decimal.New(5, -1), 0},
+		{"cat", decimal.New(1, 0), nil, decimal.New(5, -1), 2},
+		{"dog", decimal.New(20, 0), &mgPerKg, decimal.New(5, 0), 2},
+	}
+	for i, test := range tests {
+		dose, err := wh.CalculateDose(item.ID(), test.species, test.weight, test.mgPerKg)
+		if err != nil {
+			t.Fatalf(`CalculateDose returns an error for dose %d: %s`, i, err)
+		}
+		if !dose.Quantity.Equal(test.quantity) || len(dose.Warnings) != test.warnings {
+			t.Errorf(`Expected %s with %d warnings for dose %d, got %s with %v`, test.quantity, test.warnings, i, dose.Quantity, dose.Warnings)
+		}
+	}
+
+	if _, err := wh.CalculateDose(item.ID(), "horse", decimal.New(500, 0), nil); err == nil {
+		t.Fatalf(`CalculateDose returns a dose for a species without a labelled dose`)
+	}
+
+	body, _ = json.Marshal(&NewDoseDTO{Species: "dog", Weight: "20"})
+	resp, err = http.Post(buildURL(s.URL, fmt.Sprintf("/data/stock/%s/dose", item.ID())), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error sending POST request: %s", err)
+	}
+	dose := &DoseDTO{}
+	json.NewDecoder(resp.Body).Decode(dose)
+	resp.Body.Close()
+	if dose.Quantity != "3" || dose.Mg != "150" || dose.ActualMgPerKg != "7.5" || len(dose.Warnings) != 0 {
+		t.Fatalf("Unexpected dose %+v", dose)
+	}
+}
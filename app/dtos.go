@@ -1,5 +1,14 @@
 package app
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
 // CollectionResponseDTO is a data transfer object needed for the RESTful API implementation.
 // It contains short information about the collection and the urls for each item in the collection.
 type CollectionResponseDTO struct {
@@ -21,11 +30,44 @@ type StockDTO struct {
 	MinQuantity    string `json:"minQuantity"`
 
 	DistributorID string `json:"distributorID"`
+
+	ControlledSchedule   string `json:"controlledSchedule,omitempty"`
+	PrescriptionRequired bool   `json:"prescriptionRequired"`
+
+	// Barcodes are only set in the responses for a single stock item
+	Barcodes []string `json:"barcodes,omitempty"`
+}
+
+func newStockDTO(item Stock) *StockDTO {
+	dto := &StockDTO{
+		ID:                   item.ID(),
+		Name:                 item.Name(),
+		Type:                 item.Type(),
+		Quantity:             item.Quantity().String(),
+		MinQuantity:          item.MinQuantity().String(),
+		DistributorID:        item.DistributorID(),
+		ControlledSchedule:   item.ControlledSchedule(),
+		PrescriptionRequired: item.PrescriptionRequired(),
+	}
+	if item.IsExpirable() {
+		dto.ExpirationDate = item.ExpirationDate().UTC().Format(dateLayout)
+	}
+	return dto
+}
+
+// DistributorDTO is a data transfer object that can be used for marshaling a distributor
+type DistributorDTO struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+func newDistributorDTO(d Distributor) *DistributorDTO {
+	return &DistributorDTO{ID: d.ID(), Name: d.Name()}
 }
 
 // NewStockDTO is a data transfer object that can be used between
 // reading a JSON with data for a new stock item and
-// creating the new stock item with NewStock(*NewStockDTO) (Stock, error)
+// creating the new stock item with NewStock(*NewStockDTO, StockTypeReader) (Stock, error)
 type NewStockDTO struct {
 	Name string    `json:"name"`
 	Type stockType `json:"type"`
@@ -36,4 +78,2103 @@ type NewStockDTO struct {
 	MinQuantity    string `json:"minQuantity"`
 
 	DistributorID string `json:"distributorID"`
+
+	ControlledSchedule   string `json:"controlledSchedule,omitempty"`
+	PrescriptionRequired bool   `json:"prescriptionRequired"`
+}
+
+// StockTypeDTO is a data transfer object that can be used for marshaling and unmarshaling
+// the stock types in the stock type registry
+type StockTypeDTO struct {
+	ID   stockType `json:"id"`
+	Name string    `json:"name"`
+
+	Expirable bool `json:"expirable"`
+
+	IntegerOnly      bool  `json:"integerOnly"`
+	MaxDecimalPlaces int32 `json:"maxDecimalPlaces"`
+	NonNegative      bool  `json:"nonNegative"`
+
+	ParentID *stockType `json:"parentID,omitempty"`
+}
+
+func newStockTypeDTO(info StockTypeInfo) *StockTypeDTO {
+	return &StockTypeDTO{
+		ID:               info.ID,
+		Name:             info.Name,
+		Expirable:        info.Expirable,
+		IntegerOnly:      info.QuantityRule.IntegerOnly,
+		MaxDecimalPlaces: info.QuantityRule.MaxDecimalPlaces,
+		NonNegative:      info.QuantityRule.NonNegative,
+		ParentID:         info.ParentID,
+	}
+}
+
+func (dto *StockTypeDTO) stockTypeInfo() StockTypeInfo {
+	return StockTypeInfo{
+		ID:        dto.ID,
+		Name:      dto.Name,
+		Expirable: dto.Expirable,
+		QuantityRule: quantityRule{
+			IntegerOnly:      dto.IntegerOnly,
+			MaxDecimalPlaces: dto.MaxDecimalPlaces,
+			NonNegative:      dto.NonNegative,
+		},
+		ParentID: dto.ParentID,
+	}
+}
+
+// MovementDTO is a data transfer object that can be used for marshaling
+// an entry of the stock movements ledger
+type MovementDTO struct {
+	ID      string       `json:"id"`
+	StockID string       `json:"stockID"`
+	Kind    movementKind `json:"kind"`
+
+	Quantity string `json:"quantity"`
+	Balance  string `json:"balance"`
+
+	Time   string `json:"time"`
+	UserID string `json:"userID"`
+
+	Reference  string `json:"reference,omitempty"`
+	PatientRef string `json:"patientRef,omitempty"`
+	Prescriber string `json:"prescriber,omitempty"`
+	WitnessID  string `json:"witnessID,omitempty"`
+
+	LotID      string `json:"lotID,omitempty"`
+	LocationID string `json:"locationID,omitempty"`
+	PatientID  string `json:"patientID,omitempty"`
+
+	PrescriptionID string `json:"prescriptionID,omitempty"`
+
+	// Substitutes are suggested when a dispense leaves the stock item insufficient
+	Substitutes []*SubstituteDTO `json:"substitutes,omitempty"`
+}
+
+func newMovementDTO(mv *Movement) *MovementDTO {
+	return &MovementDTO{
+		ID:         mv.ID,
+		StockID:    mv.StockID,
+		Kind:       mv.Kind,
+		Quantity:   mv.Quantity.String(),
+		Balance:    mv.Balance.String(),
+		Time:       mv.Time.UTC().Format(dateLayout),
+		UserID:     mv.UserID,
+		Reference:  mv.Reference,
+		PatientRef: mv.PatientRef,
+		Prescriber: mv.Prescriber,
+		WitnessID:  mv.WitnessID,
+		LotID:      mv.LotID,
+		LocationID: mv.LocationID,
+		PatientID:  mv.PatientID,
+
+		PrescriptionID: mv.PrescriptionID,
+	}
+}
+
+// NewMovementDTO is a data transfer object that can be used for unmarshaling
+// a request for a new stock movement.
+// Movements of controlled substances must be witnessed by a second user,
+// who confirms them with their password.
+type NewMovementDTO struct {
+	Kind     movementKind `json:"kind"`
+	Quantity string       `json:"quantity"`
+
+	Reference  string `json:"reference"`
+	PatientRef string `json:"patientRef"`
+	Prescriber string `json:"prescriber"`
+
+	Witness         string `json:"witness"`
+	WitnessPassword string `json:"witnessPassword"`
+
+	// LotID is set for movements of an existing lot, Lot is set for receipts of a new lot
+	LotID string     `json:"lotID"`
+	Lot   *NewLotDTO `json:"lot"`
+
+	// LocationID is the storage location of a movement of stock without lots
+	LocationID string `json:"locationID"`
+
+	// PatientID is the patient that stock is dispensed to
+	PatientID string `json:"patientID"`
+	// PrescriptionID is the prescription that stock is dispensed against,
+	// its patient and prescriber are used if they are not set
+	PrescriptionID string `json:"prescriptionID"`
+}
+
+// LotDTO is a data transfer object that can be used for marshaling a stock lot
+type LotDTO struct {
+	ID      string `json:"id"`
+	StockID string `json:"stockID"`
+	Number  string `json:"number"`
+
+	ExpirationDate string `json:"expirationDate,omitempty"`
+	Quantity       string `json:"quantity"`
+
+	LocationID string `json:"locationID"`
+	UnitCost   string `json:"unitCost"`
+
+	State       lotState `json:"state"`
+	StateReason string   `json:"stateReason,omitempty"`
+
+	Received string `json:"received"`
+}
+
+func newLotDTO(lot *Lot) *LotDTO {
+	dto := &LotDTO{
+		ID:          lot.ID,
+		StockID:     lot.StockID,
+		Number:      lot.Number,
+		Quantity:    lot.Quantity.String(),
+		LocationID:  lot.LocationID,
+		UnitCost:    lot.UnitCost.String(),
+		State:       lot.State,
+		StateReason: lot.StateReason,
+		Received:    lot.Received.UTC().Format(dateLayout),
+	}
+	if lot.ExpirationDate != nil {
+		dto.ExpirationDate = lot.ExpirationDate.UTC().Format(dateLayout)
+	}
+	return dto
+}
+
+// NewLotDTO is a data transfer object that can be used for unmarshaling
+// the data of a new lot in a receipt
+type NewLotDTO struct {
+	Number         string `json:"number"`
+	ExpirationDate string `json:"expirationDate"`
+	LocationID     string `json:"locationID"`
+	UnitCost       string `json:"unitCost"`
+}
+
+func (dto *NewLotDTO) lot() (*Lot, error) {
+	lot := &Lot{Number: dto.Number, LocationID: dto.LocationID}
+
+	if dto.UnitCost != "" {
+		unitCost, err := decimal.NewFromString(dto.UnitCost)
+		if err != nil {
+			return nil, ValidationErrors{{"lot.unitCost", "invalid unit cost"}}
+		}
+		lot.UnitCost = unitCost
+	}
+
+	if dto.ExpirationDate != "" {
+		date, err := validDateFromString(dto.ExpirationDate)
+		if err != nil {
+			return nil, ValidationErrors{{"lot.expirationDate", err.Error()}}
+		}
+		lot.ExpirationDate = &date
+	}
+
+	return lot, nil
+}
+
+// LotStateDTO is a data transfer object that can be used for unmarshaling
+// a change of a lot's state and for marshaling the lot's state history.
+// The witness is required for write-offs of controlled substances.
+type LotStateDTO struct {
+	From   lotState `json:"from,omitempty"`
+	State  lotState `json:"state"`
+	Time   string   `json:"time,omitempty"`
+	UserID string   `json:"userID,omitempty"`
+	Reason string   `json:"reason"`
+
+	Witness         string `json:"witness,omitempty"`
+	WitnessPassword string `json:"witnessPassword,omitempty"`
+}
+
+func newLotStateDTO(change *LotStateChange) *LotStateDTO {
+	return &LotStateDTO{
+		From:   change.From,
+		State:  change.To,
+		Time:   change.Time.UTC().Format(dateLayout),
+		UserID: change.UserID,
+		Reason: change.Reason,
+	}
+}
+
+// WriteOffDTO is a data transfer object that can be used for marshaling
+// a write-off in the write-off report
+type WriteOffDTO struct {
+	Time      string `json:"time"`
+	StockID   string `json:"stockID"`
+	Name      string `json:"name"`
+	LotID     string `json:"lotID,omitempty"`
+	LotNumber string `json:"lotNumber,omitempty"`
+	Quantity  string `json:"quantity"`
+	UnitCost  string `json:"unitCost"`
+	Value     string `json:"value"`
+	Reason    string `json:"reason"`
+	UserID    string `json:"userID"`
+}
+
+// WriteOffReportDTO is a data transfer object that can be used for marshaling
+// the write-offs in a period and their total value
+type WriteOffReportDTO struct {
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	TotalValue string         `json:"totalValue"`
+	WriteOffs  []*WriteOffDTO `json:"writeOffs"`
+}
+
+// UpdateLotDTO is a data transfer object that can be used for unmarshaling
+// changes to an existing lot
+type UpdateLotDTO struct {
+	LocationID string `json:"locationID"`
+}
+
+// LocationDTO is a data transfer object that can be used for marshaling and unmarshaling
+// a storage location. Locations without temperature requirements have empty temperatures.
+type LocationDTO struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	MinTemperature string `json:"minTemperature,omitempty"`
+	MaxTemperature string `json:"maxTemperature,omitempty"`
+}
+
+func newLocationDTO(sl *StorageLocation) *LocationDTO {
+	dto := &LocationDTO{ID: sl.ID, Name: sl.Name}
+	if sl.HasTemperatureRange {
+		dto.MinTemperature = sl.MinTemperature.String()
+		dto.MaxTemperature = sl.MaxTemperature.String()
+	}
+	return dto
+}
+
+func (dto *LocationDTO) location() (*StorageLocation, error) {
+	sl := &StorageLocation{ID: dto.ID, Name: dto.Name}
+	if dto.MinTemperature == "" && dto.MaxTemperature == "" {
+		return sl, nil
+	}
+
+	errs := ValidationErrors{}
+	minTemperature, err := decimal.NewFromString(dto.MinTemperature)
+	if err != nil {
+		errs = append(errs, ValidationError{"minTemperature", "invalid temperature"})
+	}
+	maxTemperature, err := decimal.NewFromString(dto.MaxTemperature)
+	if err != nil {
+		errs = append(errs, ValidationError{"maxTemperature", "invalid temperature"})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	sl.HasTemperatureRange = true
+	sl.MinTemperature = minTemperature
+	sl.MaxTemperature = maxTemperature
+	return sl, nil
+}
+
+// TemperatureReadingDTO is a data transfer object that can be used for unmarshaling
+// a reading sent by a temperature logger. Readings without time are taken at the time they are received.
+type TemperatureReadingDTO struct {
+	Time        string `json:"time"`
+	Temperature string `json:"temperature"`
+	LoggerID    string `json:"loggerID"`
+}
+
+func (dto *TemperatureReadingDTO) reading() (TemperatureReading, error) {
+	reading := TemperatureReading{Time: time.Now().UTC(), LoggerID: dto.LoggerID}
+
+	errs := ValidationErrors{}
+	if dto.Time != "" {
+		t, err := time.Parse(dateLayout, dto.Time)
+		if err != nil {
+			errs = append(errs, ValidationError{"time", err.Error()})
+		}
+		reading.Time = t
+	}
+	temperature, err := decimal.NewFromString(dto.Temperature)
+	if err != nil {
+		errs = append(errs, ValidationError{"temperature", "invalid temperature"})
+	}
+	reading.Temperature = temperature
+
+	if len(errs) > 0 {
+		return reading, errs
+	}
+	return reading, nil
+}
+
+// ExcursionDTO is a data transfer object that can be used for marshaling a temperature excursion
+type ExcursionDTO struct {
+	ID         string `json:"id"`
+	LocationID string `json:"locationID"`
+
+	Started        string `json:"started"`
+	MinTemperature string `json:"minTemperature"`
+	MaxTemperature string `json:"maxTemperature"`
+
+	LotIDs []string `json:"lotIDs"`
+
+	Reviewed   bool   `json:"reviewed"`
+	ReviewedBy string `json:"reviewedBy,omitempty"`
+	ReviewedAt string `json:"reviewedAt,omitempty"`
+	ReviewNote string `json:"reviewNote,omitempty"`
+	Released   bool   `json:"released"`
+}
+
+func newExcursionDTO(e *Excursion) *ExcursionDTO {
+	dto := &ExcursionDTO{
+		ID:             e.ID,
+		LocationID:     e.LocationID,
+		Started:        e.Started.UTC().Format(dateLayout),
+		MinTemperature: e.MinTemperature.String(),
+		MaxTemperature: e.MaxTemperature.String(),
+		LotIDs:         e.LotIDs,
+		Reviewed:       e.Reviewed,
+		ReviewedBy:     e.ReviewedBy,
+		ReviewNote:     e.ReviewNote,
+		Released:       e.Released,
+	}
+	if e.Reviewed {
+		dto.ReviewedAt = e.ReviewedAt.UTC().Format(dateLayout)
+	}
+	return dto
+}
+
+// ExcursionReviewDTO is a data transfer object that can be used for unmarshaling
+// the review of a temperature excursion. Release makes the quarantined lots available again.
+type ExcursionReviewDTO struct {
+	Release bool   `json:"release"`
+	Note    string `json:"note"`
+}
+
+// RecallDTO is a data transfer object that can be used for marshaling a recall
+// and the current state of the recalled lots
+type RecallDTO struct {
+	ID            string   `json:"id"`
+	StockID       string   `json:"stockID"`
+	DistributorID string   `json:"distributorID"`
+	LotNumbers    []string `json:"lotNumbers"`
+	Reference     string   `json:"reference"`
+	Reason        string   `json:"reason"`
+	Created       string   `json:"created"`
+	UserID        string   `json:"userID"`
+
+	Lots []*LotDTO `json:"lots"`
+}
+
+func newRecallDTO(r *Recall, lots []*Lot) *RecallDTO {
+	dto := &RecallDTO{
+		ID:            r.ID,
+		StockID:       r.StockID,
+		DistributorID: r.DistributorID,
+		LotNumbers:    r.LotNumbers,
+		Reference:     r.Reference,
+		Reason:        r.Reason,
+		Created:       r.Created.UTC().Format(dateLayout),
+		UserID:        r.UserID,
+		Lots:          make([]*LotDTO, 0, len(lots)),
+	}
+	for _, lot := range lots {
+		dto.Lots = append(dto.Lots, newLotDTO(lot))
+	}
+	return dto
+}
+
+// NewRecallDTO is a data transfer object that can be used for unmarshaling
+// a new recall. The distributor defaults to the distributor of the stock item.
+type NewRecallDTO struct {
+	StockID       string   `json:"stockID"`
+	DistributorID string   `json:"distributorID"`
+	LotNumbers    []string `json:"lotNumbers"`
+	Reference     string   `json:"reference"`
+	Reason        string   `json:"reason"`
+}
+
+func (dto *NewRecallDTO) recall() *Recall {
+	return &Recall{
+		StockID:       dto.StockID,
+		DistributorID: dto.DistributorID,
+		LotNumbers:    dto.LotNumbers,
+		Reference:     dto.Reference,
+		Reason:        dto.Reason,
+	}
+}
+
+// JobDTO is a data transfer object that can be used for marshaling
+// the status of a background job. Times are empty if the job has not run or is disabled.
+type JobDTO struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Enabled  bool   `json:"enabled"`
+	Running  bool   `json:"running"`
+
+	LastRun      string `json:"lastRun,omitempty"`
+	LastDuration string `json:"lastDuration,omitempty"`
+	LastOutcome  string `json:"lastOutcome,omitempty"`
+	LastError    string `json:"lastError,omitempty"`
+
+	NextRun string `json:"nextRun,omitempty"`
+}
+
+func newJobDTO(job *JobStatus) *JobDTO {
+	dto := &JobDTO{
+		Name:        job.Name,
+		Schedule:    job.Schedule,
+		Enabled:     job.Enabled,
+		Running:     job.Running,
+		LastOutcome: job.LastOutcome,
+		LastError:   job.LastError,
+	}
+	if !job.LastRun.IsZero() {
+		dto.LastRun = job.LastRun.UTC().Format(dateLayout)
+		dto.LastDuration = job.LastDuration.String()
+	}
+	if !job.NextRun.IsZero() {
+		dto.NextRun = job.NextRun.UTC().Format(dateLayout)
+	}
+	return dto
+}
+
+// JobScheduleDTO is a data transfer object that can be used for unmarshaling
+// a new schedule of a background job
+type JobScheduleDTO struct {
+	Schedule string `json:"schedule"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// NotificationPreferencesDTO is a data transfer object that can be used for marshaling
+// and unmarshaling the notification preferences of a user
+type NotificationPreferencesDTO struct {
+	Email        string `json:"email"`
+	Expiring     bool   `json:"expiring"`
+	Insufficient bool   `json:"insufficient"`
+}
+
+func newNotificationPreferencesDTO(np *NotificationPreferences) *NotificationPreferencesDTO {
+	return &NotificationPreferencesDTO{
+		Email:        np.Email,
+		Expiring:     np.Expiring,
+		Insufficient: np.Insufficient,
+	}
+}
+
+func (dto *NotificationPreferencesDTO) preferences() NotificationPreferences {
+	return NotificationPreferences{
+		Email:        dto.Email,
+		Expiring:     dto.Expiring,
+		Insufficient: dto.Insufficient,
+	}
+}
+
+// WebhookDTO is a data transfer object that can be used for marshaling and unmarshaling
+// a webhook subscription. The secret is only returned when the webhook is created.
+type WebhookDTO struct {
+	ID      string      `json:"id"`
+	URL     string      `json:"url"`
+	Secret  string      `json:"secret,omitempty"`
+	Events  []EventType `json:"events"`
+	Active  bool        `json:"active"`
+	Created string      `json:"created,omitempty"`
+}
+
+func newWebhookDTO(wh *Webhook) *WebhookDTO {
+	return &WebhookDTO{
+		ID:      wh.ID,
+		URL:     wh.URL,
+		Events:  wh.Events,
+		Active:  wh.Active,
+		Created: wh.Created.UTC().Format(dateLayout),
+	}
+}
+
+func (dto *WebhookDTO) webhook() *Webhook {
+	return &Webhook{
+		ID:     dto.ID,
+		URL:    dto.URL,
+		Secret: dto.Secret,
+		Events: dto.Events,
+		Active: dto.Active,
+	}
+}
+
+// DeliveryDTO is a data transfer object that can be used for marshaling
+// an entry of the delivery log of a webhook
+type DeliveryDTO struct {
+	ID        string         `json:"id"`
+	EventType EventType      `json:"eventType"`
+	Payload   string         `json:"payload"`
+	Status    deliveryStatus `json:"status"`
+	Attempts  int            `json:"attempts"`
+
+	ResponseCode int    `json:"responseCode,omitempty"`
+	Error        string `json:"error,omitempty"`
+
+	Created     string `json:"created"`
+	NextAttempt string `json:"nextAttempt,omitempty"`
+}
+
+func newDeliveryDTO(d *Delivery) *DeliveryDTO {
+	dto := &DeliveryDTO{
+		ID:           d.ID,
+		EventType:    d.EventType,
+		Payload:      d.Payload,
+		Status:       d.Status,
+		Attempts:     d.Attempts,
+		ResponseCode: d.ResponseCode,
+		Error:        d.Error,
+		Created:      d.Created.UTC().Format(dateLayout),
+	}
+	if d.Status == PENDING {
+		dto.NextAttempt = d.NextAttempt.UTC().Format(dateLayout)
+	}
+	return dto
+}
+
+// BarcodeDTO is a data transfer object that can be used for unmarshaling
+// a barcode of a stock item or a scanned code
+type BarcodeDTO struct {
+	Code string `json:"code"`
+}
+
+// ScanDTO is a data transfer object that can be used for marshaling the data of a scanned code.
+// If the GTIN belongs to a stock item, the item and a pre-filled receipt of the scanned lot are set.
+type ScanDTO struct {
+	GTIN           string `json:"gtin"`
+	LotNumber      string `json:"lotNumber,omitempty"`
+	ExpirationDate string `json:"expirationDate,omitempty"`
+	SerialNumber   string `json:"serialNumber,omitempty"`
+
+	Stock   *StockDTO       `json:"stock,omitempty"`
+	Receipt *NewMovementDTO `json:"receipt,omitempty"`
+}
+
+// StocktakeDTO is a data transfer object that can be used for marshaling a stocktake
+type StocktakeDTO struct {
+	ID    string         `json:"id"`
+	Name  string         `json:"name"`
+	Blind bool           `json:"blind"`
+	State stocktakeState `json:"state"`
+
+	Created string `json:"created"`
+	UserID  string `json:"userID"`
+
+	Closed   string `json:"closed,omitempty"`
+	ClosedBy string `json:"closedBy,omitempty"`
+}
+
+func newStocktakeDTO(st *Stocktake) *StocktakeDTO {
+	dto := &StocktakeDTO{
+		ID:       st.ID,
+		Name:     st.Name,
+		Blind:    st.Blind,
+		State:    st.State,
+		Created:  st.Created.UTC().Format(dateLayout),
+		UserID:   st.UserID,
+		ClosedBy: st.ClosedBy,
+	}
+	if st.Closed != nil {
+		dto.Closed = st.Closed.UTC().Format(dateLayout)
+	}
+	return dto
+}
+
+// NewStocktakeDTO is a data transfer object that can be used for unmarshaling
+// the data of a new stocktake
+type NewStocktakeDTO struct {
+	Name  string `json:"name"`
+	Blind bool   `json:"blind"`
+}
+
+// StocktakeLineDTO is a data transfer object that can be used for marshaling a line
+// of the count sheet or the variance report of a stocktake.
+// The quantities are omitted on the count sheets of open blind stocktakes,
+// so the counters are not influenced by the expected quantities or each other's counts.
+type StocktakeLineDTO struct {
+	StockID    string `json:"stockID"`
+	LotID      string `json:"lotID,omitempty"`
+	Name       string `json:"name"`
+	LotNumber  string `json:"lotNumber,omitempty"`
+	LocationID string `json:"locationID,omitempty"`
+
+	Expected  string `json:"expected,omitempty"`
+	Counted   string `json:"counted,omitempty"`
+	CountedBy string `json:"countedBy,omitempty"`
+	Counts    int    `json:"counts"`
+
+	Variance string `json:"variance,omitempty"`
+	Value    string `json:"value,omitempty"`
+}
+
+func newStocktakeLineDTO(line *StocktakeLine, blind bool) *StocktakeLineDTO {
+	dto := &StocktakeLineDTO{
+		StockID:    line.StockID,
+		LotID:      line.LotID,
+		Name:       line.Name,
+		LotNumber:  line.LotNumber,
+		LocationID: line.LocationID,
+		CountedBy:  line.CountedBy,
+		Counts:     line.Counts,
+	}
+	if blind {
+		return dto
+	}
+
+	dto.Expected = line.Expected.String()
+	if line.Counted != nil {
+		dto.Counted = line.Counted.String()
+		dto.Variance = line.Variance().String()
+		dto.Value = line.Value().String()
+	}
+	return dto
+}
+
+// StocktakeCountDTO is a data transfer object that can be used for unmarshaling
+// a count of a line of a stocktake
+type StocktakeCountDTO struct {
+	StockID  string `json:"stockID"`
+	LotID    string `json:"lotID"`
+	Quantity string `json:"quantity"`
+}
+
+// VarianceReportDTO is a data transfer object that can be used for marshaling
+// the discrepancies found by a stocktake
+type VarianceReportDTO struct {
+	Stocktake *StocktakeDTO `json:"stocktake"`
+
+	// Lines are the counted lines with a discrepancy
+	Lines []*StocktakeLineDTO `json:"lines"`
+	// Uncounted is the number of lines without a count, they are not adjusted on approval
+	Uncounted  int    `json:"uncounted"`
+	TotalValue string `json:"totalValue"`
+}
+
+// StocktakeApprovalDTO is a data transfer object that can be used for unmarshaling
+// the approval of a stocktake. The witness is required for the adjustments of controlled substances.
+type StocktakeApprovalDTO struct {
+	Witness         string `json:"witness"`
+	WitnessPassword string `json:"witnessPassword"`
+}
+
+// StockLevelDTO is a data transfer object that can be used for marshaling
+// the quantity of a stock item in a storage location
+type StockLevelDTO struct {
+	StockID     string `json:"stockID"`
+	LocationID  string `json:"locationID"`
+	Quantity    string `json:"quantity"`
+	Available   string `json:"available"`
+	MinQuantity string `json:"minQuantity,omitempty"`
+}
+
+func newStockLevelDTO(level *StockLevel) *StockLevelDTO {
+	dto := &StockLevelDTO{
+		StockID:    level.StockID,
+		LocationID: level.LocationID,
+		Quantity:   level.Quantity.String(),
+		Available:  level.Available.String(),
+	}
+	if level.MinQuantity != nil {
+		dto.MinQuantity = level.MinQuantity.String()
+	}
+	return dto
+}
+
+// MinQuantityDTO is a data transfer object that can be used for unmarshaling
+// the minimum quantity of a stock item in a storage location. An empty minimum quantity removes it.
+type MinQuantityDTO struct {
+	MinQuantity string `json:"minQuantity"`
+}
+
+// TransferLineDTO is a data transfer object that can be used for marshaling
+// and unmarshaling a line of a transfer
+type TransferLineDTO struct {
+	StockID  string `json:"stockID"`
+	LotID    string `json:"lotID,omitempty"`
+	Quantity string `json:"quantity"`
+}
+
+func newTransferLineDTOs(lines []TransferLine) []*TransferLineDTO {
+	dtos := make([]*TransferLineDTO, 0, len(lines))
+	for _, line := range lines {
+		dtos = append(dtos, &TransferLineDTO{
+			StockID:  line.StockID,
+			LotID:    line.LotID,
+			Quantity: line.Quantity.String(),
+		})
+	}
+	return dtos
+}
+
+// TransferDTO is a data transfer object that can be used for marshaling a transfer
+type TransferDTO struct {
+	ID             string        `json:"id"`
+	FromLocationID string        `json:"fromLocationID"`
+	ToLocationID   string        `json:"toLocationID"`
+	State          transferState `json:"state"`
+	Note           string        `json:"note,omitempty"`
+
+	Lines []*TransferLineDTO `json:"lines"`
+	Picks []*TransferLineDTO `json:"picks"`
+
+	Created string `json:"created"`
+	UserID  string `json:"userID"`
+
+	Dispatched   string `json:"dispatched,omitempty"`
+	DispatchedBy string `json:"dispatchedBy,omitempty"`
+	Closed       string `json:"closed,omitempty"`
+	ClosedBy     string `json:"closedBy,omitempty"`
+}
+
+func newTransferDTO(t *Transfer) *TransferDTO {
+	dto := &TransferDTO{
+		ID:             t.ID,
+		FromLocationID: t.FromLocationID,
+		ToLocationID:   t.ToLocationID,
+		State:          t.State,
+		Note:           t.Note,
+		Lines:          newTransferLineDTOs(t.Lines),
+		Picks:          newTransferLineDTOs(t.Picks),
+		Created:        t.Created.UTC().Format(dateLayout),
+		UserID:         t.UserID,
+		DispatchedBy:   t.DispatchedBy,
+		ClosedBy:       t.ClosedBy,
+	}
+	if t.Dispatched != nil {
+		dto.Dispatched = t.Dispatched.UTC().Format(dateLayout)
+	}
+	if t.Closed != nil {
+		dto.Closed = t.Closed.UTC().Format(dateLayout)
+	}
+	return dto
+}
+
+// NewTransferDTO is a data transfer object that can be used for unmarshaling
+// a request for a transfer. An empty fromLocationID requests unassigned stock.
+type NewTransferDTO struct {
+	FromLocationID string             `json:"fromLocationID"`
+	ToLocationID   string             `json:"toLocationID"`
+	Note           string             `json:"note"`
+	Lines          []*TransferLineDTO `json:"lines"`
+}
+
+func (dto *NewTransferDTO) transfer() (*Transfer, error) {
+	t := &Transfer{
+		FromLocationID: dto.FromLocationID,
+		ToLocationID:   dto.ToLocationID,
+		Note:           dto.Note,
+		Lines:          make([]TransferLine, 0, len(dto.Lines)),
+	}
+
+	errs := ValidationErrors{}
+	for i, line := range dto.Lines {
+		quantity, err := validQuantityFromString(line.Quantity)
+		if err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].quantity", i), err.Error()})
+			continue
+		}
+		t.Lines = append(t.Lines, TransferLine{StockID: line.StockID, LotID: line.LotID, Quantity: quantity})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return t, nil
+}
+
+// OwnerDTO is a data transfer object that can be used for marshaling and unmarshaling an owner
+type OwnerDTO struct {
+	ID        string `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+
+	Email   string `json:"email"`
+	Phone   string `json:"phone"`
+	Address string `json:"address"`
+
+	MarketingConsent   bool `json:"marketingConsent"`
+	ReminderConsent    bool `json:"reminderConsent"`
+	DataSharingConsent bool `json:"dataSharingConsent"`
+
+	Created string `json:"created,omitempty"`
+}
+
+func newOwnerDTO(o *Owner) *OwnerDTO {
+	return &OwnerDTO{
+		ID:                 o.ID,
+		FirstName:          o.FirstName,
+		LastName:           o.LastName,
+		Email:              o.Email,
+		Phone:              o.Phone,
+		Address:            o.Address,
+		MarketingConsent:   o.MarketingConsent,
+		ReminderConsent:    o.ReminderConsent,
+		DataSharingConsent: o.DataSharingConsent,
+		Created:            o.Created.UTC().Format(dateLayout),
+	}
+}
+
+func (dto *OwnerDTO) owner() *Owner {
+	return &Owner{
+		ID:                 dto.ID,
+		FirstName:          dto.FirstName,
+		LastName:           dto.LastName,
+		Email:              dto.Email,
+		Phone:              dto.Phone,
+		Address:            dto.Address,
+		MarketingConsent:   dto.MarketingConsent,
+		ReminderConsent:    dto.ReminderConsent,
+		DataSharingConsent: dto.DataSharingConsent,
+	}
+}
+
+// PatientDTO is a data transfer object that can be used for marshaling and unmarshaling a patient.
+// The weight is in kilograms, an empty weight or date of birth is not known.
+type PatientDTO struct {
+	ID      string `json:"id"`
+	OwnerID string `json:"ownerID"`
+	Name    string `json:"name"`
+
+	Species         string `json:"species"`
+	Breed           string `json:"breed"`
+	DateOfBirth     string `json:"dateOfBirth,omitempty"`
+	Weight          string `json:"weight,omitempty"`
+	MicrochipNumber string `json:"microchipNumber,omitempty"`
+
+	Created string `json:"created,omitempty"`
+}
+
+func newPatientDTO(p *Patient) *PatientDTO {
+	dto := &PatientDTO{
+		ID:              p.ID,
+		OwnerID:         p.OwnerID,
+		Name:            p.Name,
+		Species:         p.Species,
+		Breed:           p.Breed,
+		MicrochipNumber: p.MicrochipNumber,
+		Created:         p.Created.UTC().Format(dateLayout),
+	}
+	if p.DateOfBirth != nil {
+		dto.DateOfBirth = p.DateOfBirth.UTC().Format(dateLayout)
+	}
+	if !p.Weight.IsZero() {
+		dto.Weight = p.Weight.String()
+	}
+	return dto
+}
+
+func (dto *PatientDTO) patient() (*Patient, error) {
+	p := &Patient{
+		ID:              dto.ID,
+		OwnerID:         dto.OwnerID,
+		Name:            dto.Name,
+		Species:         dto.Species,
+		Breed:           dto.Breed,
+		MicrochipNumber: dto.MicrochipNumber,
+	}
+
+	errs := ValidationErrors{}
+	if dto.DateOfBirth != "" {
+		date, err := validDateFromString(dto.DateOfBirth)
+		if err != nil {
+			errs = append(errs, ValidationError{"dateOfBirth", "invalid date"})
+		}
+		p.DateOfBirth = &date
+	}
+	if dto.Weight != "" {
+		weight, err := decimal.NewFromString(dto.Weight)
+		if err != nil {
+			errs = append(errs, ValidationError{"weight", "invalid weight"})
+		}
+		p.Weight = weight
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return p, nil
+}
+
+// PrescriptionDTO is a data transfer object that can be used for marshaling a prescription
+type PrescriptionDTO struct {
+	ID         string `json:"id"`
+	StockID    string `json:"stockID"`
+	PatientID  string `json:"patientID"`
+	Prescriber string `json:"prescriber"`
+
+	Dose      string `json:"dose"`
+	Quantity  string `json:"quantity"`
+	Repeats   int    `json:"repeats"`
+	Remaining int    `json:"remaining"`
+
+	ValidFrom  string `json:"validFrom"`
+	ValidUntil string `json:"validUntil"`
+	Valid      bool   `json:"valid"`
+
+	Created string `json:"created"`
+	UserID  string `json:"userID"`
+}
+
+func newPrescriptionDTO(p *Prescription) *PrescriptionDTO {
+	return &PrescriptionDTO{
+		ID:         p.ID,
+		StockID:    p.StockID,
+		PatientID:  p.PatientID,
+		Prescriber: p.Prescriber,
+		Dose:       p.Dose,
+		Quantity:   p.Quantity.String(),
+		Repeats:    p.Repeats,
+		Remaining:  p.Remaining,
+		ValidFrom:  p.ValidFrom.UTC().Format(dateLayout),
+		ValidUntil: p.ValidUntil.UTC().Format(dateLayout),
+		Valid:      p.isValid(time.Now()),
+		Created:    p.Created.UTC().Format(dateLayout),
+		UserID:     p.UserID,
+	}
+}
+
+// NewPrescriptionDTO is a data transfer object that can be used for unmarshaling
+// a new prescription. The prescription is valid from its creation if validFrom is empty
+// and the prescriber is the user who sends the request if it is empty.
+type NewPrescriptionDTO struct {
+	StockID    string `json:"stockID"`
+	PatientID  string `json:"patientID"`
+	Prescriber string `json:"prescriber"`
+
+	Dose     string `json:"dose"`
+	Quantity string `json:"quantity"`
+	Repeats  int    `json:"repeats"`
+
+	ValidFrom  string `json:"validFrom"`
+	ValidUntil string `json:"validUntil"`
+}
+
+func (dto *NewPrescriptionDTO) prescription() (*Prescription, error) {
+	p := &Prescription{
+		StockID:    dto.StockID,
+		PatientID:  dto.PatientID,
+		Prescriber: dto.Prescriber,
+		Dose:       dto.Dose,
+		Repeats:    dto.Repeats,
+	}
+
+	errs := ValidationErrors{}
+	quantity, err := validQuantityFromString(dto.Quantity)
+	if err != nil {
+		errs = append(errs, ValidationError{"quantity", err.Error()})
+	}
+	p.Quantity = quantity
+	if dto.ValidFrom != "" {
+		if p.ValidFrom, err = validDateFromString(dto.ValidFrom); err != nil {
+			errs = append(errs, ValidationError{"validFrom", "invalid date"})
+		}
+	}
+	if p.ValidUntil, err = validDateFromString(dto.ValidUntil); err != nil {
+		errs = append(errs, ValidationError{"validUntil", "invalid date"})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return p, nil
+}
+
+// SaleLineDTO is a data transfer object that can be used for marshaling and unmarshaling
+// a line of a sale. Discount and VAT rate are percentages, empty ones are zero.
+type SaleLineDTO struct {
+	StockID string `json:"stockID"`
+	LotID   string `json:"lotID,omitempty"`
+	Name    string `json:"name,omitempty"`
+
+	Quantity  string `json:"quantity"`
+	UnitPrice string `json:"unitPrice"`
+	Discount  string `json:"discount,omitempty"`
+	VATRate   string `json:"vatRate,omitempty"`
+
+	Total      string `json:"total,omitempty"`
+	VAT        string `json:"vat,omitempty"`
+	Returned   string `json:"returned,omitempty"`
+	Refunded   string `json:"refunded,omitempty"`
+	MovementID string `json:"movementID,omitempty"`
+}
+
+func newSaleLineDTO(l *SaleLine) *SaleLineDTO {
+	return &SaleLineDTO{
+		StockID:    l.StockID,
+		LotID:      l.LotID,
+		Name:       l.Name,
+		Quantity:   l.Quantity.String(),
+		UnitPrice:  l.UnitPrice.StringFixed(2),
+		Discount:   l.Discount.String(),
+		VATRate:    l.VATRate.String(),
+		Total:      l.Total().StringFixed(2),
+		VAT:        l.VAT().StringFixed(2),
+		Returned:   l.Returned.String(),
+		Refunded:   l.Refunded.StringFixed(2),
+		MovementID: l.MovementID,
+	}
+}
+
+// SaleDTO is a data transfer object that can be used for marshaling a sale with its refunds
+type SaleDTO struct {
+	ID     string `json:"id"`
+	Number int    `json:"number"`
+
+	Lines         []*SaleLineDTO `json:"lines"`
+	PaymentMethod paymentMethod  `json:"paymentMethod"`
+	CustomerID    string         `json:"customerID,omitempty"`
+	LocationID    string         `json:"locationID,omitempty"`
+
+	Total    string `json:"total"`
+	VAT      string `json:"vat"`
+	Refunded string `json:"refunded"`
+
+	Refunds []*RefundDTO `json:"refunds,omitempty"`
+
+	Created string `json:"created"`
+	UserID  string `json:"userID"`
+}
+
+func newSaleDTO(s *Sale, refunds []*Refund) *SaleDTO {
+	dto := &SaleDTO{
+		ID:            s.ID,
+		Number:        s.Number,
+		Lines:         make([]*SaleLineDTO, 0, len(s.Lines)),
+		PaymentMethod: s.PaymentMethod,
+		CustomerID:    s.CustomerID,
+		LocationID:    s.LocationID,
+		Total:         s.Total().StringFixed(2),
+		VAT:           s.VAT().StringFixed(2),
+		Refunded:      s.Refunded().StringFixed(2),
+		Created:       s.Created.UTC().Format(dateLayout),
+		UserID:        s.UserID,
+	}
+	for _, l := range s.Lines {
+		dto.Lines = append(dto.Lines, newSaleLineDTO(l))
+	}
+	for _, r := range refunds {
+		dto.Refunds = append(dto.Refunds, newRefundDTO(r))
+	}
+	return dto
+}
+
+// NewSaleDTO is a data transfer object that can be used for unmarshaling a sale
+type NewSaleDTO struct {
+	Lines         []*SaleLineDTO `json:"lines"`
+	PaymentMethod paymentMethod  `json:"paymentMethod"`
+	CustomerID    string         `json:"customerID"`
+	LocationID    string         `json:"locationID"`
+}
+
+// optionalDecimal parses a decimal that is zero when it is not set
+func optionalDecimal(s string) (decimal.Decimal, error) {
+	if s == "" {
+		return decimal.Zero, nil
+	}
+	return decimal.NewFromString(s)
+}
+
+func (dto *NewSaleDTO) sale() (*Sale, error) {
+	s := &Sale{
+		Lines:         make([]*SaleLine, 0, len(dto.Lines)),
+		PaymentMethod: dto.PaymentMethod,
+		CustomerID:    dto.CustomerID,
+		LocationID:    dto.LocationID,
+	}
+
+	errs := ValidationErrors{}
+	for i, l := range dto.Lines {
+		field := func(name string) string { return fmt.Sprintf("lines[%d].%s", i, name) }
+		line := &SaleLine{StockID: l.StockID, LotID: l.LotID}
+
+		var err error
+		if line.Quantity, err = validQuantityFromString(l.Quantity); err != nil {
+			errs = append(errs, ValidationError{field("quantity"), err.Error()})
+		}
+		if line.UnitPrice, err = decimal.NewFromString(l.UnitPrice); err != nil {
+			errs = append(errs, ValidationError{field("unitPrice"), "invalid price"})
+		}
+		if line.Discount, err = optionalDecimal(l.Discount); err != nil {
+			errs = append(errs, ValidationError{field("discount"), "invalid discount"})
+		}
+		if line.VATRate, err = optionalDecimal(l.VATRate); err != nil {
+			errs = append(errs, ValidationError{field("vatRate"), "invalid VAT rate"})
+		}
+		s.Lines = append(s.Lines, line)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return s, nil
+}
+
+// RefundLineDTO is a data transfer object that can be used for marshaling and unmarshaling
+// a returned quantity of a sale's line
+type RefundLineDTO struct {
+	Line     int    `json:"line"`
+	Quantity string `json:"quantity"`
+	Restock  bool   `json:"restock"`
+
+	Amount     string `json:"amount,omitempty"`
+	MovementID string `json:"movementID,omitempty"`
+}
+
+// RefundDTO is a data transfer object that can be used for marshaling a refund
+type RefundDTO struct {
+	ID     string `json:"id"`
+	SaleID string `json:"saleID"`
+
+	Lines         []*RefundLineDTO `json:"lines"`
+	PaymentMethod paymentMethod    `json:"paymentMethod"`
+	Reason        string           `json:"reason,omitempty"`
+	Amount        string           `json:"amount"`
+
+	Created string `json:"created"`
+	UserID  string `json:"userID"`
+}
+
+func newRefundDTO(r *Refund) *RefundDTO {
+	dto := &RefundDTO{
+		ID:            r.ID,
+		SaleID:        r.SaleID,
+		Lines:         make([]*RefundLineDTO, 0, len(r.Lines)),
+		PaymentMethod: r.PaymentMethod,
+		Reason:        r.Reason,
+		Amount:        r.Amount().StringFixed(2),
+		Created:       r.Created.UTC().Format(dateLayout),
+		UserID:        r.UserID,
+	}
+	for _, l := range r.Lines {
+		dto.Lines = append(dto.Lines, &RefundLineDTO{
+			Line:       l.Line,
+			Quantity:   l.Quantity.String(),
+			Restock:    l.Restock,
+			Amount:     l.Amount.StringFixed(2),
+			MovementID: l.MovementID,
+		})
+	}
+	return dto
+}
+
+// NewRefundDTO is a data transfer object that can be used for unmarshaling a refund of a sale
+type NewRefundDTO struct {
+	Lines         []*RefundLineDTO `json:"lines"`
+	PaymentMethod paymentMethod    `json:"paymentMethod"`
+	Reason        string           `json:"reason"`
+}
+
+func (dto *NewRefundDTO) refund() (*Refund, error) {
+	r := &Refund{
+		Lines:         make([]RefundLine, 0, len(dto.Lines)),
+		PaymentMethod: dto.PaymentMethod,
+		Reason:        dto.Reason,
+	}
+
+	errs := ValidationErrors{}
+	for i, l := range dto.Lines {
+		quantity, err := validQuantityFromString(l.Quantity)
+		if err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].quantity", i), err.Error()})
+		}
+		r.Lines = append(r.Lines, RefundLine{Line: l.Line, Quantity: quantity, Restock: l.Restock})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return r, nil
+}
+
+// PurchaseOrderLineDTO is a data transfer object that can be used for marshaling and unmarshaling
+// a line of a purchase order
+type PurchaseOrderLineDTO struct {
+	ID        string `json:"id,omitempty"`
+	StockID   string `json:"stockID"`
+	Quantity  string `json:"quantity"`
+	UnitPrice string `json:"unitPrice"`
+}
+
+// PurchaseOrderDTO is a data transfer object that can be used for marshaling a purchase order
+type PurchaseOrderDTO struct {
+	ID            string                  `json:"id"`
+	DistributorID string                  `json:"distributorID"`
+	Reference     string                  `json:"reference,omitempty"`
+	Lines         []*PurchaseOrderLineDTO `json:"lines"`
+
+	Created string `json:"created"`
+	UserID  string `json:"userID"`
+}
+
+func newPurchaseOrderDTO(po *PurchaseOrder) *PurchaseOrderDTO {
+	dto := &PurchaseOrderDTO{
+		ID:            po.ID,
+		DistributorID: po.DistributorID,
+		Reference:     po.Reference,
+		Lines:         make([]*PurchaseOrderLineDTO, 0, len(po.Lines)),
+		Created:       po.Created.UTC().Format(dateLayout),
+		UserID:        po.UserID,
+	}
+	for _, l := range po.Lines {
+		dto.Lines = append(dto.Lines, &PurchaseOrderLineDTO{
+			ID:        l.ID,
+			StockID:   l.StockID,
+			Quantity:  l.Quantity.String(),
+			UnitPrice: l.UnitPrice.String(),
+		})
+	}
+	return dto
+}
+
+// NewPurchaseOrderDTO is a data transfer object that can be used for unmarshaling a purchase order
+type NewPurchaseOrderDTO struct {
+	DistributorID string                  `json:"distributorID"`
+	Reference     string                  `json:"reference"`
+	Lines         []*PurchaseOrderLineDTO `json:"lines"`
+}
+
+func (dto *NewPurchaseOrderDTO) purchaseOrder() (*PurchaseOrder, error) {
+	po := &PurchaseOrder{
+		DistributorID: dto.DistributorID,
+		Reference:     dto.Reference,
+		Lines:         make([]*PurchaseOrderLine, 0, len(dto.Lines)),
+	}
+
+	errs := ValidationErrors{}
+	for i, l := range dto.Lines {
+		line := &PurchaseOrderLine{StockID: l.StockID}
+
+		var err error
+		if line.Quantity, err = validQuantityFromString(l.Quantity); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].quantity", i), err.Error()})
+		}
+		if line.UnitPrice, err = decimal.NewFromString(l.UnitPrice); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].unitPrice", i), "invalid price"})
+		}
+		po.Lines = append(po.Lines, line)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return po, nil
+}
+
+// InvoiceLineDTO is a data transfer object that can be used for marshaling and unmarshaling
+// a line of a distributor's invoice
+type InvoiceLineDTO struct {
+	PurchaseOrderLineID string `json:"purchaseOrderLineID"`
+	LotID               string `json:"lotID"`
+	Quantity            string `json:"quantity"`
+	UnitPrice           string `json:"unitPrice"`
+	Total               string `json:"total,omitempty"`
+}
+
+// InvoiceDTO is a data transfer object that can be used for marshaling a distributor's invoice
+type InvoiceDTO struct {
+	ID            string `json:"id"`
+	DistributorID string `json:"distributorID"`
+	Number        string `json:"number"`
+
+	Date    string `json:"date"`
+	DueDate string `json:"dueDate"`
+	Overdue bool   `json:"overdue"`
+
+	Lines []*InvoiceLineDTO `json:"lines"`
+	Total string            `json:"total"`
+	State invoiceState      `json:"state"`
+
+	Created    string `json:"created"`
+	UserID     string `json:"userID"`
+	Approved   string `json:"approved,omitempty"`
+	ApprovedBy string `json:"approvedBy,omitempty"`
+	Paid       string `json:"paid,omitempty"`
+	PaidBy     string `json:"paidBy,omitempty"`
+}
+
+func newInvoiceDTO(inv *Invoice) *InvoiceDTO {
+	dto := &InvoiceDTO{
+		ID:            inv.ID,
+		DistributorID: inv.DistributorID,
+		Number:        inv.Number,
+		Date:          inv.Date.UTC().Format(dateLayout),
+		DueDate:       inv.DueDate.UTC().Format(dateLayout),
+		Overdue:       inv.isOverdue(time.Now()),
+		Lines:         make([]*InvoiceLineDTO, 0, len(inv.Lines)),
+		Total:         inv.Total().StringFixed(2),
+		State:         inv.State,
+		Created:       inv.Created.UTC().Format(dateLayout),
+		UserID:        inv.UserID,
+		ApprovedBy:    inv.ApprovedBy,
+		PaidBy:        inv.PaidBy,
+	}
+	for _, l := range inv.Lines {
+		dto.Lines = append(dto.Lines, &InvoiceLineDTO{
+			PurchaseOrderLineID: l.PurchaseOrderLineID,
+			LotID:               l.LotID,
+			Quantity:            l.Quantity.String(),
+			UnitPrice:           l.UnitPrice.String(),
+			Total:               l.Total().StringFixed(2),
+		})
+	}
+	if inv.Approved != nil {
+		dto.Approved = inv.Approved.UTC().Format(dateLayout)
+	}
+	if inv.Paid != nil {
+		dto.Paid = inv.Paid.UTC().Format(dateLayout)
+	}
+	return dto
+}
+
+// NewInvoiceDTO is a data transfer object that can be used for unmarshaling a distributor's invoice.
+// An empty due date is 30 days after the invoice's date.
+type NewInvoiceDTO struct {
+	DistributorID string            `json:"distributorID"`
+	Number        string            `json:"number"`
+	Date          string            `json:"date"`
+	DueDate       string            `json:"dueDate"`
+	Lines         []*InvoiceLineDTO `json:"lines"`
+}
+
+func (dto *NewInvoiceDTO) invoice() (*Invoice, error) {
+	inv := &Invoice{
+		DistributorID: dto.DistributorID,
+		Number:        dto.Number,
+		Lines:         make([]*InvoiceLine, 0, len(dto.Lines)),
+	}
+
+	var (
+		errs = ValidationErrors{}
+		err  error
+	)
+	if inv.Date, err = validDateFromString(dto.Date); err != nil {
+		errs = append(errs, ValidationError{"date", "invalid date"})
+	}
+	if dto.DueDate != "" {
+		if inv.DueDate, err = validDateFromString(dto.DueDate); err != nil {
+			errs = append(errs, ValidationError{"dueDate", "invalid date"})
+		}
+	}
+	for i, l := range dto.Lines {
+		line := &InvoiceLine{PurchaseOrderLineID: l.PurchaseOrderLineID, LotID: l.LotID}
+		if line.Quantity, err = validQuantityFromString(l.Quantity); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].quantity", i), err.Error()})
+		}
+		if line.UnitPrice, err = decimal.NewFromString(l.UnitPrice); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].unitPrice", i), "invalid price"})
+		}
+		inv.Lines = append(inv.Lines, line)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return inv, nil
+}
+
+// InvoiceLineMatchDTO is a data transfer object that can be used for marshaling
+// the three-way match of an invoice line
+type InvoiceLineMatchDTO struct {
+	Line    int    `json:"line"`
+	StockID string `json:"stockID"`
+
+	Ordered       string `json:"ordered"`
+	Received      string `json:"received"`
+	OrderInvoiced string `json:"orderInvoiced"`
+	LotInvoiced   string `json:"lotInvoiced"`
+
+	OrderPrice   string `json:"orderPrice"`
+	ReceiptPrice string `json:"receiptPrice"`
+	InvoicePrice string `json:"invoicePrice"`
+
+	Discrepancies []discrepancy `json:"discrepancies"`
+}
+
+// InvoiceMatchDTO is a data transfer object that can be used for marshaling
+// the three-way match of an invoice
+type InvoiceMatchDTO struct {
+	InvoiceID string                 `json:"invoiceID"`
+	Matched   bool                   `json:"matched"`
+	Lines     []*InvoiceLineMatchDTO `json:"lines"`
+}
+
+func newInvoiceMatchDTO(invoiceID string, matches []*InvoiceLineMatch) *InvoiceMatchDTO {
+	dto := &InvoiceMatchDTO{
+		InvoiceID: invoiceID,
+		Matched:   isMatched(matches),
+		Lines:     make([]*InvoiceLineMatchDTO, 0, len(matches)),
+	}
+	for _, m := range matches {
+		dto.Lines = append(dto.Lines, &InvoiceLineMatchDTO{
+			Line:          m.Line,
+			StockID:       m.StockID,
+			Ordered:       m.Ordered.String(),
+			Received:      m.Received.String(),
+			OrderInvoiced: m.OrderInvoiced.String(),
+			LotInvoiced:   m.LotInvoiced.String(),
+			OrderPrice:    m.OrderPrice.String(),
+			ReceiptPrice:  m.ReceiptPrice.String(),
+			InvoicePrice:  m.InvoicePrice.String(),
+			Discrepancies: m.Discrepancies,
+		})
+	}
+	return dto
+}
+
+// InvoiceApprovalDTO is a data transfer object that can be used for unmarshaling
+// the approval of an invoice for payment
+type InvoiceApprovalDTO struct {
+	AcceptDiscrepancies bool `json:"acceptDiscrepancies"`
+}
+
+// PayablesDTO is a data transfer object that can be used for marshaling the payables of a distributor
+type PayablesDTO struct {
+	DistributorID string        `json:"distributorID"`
+	Outstanding   string        `json:"outstanding"`
+	Overdue       string        `json:"overdue"`
+	Paid          string        `json:"paid"`
+	Unpaid        []*InvoiceDTO `json:"unpaid"`
+}
+
+func newPayablesDTO(p *Payables) *PayablesDTO {
+	dto := &PayablesDTO{
+		DistributorID: p.DistributorID,
+		Outstanding:   p.Outstanding.StringFixed(2),
+		Overdue:       p.Overdue.StringFixed(2),
+		Paid:          p.Paid.StringFixed(2),
+		Unpaid:        make([]*InvoiceDTO, 0, len(p.Unpaid)),
+	}
+	for _, inv := range p.Unpaid {
+		dto.Unpaid = append(dto.Unpaid, newInvoiceDTO(inv))
+	}
+	return dto
+}
+
+// DoseRangeDTO is a data transfer object that can be used for marshaling and unmarshaling
+// the labelled dose of a medicine for a species
+type DoseRangeDTO struct {
+	Species    string `json:"species"`
+	MinMgPerKg string `json:"minMgPerKg"`
+	MaxMgPerKg string `json:"maxMgPerKg"`
+}
+
+// DosingDTO is a data transfer object that can be used for marshaling and unmarshaling
+// the dosing rules of a stock item. The concentration is in mg per unit of the item.
+type DosingDTO struct {
+	StockID       string          `json:"stockID"`
+	Concentration string          `json:"concentration"`
+	Increment     string          `json:"increment"`
+	Ranges        []*DoseRangeDTO `json:"ranges"`
+}
+
+func newDosingDTO(d *Dosing) *DosingDTO {
+	dto := &DosingDTO{
+		StockID:       d.StockID,
+		Concentration: d.Concentration.String(),
+		Increment:     d.Increment.String(),
+		Ranges:        make([]*DoseRangeDTO, 0, len(d.Ranges)),
+	}
+	for _, r := range d.Ranges {
+		dto.Ranges = append(dto.Ranges, &DoseRangeDTO{
+			Species:    r.Species,
+			MinMgPerKg: r.MinMgPerKg.String(),
+			MaxMgPerKg: r.MaxMgPerKg.String(),
+		})
+	}
+	return dto
+}
+
+func (dto *DosingDTO) dosing() (*Dosing, error) {
+	d := &Dosing{
+		StockID: dto.StockID,
+		Ranges:  make([]DoseRange, 0, len(dto.Ranges)),
+	}
+
+	errs := ValidationErrors{}
+	var err error
+	if d.Concentration, err = decimal.NewFromString(dto.Concentration); err != nil {
+		errs = append(errs, ValidationError{"concentration", "invalid concentration"})
+	}
+	if d.Increment, err = decimal.NewFromString(dto.Increment); err != nil {
+		errs = append(errs, ValidationError{"increment", "invalid increment"})
+	}
+	for i, r := range dto.Ranges {
+		field := func(name string) string { return fmt.Sprintf("ranges[%d].%s", i, name) }
+		dr := DoseRange{Species: r.Species}
+
+		if dr.MinMgPerKg, err = decimal.NewFromString(r.MinMgPerKg); err != nil {
+			errs = append(errs, ValidationError{field("minMgPerKg"), "invalid dose"})
+		}
+		if dr.MaxMgPerKg, err = decimal.NewFromString(r.MaxMgPerKg); err != nil {
+			errs = append(errs, ValidationError{field("maxMgPerKg"), "invalid dose"})
+		}
+		d.Ranges = append(d.Ranges, dr)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return d, nil
+}
+
+// NewDoseDTO is a data transfer object that can be used for unmarshaling a request for a dose.
+// The weight is in kg. The dose aims for the middle of the labelled range if mgPerKg is empty.
+type NewDoseDTO struct {
+	Species string `json:"species"`
+	Weight  string `json:"weight"`
+	MgPerKg string `json:"mgPerKg"`
+}
+
+// DoseDTO is a data transfer object that can be used for marshaling a calculated dose
+type DoseDTO struct {
+	StockID string        `json:"stockID"`
+	Species string        `json:"species"`
+	Weight  string        `json:"weight"`
+	MgPerKg string        `json:"mgPerKg"`
+	Range   *DoseRangeDTO `json:"range"`
+
+	Quantity      string `json:"quantity"`
+	Mg            string `json:"mg"`
+	ActualMgPerKg string `json:"actualMgPerKg"`
+
+	Warnings []string `json:"warnings"`
+}
+
+func newDoseDTO(d *Dose) *DoseDTO {
+	dto := &DoseDTO{
+		StockID: d.StockID,
+		Species: d.Species,
+		Weight:  d.Weight.String(),
+		MgPerKg: d.MgPerKg.String(),
+		Range: &DoseRangeDTO{
+			Species:    d.Range.Species,
+			MinMgPerKg: d.Range.MinMgPerKg.String(),
+			MaxMgPerKg: d.Range.MaxMgPerKg.String(),
+		},
+		Quantity:      d.Quantity.String(),
+		Mg:            d.Mg.String(),
+		ActualMgPerKg: d.ActualMgPerKg.String(),
+		Warnings:      d.Warnings,
+	}
+	if dto.Warnings == nil {
+		dto.Warnings = []string{}
+	}
+	return dto
+}
+
+// EquivalenceDTO is a data transfer object that can be used for marshaling and unmarshaling
+// the active ingredient, strength and form of a stock item
+type EquivalenceDTO struct {
+	StockID          string `json:"stockID"`
+	ActiveIngredient string `json:"activeIngredient"`
+	Strength         string `json:"strength"`
+	Form             string `json:"form"`
+}
+
+// SubstituteDTO is a data transfer object that can be used for marshaling
+// a stock item that can replace another one
+type SubstituteDTO struct {
+	Stock  *StockDTO        `json:"stock"`
+	Reason substituteReason `json:"reason"`
+
+	Available      string `json:"available"`
+	ExpirationDate string `json:"expirationDate,omitempty"`
+}
+
+func newSubstituteDTOs(substitutes []*Substitute) []*SubstituteDTO {
+	dtos := make([]*SubstituteDTO, 0, len(substitutes))
+	for _, s := range substitutes {
+		dto := &SubstituteDTO{
+			Stock:     newStockDTO(s.Stock),
+			Reason:    s.Reason,
+			Available: s.Available.String(),
+		}
+		if s.ExpirationDate != nil {
+			dto.ExpirationDate = s.ExpirationDate.UTC().Format(dateLayout)
+		}
+		dtos = append(dtos, dto)
+	}
+	return dtos
+}
+
+// NewSubstituteDTO is a data transfer object that can be used for unmarshaling
+// a manual link to a substitute
+type NewSubstituteDTO struct {
+	SubstituteID string `json:"substituteID"`
+}
+
+// InsufficientStockDTO is a data transfer object that can be used for marshaling the insufficient
+// stock items. Substitutes has the in-stock substitutes of the items by the items' ids.
+type InsufficientStockDTO struct {
+	Info        string                      `json:"info"`
+	URLs        []string                    `json:"urls"`
+	Substitutes map[string][]*SubstituteDTO `json:"substitutes"`
+}
+
+// BundleComponentDTO is a data transfer object that can be used for marshaling and unmarshaling
+// a component of a bundle and its quantity in a single kit
+type BundleComponentDTO struct {
+	StockID  string `json:"stockID"`
+	Quantity string `json:"quantity"`
+}
+
+// BundleDTO is a data transfer object that can be used for marshaling and unmarshaling
+// the definition of a bundle. The quantities are only marshaled.
+type BundleDTO struct {
+	StockID    string                `json:"stockID"`
+	Components []*BundleComponentDTO `json:"components"`
+
+	Assembled string `json:"assembled,omitempty"`
+	Buildable string `json:"buildable,omitempty"`
+	Available string `json:"available,omitempty"`
+}
+
+func newBundleDTO(b *Bundle, availability *BundleAvailability) *BundleDTO {
+	dto := &BundleDTO{
+		StockID:    b.StockID,
+		Components: make([]*BundleComponentDTO, 0, len(b.Components)),
+		Assembled:  availability.Assembled.String(),
+		Buildable:  availability.Buildable.String(),
+		Available:  availability.Available().String(),
+	}
+	for _, c := range b.Components {
+		dto.Components = append(dto.Components, &BundleComponentDTO{c.StockID, c.Quantity.String()})
+	}
+	return dto
+}
+
+func (dto *BundleDTO) bundle() (*Bundle, error) {
+	b := &Bundle{
+		StockID:    dto.StockID,
+		Components: make([]BundleComponent, 0, len(dto.Components)),
+	}
+
+	errs := ValidationErrors{}
+	for i, c := range dto.Components {
+		quantity, err := validQuantityFromString(c.Quantity)
+		if err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("components[%d].quantity", i), err.Error()})
+		}
+		b.Components = append(b.Components, BundleComponent{StockID: c.StockID, Quantity: quantity})
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return b, nil
+}
+
+// AssemblyDTO is a data transfer object that can be used for unmarshaling an assembly
+// of kits of a bundle. A negative quantity takes assembled kits apart.
+type AssemblyDTO struct {
+	Quantity   string `json:"quantity"`
+	LocationID string `json:"locationID"`
+}
+
+// ReorderSuggestionDTO is a data transfer object that can be used for marshaling
+// the suggested reorder point of a stock item next to its current minimum quantity
+type ReorderSuggestionDTO struct {
+	StockID string `json:"stockID"`
+	Name    string `json:"name"`
+
+	DailyConsumption string `json:"dailyConsumption"`
+	DailyDeviation   string `json:"dailyDeviation"`
+	LeadTimeDays     string `json:"leadTimeDays"`
+	LeadTimeMeasured bool   `json:"leadTimeMeasured"`
+
+	SafetyStock   string `json:"safetyStock"`
+	ReorderPoint  string `json:"reorderPoint"`
+	OrderQuantity string `json:"orderQuantity"`
+
+	MinQuantity string `json:"minQuantity"`
+	Difference  string `json:"difference"`
+}
+
+// ReorderReportDTO is a data transfer object that can be used for marshaling the reorder point report
+type ReorderReportDTO struct {
+	From         string                  `json:"from"`
+	To           string                  `json:"to"`
+	ServiceLevel float64                 `json:"serviceLevel"`
+	Suggestions  []*ReorderSuggestionDTO `json:"suggestions"`
+}
+
+func newReorderReportDTO(opts ForecastOptions, suggestions []*ReorderSuggestion) *ReorderReportDTO {
+	dto := &ReorderReportDTO{
+		From:         opts.From.Format(dateLayout),
+		To:           opts.To.Format(dateLayout),
+		ServiceLevel: opts.ServiceLevel,
+		Suggestions:  make([]*ReorderSuggestionDTO, 0, len(suggestions)),
+	}
+	for _, s := range suggestions {
+		dto.Suggestions = append(dto.Suggestions, &ReorderSuggestionDTO{
+			StockID:          s.StockID,
+			Name:             s.Name,
+			DailyConsumption: s.DailyConsumption.String(),
+			DailyDeviation:   s.DailyDeviation.String(),
+			LeadTimeDays:     s.LeadTimeDays.String(),
+			LeadTimeMeasured: s.LeadTimeMeasured,
+			SafetyStock:      s.SafetyStock.String(),
+			ReorderPoint:     s.ReorderPoint.String(),
+			OrderQuantity:    s.OrderQuantity.String(),
+			MinQuantity:      s.MinQuantity.String(),
+			Difference:       s.Difference().String(),
+		})
+	}
+	return dto
+}
+
+// ApplyReorderPointsDTO is a data transfer object that can be used for unmarshaling
+// the stock items whose minimum quantities are set to their reorder points.
+// All stock items are updated if stockIDs is empty.
+type ApplyReorderPointsDTO struct {
+	StockIDs []string `json:"stockIDs"`
+}
+
+// ABCItemDTO is a data transfer object that can be used for marshaling
+// a stock item in the ABC classification report
+type ABCItemDTO struct {
+	StockID string    `json:"stockID"`
+	Name    string    `json:"name"`
+	Type    stockType `json:"type"`
+
+	Quantity        string `json:"quantity"`
+	Value           string `json:"value"`
+	Share           string `json:"share"`
+	CumulativeShare string `json:"cumulativeShare"`
+	Class           string `json:"class"`
+}
+
+// ABCReportDTO is a data transfer object that can be used for marshaling the ABC classification report
+type ABCReportDTO struct {
+	From       string        `json:"from"`
+	To         string        `json:"to"`
+	TotalValue string        `json:"totalValue"`
+	Items      []*ABCItemDTO `json:"items"`
+}
+
+func newABCReportDTO(from, to time.Time, items []*ABCItem) *ABCReportDTO {
+	var (
+		total = decimal.Zero
+		dto   = &ABCReportDTO{
+			From:  from.Format(dateLayout),
+			To:    to.Format(dateLayout),
+			Items: make([]*ABCItemDTO, 0, len(items)),
+		}
+	)
+	for _, item := range items {
+		total = total.Add(item.Value)
+		dto.Items = append(dto.Items, &ABCItemDTO{
+			StockID:         item.StockID,
+			Name:            item.Name,
+			Type:            item.Type,
+			Quantity:        item.Quantity.String(),
+			Value:           item.Value.String(),
+			Share:           item.Share.String(),
+			CumulativeShare: item.CumulativeShare.String(),
+			Class:           string(item.Class),
+		})
+	}
+	dto.TotalValue = total.String()
+	return dto
+}
+
+func (dto *ABCReportDTO) csvHeader() []string {
+	return []string{"Stock ID", "Name", "Type", "Quantity", "Value", "Share", "Cumulative share", "Class"}
+}
+
+func (dto *ABCReportDTO) csvRows() [][]string {
+	rows := make([][]string, 0, len(dto.Items))
+	for _, i := range dto.Items {
+		rows = append(rows, []string{i.StockID, i.Name, strconv.Itoa(int(i.Type)), i.Quantity, i.Value, i.Share, i.CumulativeShare, i.Class})
+	}
+	return rows
+}
+
+// TurnoverDTO is a data transfer object that can be used for marshaling the inventory turnover
+// of a stock item or a stock type. DaysOnHand is empty if nothing was consumed.
+type TurnoverDTO struct {
+	StockID  string    `json:"stockID,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Type     stockType `json:"type"`
+	TypeName string    `json:"typeName,omitempty"`
+
+	Consumed      string `json:"consumed"`
+	ConsumedValue string `json:"consumedValue"`
+	AverageOnHand string `json:"averageOnHand"`
+	AverageValue  string `json:"averageValue"`
+	ClosingOnHand string `json:"closingOnHand"`
+	ClosingValue  string `json:"closingValue"`
+	Turnover      string `json:"turnover"`
+	DaysOnHand    string `json:"daysOnHand"`
+}
+
+// TurnoverReportDTO is a data transfer object that can be used for marshaling the turnover report.
+// By is either item or stockType.
+type TurnoverReportDTO struct {
+	From      string         `json:"from"`
+	To        string         `json:"to"`
+	By        string         `json:"by"`
+	Turnovers []*TurnoverDTO `json:"turnovers"`
+}
+
+func newTurnoverReportDTO(from, to time.Time, by string, turnovers []*Turnover) *TurnoverReportDTO {
+	dto := &TurnoverReportDTO{
+		From:      from.Format(dateLayout),
+		To:        to.Format(dateLayout),
+		By:        by,
+		Turnovers: make([]*TurnoverDTO, 0, len(turnovers)),
+	}
+	for _, t := range turnovers {
+		td := &TurnoverDTO{
+			StockID:       t.StockID,
+			Name:          t.Name,
+			Type:          t.Type,
+			TypeName:      t.TypeName,
+			Consumed:      t.Consumed.String(),
+			ConsumedValue: t.ConsumedValue.String(),
+			AverageOnHand: t.AverageOnHand.String(),
+			AverageValue:  t.AverageValue.String(),
+			ClosingOnHand: t.ClosingOnHand.String(),
+			ClosingValue:  t.ClosingValue.String(),
+			Turnover:      t.Turnover.String(),
+		}
+		if t.DaysOnHand != nil {
+			td.DaysOnHand = t.DaysOnHand.String()
+		}
+		dto.Turnovers = append(dto.Turnovers, td)
+	}
+	return dto
+}
+
+func (dto *TurnoverReportDTO) csvHeader() []string {
+	return []string{
+		"Stock ID", "Name", "Type", "Type name", "Consumed", "Consumed value",
+		"Average on hand", "Average value", "Closing on hand", "Closing value", "Turnover", "Days on hand",
+	}
+}
+
+func (dto *TurnoverReportDTO) csvRows() [][]string {
+	rows := make([][]string, 0, len(dto.Turnovers))
+	for _, t := range dto.Turnovers {
+		rows = append(rows, []string{
+			t.StockID, t.Name, strconv.Itoa(int(t.Type)), t.TypeName, t.Consumed, t.ConsumedValue,
+			t.AverageOnHand, t.AverageValue, t.ClosingOnHand, t.ClosingValue, t.Turnover, t.DaysOnHand,
+		})
+	}
+	return rows
+}
+
+// DeadStockItemDTO is a data transfer object that can be used for marshaling
+// a stock item in the dead stock report. LastMovement is empty if the item never moved.
+type DeadStockItemDTO struct {
+	StockID      string `json:"stockID"`
+	Name         string `json:"name"`
+	Quantity     string `json:"quantity"`
+	Value        string `json:"value"`
+	LastMovement string `json:"lastMovement"`
+}
+
+// DeadStockReportDTO is a data transfer object that can be used for marshaling
+// the stock items that have not moved for Days days and their total value
+type DeadStockReportDTO struct {
+	Days       int                 `json:"days"`
+	Since      string              `json:"since"`
+	TotalValue string              `json:"totalValue"`
+	Items      []*DeadStockItemDTO `json:"items"`
+}
+
+func newDeadStockReportDTO(days int, since time.Time, items []*DeadStockItem) *DeadStockReportDTO {
+	var (
+		total = decimal.Zero
+		dto   = &DeadStockReportDTO{
+			Days:  days,
+			Since: since.Format(dateLayout),
+			Items: make([]*DeadStockItemDTO, 0, len(items)),
+		}
+	)
+	for _, item := range items {
+		total = total.Add(item.Value)
+		id := &DeadStockItemDTO{
+			StockID:  item.StockID,
+			Name:     item.Name,
+			Quantity: item.Quantity.String(),
+			Value:    item.Value.String(),
+		}
+		if item.LastMovement != nil {
+			id.LastMovement = item.LastMovement.UTC().Format(dateLayout)
+		}
+		dto.Items = append(dto.Items, id)
+	}
+	dto.TotalValue = total.String()
+	return dto
+}
+
+func (dto *DeadStockReportDTO) csvHeader() []string {
+	return []string{"Stock ID", "Name", "Quantity", "Value", "Last movement"}
+}
+
+func (dto *DeadStockReportDTO) csvRows() [][]string {
+	rows := make([][]string, 0, len(dto.Items))
+	for _, i := range dto.Items {
+		rows = append(rows, []string{i.StockID, i.Name, i.Quantity, i.Value, i.LastMovement})
+	}
+	return rows
+}
+
+// ExpiryLossDTO is a data transfer object that can be used for marshaling
+// the value of the expired stock written off in a month
+type ExpiryLossDTO struct {
+	Month     string `json:"month"`
+	WriteOffs int    `json:"writeOffs"`
+	Value     string `json:"value"`
+}
+
+// ExpiryLossReportDTO is a data transfer object that can be used for marshaling
+// the monthly expiry losses in a period and their total value
+type ExpiryLossReportDTO struct {
+	From       string           `json:"from"`
+	To         string           `json:"to"`
+	TotalValue string           `json:"totalValue"`
+	Months     []*ExpiryLossDTO `json:"months"`
+}
+
+func newExpiryLossReportDTO(from, to time.Time, losses []*ExpiryLoss) *ExpiryLossReportDTO {
+	var (
+		total = decimal.Zero
+		dto   = &ExpiryLossReportDTO{
+			From:   from.Format(dateLayout),
+			To:     to.Format(dateLayout),
+			Months: make([]*ExpiryLossDTO, 0, len(losses)),
+		}
+	)
+	for _, l := range losses {
+		total = total.Add(l.Value)
+		dto.Months = append(dto.Months, &ExpiryLossDTO{Month: l.Month, WriteOffs: l.WriteOffs, Value: l.Value.String()})
+	}
+	dto.TotalValue = total.String()
+	return dto
+}
+
+func (dto *ExpiryLossReportDTO) csvHeader() []string {
+	return []string{"Month", "Write-offs", "Value"}
+}
+
+func (dto *ExpiryLossReportDTO) csvRows() [][]string {
+	rows := make([][]string, 0, len(dto.Months))
+	for _, m := range dto.Months {
+		rows = append(rows, []string{m.Month, strconv.Itoa(m.WriteOffs), m.Value})
+	}
+	return rows
+}
+
+// SnapshotLotDTO is a data transfer object that can be used for marshaling
+// the quantity of a lot at the time of an inventory snapshot
+type SnapshotLotDTO struct {
+	Number         string `json:"number"`
+	ExpirationDate string `json:"expirationDate,omitempty"`
+	Quantity       string `json:"quantity"`
+}
+
+// SnapshotItemDTO is a data transfer object that can be used for marshaling
+// a stock item with its quantity and lots at the time of an inventory snapshot
+type SnapshotItemDTO struct {
+	StockID  string            `json:"stockID"`
+	Name     string            `json:"name"`
+	Quantity string            `json:"quantity"`
+	Lots     []*SnapshotLotDTO `json:"lots"`
+}
+
+func newSnapshotItemDTO(item *SnapshotItem) *SnapshotItemDTO {
+	dto := &SnapshotItemDTO{
+		StockID:  item.StockID,
+		Name:     item.Name,
+		Quantity: item.Quantity.String(),
+		Lots:     make([]*SnapshotLotDTO, 0, len(item.Lots)),
+	}
+	for _, lot := range item.Lots {
+		ld := &SnapshotLotDTO{Number: lot.Number, Quantity: lot.Quantity.String()}
+		if lot.ExpirationDate != nil {
+			ld.ExpirationDate = lot.ExpirationDate.UTC().Format(dateLayout)
+		}
+		dto.Lots = append(dto.Lots, ld)
+	}
+	return dto
+}
+
+// InventorySnapshotDTO is a data transfer object that can be used for marshaling an inventory snapshot.
+// The id and the creation time are empty for inventories reconstructed on request
+// and the items are left out of lists of snapshots.
+type InventorySnapshotDTO struct {
+	ID      string             `json:"id,omitempty"`
+	Time    string             `json:"time"`
+	Created string             `json:"created,omitempty"`
+	Closed  bool               `json:"closed"`
+	Items   []*SnapshotItemDTO `json:"items,omitempty"`
+}
+
+func newInventorySnapshotDTO(snapshot *InventorySnapshot) *InventorySnapshotDTO {
+	dto := &InventorySnapshotDTO{
+		ID:     snapshot.ID,
+		Time:   snapshot.Time.UTC().Format(dateLayout),
+		Closed: snapshot.Closed,
+	}
+	if !snapshot.Created.IsZero() {
+		dto.Created = snapshot.Created.UTC().Format(dateLayout)
+	}
+	for _, item := range snapshot.Items {
+		dto.Items = append(dto.Items, newSnapshotItemDTO(item))
+	}
+	return dto
+}
+
+// AuditEntryDTO is a data transfer object that can be used for marshaling an entry of the audit log
+type AuditEntryDTO struct {
+	Sequence int64  `json:"sequence"`
+	Time     string `json:"time"`
+
+	EntityType string          `json:"entityType"`
+	EntityID   string          `json:"entityID"`
+	Action     string          `json:"action"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+
+	UserID    string `json:"userID"`
+	ClientIP  string `json:"clientIP"`
+	RequestID string `json:"requestID"`
+
+	PreviousHash string `json:"previousHash"`
+	Hash         string `json:"hash"`
+}
+
+func newAuditEntryDTO(e *AuditEntry) *AuditEntryDTO {
+	return &AuditEntryDTO{
+		Sequence:     e.Sequence,
+		Time:         e.Time.UTC().Format(dateLayout),
+		EntityType:   e.EntityType,
+		EntityID:     e.EntityID,
+		Action:       string(e.Action),
+		Before:       e.Before,
+		After:        e.After,
+		UserID:       e.UserID,
+		ClientIP:     e.ClientIP,
+		RequestID:    e.RequestID,
+		PreviousHash: e.PreviousHash,
+		Hash:         e.Hash,
+	}
+}
+
+// AuditVerificationDTO is a data transfer object that can be used for marshaling the result
+// of checking the audit log. BrokenAt is the sequence of the first entry that was tampered with.
+type AuditVerificationDTO struct {
+	Intact   bool  `json:"intact"`
+	BrokenAt int64 `json:"brokenAt,omitempty"`
+}
+
+// NewDistributorDTO is a data transfer object that can be used for unmarshaling
+// a new distributor or the changes to an existing one
+type NewDistributorDTO struct {
+	Name string `json:"name"`
+}
+
+// UserDTO is a data transfer object that can be used for marshaling a user. Passwords are never sent.
+type UserDTO struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func newUserDTO(u User) *UserDTO {
+	return &UserDTO{ID: u.ID(), Name: u.Name()}
+}
+
+// NewUserDTO is a data transfer object that can be used for unmarshaling a new user
+// or the changes to an existing one. The password of an existing user is kept if it is empty.
+type NewUserDTO struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
 }
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// EventType is the type of a change committed through the warehouse
+type EventType string
+
+const (
+	STOCK_CREATED       EventType = "stock.created"
+	STOCK_UPDATED       EventType = "stock.updated"
+	STOCK_DELETED       EventType = "stock.deleted"
+	STOCK_DISPENSED     EventType = "stock.dispensed"
+	STOCK_BELOW_MINIMUM EventType = "stock.below-minimum"
+	LOT_EXPIRED         EventType = "lot.expired"
+
+	DISTRIBUTOR_CREATED EventType = "distributor.created"
+	DISTRIBUTOR_UPDATED EventType = "distributor.updated"
+	DISTRIBUTOR_DELETED EventType = "distributor.deleted"
+)
+
+// eventTypes are the known event types
+var eventTypes = []EventType{
+	STOCK_CREATED,
+	STOCK_UPDATED,
+	STOCK_DELETED,
+	STOCK_DISPENSED,
+	STOCK_BELOW_MINIMUM,
+	LOT_EXPIRED,
+	DISTRIBUTOR_CREATED,
+	DISTRIBUTOR_UPDATED,
+	DISTRIBUTOR_DELETED,
+}
+
+func (et EventType) isValid() bool {
+	for _, t := range eventTypes {
+		if t == et {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is a change committed through the warehouse.
+// Data is a DTO of the changed entity, so it can be marshaled as it is.
+type Event struct {
+	Type EventType
+	Time time.Time
+	Data interface{}
+}
+
+// EventListener is called for every event after the change is committed.
+// Listeners are called synchronously and must not block.
+type EventListener func(Event)
+
+// eventPublisher keeps the listeners of the warehouse's events
+type eventPublisher struct {
+	mutex     sync.RWMutex
+	listeners []EventListener
+}
+
+func (ep *eventPublisher) AddListener(l EventListener) {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+
+	ep.listeners = append(ep.listeners, l)
+}
+
+func (ep *eventPublisher) publish(t EventType, data interface{}) {
+	ep.mutex.RLock()
+	defer ep.mutex.RUnlock()
+
+	e := Event{Type: t, Time: time.Now().UTC(), Data: data}
+	for _, l := range ep.listeners {
+		l(e)
+	}
+}
+
+// StockDeletedDTO is the data of the STOCK_DELETED event
+type StockDeletedDTO struct {
+	ID string `json:"id"`
+}
+
+// publishStockChange publishes the event of a changed stock item
+// and STOCK_BELOW_MINIMUM if the change took the item's available quantity below its minimum.
+// previous is the item before the change or nil for new items.
+func (wh *dafaultWarehouse) publishStockChange(t EventType, item Stock, previous Stock) {
+	wh.publish(t, newStockDTO(item))
+
+	// changes of stock items do not change their lots
+	unavailable := wh.unavailableQuantity(item.ID())
+	wasAbove := previous == nil || previous.Quantity().Sub(unavailable).Cmp(previous.MinQuantity()) >= 0
+	if wasAbove && item.Quantity().Sub(unavailable).Cmp(item.MinQuantity()) < 0 {
+		wh.publish(STOCK_BELOW_MINIMUM, newStockDTO(item))
+	}
+}
+
+// publishBelowMinimum publishes STOCK_BELOW_MINIMUM if the available quantity
+// of the stock item with id dropped below its minimum from before to after
+func (wh *dafaultWarehouse) publishBelowMinimum(id string, before, after decimal.Decimal) {
+	item, ok := wh.ReadStock(id)
+	if !ok {
+		return
+	}
+
+	if before.Cmp(item.MinQuantity()) >= 0 && after.Cmp(item.MinQuantity()) < 0 {
+		wh.publish(STOCK_BELOW_MINIMUM, newStockDTO(item))
+	}
+}
+
+// publishMovement publishes the events of a committed movement and of the assembly it needed
+func (wh *dafaultWarehouse) publishMovement(mv *Movement) {
+	for _, assembly := range mv.assembly {
+		wh.publishMovement(assembly)
+	}
+
+	item, ok := wh.ReadStock(mv.StockID)
+	if !ok {
+		return
+	}
+
+	if mv.Kind == DISPENSE {
+		wh.publish(STOCK_DISPENSED, newMovementDTO(mv))
+	}
+
+	wh.publish(STOCK_UPDATED, newStockDTO(item))
+	if mv.availableBefore.Cmp(item.MinQuantity()) >= 0 && mv.availableAfter.Cmp(item.MinQuantity()) < 0 {
+		wh.publish(STOCK_BELOW_MINIMUM, newStockDTO(item))
+	}
+}
@@ -0,0 +1,334 @@
+package app
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ForecastOptions are the parameters of the reorder point calculation
+type ForecastOptions struct {
+	// From and To are the period [From, To) of the movement history the forecast is based on
+	From time.Time
+	To   time.Time
+
+	// ServiceLevel is the wanted probability of not running out of stock
+	// while waiting for a delivery, e.g. 0.95
+	ServiceLevel float64
+	// LeadTimeDays is the lead time of the distributors without a delivery history
+	LeadTimeDays float64
+	// ReviewDays is the number of days of consumption that an order should cover
+	ReviewDays float64
+}
+
+func (opts *ForecastOptions) validate() error {
+	errs := ValidationErrors{}
+
+	if !opts.From.Before(opts.To) {
+		errs = append(errs, ValidationError{"from", "the period must start before it ends"})
+	}
+	if opts.ServiceLevel <= 0 || opts.ServiceLevel >= 1 {
+		errs = append(errs, ValidationError{"serviceLevel", "service level must be between 0 and 1"})
+	}
+	if opts.LeadTimeDays < 0 {
+		errs = append(errs, ValidationError{"leadTime", "lead time must not be negative"})
+	}
+	if opts.ReviewDays <= 0 {
+		errs = append(errs, ValidationError{"reviewDays", "review period must be positive"})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// days returns the number of days in the history period, a started day counts as a whole one
+func (opts *ForecastOptions) days() int {
+	return int(math.Ceil(opts.To.Sub(opts.From).Hours() / 24))
+}
+
+// ReorderSuggestion is the suggested minimum quantity of a stock item calculated from its consumption.
+// The reorder point covers the consumption during the lead time and a safety stock
+// for the variability of the daily consumption: SafetyStock = z * DailyDeviation * sqrt(LeadTimeDays),
+// where z is the standard score of the service level.
+type ReorderSuggestion struct {
+	StockID string
+	Name    string
+
+	// DailyConsumption is the average quantity consumed per day and
+	// DailyDeviation is the standard deviation of the daily quantities
+	DailyConsumption decimal.Decimal
+	DailyDeviation   decimal.Decimal
+
+	LeadTimeDays decimal.Decimal
+	// LeadTimeMeasured is false if the distributor has no delivery history and the default lead time is used
+	LeadTimeMeasured bool
+
+	SafetyStock   decimal.Decimal
+	ReorderPoint  decimal.Decimal
+	OrderQuantity decimal.Decimal
+
+	// MinQuantity is the current minimum quantity of the item
+	MinQuantity decimal.Decimal
+}
+
+// Difference returns how much the suggested reorder point is above the current minimum quantity
+func (rs *ReorderSuggestion) Difference() decimal.Decimal {
+	return rs.ReorderPoint.Sub(rs.MinQuantity)
+}
+
+// dailyConsumption returns the quantities consumed by each stock item on each day of the period.
+// Dispenses and components taken for kits are consumed, returns to stock are not subtracted.
+func (wh *dafaultWarehouse) dailyConsumption(opts ForecastOptions) map[string][]float64 {
+	rows, err := wh.database.Query(`
+		SELECT
+			stock_id,
+			time,
+			quantity
+		FROM
+			stock_movements
+		WHERE
+			(kind = ? OR (kind = ? AND quantity < 0)) AND time >= ? AND time < ?
+	`, DISPENSE, ASSEMBLY, opts.From.UTC(), opts.To.UTC())
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var (
+		days        = opts.days()
+		consumption = make(map[string][]float64)
+	)
+	for rows.Next() {
+		var (
+			stockID  string
+			t        time.Time
+			quantity decimal.Decimal
+		)
+		if err = rows.Scan(&stockID, &t, &quantity); err != nil {
+			panic(err)
+		}
+
+		if _, ok := consumption[stockID]; !ok {
+			consumption[stockID] = make([]float64, days)
+		}
+		day := int(t.Sub(opts.From).Hours() / 24)
+		q, _ := quantity.Abs().Float64()
+		consumption[stockID][day] += q
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return consumption
+}
+
+// leadTimes returns the average number of days between ordering from a distributor
+// and receiving the lots that were invoiced for the orders
+func (wh *dafaultWarehouse) leadTimes() map[string]float64 {
+	rows, err := wh.database.Query(`
+		SELECT DISTINCT
+			o.id,
+			l.id,
+			o.distributor_id,
+			o.created,
+			l.received
+		FROM
+			invoice_lines i
+		JOIN
+			purchase_order_lines ol ON ol.id = i.order_line_id
+		JOIN
+			purchase_orders o ON o.id = ol.order_id
+		JOIN
+			stock_lots l ON l.id = i.lot_id
+	`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var (
+		total = make(map[string]float64)
+		count = make(map[string]int)
+	)
+	for rows.Next() {
+		var (
+			orderID, lotID, distributorID string
+			ordered, received             time.Time
+		)
+		if err = rows.Scan(&orderID, &lotID, &distributorID, &ordered, &received); err != nil {
+			panic(err)
+		}
+		if received.Before(ordered) {
+			// lots received before the order was entered say nothing about the lead time
+			continue
+		}
+		total[distributorID] += received.Sub(ordered).Hours() / 24
+		count[distributorID]++
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	leadTimes := make(map[string]float64, len(total))
+	for id, days := range total {
+		leadTimes[id] = days / float64(count[id])
+	}
+	return leadTimes
+}
+
+// roundUp rounds a suggested quantity up to a quantity that is valid for the item
+func roundUp(quantity float64, rule quantityRule) decimal.Decimal {
+	places := rule.MaxDecimalPlaces
+	if rule.IntegerOnly {
+		places = 0
+	}
+	// the float noise of exact quantities must not round them up
+	return decimal.NewFromFloat(quantity).Round(6).Shift(places).Ceil().Shift(-places)
+}
+
+// ReorderSuggestions calculates the reorder points and order quantities of all stock items
+// from their consumption in the period of the options. The items are ordered by name.
+func (wh *dafaultWarehouse) ReorderSuggestions(opts ForecastOptions) ([]*ReorderSuggestion, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		consumption = wh.dailyConsumption(opts)
+		leadTimes   = wh.leadTimes()
+		z           = math.Sqrt2 * math.Erfinv(2*opts.ServiceLevel-1)
+		suggestions = make([]*ReorderSuggestion, 0)
+	)
+	for _, item := range wh.Stock() {
+		var mean, deviation float64
+		if daily, ok := consumption[item.ID()]; ok {
+			for _, q := range daily {
+				mean += q
+			}
+			mean /= float64(len(daily))
+			for _, q := range daily {
+				deviation += (q - mean) * (q - mean)
+			}
+			deviation = math.Sqrt(deviation / float64(len(daily)))
+		}
+
+		leadTime, measured := leadTimes[item.DistributorID()]
+		if !measured {
+			leadTime = opts.LeadTimeDays
+		}
+
+		safetyStock := math.Max(z, 0) * deviation * math.Sqrt(leadTime)
+		rule := item.QuantityRule()
+		suggestions = append(suggestions, &ReorderSuggestion{
+			StockID:          item.ID(),
+			Name:             item.Name(),
+			DailyConsumption: decimal.NewFromFloat(mean).Round(3),
+			DailyDeviation:   decimal.NewFromFloat(deviation).Round(3),
+			LeadTimeDays:     decimal.NewFromFloat(leadTime).Round(1),
+			LeadTimeMeasured: measured,
+			SafetyStock:      roundUp(safetyStock, rule),
+			ReorderPoint:     roundUp(mean*leadTime+safetyStock, rule),
+			OrderQuantity:    roundUp(mean*opts.ReviewDays, rule),
+			MinQuantity:      item.MinQuantity(),
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Name != suggestions[j].Name {
+			return suggestions[i].Name < suggestions[j].Name
+		}
+		return suggestions[i].StockID < suggestions[j].StockID
+	})
+	return suggestions, nil
+}
+
+// ApplyReorderPoints sets the minimum quantities of the stock items with the given ids,
+// or of all stock items that were used in the period if no ids are given, to their
+// suggested reorder points.
+// The changes of all items are recorded in the audit trail, none of them is applied
+// if one cannot be recorded. The applied suggestions are returned.
+func (wh *dafaultWarehouse) ApplyReorderPoints(opts ForecastOptions, stockIDs []string, trail *auditTrail) ([]*ReorderSuggestion, error) {
+	suggestions, err := wh.ReorderSuggestions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stockIDs) > 0 {
+		byID := make(map[string]*ReorderSuggestion, len(suggestions))
+		for _, s := range suggestions {
+			byID[s.StockID] = s
+		}
+
+		selected := make([]*ReorderSuggestion, 0, len(stockIDs))
+		errs := ValidationErrors{}
+		for _, id := range stockIDs {
+			s, ok := byID[id]
+			if !ok {
+				errs = append(errs, ValidationError{"stockIDs", "no such stock item: " + id})
+				continue
+			}
+			selected = append(selected, s)
+		}
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		suggestions = selected
+	} else {
+		// without consumption the reorder point is zero, which would wipe the minimum
+		// quantities of items that are not used in the period but still have to be stocked
+		used := make([]*ReorderSuggestion, 0, len(suggestions))
+		for _, s := range suggestions {
+			if s.DailyConsumption.IsPositive() {
+				used = append(used, s)
+			}
+		}
+		suggestions = used
+	}
+
+	previous := make(map[string]Stock, len(suggestions))
+	for _, s := range suggestions {
+		if item, ok := wh.ReadStock(s.StockID); ok {
+			previous[s.StockID] = item
+		}
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	for _, s := range suggestions {
+		_, err := tx.Exec(`UPDATE warehouse SET min_quantity = ? WHERE id = ?`, s.ReorderPoint.String(), s.StockID)
+		if err != nil {
+			panic(err)
+		}
+
+		if item, ok := previous[s.StockID]; ok {
+			before, after := newStockDTO(item), newStockDTO(item)
+			after.MinQuantity = s.ReorderPoint.String()
+			if err := trail.recordTx(tx, AUDIT_STOCK, s.StockID, before, after); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+
+	for _, s := range suggestions {
+		s.MinQuantity = s.ReorderPoint
+		if item, ok := wh.ReadStock(s.StockID); ok && previous[s.StockID] != nil {
+			wh.publishStockChange(STOCK_UPDATED, item, previous[s.StockID])
+		}
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// forecastOptions reads the options of a forecast from the query parameters.
+// The history period is read like the period of a report, the service level
+// defaults to 0.95, the lead time to 7 days and the review period to 30 days.
+func forecastOptions(r *http.Request) (ForecastOptions, error) {
+	opts := ForecastOptions{ServiceLevel: 0.95, LeadTimeDays: 7, ReviewDays: 30}
+
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		return opts, err
+	}
+	opts.From, opts.To = from, to
+
+	var (
+		query = r.URL.Query()
+		errs  = ValidationErrors{}
+	)
+	params := []struct {
+		name  string
+		value *float64
+	}{
+		{"serviceLevel", &opts.ServiceLevel},
+		{"leadTime", &opts.LeadTimeDays},
+		{"reviewDays", &opts.ReviewDays},
+	}
+	for _, p := range params {
+		if s := query.Get(p.name); s != "" {
+			if *p.value, err = strconv.ParseFloat(s, 64); err != nil {
+				errs = append(errs, ValidationError{p.name, "invalid number"})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return opts, errs
+	}
+	return opts, opts.validate()
+}
+
+// Handler for GET /reports/reorder-points?from=<date>&to=<date>&serviceLevel=<0-1>&leadTime=<days>&reviewDays=<days>
+//
+// Compares the reorder points and order quantities calculated from the consumption
+// in the period with the current minimum quantities of the stock items.
+func (m *madminHandler) reorderReportHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := forecastOptions(r)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	suggestions, err := m.warehouse.ReorderSuggestions(opts)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newReorderReportDTO(opts, suggestions))
+}
+
+// Handler for POST /reports/reorder-points/apply?from=<date>&to=<date>&serviceLevel=<0-1>&leadTime=<days>&reviewDays=<days>
+//
+// Sets the minimum quantities of the stock items in the body, or of all stock items that
+// were used in the period if none are listed, to their reorder points and returns the applied suggestions.
+func (m *madminHandler) applyReorderPointsHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &ApplyReorderPointsDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	opts, err := forecastOptions(r)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	suggestions, err := m.warehouse.ApplyReorderPoints(opts, dto.StockIDs, m.auditTrail(r))
+	if err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newReorderReportDTO(opts, suggestions))
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestReorderSuggestions(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+		wh            = madminHandler.warehouse
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	collar, _ := defaultUnexpirableStockItem(ACCESSORY)
+	collar.SetQuantity(decimal.New(20, 0))
+	wh.CreateStock(collar, nil)
+
+	idle, _ := defaultUnexpirableStockItem(ACCESSORY)
+	idle.SetMinQuantity(decimal.New(5, 0))
+	wh.CreateStock(idle, nil)
+
+	for _, quantity := range []int64{4, 2} {
+		wh.RecordMovement(&Movement{StockID: collar.ID(), Kind: DISPENSE, Quantity: decimal.New(quantity, 0)})
+	}
+
+	// 6 collars on one of four days without a delivery history
+	from := time.Now().UTC().AddDate(0, 0, -3).Truncate(time.Hour)
+	opts := ForecastOptions{From: from, To: from.AddDate(0, 0, 4), ServiceLevel: 0.5, LeadTimeDays: 4, ReviewDays: 10}
+
+	if _, err := wh.ReorderSuggestions(ForecastOptions{From: opts.From, To: opts.To, ServiceLevel: 1, ReviewDays: 10}); err == nil {
+		t.Fatalf(`ReorderSuggestions accepts a service level of 1`)
+	}
+
+	suggestions, err := wh.ReorderSuggestions(opts)
+	if err != nil {
+		t.Fatalf(`ReorderSuggestions returns an error for valid options: %s`, err)
+	}
+	byID := make(map[string]*ReorderSuggestion)
+	for _, s := range suggestions {
+		byID[s.StockID] = s
+	}
+	if s := byID[collar.ID()]; !s.DailyConsumption.Equal(decimal.New(15, -1)) || !s.DailyDeviation.Equal(decimal.New(2598, -3)) ||
+		!s.ReorderPoint.Equal(decimal.New(6, 0)) || !s.OrderQuantity.Equal(decimal.New(15, 0)) || s.LeadTimeMeasured {
+		t.Fatalf(`Unexpected suggestion %+v`, s)
+	}
+	if s := byID[idle.ID()]; !s.ReorderPoint.IsZero() || !s.Difference().Equal(decimal.New(-5, 0)) {
+		t.Fatalf(`Unexpected suggestion for an item without consumption %+v`, s)
+	}
+
+	// the safety stock covers the variability at a higher service level
+	opts.ServiceLevel = 0.95
+	suggestions, _ = wh.ReorderSuggestions(opts)
+	for _, s := range suggestions {
+		if s.StockID == collar.ID() && (!s.SafetyStock.Equal(decimal.New(9, 0)) || !s.ReorderPoint.Equal(decimal.New(15, 0))) {
+			t.Fatalf(`Unexpected suggestion at service level 0.95 %+v`, s)
+		}
+	}
+
+	query := url.Values{}
+	query.Set("from", opts.From.Format(dateLayout))
+	query.Set("to", opts.To.Format(dateLayout))
+	query.Set("serviceLevel", "0.5")
+	query.Set("leadTime", "4")
+	body, _ := json.Marshal(&ApplyReorderPointsDTO{StockIDs: []string{collar.ID()}})
+	resp, err := http.Post(buildURL(s.URL, fmt.Sprintf("/data/reports/reorder-points/apply?%s", query.Encode())), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error sending POST request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	if item, _ := wh.ReadStock(collar.ID()); !item.MinQuantity().Equal(decimal.New(6, 0)) {
+		t.Fatalf(`Expected the reorder point as minimum quantity, got %s`, item.MinQuantity())
+	}
+	if item, _ := wh.ReadStock(idle.ID()); !item.MinQuantity().Equal(decimal.New(5, 0)) {
+		t.Fatalf(`A stock item that was not selected got a new minimum quantity %s`, item.MinQuantity())
+	}
+
+	// applying all suggestions leaves the minimum quantities of unused items alone
+	query.Set("serviceLevel", "0.95")
+	body, _ = json.Marshal(&ApplyReorderPointsDTO{})
+	resp, err = http.Post(buildURL(s.URL, fmt.Sprintf("/data/reports/reorder-points/apply?%s", query.Encode())), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error sending POST request: %s", err)
+	}
+	report := &ReorderReportDTO{}
+	json.NewDecoder(resp.Body).Decode(report)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(report.Suggestions) != 1 {
+		t.Fatalf("Expected status %d and a single applied suggestion, got %d %+v", http.StatusOK, resp.StatusCode, report)
+	}
+
+	if item, _ := wh.ReadStock(collar.ID()); !item.MinQuantity().Equal(decimal.New(15, 0)) {
+		t.Fatalf(`Expected the reorder point at service level 0.95 as minimum quantity, got %s`, item.MinQuantity())
+	}
+	if item, _ := wh.ReadStock(idle.ID()); !item.MinQuantity().Equal(decimal.New(5, 0)) {
+		t.Fatalf(`A stock item without consumption got a new minimum quantity %s`, item.MinQuantity())
+	}
+}
@@ -0,0 +1,220 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// gs1GroupSeparator is the FNC1 character that ends variable length fields in GS1 codes
+const gs1GroupSeparator = '\x1d'
+
+// gs1Symbologies are the symbology identifiers scanners prefix GS1 codes with
+var gs1Symbologies = []string{"]d2", "]C1", "]Q3", "]e0"}
+
+// gs1FieldLengths are the lengths of the fixed length fields of the supported application identifiers.
+// Fields with a zero length have a variable length of up to gs1MaxVariableLength characters.
+var gs1FieldLengths = map[string]int{
+	"00": 18, // SSCC
+	"01": 14, // GTIN
+	"02": 14, // GTIN of contained trade items
+	"10": 0,  // batch or lot number
+	"11": 6,  // production date
+	"13": 6,  // packaging date
+	"15": 6,  // best before date
+	"17": 6,  // expiration date
+	"20": 2,  // product variant
+	"21": 0,  // serial number
+	"30": 0,  // variable count
+	"37": 0,  // count of trade items
+}
+
+const gs1MaxVariableLength = 20
+
+// GS1Code is the data of a scanned barcode.
+// Plain GTIN/EAN barcodes only have a GTIN.
+type GS1Code struct {
+	// GTIN is normalized to 14 digits
+	GTIN string
+
+	LotNumber string
+	// ExpirationDate is nil if the code has no expiration date
+	ExpirationDate *time.Time
+	SerialNumber   string
+}
+
+// isDigits checks if s is a non-empty string of decimal digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeGTIN validates a GTIN-8, GTIN-12 (UPC), GTIN-13 (EAN) or GTIN-14
+// and pads it with zeros to 14 digits
+func normalizeGTIN(code string) (string, error) {
+	code = strings.TrimSpace(code)
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return "", errors.New("a GTIN must have 8, 12, 13 or 14 digits")
+	}
+	if !isDigits(code) {
+		return "", errors.New("a GTIN must contain only digits")
+	}
+
+	gtin := strings.Repeat("0", 14-len(code)) + code
+
+	sum := 0
+	for i, c := range gtin[:13] {
+		digit := int(c - '0')
+		if i%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	if check := (10 - sum%10) % 10; check != int(gtin[13]-'0') {
+		return "", errors.New("invalid GTIN check digit")
+	}
+
+	return gtin, nil
+}
+
+// parseGS1Date parses a YYMMDD date. A day of 00 means the last day of the month.
+func parseGS1Date(s string) (time.Time, error) {
+	if !isDigits(s) || len(s) != 6 {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+
+	var (
+		year  = 2000 + int(s[0]-'0')*10 + int(s[1]-'0')
+		month = time.Month(int(s[2]-'0')*10 + int(s[3]-'0'))
+		day   = int(s[4]-'0')*10 + int(s[5]-'0')
+	)
+	if month < 1 || month > 12 {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+
+	if day == 0 {
+		return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC), nil
+	}
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if date.Day() != day {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return date, nil
+}
+
+// gs1Fields splits a GS1 element string into its application identifiers and values.
+// Both the raw form with group separators and the human readable form "(01)...(10)..." are supported.
+func gs1Fields(s string) (map[string]string, error) {
+	fields := make(map[string]string)
+
+	if strings.HasPrefix(s, "(") {
+		for _, part := range strings.Split(s[1:], "(") {
+			i := strings.Index(part, ")")
+			if i < 0 {
+				return nil, errors.New("unbalanced parentheses in GS1 code")
+			}
+			ai, value := part[:i], part[i+1:]
+			length, ok := gs1FieldLengths[ai]
+			if !ok {
+				return nil, fmt.Errorf("unsupported application identifier %s", ai)
+			}
+			if (length > 0 && len(value) != length) || (length == 0 && (value == "" || len(value) > gs1MaxVariableLength)) {
+				return nil, fmt.Errorf("invalid length of application identifier %s", ai)
+			}
+			fields[ai] = value
+		}
+		return fields, nil
+	}
+
+	for len(s) > 0 {
+		if s[0] == gs1GroupSeparator {
+			s = s[1:]
+			continue
+		}
+		if len(s) < 2 {
+			return nil, errors.New("truncated GS1 code")
+		}
+
+		ai := s[:2]
+		length, ok := gs1FieldLengths[ai]
+		if !ok {
+			return nil, fmt.Errorf("unsupported application identifier %s", ai)
+		}
+		s = s[2:]
+
+		if length == 0 {
+			length = strings.IndexRune(s, gs1GroupSeparator)
+			if length < 0 {
+				length = len(s)
+			}
+			if length == 0 || length > gs1MaxVariableLength {
+				return nil, fmt.Errorf("invalid length of application identifier %s", ai)
+			}
+		} else if len(s) < length {
+			return nil, fmt.Errorf("truncated application identifier %s", ai)
+		}
+
+		fields[ai] = s[:length]
+		s = s[length:]
+	}
+
+	return fields, nil
+}
+
+// ParseGS1 parses a scanned barcode. It accepts plain GTIN/EAN codes
+// and GS1 codes with the application identifiers 01 (GTIN), 10 (lot),
+// 17 (expiration date) and 21 (serial number). Other common fixed length
+// identifiers are skipped.
+func ParseGS1(code string) (*GS1Code, error) {
+	code = strings.TrimRight(code, "\r\n")
+	for _, prefix := range gs1Symbologies {
+		code = strings.TrimPrefix(code, prefix)
+	}
+	if code == "" {
+		return nil, errors.New("empty barcode")
+	}
+
+	if isDigits(code) && len(code) <= 14 {
+		gtin, err := normalizeGTIN(code)
+		if err != nil {
+			return nil, err
+		}
+		return &GS1Code{GTIN: gtin}, nil
+	}
+
+	fields, err := gs1Fields(code)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &GS1Code{
+		LotNumber:    fields["10"],
+		SerialNumber: fields["21"],
+	}
+	gtin, ok := fields["01"]
+	if !ok {
+		return nil, errors.New("GS1 code has no GTIN")
+	}
+	if result.GTIN, err = normalizeGTIN(gtin); err != nil {
+		return nil, err
+	}
+	if date, ok := fields["17"]; ok {
+		expirationDate, err := parseGS1Date(date)
+		if err != nil {
+			return nil, err
+		}
+		result.ExpirationDate = &expirationDate
+	}
+
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseGS1(t *testing.T) {
+	expirationDate := time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		code  string
+		valid bool
+		want  GS1Code
+	}{
+		{"4006381333931", true, GS1Code{GTIN: "04006381333931"}},
+		{"4006381333932", false, GS1Code{}},
+		{"]d201095060001343521725123110AB-123\x1d21SN42", true, GS1Code{GTIN: "09506000134352", LotNumber: "AB-123", ExpirationDate: &expirationDate, SerialNumber: "SN42"}},
+		{"(01)09506000134352(17)251200(10)AB-123", true, GS1Code{GTIN: "09506000134352", LotNumber: "AB-123", ExpirationDate: &expirationDate}},
+		{"010950600013435210AB-123", true, GS1Code{GTIN: "09506000134352", LotNumber: "AB-123"}},
+		{"0109506000134352171302301", false, GS1Code{}},
+		{"1010AB-123", false, GS1Code{}},
+		{"01095060001343529912", false, GS1Code{}},
+		{"", false, GS1Code{}},
+	}
+
+	for _, test := range tests {
+		code, err := ParseGS1(test.code)
+		if (err == nil) != test.valid {
+			t.Errorf(`ParseGS1(%q) returns error %v`, test.code, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if code.GTIN != test.want.GTIN || code.LotNumber != test.want.LotNumber || code.SerialNumber != test.want.SerialNumber ||
+			(code.ExpirationDate == nil) != (test.want.ExpirationDate == nil) ||
+			(code.ExpirationDate != nil && !code.ExpirationDate.Equal(*test.want.ExpirationDate)) {
+			t.Errorf(`ParseGS1(%q) = %+v, expected %+v`, test.code, code, test.want)
+		}
+	}
+}
+
+func TestScanReceipt(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	madminHandler.warehouse.CreateStock(item, nil)
+
+	other, _ := defaultUnexpirableStockItem(ACCESSORY)
+	madminHandler.warehouse.CreateStock(other, nil)
+
+	if err := madminHandler.warehouse.AddBarcode(item.ID(), "9506000134352"); err != nil {
+		t.Fatalf(`AddBarcode returns an error for a valid EAN: %s`, err)
+	}
+	if err := madminHandler.warehouse.AddBarcode(other.ID(), "09506000134352"); err == nil {
+		t.Fatalf(`AddBarcode assigns the barcode of an item to another item`)
+	}
+
+	resp, err := http.Get(buildURL(s.URL, "/data/stock/by-barcode/09506000134352"))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	found := &StockDTO{}
+	json.NewDecoder(resp.Body).Decode(found)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || found.ID != item.ID() || len(found.Barcodes) != 1 {
+		t.Fatalf("Unexpected stock item for barcode: %d %+v", resp.StatusCode, found)
+	}
+
+	body, _ := json.Marshal(&BarcodeDTO{Code: "01095060001343521725123110AB-123"})
+	resp, err = http.Post(buildURL(s.URL, "/data/stock/scan"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error sending POST request: %s", err)
+	}
+	scan := &ScanDTO{}
+	json.NewDecoder(resp.Body).Decode(scan)
+	resp.Body.Close()
+
+	if scan.Stock == nil || scan.Receipt == nil || scan.Receipt.Lot == nil || scan.Receipt.Lot.Number != "AB-123" {
+		t.Fatalf("Unexpected scan result %+v", scan)
+	}
+
+	// the pre-filled receipt is accepted once a quantity is set
+	scan.Receipt.Quantity = "10"
+	body, _ = json.Marshal(scan.Receipt)
+	resp, err = http.Post(buildURL(s.URL, fmt.Sprintf("/data/stock/%s/movements", item.ID())), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error sending POST request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected %d but got %d for the scanned receipt", http.StatusCreated, resp.StatusCode)
+	}
+}
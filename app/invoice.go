@@ -0,0 +1,591 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type invoiceState string
+
+// Distributor invoices are OPEN until they are APPROVED for payment and then PAID.
+// Invoices that do not match their orders and receipts are only approved
+// if their discrepancies are accepted.
+const (
+	INVOICE_OPEN     invoiceState = "open"
+	INVOICE_APPROVED invoiceState = "approved"
+	INVOICE_PAID     invoiceState = "paid"
+)
+
+// Invoice is a distributor's bill for the delivered lots of purchase orders
+type Invoice struct {
+	ID            string
+	DistributorID string
+	// Number is the distributor's invoice number
+	Number string
+
+	Date    time.Time
+	DueDate time.Time
+
+	Lines []*InvoiceLine
+	State invoiceState
+
+	Created time.Time
+	UserID  string
+
+	Approved   *time.Time
+	ApprovedBy string
+	Paid       *time.Time
+	PaidBy     string
+}
+
+// InvoiceLine is an invoiced quantity of a received lot for the line of a purchase order
+type InvoiceLine struct {
+	PurchaseOrderLineID string
+	LotID               string
+	Quantity            decimal.Decimal
+	UnitPrice           decimal.Decimal
+}
+
+// Total returns the invoiced amount of the line
+func (l *InvoiceLine) Total() decimal.Decimal {
+	return l.Quantity.Mul(l.UnitPrice).Round(2)
+}
+
+// Total returns the invoiced amount
+func (inv *Invoice) Total() decimal.Decimal {
+	total := decimal.Zero
+	for _, l := range inv.Lines {
+		total = total.Add(l.Total())
+	}
+	return total
+}
+
+// isOverdue reports if the invoice is not paid after its due date
+func (inv *Invoice) isOverdue(now time.Time) bool {
+	return inv.State != INVOICE_PAID && now.After(inv.DueDate)
+}
+
+type discrepancy string
+
+// The discrepancies of the three-way match of an invoice line with its purchase order line and lot:
+// NOT_RECEIVED - more of the lot is invoiced than was received,
+// NOT_ORDERED - more of the order line is invoiced than was ordered,
+// ORDER_PRICE - the invoiced price is not the ordered price,
+// RECEIPT_PRICE - the invoiced price is not the unit cost of the received lot.
+const (
+	NOT_RECEIVED  discrepancy = "not-received"
+	NOT_ORDERED   discrepancy = "not-ordered"
+	ORDER_PRICE   discrepancy = "order-price"
+	RECEIPT_PRICE discrepancy = "receipt-price"
+)
+
+// InvoiceLineMatch is the three-way match of an invoice line.
+// The invoiced quantities include the earlier invoices of the same lot and order line.
+type InvoiceLineMatch struct {
+	Line    int
+	StockID string
+
+	Ordered       decimal.Decimal
+	Received      decimal.Decimal
+	OrderInvoiced decimal.Decimal
+	LotInvoiced   decimal.Decimal
+
+	OrderPrice   decimal.Decimal
+	ReceiptPrice decimal.Decimal
+	InvoicePrice decimal.Decimal
+
+	Discrepancies []discrepancy
+}
+
+// Payables is the state of a distributor's invoices
+type Payables struct {
+	DistributorID string
+
+	// Outstanding is the amount of the unpaid invoices, Overdue is the part of it that is past due
+	Outstanding decimal.Decimal
+	Overdue     decimal.Decimal
+	Paid        decimal.Decimal
+
+	// Unpaid are the unpaid invoices, the ones that are due first are first
+	Unpaid []*Invoice
+}
+
+func (wh *dafaultWarehouse) initInvoicesTables() {
+	invoicesTables := `
+	CREATE TABLE IF NOT EXISTS
+		invoices (
+			id TEXT NOT NULL PRIMARY KEY,
+			distributor_id TEXT NOT NULL,
+			number TEXT NOT NULL,
+			date DATETIME NOT NULL,
+			due_date DATETIME NOT NULL,
+			state TEXT NOT NULL,
+			created DATETIME NOT NULL,
+			user_id TEXT NOT NULL,
+			approved DATETIME,
+			approved_by TEXT NOT NULL,
+			paid DATETIME,
+			paid_by TEXT NOT NULL,
+			UNIQUE (distributor_id, number),
+			FOREIGN KEY (distributor_id) REFERENCES distributors (id)
+	);
+	CREATE TABLE IF NOT EXISTS
+		invoice_lines (
+			invoice_id TEXT NOT NULL,
+			order_line_id TEXT NOT NULL,
+			lot_id TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			unit_price NUMERIC NOT NULL,
+			FOREIGN KEY (invoice_id) REFERENCES invoices (id),
+			FOREIGN KEY (order_line_id) REFERENCES purchase_order_lines (id)
+	);
+	CREATE INDEX IF NOT EXISTS
+		invoice_lines_order_line_id ON invoice_lines (order_line_id);
+	CREATE INDEX IF NOT EXISTS
+		invoice_lines_lot_id ON invoice_lines (lot_id);
+	`
+	_, err := wh.database.Exec(invoicesTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (inv *Invoice) validate(wh *dafaultWarehouse) error {
+	errs := ValidationErrors{}
+
+	if _, ok := wh.ReadDistributor(inv.DistributorID); !ok {
+		errs = append(errs, ValidationError{"distributorID", "no such distributor"})
+	}
+	if strings.TrimSpace(inv.Number) == "" {
+		errs = append(errs, ValidationError{"number", "no invoice number set"})
+	} else {
+		var invoices int
+		err := wh.database.QueryRow(`
+			SELECT
+				COUNT(*)
+			FROM
+				invoices
+			WHERE
+				distributor_id = ? AND number = ?
+		`, inv.DistributorID, inv.Number).Scan(&invoices)
+		if err != nil {
+			panic(err)
+		}
+		if invoices > 0 {
+			errs = append(errs, ValidationError{"number", "the invoice is already recorded"})
+		}
+	}
+	if inv.DueDate.Before(inv.Date) {
+		errs = append(errs, ValidationError{"dueDate", "the invoice is due before its date"})
+	}
+	if len(inv.Lines) == 0 {
+		errs = append(errs, ValidationError{"lines", "no stock invoiced"})
+	}
+
+	for i, line := range inv.Lines {
+		field := func(name string) string { return fmt.Sprintf("lines[%d].%s", i, name) }
+
+		orderLine, orderID, ok := wh.readPurchaseOrderLine(line.PurchaseOrderLineID)
+		if !ok {
+			errs = append(errs, ValidationError{field("purchaseOrderLineID"), "no such purchase order line"})
+			continue
+		}
+		if po, _ := wh.ReadPurchaseOrder(orderID); po.DistributorID != inv.DistributorID {
+			errs = append(errs, ValidationError{field("purchaseOrderLineID"), "the stock was ordered from another distributor"})
+		}
+		if lot, ok := wh.ReadLot(line.LotID); !ok || lot.StockID != orderLine.StockID {
+			errs = append(errs, ValidationError{field("lotID"), "no lot of the ordered stock item"})
+		}
+		if line.Quantity.Sign() <= 0 {
+			errs = append(errs, ValidationError{field("quantity"), "quantity must be positive"})
+		}
+		if line.UnitPrice.Sign() < 0 {
+			errs = append(errs, ValidationError{field("unitPrice"), "price must not be negative"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CreateInvoice records an open invoice of a distributor.
+// Invoices without a due date are due 30 days after their date.
+func (wh *dafaultWarehouse) CreateInvoice(inv *Invoice) error {
+	if inv.DueDate.IsZero() {
+		inv.DueDate = inv.Date.AddDate(0, 0, 30)
+	}
+	if err := inv.validate(wh); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	inv.ID = id
+	inv.State = INVOICE_OPEN
+	inv.Created = time.Now().UTC()
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			invoices (
+				id,
+				distributor_id,
+				number,
+				date,
+				due_date,
+				state,
+				created,
+				user_id,
+				approved_by,
+				paid_by)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, '', '')
+	`,
+		inv.ID,
+		inv.DistributorID,
+		inv.Number,
+		inv.Date,
+		inv.DueDate,
+		inv.State,
+		inv.Created,
+		inv.UserID)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, line := range inv.Lines {
+		_, err = tx.Exec(`
+			INSERT INTO
+				invoice_lines (
+					invoice_id,
+					order_line_id,
+					lot_id,
+					quantity,
+					unit_price)
+			VALUES(?, ?, ?, ?, ?)
+		`, inv.ID, line.PurchaseOrderLineID, line.LotID, line.Quantity.String(), line.UnitPrice.String())
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// invoiceColumns are the columns of the invoices table, in the order expected by scanInvoice
+const invoiceColumns = `
+	id,
+	distributor_id,
+	number,
+	date,
+	due_date,
+	state,
+	created,
+	user_id,
+	approved,
+	approved_by,
+	paid,
+	paid_by
+`
+
+func scanInvoice(row rowScanner) (*Invoice, error) {
+	inv := &Invoice{}
+	err := row.Scan(
+		&inv.ID,
+		&inv.DistributorID,
+		&inv.Number,
+		&inv.Date,
+		&inv.DueDate,
+		&inv.State,
+		&inv.Created,
+		&inv.UserID,
+		&inv.Approved,
+		&inv.ApprovedBy,
+		&inv.Paid,
+		&inv.PaidBy)
+	return inv, err
+}
+
+func (wh *dafaultWarehouse) ReadInvoice(id string) (*Invoice, bool) {
+	inv, err := scanInvoice(wh.database.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = ?`, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	inv.Lines = wh.invoiceLines(inv.ID)
+	return inv, true
+}
+
+func (wh *dafaultWarehouse) invoiceLines(invoiceID string) []*InvoiceLine {
+	rows, err := wh.database.Query(`
+		SELECT
+			order_line_id,
+			lot_id,
+			quantity,
+			unit_price
+		FROM
+			invoice_lines
+		WHERE
+			invoice_id = ?
+		ORDER BY
+			rowid
+	`, invoiceID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	lines := make([]*InvoiceLine, 0)
+	for rows.Next() {
+		line := &InvoiceLine{}
+		if err = rows.Scan(&line.PurchaseOrderLineID, &line.LotID, &line.Quantity, &line.UnitPrice); err != nil {
+			panic(err)
+		}
+		lines = append(lines, line)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return lines
+}
+
+// Invoices returns the invoices of the distributor, or of all distributors for an empty
+// distributor id, in the given state or in any state. The ones that are due first are first.
+func (wh *dafaultWarehouse) Invoices(distributorID string, state invoiceState) []*Invoice {
+	rows, err := wh.database.Query(`
+		SELECT `+invoiceColumns+`
+		FROM
+			invoices
+		WHERE
+			(? = '' OR distributor_id = ?) AND (? = '' OR state = ?)
+		ORDER BY
+			due_date, rowid
+	`, distributorID, distributorID, state, state)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	invoices := make([]*Invoice, 0)
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			panic(err)
+		}
+		invoices = append(invoices, inv)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	for _, inv := range invoices {
+		inv.Lines = wh.invoiceLines(inv.ID)
+	}
+	return invoices
+}
+
+// sumQuantities returns the sum of the quantities selected by the query
+func (wh *dafaultWarehouse) sumQuantities(query string, args ...interface{}) decimal.Decimal {
+	rows, err := wh.database.Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	sum := decimal.Zero
+	for rows.Next() {
+		var quantity decimal.Decimal
+		if err = rows.Scan(&quantity); err != nil {
+			panic(err)
+		}
+		sum = sum.Add(quantity)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return sum
+}
+
+// MatchInvoice compares every line of the invoice with the ordered quantity and price
+// of its purchase order line and with the received quantity and unit cost of its lot
+func (wh *dafaultWarehouse) MatchInvoice(id string) ([]*InvoiceLineMatch, bool) {
+	inv, ok := wh.ReadInvoice(id)
+	if !ok {
+		return nil, false
+	}
+
+	matches := make([]*InvoiceLineMatch, 0, len(inv.Lines))
+	for i, line := range inv.Lines {
+		orderLine, _, _ := wh.readPurchaseOrderLine(line.PurchaseOrderLineID)
+		lot, _ := wh.ReadLot(line.LotID)
+
+		m := &InvoiceLineMatch{
+			Line:          i,
+			StockID:       orderLine.StockID,
+			Ordered:       orderLine.Quantity,
+			OrderPrice:    orderLine.UnitPrice,
+			ReceiptPrice:  lot.UnitCost,
+			InvoicePrice:  line.UnitPrice,
+			Discrepancies: make([]discrepancy, 0),
+		}
+		m.Received = wh.sumQuantities(`
+			SELECT
+				quantity
+			FROM
+				stock_movements
+			WHERE
+				lot_id = ? AND kind = ?
+		`, line.LotID, RECEIPT)
+		// the invoices up to this one, later invoices do not change its match
+		m.OrderInvoiced = wh.sumQuantities(`
+			SELECT
+				l.quantity
+			FROM
+				invoice_lines l
+			JOIN
+				invoices i ON i.id = l.invoice_id
+			WHERE
+				l.order_line_id = ? AND i.rowid <= (SELECT rowid FROM invoices WHERE id = ?)
+		`, line.PurchaseOrderLineID, inv.ID)
+		m.LotInvoiced = wh.sumQuantities(`
+			SELECT
+				l.quantity
+			FROM
+				invoice_lines l
+			JOIN
+				invoices i ON i.id = l.invoice_id
+			WHERE
+				l.lot_id = ? AND i.rowid <= (SELECT rowid FROM invoices WHERE id = ?)
+		`, line.LotID, inv.ID)
+
+		if m.LotInvoiced.GreaterThan(m.Received) {
+			m.Discrepancies = append(m.Discrepancies, NOT_RECEIVED)
+		}
+		if m.OrderInvoiced.GreaterThan(m.Ordered) {
+			m.Discrepancies = append(m.Discrepancies, NOT_ORDERED)
+		}
+		if !m.InvoicePrice.Equal(m.OrderPrice) {
+			m.Discrepancies = append(m.Discrepancies, ORDER_PRICE)
+		}
+		// lots that were received without a cost cannot be compared
+		if !m.ReceiptPrice.IsZero() && !m.InvoicePrice.Equal(m.ReceiptPrice) {
+			m.Discrepancies = append(m.Discrepancies, RECEIPT_PRICE)
+		}
+
+		matches = append(matches, m)
+	}
+
+	return matches, true
+}
+
+// isMatched reports if no line of the match has discrepancies
+func isMatched(matches []*InvoiceLineMatch) bool {
+	for _, m := range matches {
+		if len(m.Discrepancies) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ApproveInvoice approves an open invoice for payment. An invoice with discrepancies
+// is only approved if they are accepted.
+func (wh *dafaultWarehouse) ApproveInvoice(id, userID string, acceptDiscrepancies bool) error {
+	matches, ok := wh.MatchInvoice(id)
+	if !ok {
+		return errors.New("no such invoice")
+	}
+	if !isMatched(matches) && !acceptDiscrepancies {
+		return ValidationErrors{{"acceptDiscrepancies", "the invoice does not match its orders and receipts"}}
+	}
+
+	return wh.changeInvoiceState(id, INVOICE_OPEN, INVOICE_APPROVED, "approved", userID)
+}
+
+// PayInvoice marks an approved invoice as paid
+func (wh *dafaultWarehouse) PayInvoice(id, userID string) error {
+	if _, ok := wh.ReadInvoice(id); !ok {
+		return errors.New("no such invoice")
+	}
+
+	return wh.changeInvoiceState(id, INVOICE_APPROVED, INVOICE_PAID, "paid", userID)
+}
+
+// changeInvoiceState moves an invoice from one state to the next and records the time
+// and user in the columns that are named after the new state
+func (wh *dafaultWarehouse) changeInvoiceState(id string, from, to invoiceState, column, userID string) error {
+	res, err := wh.database.Exec(`
+		UPDATE
+			invoices
+		SET
+			state = ?,
+			`+column+` = ?,
+			`+column+`_by = ?
+		WHERE
+			id = ? AND state = ?
+	`, to, time.Now().UTC(), userID, id, from)
+	if err != nil {
+		panic(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		panic(err)
+	} else if n == 0 {
+		return fmt.Errorf("only %s invoices can be %s", from, to)
+	}
+
+	return nil
+}
+
+// Payables returns the outstanding, overdue and paid amounts of the distributor's invoices
+func (wh *dafaultWarehouse) Payables(distributorID string) *Payables {
+	var (
+		now = time.Now()
+		p   = &Payables{
+			DistributorID: distributorID,
+			Outstanding:   decimal.Zero,
+			Overdue:       decimal.Zero,
+			Paid:          decimal.Zero,
+			Unpaid:        make([]*Invoice, 0),
+		}
+	)
+
+	for _, inv := range wh.Invoices(distributorID, "") {
+		if inv.State == INVOICE_PAID {
+			p.Paid = p.Paid.Add(inv.Total())
+			continue
+		}
+		p.Outstanding = p.Outstanding.Add(inv.Total())
+		if inv.isOverdue(now) {
+			p.Overdue = p.Overdue.Add(inv.Total())
+		}
+		p.Unpaid = append(p.Unpaid, inv)
+	}
+
+	return p
+}
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (m *madminHandler) purchaseOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listPurchaseOrdersHandler(w, r)
+	case "POST":
+		m.addPurchaseOrderHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /purchase-orders/?distributorID=<id>
+//
+// Lists the purchase orders, the latest are first. With distributorID,
+// only the orders from the distributor are listed.
+func (m *madminHandler) listPurchaseOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	orders := m.warehouse.PurchaseOrders(r.URL.Query().Get("distributorID"))
+
+	resp := make([]*PurchaseOrderDTO, 0, len(orders))
+	for _, po := range orders {
+		resp = append(resp, newPurchaseOrderDTO(po))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /purchase-orders/
+//
+// Saves an order of stock from a distributor and returns it with the ids of its lines.
+func (m *madminHandler) addPurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewPurchaseOrderDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	po, err := dto.purchaseOrder()
+	if err == nil {
+		po.UserID = requestUserID(r)
+		err = m.warehouse.CreatePurchaseOrder(po)
+	}
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, newPurchaseOrderDTO(po))
+}
+
+// Handler for GET /purchase-orders/<id>
+//
+// Returns JSON with data for the purchase order with the given id.
+func (m *madminHandler) getPurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	po, ok := m.warehouse.ReadPurchaseOrder(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newPurchaseOrderDTO(po))
+}
+
+func (m *madminHandler) invoicesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listInvoicesHandler(w, r)
+	case "POST":
+		m.addInvoiceHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /invoices/?distributorID=<id>&state=<state>
+//
+// Lists the invoices, the ones that are due first are first.
+// The invoices can be filtered by distributor and state.
+func (m *madminHandler) listInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	state := invoiceState(query.Get("state"))
+	if state != "" && state != INVOICE_OPEN && state != INVOICE_APPROVED && state != INVOICE_PAID {
+		respondBadRequest(w, ValidationErrors{{"state", "invalid invoice state"}})
+		return
+	}
+
+	invoices := m.warehouse.Invoices(query.Get("distributorID"), state)
+
+	resp := make([]*InvoiceDTO, 0, len(invoices))
+	for _, inv := range invoices {
+		resp = append(resp, newInvoiceDTO(inv))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /invoices/
+//
+// Records a distributor's invoice and returns it.
+func (m *madminHandler) addInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewInvoiceDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	inv, err := dto.invoice()
+	if err == nil {
+		inv.UserID = requestUserID(r)
+		err = m.warehouse.CreateInvoice(inv)
+	}
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, newInvoiceDTO(inv))
+}
+
+// Handler for GET /invoices/<id>
+//
+// Returns JSON with data for the invoice with the given id.
+func (m *madminHandler) getInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	inv, ok := m.warehouse.ReadInvoice(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newInvoiceDTO(inv))
+}
+
+// Handler for GET /invoices/<id>/match
+//
+// Returns the three-way match of the invoice with <id> against the ordered
+// and received quantities and prices, with the discrepancies of every line.
+func (m *madminHandler) invoiceMatchHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	matches, ok := m.warehouse.MatchInvoice(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newInvoiceMatchDTO(id, matches))
+}
+
+// Handler for POST /invoices/<id>/approve
+//
+// Approves the invoice with <id> for payment. Invoices with discrepancies
+// are only approved with acceptDiscrepancies.
+func (m *madminHandler) approveInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &InvoiceApprovalDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadInvoice(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := m.warehouse.ApproveInvoice(id, requestUserID(r), dto.AcceptDiscrepancies); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	inv, _ := m.warehouse.ReadInvoice(id)
+	respondJSON(w, http.StatusOK, newInvoiceDTO(inv))
+}
+
+// Handler for POST /invoices/<id>/pay
+//
+// Marks the approved invoice with <id> as paid.
+func (m *madminHandler) payInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.ReadInvoice(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := m.warehouse.PayInvoice(id, requestUserID(r)); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	inv, _ := m.warehouse.ReadInvoice(id)
+	respondJSON(w, http.StatusOK, newInvoiceDTO(inv))
+}
+
+// Handler for GET /distributors/<id>/payables
+//
+// Returns the outstanding, overdue and paid amounts of the distributor's invoices
+// with the unpaid invoices.
+func (m *madminHandler) payablesHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.ReadDistributor(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newPayablesDTO(m.warehouse.Payables(id)))
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestInvoiceMatch(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+		wh            = madminHandler.warehouse
+		expiration    = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	distributor, _ := NewDistributor("Vet Supplies")
+	wh.CreateDistributor(distributor, nil)
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	item.SetQuantity(decimal.Zero)
+	wh.CreateStock(item, nil)
+
+	po := &PurchaseOrder{DistributorID: distributor.ID(), Reference: "PO-1", Lines: []*PurchaseOrderLine{
+		{StockID: item.ID(), Quantity: decimal.New(10, 0), UnitPrice: decimal.New(250, -2)},
+	}}
+	if err := wh.CreatePurchaseOrder(po); err != nil {
+		t.Fatalf(`CreatePurchaseOrder returns an error for a valid order: %s`, err)
+	}
+
+	receipt := &Movement{StockID: item.ID(), Kind: RECEIPT, Quantity: decimal.New(8, 0), Lot: &Lot{Number: "A", ExpirationDate: &expiration, UnitCost: decimal.New(250, -2)}}
+	if err := wh.RecordMovement(receipt); err != nil {
+		t.Fatalf(`RecordMovement returns an error for a valid receipt: %s`, err)
+	}
+
+	newInvoice := func(number string, date time.Time, quantity, price decimal.Decimal) *Invoice {
+		return &Invoice{DistributorID: distributor.ID(), Number: number, Date: date, Lines: []*InvoiceLine{
+			{PurchaseOrderLineID: po.Lines[0].ID, LotID: receipt.LotID, Quantity: quantity, UnitPrice: price},
+		}}
+	}
+
+	first := newInvoice("INV-1", time.Now().UTC(), decimal.New(8, 0), decimal.New(250, -2))
+	if err := wh.CreateInvoice(first); err != nil {
+		t.Fatalf(`CreateInvoice returns an error for a valid invoice: %s`, err)
+	}
+	if err := wh.CreateInvoice(newInvoice("INV-1", time.Now().UTC(), decimal.New(1, 0), decimal.New(1, 0))); err == nil {
+		t.Fatalf(`CreateInvoice records the same invoice twice`)
+	}
+	if matches, _ := wh.MatchInvoice(first.ID); !isMatched(matches) {
+		t.Fatalf(`Expected the invoice to match, got %+v`, matches[0])
+	}
+	if err := wh.PayInvoice(first.ID, "accountant"); err == nil {
+		t.Fatalf(`PayInvoice pays an invoice that is not approved`)
+	}
+	if err := wh.ApproveInvoice(first.ID, "manager", false); err != nil {
+		t.Fatalf(`ApproveInvoice returns an error for a matched invoice: %s`, err)
+	}
+	if err := wh.PayInvoice(first.ID, "accountant"); err != nil {
+		t.Fatalf(`PayInvoice returns an error for an approved invoice: %s`, err)
+	}
+
+	// the second invoice bills more than was ordered and received at a higher price
+	second := newInvoice("INV-2", time.Now().UTC().AddDate(0, 0, -40), decimal.New(3, 0), decimal.New(275, -2))
+	if err := wh.CreateInvoice(second); err != nil {
+		t.Fatalf(`CreateInvoice returns an error for a valid invoice: %s`, err)
+	}
+
+	resp, err := http.Get(buildURL(s.URL, fmt.Sprintf("/data/invoices/%s/match", second.ID)))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	match := &InvoiceMatchDTO{}
+	json.NewDecoder(resp.Body).Decode(match)
+	resp.Body.Close()
+	want := fmt.Sprint([]discrepancy{NOT_RECEIVED, NOT_ORDERED, ORDER_PRICE, RECEIPT_PRICE})
+	if match.Matched || len(match.Lines) != 1 || fmt.Sprint(match.Lines[0].Discrepancies) != want || match.Lines[0].Received != "8" || match.Lines[0].OrderInvoiced != "11" {
+		t.Fatalf("Unexpected match of the second invoice %+v", match)
+	}
+	if matches, _ := wh.MatchInvoice(first.ID); !isMatched(matches) {
+		t.Fatalf(`A later invoice changes the match of an earlier one`)
+	}
+
+	if err := wh.ApproveInvoice(second.ID, "manager", false); err == nil {
+		t.Fatalf(`ApproveInvoice approves an invoice with discrepancies`)
+	}
+	if err := wh.ApproveInvoice(second.ID, "manager", true); err != nil {
+		t.Fatalf(`ApproveInvoice returns an error for accepted discrepancies: %s`, err)
+	}
+
+	payables := wh.Payables(distributor.ID())
+	if !payables.Outstanding.Equal(decimal.New(825, -2)) || !payables.Overdue.Equal(decimal.New(825, -2)) ||
+		!payables.Paid.Equal(decimal.New(20, 0)) || len(payables.Unpaid) != 1 {
+		t.Fatalf(`Unexpected payables %+v`, payables)
+	}
+}
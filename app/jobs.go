@@ -0,0 +1,215 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// expiryWarningDays is how many days before its expiration date a stock item is listed as expiring
+const expiryWarningDays = 7
+
+// insufficientStock returns the stock items whose available quantity is below their minimum quantity
+func insufficientStock(wh Warehouse) []Stock {
+	var (
+		stockItems = wh.Stock()
+		available  = wh.AvailableQuantities()
+		items      = make([]Stock, 0)
+	)
+
+	for _, stock := range stockItems {
+		// only stock that can be dispensed counts
+		if available[stock.ID()].Cmp(stock.MinQuantity()) == -1 {
+			items = append(items, stock)
+		}
+	}
+
+	return items
+}
+
+// expiringStock returns the expirable stock items that expire before the limit
+func expiringStock(wh Warehouse, limit time.Time) []Stock {
+	items := make([]Stock, 0)
+
+	for _, stock := range wh.Stock() {
+		if stock.IsExpirable() && stock.ExpirationDate().Before(limit) {
+			items = append(items, stock)
+		}
+	}
+
+	return items
+}
+
+// insufficientStockAt returns the stock items whose available quantity in the location
+// is below their minimum quantity in the location
+func insufficientStockAt(wh Warehouse, locationID string) []Stock {
+	items := make([]Stock, 0)
+
+	for _, level := range wh.StockLevels(locationID) {
+		if level.MinQuantity == nil || !level.Available.LessThan(*level.MinQuantity) {
+			continue
+		}
+		if item, ok := wh.ReadStock(level.StockID); ok {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// expiringStockAt returns the expirable stock items in the location that expire before the limit.
+// Items with lots in the location expire with their first lot.
+func expiringStockAt(wh Warehouse, locationID string, limit time.Time) []Stock {
+	var (
+		items    = make([]Stock, 0)
+		lotFirst = make(map[string]time.Time)
+	)
+
+	for _, lot := range wh.LocationLots(locationID) {
+		if _, ok := lotFirst[lot.StockID]; !ok && lot.ExpirationDate != nil {
+			lotFirst[lot.StockID] = *lot.ExpirationDate
+		}
+	}
+
+	for _, level := range wh.StockLevels(locationID) {
+		if level.Quantity.Sign() <= 0 {
+			continue
+		}
+		item, ok := wh.ReadStock(level.StockID)
+		if !ok || !item.IsExpirable() {
+			continue
+		}
+
+		expirationDate, ok := lotFirst[item.ID()]
+		if !ok {
+			expirationDate = item.ExpirationDate()
+		}
+		if expirationDate.Before(limit) {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// registerJobs adds the background jobs of madmin to the scheduler with their default schedules.
+// There is no session cleanup job, every request is authenticated with its Basic credentials
+// and madmin keeps no sessions that could expire.
+func (m *madminHandler) registerJobs() {
+	jobs := []struct {
+		name string
+		spec string
+		run  JobFunc
+	}{
+		{"expiry-scan", "0 2 * * *", m.expiryScanJob},
+		{"low-stock-scan", "0 6 * * *", m.lowStockScanJob},
+		{"stock-digest", "0 7 * * *", m.stockDigestJob},
+		{"month-end-snapshot", "5 0 1 * *", m.monthEndSnapshotJob},
+	}
+
+	for _, job := range jobs {
+		if err := m.scheduler.AddJob(job.name, job.spec, job.run); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// expiryScanJob moves the expired lots to the EXPIRED state and counts the stock items that expire soon
+func (m *madminHandler) expiryScanJob(now time.Time) (string, error) {
+	var (
+		expiredLots = m.warehouse.ExpireLots(now)
+		expiring    = expiringStock(m.warehouse, now.AddDate(0, 0, expiryWarningDays))
+	)
+
+	return fmt.Sprintf("%d lots expired, %d stock items expire within %d days", len(expiredLots), len(expiring), expiryWarningDays), nil
+}
+
+// lowStockScanJob counts the stock items below their minimum quantity
+func (m *madminHandler) lowStockScanJob(now time.Time) (string, error) {
+	return fmt.Sprintf("%d stock items below minimum quantity", len(insufficientStock(m.warehouse))), nil
+}
+
+func (m *madminHandler) jobHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getJobHandler(w, r)
+	case "PUT":
+		m.updateJobHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /jobs/
+//
+// Lists the background jobs with their schedules and last runs.
+func (m *madminHandler) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	jobs := m.scheduler.Jobs()
+
+	resp := make([]*JobDTO, 0, len(jobs))
+	for i := range jobs {
+		resp = append(resp, newJobDTO(&jobs[i]))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for GET /jobs/<name>
+//
+// Returns the schedule and the last run of the job with <name>.
+func (m *madminHandler) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := m.scheduler.Job(mux.Vars(r)["name"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newJobDTO(&job))
+}
+
+// Handler for PUT /jobs/<name>
+//
+// Changes the schedule of the job with <name> or enables and disables it.
+func (m *madminHandler) updateJobHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	dto := &JobScheduleDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.scheduler.Job(name); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err := m.scheduler.SetSchedule(name, dto.Schedule, dto.Enabled, m.auditTrail(r))
+	if err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for POST /jobs/<name>/run
+//
+// Runs the job with <name> immediately and returns its status after the run.
+func (m *madminHandler) runJobHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, ok := m.scheduler.Job(name); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	job, err := m.scheduler.Trigger(name)
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Error in running job: %s", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newJobDTO(&job))
+}
@@ -0,0 +1,414 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/gorilla/mux"
+	"github.com/jung-kurt/gofpdf"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// labelSize is the size of a label in millimeters
+type labelSize struct {
+	Width  float64
+	Height float64
+}
+
+// labelSizes are the supported sizes of the common label printers' rolls
+var labelSizes = map[string]labelSize{
+	"50x25":  {50, 25},
+	"57x32":  {57, 32},
+	"62x29":  {62, 29},
+	"62x100": {62, 100},
+	"100x50": {100, 50},
+}
+
+const (
+	defaultLabelSize = "62x29"
+	labelMargin      = 2.0
+	// labelDotsPerMM is the resolution of PNG labels, which matches 203 dpi thermal printers
+	labelDotsPerMM = 8
+	maxLabelCopies = 100
+)
+
+type symbology string
+
+const (
+	CODE128    symbology = "code128"
+	DATAMATRIX symbology = "datamatrix"
+)
+
+var errBarcodeTooLarge = errors.New("the barcode does not fit on the label, use a larger label or a DataMatrix code")
+
+// label is the content of a shelf label of a stock item or a label of a lot
+type label struct {
+	Name           string
+	Type           string
+	LotNumber      string
+	ExpirationDate string
+
+	// Code is the content of the barcode. It is a GS1 element string for items with a GTIN,
+	// otherwise it is the id of the lot or the stock item.
+	Code      string
+	GS1       bool
+	Symbology symbology
+}
+
+// gs1ElementString builds the GS1 element string of a lot of a trade item.
+// The lot number is last, so it needs no separator.
+func gs1ElementString(gtin, lotNumber string, expirationDate *time.Time) string {
+	code := "01" + gtin
+	if expirationDate != nil {
+		code += "17" + expirationDate.Format("060102")
+	}
+	if lotNumber != "" {
+		code += "10" + lotNumber
+	}
+	return code
+}
+
+// newLabel builds the label of the stock item or, if lot is not nil, the label of the lot
+func (m *madminHandler) newLabel(item Stock, lot *Lot, sym symbology) *label {
+	l := &label{Name: item.Name(), Symbology: sym, Code: item.ID()}
+
+	if kind, ok := m.warehouse.StockTypes().ReadStockType(item.Type()); ok {
+		l.Type = kind.Name
+	}
+
+	var (
+		expirationDate *time.Time
+		gtin           string
+	)
+	if barcodes := m.warehouse.Barcodes(item.ID()); len(barcodes) > 0 {
+		gtin = barcodes[0]
+	}
+
+	if lot == nil {
+		if item.IsExpirable() {
+			date := item.ExpirationDate()
+			expirationDate = &date
+		}
+		if gtin != "" {
+			l.Code = gtin
+		}
+	} else {
+		l.LotNumber = lot.Number
+		l.Code = lot.ID
+		expirationDate = lot.ExpirationDate
+		if gtin != "" {
+			l.Code = gs1ElementString(gtin, lot.Number, expirationDate)
+			l.GS1 = true
+		}
+	}
+
+	if expirationDate != nil {
+		l.ExpirationDate = expirationDate.UTC().Format("2006-01-02")
+	}
+	return l
+}
+
+// lines returns the text lines of the label
+func (l *label) lines() []string {
+	lines := []string{l.Name, l.Type}
+	if l.LotNumber != "" {
+		lines = append(lines, "Lot: "+l.LotNumber)
+	}
+	if l.ExpirationDate != "" {
+		lines = append(lines, "Exp: "+l.ExpirationDate)
+	}
+	return lines
+}
+
+// barcode renders the barcode of the label with the given size in dots
+func (l *label) barcode(width, height int) (image.Image, error) {
+	var (
+		bc  barcode.Barcode
+		err error
+	)
+	switch l.Symbology {
+	case CODE128:
+		content := l.Code
+		if l.GS1 {
+			// GS1-128 starts with FNC1
+			content = string(code128.FNC1) + content
+		}
+		bc, err = code128.Encode(content)
+	case DATAMATRIX:
+		// GS1 DataMatrix starts with FNC1
+		bc, err = encodeDataMatrix(l.Code, l.GS1)
+	default:
+		return nil, fmt.Errorf("unsupported symbology %s", l.Symbology)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// scale by whole modules, so the bars stay sharp
+	var (
+		bounds = bc.Bounds()
+		scaleX = width / bounds.Dx()
+		scaleY = height / bounds.Dy()
+	)
+	if l.Symbology == DATAMATRIX && scaleY < scaleX {
+		scaleX = scaleY
+	}
+	if scaleX < 1 {
+		return nil, errBarcodeTooLarge
+	}
+	if l.Symbology == DATAMATRIX {
+		return barcode.Scale(bc, bounds.Dx()*scaleX, bounds.Dy()*scaleX)
+	}
+	return barcode.Scale(bc, bounds.Dx()*scaleX, height)
+}
+
+// labelLayout is the position of the text and the barcode on the label in millimeters
+type labelLayout struct {
+	textX, textY, textWidth, textHeight float64
+	codeX, codeY, codeWidth, codeHeight float64
+}
+
+// layout puts a square DataMatrix code on the right of the text
+// and a Code128 barcode below the text
+func (l *label) layout(size labelSize) labelLayout {
+	var (
+		width  = size.Width - 2*labelMargin
+		height = size.Height - 2*labelMargin
+	)
+
+	if l.Symbology == DATAMATRIX {
+		side := height
+		if side > width/2 {
+			side = width / 2
+		}
+		return labelLayout{
+			textX: labelMargin, textY: labelMargin, textWidth: width - side - labelMargin, textHeight: height,
+			codeX: size.Width - labelMargin - side, codeY: labelMargin, codeWidth: side, codeHeight: side,
+		}
+	}
+
+	codeHeight := height * 0.4
+	return labelLayout{
+		textX: labelMargin, textY: labelMargin, textWidth: width, textHeight: height - codeHeight - labelMargin,
+		codeX: labelMargin, codeY: size.Height - labelMargin - codeHeight, codeWidth: width, codeHeight: codeHeight,
+	}
+}
+
+// writePDF writes a PDF with a page for every copy of the label
+func (l *label) writePDF(w io.Writer, size labelSize, copies int) error {
+	var (
+		pdf = gofpdf.NewCustom(&gofpdf.InitType{
+			UnitStr: "mm",
+			Size:    gofpdf.SizeType{Wd: size.Width, Ht: size.Height},
+		})
+		tr     = pdf.UnicodeTranslatorFromDescriptor("")
+		layout = l.layout(size)
+		lines  = l.lines()
+	)
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+
+	code, err := l.barcode(int(layout.codeWidth*labelDotsPerMM), int(layout.codeHeight*labelDotsPerMM))
+	if err != nil {
+		return err
+	}
+	// gofpdf does not support the 16-bit PNGs of the barcode package
+	gray := image.NewGray(code.Bounds())
+	draw.Draw(gray, gray.Bounds(), code, code.Bounds().Min, draw.Src)
+	codePNG := &bytes.Buffer{}
+	if err := png.Encode(codePNG, gray); err != nil {
+		return err
+	}
+	pdf.RegisterImageOptionsReader("barcode", gofpdf.ImageOptions{ImageType: "PNG"}, codePNG)
+
+	// the font size fits all lines in the text area, 1pt is 0.3528mm
+	lineHeight := layout.textHeight / float64(len(lines))
+	if lineHeight > 6 {
+		lineHeight = 6
+	}
+	lineFontSize := lineHeight / 0.3528 * 0.8
+
+	for i := 0; i < copies; i++ {
+		pdf.AddPage()
+
+		for j, line := range lines {
+			style := ""
+			if j == 0 {
+				style = "B"
+			}
+			fontSize := lineFontSize
+			pdf.SetFont("Helvetica", style, fontSize)
+			// long names are shrunk to the width of the text area
+			for pdf.GetStringWidth(tr(line)) > layout.textWidth && fontSize > 4 {
+				fontSize *= 0.9
+				pdf.SetFontSize(fontSize)
+			}
+			pdf.SetXY(layout.textX, layout.textY+float64(j)*lineHeight)
+			pdf.CellFormat(layout.textWidth, lineHeight, tr(line), "", 0, "L", false, 0, "")
+		}
+
+		codeWidth, codeHeight := layout.codeWidth, layout.codeHeight
+		if l.Symbology == DATAMATRIX {
+			codeWidth = codeHeight
+		}
+		pdf.ImageOptions("barcode", layout.codeX, layout.codeY, codeWidth, codeHeight, false, gofpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+	}
+
+	return pdf.Output(w)
+}
+
+// drawText draws the text at the dot (x, y) with the fixed font scaled by a whole number
+func drawText(dst draw.Image, x, y int, text string, scale int, maxWidth int) {
+	face := basicfont.Face7x13
+
+	var (
+		textWidth = font.MeasureString(face, text).Ceil()
+		src       = image.NewGray(image.Rect(0, 0, textWidth, face.Height))
+		d         = &font.Drawer{Dst: src, Src: image.White, Face: face, Dot: fixed.P(0, face.Ascent)}
+	)
+	for textWidth*scale > maxWidth && scale > 1 {
+		scale--
+	}
+	d.DrawString(text)
+
+	for sy := 0; sy < face.Height*scale; sy++ {
+		for sx := 0; sx < textWidth*scale && sx < maxWidth; sx++ {
+			if src.GrayAt(sx/scale, sy/scale).Y > 127 {
+				dst.Set(x+sx, y+sy, color.Black)
+			}
+		}
+	}
+}
+
+// writePNG writes the label as a PNG image with the resolution of thermal label printers
+func (l *label) writePNG(w io.Writer, size labelSize) error {
+	var (
+		dots   = func(mm float64) int { return int(mm * labelDotsPerMM) }
+		img    = image.NewGray(image.Rect(0, 0, dots(size.Width), dots(size.Height)))
+		layout = l.layout(size)
+		lines  = l.lines()
+	)
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	code, err := l.barcode(dots(layout.codeWidth), dots(layout.codeHeight))
+	if err != nil {
+		return err
+	}
+	codeOrigin := image.Pt(dots(layout.codeX), dots(layout.codeY))
+	draw.Draw(img, code.Bounds().Add(codeOrigin), code, code.Bounds().Min, draw.Src)
+
+	lineHeight := dots(layout.textHeight) / len(lines)
+	scale := lineHeight / basicfont.Face7x13.Height
+	if scale < 1 {
+		scale = 1
+	}
+	for i, line := range lines {
+		drawText(img, dots(layout.textX), dots(layout.textY)+i*lineHeight, line, scale, dots(layout.textWidth))
+	}
+
+	return png.Encode(w, img)
+}
+
+// Handler for GET /stock/<id>/label?lot=<lotID>&format=pdf|png&size=<size>&symbology=datamatrix|code128&copies=<n>
+//
+// Returns a shelf label of the stock item with <id> or, with lot, a label of one of its lots.
+// Labels are PDF by default and DataMatrix codes are used unless code128 is requested.
+func (m *madminHandler) labelHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		query  = r.URL.Query()
+		errs   = ValidationErrors{}
+		copies = 1
+	)
+
+	item, ok := m.warehouse.ReadStock(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var lot *Lot
+	if lotID := query.Get("lot"); lotID != "" {
+		if lot, ok = m.warehouse.ReadLot(lotID); !ok || lot.StockID != item.ID() {
+			errs = append(errs, ValidationError{"lot", "the stock item has no such lot"})
+		}
+	}
+
+	sizeName := query.Get("size")
+	if sizeName == "" {
+		sizeName = defaultLabelSize
+	}
+	size, ok := labelSizes[sizeName]
+	if !ok {
+		errs = append(errs, ValidationError{"size", "unsupported label size"})
+	}
+
+	sym := symbology(query.Get("symbology"))
+	switch sym {
+	case "":
+		sym = DATAMATRIX
+	case DATAMATRIX, CODE128:
+	default:
+		errs = append(errs, ValidationError{"symbology", "supported symbologies are datamatrix and code128"})
+	}
+
+	if s := query.Get("copies"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxLabelCopies {
+			errs = append(errs, ValidationError{"copies", fmt.Sprintf("copies must be between 1 and %d", maxLabelCopies)})
+		}
+		copies = n
+	}
+
+	format := query.Get("format")
+	if format != "" && format != "pdf" && format != "png" {
+		errs = append(errs, ValidationError{"format", "supported formats are pdf and png"})
+	}
+
+	if len(errs) > 0 {
+		respondBadRequest(w, errs)
+		return
+	}
+
+	l := m.newLabel(item, lot, sym)
+
+	buf := &bytes.Buffer{}
+	var err error
+	if format == "png" {
+		err = l.writePNG(buf, size)
+	} else {
+		err = l.writePDF(buf, size, copies)
+	}
+	if err == errBarcodeTooLarge {
+		respondBadRequest(w, ValidationErrors{{"size", err.Error()}})
+		return
+	}
+	if err != nil {
+		// the content of the label cannot be encoded in the barcode, e.g. it is not ASCII
+		respondBadRequest(w, ValidationErrors{{"symbology", err.Error()}})
+		return
+	}
+
+	if format == "png" {
+		w.Header().Set("Content-Type", "image/png")
+	} else {
+		w.Header().Set("Content-Type", "application/pdf")
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("Error while writing response: %s", err)
+	}
+}
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestGS1ElementString(t *testing.T) {
+	expirationDate := time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	code := gs1ElementString("09506000134352", "AB-123", &expirationDate)
+	if code != "01095060001343521725123110AB-123" {
+		t.Fatalf(`Unexpected GS1 element string %s`, code)
+	}
+
+	parsed, err := ParseGS1(code)
+	if err != nil || parsed.GTIN != "09506000134352" || parsed.LotNumber != "AB-123" || !parsed.ExpirationDate.Equal(expirationDate) {
+		t.Fatalf(`The GS1 element string of a label cannot be scanned: %+v, %v`, parsed, err)
+	}
+}
+
+// scanDataMatrix decodes the ASCII codewords of a DataMatrix code the way scanners
+// transmit them, with the symbology identifier of GS1 DataMatrix and FNC1 as GS
+func scanDataMatrix(codewords []byte) string {
+	var scanned strings.Builder
+	for i, c := range codewords {
+		switch {
+		case c == dmFNC1 && i == 0:
+			scanned.WriteString("]d2")
+		case c == dmFNC1:
+			scanned.WriteByte(gs1GroupSeparator)
+		case c >= 130:
+			fmt.Fprintf(&scanned, "%02d", c-130)
+		default:
+			scanned.WriteByte(c - 1)
+		}
+	}
+	return scanned.String()
+}
+
+func TestGS1DataMatrix(t *testing.T) {
+	// the lot number is followed by a separator when it is not the last field
+	code := "0109506000134352" + "10AB-123" + string(gs1GroupSeparator) + "17251231"
+
+	codewords, err := dmCodewords(code, true)
+	if err != nil {
+		t.Fatalf(`dmCodewords returns an error for a GS1 element string: %s`, err)
+	}
+	if codewords[0] != dmFNC1 {
+		t.Fatalf(`GS1 DataMatrix does not start with FNC1: %v`, codewords)
+	}
+
+	scanned := scanDataMatrix(codewords)
+	parsed, err := ParseGS1(scanned)
+	if err != nil || parsed.GTIN != "09506000134352" || parsed.LotNumber != "AB-123" || parsed.ExpirationDate.Format("060102") != "251231" {
+		t.Fatalf(`The scanned GS1 DataMatrix %q cannot be parsed: %+v, %v`, scanned, parsed, err)
+	}
+
+	if _, err := encodeDataMatrix(code, true); err != nil {
+		t.Fatalf(`encodeDataMatrix returns an error for a GS1 element string: %s`, err)
+	}
+	if _, err := encodeDataMatrix("10Lé1", true); err == nil {
+		t.Fatalf(`encodeDataMatrix encodes a GS1 code with non-ASCII characters`)
+	}
+	if _, err := encodeDataMatrix(strings.Repeat("x", 200), false); err != errBarcodeTooLarge {
+		t.Fatalf(`encodeDataMatrix does not reject too much data: %v`, err)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+
+		expirationDate = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	item.SetQuantity(decimal.Zero)
+	madminHandler.warehouse.CreateStock(item, nil)
+	madminHandler.warehouse.AddBarcode(item.ID(), "9506000134352")
+
+	other, _ := defaultUnexpirableStockItem(ACCESSORY)
+	madminHandler.warehouse.CreateStock(other, nil)
+
+	receipt := &Movement{
+		StockID:  item.ID(),
+		Kind:     RECEIPT,
+		Quantity: decimal.New(10, 0),
+		Lot:      &Lot{Number: "L123", ExpirationDate: &expirationDate},
+	}
+	accented := &Movement{
+		StockID:  item.ID(),
+		Kind:     RECEIPT,
+		Quantity: decimal.New(1, 0),
+		Lot:      &Lot{Number: "Lé1", ExpirationDate: &expirationDate},
+	}
+	for _, mv := range []*Movement{receipt, accented} {
+		if err := madminHandler.warehouse.RecordMovement(mv); err != nil {
+			t.Fatalf(`RecordMovement returns an error for a valid lot receipt: %s`, err)
+		}
+	}
+
+	requests := []struct {
+		path        string
+		status      int
+		contentType string
+	}{
+		{fmt.Sprintf("/data/stock/%s/label", item.ID()), http.StatusOK, "application/pdf"},
+		{fmt.Sprintf("/data/stock/%s/label?lot=%s&symbology=code128&size=100x50&copies=3", item.ID(), receipt.LotID), http.StatusOK, "application/pdf"},
+		{fmt.Sprintf("/data/stock/%s/label?lot=%s&format=png", item.ID(), receipt.LotID), http.StatusOK, "image/png"},
+		{fmt.Sprintf("/data/stock/%s/label?format=png&symbology=code128&size=100x50", other.ID()), http.StatusOK, "image/png"},
+		{fmt.Sprintf("/data/stock/%s/label?symbology=code128&size=50x25", other.ID()), http.StatusBadRequest, ""},
+		{fmt.Sprintf("/data/stock/%s/label?lot=%s", other.ID(), receipt.LotID), http.StatusBadRequest, ""},
+		{fmt.Sprintf("/data/stock/%s/label?size=10x10", item.ID()), http.StatusBadRequest, ""},
+		{fmt.Sprintf("/data/stock/%s/label?format=svg", item.ID()), http.StatusBadRequest, ""},
+		{fmt.Sprintf("/data/stock/%s/label?copies=0", item.ID()), http.StatusBadRequest, ""},
+		{fmt.Sprintf("/data/stock/%s/label?lot=%s&symbology=code128&size=100x50", item.ID(), accented.LotID), http.StatusBadRequest, ""},
+		{fmt.Sprintf("/data/stock/%s/label?lot=%s", item.ID(), accented.LotID), http.StatusBadRequest, ""},
+	}
+
+	for _, req := range requests {
+		resp, err := http.Get(buildURL(s.URL, req.path))
+		if err != nil {
+			t.Fatalf("Error sending GET request: %s", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != req.status {
+			t.Errorf("Expected %d but got %d for %s", req.status, resp.StatusCode, req.path)
+			continue
+		}
+		if req.contentType == "" {
+			continue
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != req.contentType {
+			t.Errorf("Expected %s but got %s for %s", req.contentType, contentType, req.path)
+		}
+
+		switch req.contentType {
+		case "application/pdf":
+			if !bytes.HasPrefix(body, []byte("%PDF")) {
+				t.Errorf("Response for %s is not a PDF", req.path)
+			}
+		case "image/png":
+			img, err := png.Decode(bytes.NewReader(body))
+			if err != nil {
+				t.Errorf("Response for %s is not a PNG: %s", req.path, err)
+				continue
+			}
+			// 62x29mm at 8 dots per mm
+			if !strings.HasSuffix(req.path, "100x50") && (img.Bounds().Dx() != 496 || img.Bounds().Dy() != 232) {
+				t.Errorf("Unexpected size of the PNG label %v", img.Bounds())
+			}
+		}
+	}
+}
@@ -0,0 +1,665 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// StorageLocation is a place where stock lots are kept, e.g. a shelf or a fridge.
+// Locations with a temperature range are monitored by temperature loggers.
+type StorageLocation struct {
+	ID   string
+	Name string
+
+	HasTemperatureRange bool
+	MinTemperature      decimal.Decimal
+	MaxTemperature      decimal.Decimal
+}
+
+// inRange checks if a temperature is allowed in the location
+func (sl *StorageLocation) inRange(temperature decimal.Decimal) bool {
+	if !sl.HasTemperatureRange {
+		return true
+	}
+	return temperature.Cmp(sl.MinTemperature) >= 0 && temperature.Cmp(sl.MaxTemperature) <= 0
+}
+
+func (sl *StorageLocation) validate() error {
+	errs := ValidationErrors{}
+
+	if sl.Name == "" {
+		errs = append(errs, ValidationError{"name", "cannot set empty string as name"})
+	}
+	if sl.HasTemperatureRange && sl.MinTemperature.Cmp(sl.MaxTemperature) > 0 {
+		errs = append(errs, ValidationError{"minTemperature", "minimum temperature is above maximum temperature"})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// TemperatureReading is a single reading of a temperature logger in a storage location
+type TemperatureReading struct {
+	Time        time.Time
+	Temperature decimal.Decimal
+	LoggerID    string
+}
+
+// Excursion is a period in which the temperature of a storage location was out of its range.
+// All lots stored in the location are quarantined until a user reviews the excursion.
+type Excursion struct {
+	ID         string
+	LocationID string
+
+	Started time.Time
+	// MinTemperature and MaxTemperature are the extreme readings during the excursion
+	MinTemperature decimal.Decimal
+	MaxTemperature decimal.Decimal
+
+	// LotIDs are the lots quarantined because of the excursion
+	LotIDs []string
+
+	Reviewed   bool
+	ReviewedBy string
+	ReviewedAt time.Time
+	ReviewNote string
+	Released   bool
+}
+
+// LocationManager manages the storage locations and their temperature logs
+type LocationManager interface {
+	CreateLocation(*StorageLocation) error
+	ReadLocation(string) (*StorageLocation, bool)
+	UpdateLocation(*StorageLocation) error
+	DeleteLocation(string) error
+
+	// Locations() returns a map with the ids of all locations mapped to the locations
+	Locations() map[string]*StorageLocation
+
+	// RecordTemperatures() saves the readings of a location's logger.
+	// Readings out of the location's range start an excursion (or extend the open one)
+	// and quarantine all lots in the location. The open excursion, if any, is returned.
+	RecordTemperatures(string, []TemperatureReading) (*Excursion, error)
+
+	ReadExcursion(string) (*Excursion, bool)
+	// Excursions() returns the excursions of a location, the latest are first
+	Excursions(string) []*Excursion
+	// ReviewExcursion() closes an excursion. If release is set, the lots quarantined
+	// because of the excursion become available again.
+	ReviewExcursion(id, userID, note string, release bool) error
+}
+
+type defaultLocationManager struct {
+	database *sql.DB
+}
+
+// NewLocationManager creates a location manager that keeps the storage locations,
+// temperature readings and excursions in sqlite3 tables inside the db that is passed as an argument.
+func NewLocationManager(db *sql.DB) LocationManager {
+	lm := &defaultLocationManager{database: db}
+
+	lm.initLocationsTables()
+
+	return lm
+}
+
+func (lm *defaultLocationManager) initLocationsTables() {
+	locationsTables := `
+	CREATE TABLE IF NOT EXISTS
+		storage_locations (
+			id TEXT NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL,
+			has_temperature_range BOOLEAN NOT NULL,
+			min_temperature NUMERIC,
+			max_temperature NUMERIC
+	);
+	CREATE TABLE IF NOT EXISTS
+		temperature_readings (
+			location_id TEXT NOT NULL,
+			time DATETIME NOT NULL,
+			temperature NUMERIC NOT NULL,
+			logger_id TEXT NOT NULL,
+			excursion_id TEXT NOT NULL,
+			FOREIGN KEY (location_id) REFERENCES storage_locations (id)
+	);
+	CREATE INDEX IF NOT EXISTS
+		temperature_readings_location_id ON temperature_readings (location_id, time);
+	CREATE TABLE IF NOT EXISTS
+		temperature_excursions (
+			id TEXT NOT NULL PRIMARY KEY,
+			location_id TEXT NOT NULL,
+			started DATETIME NOT NULL,
+			min_temperature NUMERIC NOT NULL,
+			max_temperature NUMERIC NOT NULL,
+			reviewed BOOLEAN NOT NULL,
+			reviewed_by TEXT NOT NULL,
+			reviewed_at DATETIME,
+			review_note TEXT NOT NULL,
+			released BOOLEAN NOT NULL,
+			FOREIGN KEY (location_id) REFERENCES storage_locations (id)
+	);
+	CREATE TABLE IF NOT EXISTS
+		excursion_lots (
+			excursion_id TEXT NOT NULL,
+			lot_id TEXT NOT NULL,
+			PRIMARY KEY (excursion_id, lot_id)
+	);
+	`
+	_, err := lm.database.Exec(locationsTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// insert in DB
+func (lm *defaultLocationManager) CreateLocation(sl *StorageLocation) error {
+	if err := sl.validate(); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	sl.ID = id
+
+	_, err = lm.database.Exec(`
+		INSERT INTO
+			storage_locations (
+				id,
+				name,
+				has_temperature_range,
+				min_temperature,
+				max_temperature)
+		VALUES(?, ?, ?, ?, ?)
+	`,
+		sl.ID,
+		sl.Name,
+		sl.HasTemperatureRange,
+		sl.MinTemperature.String(),
+		sl.MaxTemperature.String())
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// read from DB
+func (lm *defaultLocationManager) ReadLocation(id string) (*StorageLocation, bool) {
+	sl := &StorageLocation{ID: id}
+	err := lm.database.QueryRow(`
+		SELECT
+			name,
+			has_temperature_range,
+			min_temperature,
+			max_temperature
+		FROM
+			storage_locations
+		WHERE
+			id = ?
+	`, id).Scan(
+		&sl.Name,
+		&sl.HasTemperatureRange,
+		&sl.MinTemperature,
+		&sl.MaxTemperature)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	return sl, true
+}
+
+// update in DB
+func (lm *defaultLocationManager) UpdateLocation(sl *StorageLocation) error {
+	if err := sl.validate(); err != nil {
+		return err
+	}
+
+	_, err := lm.database.Exec(`
+		UPDATE
+			storage_locations
+		SET
+			name = ?,
+			has_temperature_range = ?,
+			min_temperature = ?,
+			max_temperature = ?
+		WHERE
+			id = ?
+	`,
+		sl.Name,
+		sl.HasTemperatureRange,
+		sl.MinTemperature.String(),
+		sl.MaxTemperature.String(),
+		sl.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// remove from DB
+// Locations that still hold stock or wait for transfers cannot be removed.
+func (lm *defaultLocationManager) DeleteLocation(id string) error {
+	var lots, levels, transfers int
+	err := lm.database.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM stock_lots WHERE location_id = ? AND quantity > 0),
+			(SELECT COUNT(*) FROM stock_levels WHERE location_id = ? AND quantity != 0),
+			(SELECT COUNT(*) FROM transfers WHERE (from_location_id = ? OR to_location_id = ?) AND state IN (?, ?))
+	`, id, id, id, id, REQUESTED, DISPATCHED).Scan(&lots, &levels, &transfers)
+	if err != nil {
+		panic(err)
+	}
+	if lots > 0 || levels > 0 {
+		return errors.New("storage location still holds stock")
+	}
+	if transfers > 0 {
+		return errors.New("storage location has open transfers")
+	}
+
+	_, err = lm.database.Exec(`
+		DELETE FROM
+			storage_locations
+		WHERE
+			id = ?
+	`, id)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = lm.database.Exec(`
+		DELETE FROM
+			stock_levels
+		WHERE
+			location_id = ?
+	`, id)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+func (lm *defaultLocationManager) Locations() map[string]*StorageLocation {
+	rows, err := lm.database.Query(`
+		SELECT
+			id,
+			name,
+			has_temperature_range,
+			min_temperature,
+			max_temperature
+		FROM
+			storage_locations
+	`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	locations := make(map[string]*StorageLocation)
+	for rows.Next() {
+		sl := &StorageLocation{}
+		err = rows.Scan(
+			&sl.ID,
+			&sl.Name,
+			&sl.HasTemperatureRange,
+			&sl.MinTemperature,
+			&sl.MaxTemperature)
+		if err != nil {
+			panic(err)
+		}
+		locations[sl.ID] = sl
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return locations
+}
+
+// openExcursionTx returns the id of the location's excursion that is not reviewed yet
+// or an empty string if there is no such excursion
+func openExcursionTx(tx *sql.Tx, locationID string) string {
+	var id string
+	err := tx.QueryRow(`
+		SELECT
+			id
+		FROM
+			temperature_excursions
+		WHERE
+			location_id = ? AND reviewed = 0
+	`, locationID).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+		return ""
+	case err != nil:
+		panic(err)
+	}
+	return id
+}
+
+func (lm *defaultLocationManager) RecordTemperatures(locationID string, readings []TemperatureReading) (*Excursion, error) {
+	sl, ok := lm.ReadLocation(locationID)
+	if !ok {
+		return nil, errors.New("no such storage location")
+	}
+
+	tx, err := lm.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	excursionID := openExcursionTx(tx, locationID)
+
+	for _, reading := range readings {
+		if !sl.inRange(reading.Temperature) {
+			excursionID, err = lm.extendExcursionTx(tx, excursionID, sl, reading)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		readingExcursionID := ""
+		if !sl.inRange(reading.Temperature) {
+			readingExcursionID = excursionID
+		}
+		_, err = tx.Exec(`
+			INSERT INTO
+				temperature_readings (
+					location_id,
+					time,
+					temperature,
+					logger_id,
+					excursion_id)
+			VALUES(?, ?, ?, ?, ?)
+		`,
+			locationID,
+			reading.Time.UTC(),
+			reading.Temperature.String(),
+			reading.LoggerID,
+			readingExcursionID)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if excursionID != "" {
+		quarantineLocationTx(tx, locationID, excursionID)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	if excursionID == "" {
+		return nil, nil
+	}
+	excursion, _ := lm.ReadExcursion(excursionID)
+	return excursion, nil
+}
+
+// extendExcursionTx adds an out-of-range reading to the open excursion with the given id
+// or starts a new excursion if id is empty. It returns the id of the excursion.
+func (lm *defaultLocationManager) extendExcursionTx(tx *sql.Tx, id string, sl *StorageLocation, reading TemperatureReading) (string, error) {
+	if id == "" {
+		newID, err := newUUID()
+		if err != nil {
+			return "", err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO
+				temperature_excursions (
+					id,
+					location_id,
+					started,
+					min_temperature,
+					max_temperature,
+					reviewed,
+					reviewed_by,
+					review_note,
+					released)
+			VALUES(?, ?, ?, ?, ?, 0, '', '', 0)
+		`,
+			newID,
+			sl.ID,
+			reading.Time.UTC(),
+			reading.Temperature.String(),
+			reading.Temperature.String())
+		if err != nil {
+			panic(err)
+		}
+
+		return newID, nil
+	}
+
+	_, err := tx.Exec(`
+		UPDATE
+			temperature_excursions
+		SET
+			min_temperature = MIN(min_temperature, ?),
+			max_temperature = MAX(max_temperature, ?),
+			started = MIN(started, ?)
+		WHERE
+			id = ?
+	`,
+		reading.Temperature.String(),
+		reading.Temperature.String(),
+		reading.Time.UTC(),
+		id)
+	if err != nil {
+		panic(err)
+	}
+
+	return id, nil
+}
+
+// excursionReason is the state reason of the lots quarantined because of an excursion
+func excursionReason(excursionID string) string {
+	return fmt.Sprintf("temperature excursion %s", excursionID)
+}
+
+// quarantineLocationTx quarantines all available lots in the location because of an excursion
+func quarantineLocationTx(tx *sql.Tx, locationID, excursionID string) {
+	lots := queryLotsTx(tx, `
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			location_id = ? AND state = ?
+	`, locationID, AVAILABLE)
+
+	for _, lot := range lots {
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO
+				excursion_lots (
+					excursion_id,
+					lot_id)
+			VALUES(?, ?)
+		`, excursionID, lot.ID)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := setLotStateTx(tx, lot, QUARANTINED, "", excursionReason(excursionID)); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (lm *defaultLocationManager) ReadExcursion(id string) (*Excursion, bool) {
+	var (
+		excursion  = &Excursion{ID: id}
+		reviewedAt *time.Time
+	)
+	err := lm.database.QueryRow(`
+		SELECT
+			location_id,
+			started,
+			min_temperature,
+			max_temperature,
+			reviewed,
+			reviewed_by,
+			reviewed_at,
+			review_note,
+			released
+		FROM
+			temperature_excursions
+		WHERE
+			id = ?
+	`, id).Scan(
+		&excursion.LocationID,
+		&excursion.Started,
+		&excursion.MinTemperature,
+		&excursion.MaxTemperature,
+		&excursion.Reviewed,
+		&excursion.ReviewedBy,
+		&reviewedAt,
+		&excursion.ReviewNote,
+		&excursion.Released)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+	if reviewedAt != nil {
+		excursion.ReviewedAt = *reviewedAt
+	}
+
+	rows, err := lm.database.Query(`
+		SELECT
+			lot_id
+		FROM
+			excursion_lots
+		WHERE
+			excursion_id = ?
+	`, id)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	excursion.LotIDs = make([]string, 0)
+	for rows.Next() {
+		var lotID string
+		if err = rows.Scan(&lotID); err != nil {
+			panic(err)
+		}
+		excursion.LotIDs = append(excursion.LotIDs, lotID)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return excursion, true
+}
+
+func (lm *defaultLocationManager) Excursions(locationID string) []*Excursion {
+	rows, err := lm.database.Query(`
+		SELECT
+			id
+		FROM
+			temperature_excursions
+		WHERE
+			location_id = ?
+		ORDER BY
+			started DESC
+	`, locationID)
+	if err != nil {
+		panic(err)
+	}
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			panic(err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	excursions := make([]*Excursion, 0, len(ids))
+	for _, id := range ids {
+		excursion, _ := lm.ReadExcursion(id)
+		excursions = append(excursions, excursion)
+	}
+	return excursions
+}
+
+func (lm *defaultLocationManager) ReviewExcursion(id, userID, note string, release bool) error {
+	excursion, ok := lm.ReadExcursion(id)
+	if !ok {
+		return errors.New("no such excursion")
+	}
+	if excursion.Reviewed {
+		return errors.New("excursion is already reviewed")
+	}
+
+	tx, err := lm.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE
+			temperature_excursions
+		SET
+			reviewed = 1,
+			reviewed_by = ?,
+			reviewed_at = ?,
+			review_note = ?,
+			released = ?
+		WHERE
+			id = ?
+	`, userID, time.Now().UTC(), note, release, id)
+	if err != nil {
+		panic(err)
+	}
+
+	if release {
+		// lots quarantined for other reasons in the meantime stay quarantined
+		lots := queryLotsTx(tx, `
+			SELECT `+lotColumns+`
+			FROM
+				stock_lots
+			WHERE
+				state = ? AND state_reason = ? AND id IN (
+					SELECT lot_id FROM excursion_lots WHERE excursion_id = ?)
+		`, QUARANTINED, excursionReason(id), id)
+
+		for _, lot := range lots {
+			if err := setLotStateTx(tx, lot, AVAILABLE, userID, "released after review of "+excursionReason(id)); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,438 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+func (m *madminHandler) locationsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listLocationsHandler(w, r)
+	case "POST":
+		m.addLocationHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+func (m *madminHandler) locationHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getLocationHandler(w, r)
+	case "DELETE":
+		m.removeLocationHandler(w, r)
+	case "PUT":
+		m.updateLocationHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /locations/
+//
+// Lists the storage locations.
+func (m *madminHandler) listLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	locations := m.warehouse.Locations().Locations()
+
+	resp := &CollectionResponseDTO{"List of storage locations", make([]string, 0, len(locations))}
+	for id := range locations {
+		resp.URLs = append(resp.URLs, fmt.Sprintf("/data/locations/%s", id))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for GET /locations/<id>
+//
+// Returns JSON with data for the storage location with the given id.
+func (m *madminHandler) getLocationHandler(w http.ResponseWriter, r *http.Request) {
+	sl, ok := m.warehouse.Locations().ReadLocation(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newLocationDTO(sl))
+}
+
+// Handler for POST /locations/
+//
+// Adds a storage location and returns its id.
+func (m *madminHandler) addLocationHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &LocationDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	sl, err := dto.location()
+	if err == nil {
+		err = m.warehouse.Locations().CreateLocation(sl)
+	}
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if _, err := w.Write([]byte(sl.ID)); err != nil {
+		log.Printf("Error while writing response: %s", err)
+	}
+}
+
+// Handler for PUT /locations/<id>
+//
+// Updates the name and the temperature range of the storage location with <id>.
+func (m *madminHandler) updateLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &LocationDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+	dto.ID = id
+
+	if _, ok := m.warehouse.Locations().ReadLocation(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	sl, err := dto.location()
+	if err == nil {
+		err = m.warehouse.Locations().UpdateLocation(sl)
+	}
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for DELETE /locations/<id>
+//
+// Removes the storage location with <id> if it holds no lots.
+func (m *madminHandler) removeLocationHandler(w http.ResponseWriter, r *http.Request) {
+	err := m.warehouse.Locations().DeleteLocation(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Error in removing storage location: %s", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for POST /locations/<id>/temperature
+//
+// Ingests a reading or a JSON array of readings from the temperature logger of the location.
+// If any reading is out of the location's range, all lots in the location are quarantined
+// and the excursion is returned.
+func (m *madminHandler) temperatureHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	dtos := make([]TemperatureReadingDTO, 0)
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &dtos)
+	} else {
+		dto := TemperatureReadingDTO{}
+		err = json.Unmarshal(body, &dto)
+		dtos = append(dtos, dto)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Printf("Error in unmarshaling request body: %s", err)
+		return
+	}
+
+	readings := make([]TemperatureReading, 0, len(dtos))
+	for i := range dtos {
+		reading, err := dtos[i].reading()
+		if err != nil {
+			respondBadRequest(w, err)
+			return
+		}
+		readings = append(readings, reading)
+	}
+
+	if _, ok := m.warehouse.Locations().ReadLocation(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	excursion, err := m.warehouse.Locations().RecordTemperatures(id, readings)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	if excursion == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	respondJSON(w, http.StatusAccepted, newExcursionDTO(excursion))
+}
+
+// Handler for GET /locations/<id>/excursions
+//
+// Lists the temperature excursions of the location, the latest are first.
+func (m *madminHandler) listExcursionsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.Locations().ReadLocation(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	excursions := m.warehouse.Locations().Excursions(id)
+
+	resp := make([]*ExcursionDTO, 0, len(excursions))
+	for _, e := range excursions {
+		resp = append(resp, newExcursionDTO(e))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /locations/<id>/excursions/<excursionID>/review
+//
+// Reviews a temperature excursion and optionally releases the lots quarantined because of it.
+func (m *madminHandler) reviewExcursionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	dto := &ExcursionReviewDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	excursion, ok := m.warehouse.Locations().ReadExcursion(vars["excursionID"])
+	if !ok || excursion.LocationID != vars["id"] {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err := m.warehouse.Locations().ReviewExcursion(excursion.ID, requestUserID(r), dto.Note, dto.Release)
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Error in reviewing excursion: %s", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for GET /stock/<id>/lots
+//
+// Lists the lots of the stock item with <id>.
+func (m *madminHandler) listLotsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	lots := m.warehouse.Lots(id)
+
+	resp := make([]*LotDTO, 0, len(lots))
+	for _, lot := range lots {
+		resp = append(resp, newLotDTO(lot))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func (m *madminHandler) lotHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getLotHandler(w, r)
+	case "PUT":
+		m.updateLotHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /lots/<id>
+//
+// Returns JSON with data for the lot with the given id.
+func (m *madminHandler) getLotHandler(w http.ResponseWriter, r *http.Request) {
+	lot, ok := m.warehouse.ReadLot(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newLotDTO(lot))
+}
+
+// Handler for PUT /lots/<id>
+//
+// Assigns the lot with <id> to a storage location.
+func (m *madminHandler) updateLotHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &UpdateLotDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadLot(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err := m.warehouse.MoveLot(id, dto.LocationID)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for POST /lots/<id>/state
+//
+// Changes the state of the lot with <id>. Writing off a lot removes its quantity from the stock,
+// write-offs of controlled substances must be witnessed by a second user.
+func (m *madminHandler) lotStateHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &LotStateDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadLot(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var witnessID string
+	if dto.Witness != "" {
+		if !m.userManager.ValidateUser(dto.Witness, dto.WitnessPassword) {
+			respondBadRequest(w, ValidationErrors{{"witness", "invalid witness name or password"}})
+			return
+		}
+		witness, _ := m.userManager.ReadUserByName(dto.Witness)
+		witnessID = witness.ID()
+	}
+
+	err := m.warehouse.ChangeLotState(id, dto.State, requestUserID(r), witnessID, dto.Reason)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for GET /lots/<id>/history
+//
+// Lists the state changes of the lot with <id>.
+func (m *madminHandler) lotHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.ReadLot(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	history := m.warehouse.LotHistory(id)
+
+	resp := make([]*LotStateDTO, 0, len(history))
+	for i := range history {
+		resp = append(resp, newLotStateDTO(&history[i]))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// locationScope reads the optional location query parameter of lists that can be
+// scoped by storage location. If the location does not exist it responds
+// with status code 400 and returns false.
+func (m *madminHandler) locationScope(w http.ResponseWriter, r *http.Request) (string, bool) {
+	locationID := r.URL.Query().Get("location")
+	if locationID == "" {
+		return "", true
+	}
+
+	if _, ok := m.warehouse.Locations().ReadLocation(locationID); !ok {
+		respondBadRequest(w, ValidationErrors{{"location", "no such storage location"}})
+		return "", false
+	}
+	return locationID, true
+}
+
+// Handler for GET /locations/<id>/stock
+//
+// Lists the quantities and minimum quantities of the stock items in the storage location with <id>.
+func (m *madminHandler) locationStockHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.Locations().ReadLocation(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	levels := m.warehouse.StockLevels(id)
+
+	resp := make([]*StockLevelDTO, 0, len(levels))
+	for _, level := range levels {
+		resp = append(resp, newStockLevelDTO(level))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for PUT /locations/<id>/stock/<stockID>
+//
+// Sets the minimum quantity of the stock item with <stockID> in the storage location with <id>.
+func (m *madminHandler) locationMinQuantityHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars    = mux.Vars(r)
+		id      = vars["id"]
+		stockID = vars["stockID"]
+	)
+
+	dto := &MinQuantityDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.Locations().ReadLocation(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if _, ok := m.warehouse.ReadStock(stockID); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var minQuantity *decimal.Decimal
+	if dto.MinQuantity != "" {
+		quantity, err := validQuantityFromString(dto.MinQuantity)
+		if err != nil {
+			respondBadRequest(w, ValidationErrors{{"minQuantity", err.Error()}})
+			return
+		}
+		minQuantity = &quantity
+	}
+
+	if err := m.warehouse.SetMinQuantity(stockID, id, minQuantity, m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestColdChain(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	var (
+		wh = NewWarehouse(db)
+		lm = wh.Locations()
+
+		fridge = &StorageLocation{
+			Name:                "Vaccine fridge",
+			HasTemperatureRange: true,
+			MinTemperature:      decimal.New(2, 0),
+			MaxTemperature:      decimal.New(8, 0),
+		}
+		expirationDate = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	if err := lm.CreateLocation(&StorageLocation{Name: "Broken", HasTemperatureRange: true, MinTemperature: decimal.New(8, 0)}); err == nil {
+		t.Fatalf(`CreateLocation does not return error for invalid temperature range`)
+	}
+	if err := lm.CreateLocation(fridge); err != nil {
+		t.Fatalf(`CreateLocation returns an error for a valid location: %s`, err)
+	}
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	item.SetQuantity(decimal.Zero)
+	wh.CreateStock(item, nil)
+
+	receipt := &Movement{
+		StockID:  item.ID(),
+		Kind:     RECEIPT,
+		Quantity: decimal.New(10, 0),
+		Lot:      &Lot{Number: "L123", ExpirationDate: &expirationDate, LocationID: fridge.ID},
+	}
+	if err := wh.RecordMovement(receipt); err != nil {
+		t.Fatalf(`RecordMovement returns an error for a valid lot receipt: %s`, err)
+	}
+
+	excursion, err := lm.RecordTemperatures(fridge.ID, []TemperatureReading{{Time: time.Now(), Temperature: decimal.New(5, 0)}})
+	if err != nil || excursion != nil {
+		t.Fatalf(`RecordTemperatures reports an excursion for readings in range: %v, %v`, excursion, err)
+	}
+
+	excursion, err = lm.RecordTemperatures(fridge.ID, []TemperatureReading{
+		{Time: time.Now(), Temperature: decimal.New(95, -1)},
+		{Time: time.Now(), Temperature: decimal.New(12, 0)},
+	})
+	if err != nil || excursion == nil {
+		t.Fatalf(`RecordTemperatures does not report an excursion for readings out of range: %v`, err)
+	}
+	if len(excursion.LotIDs) != 1 || excursion.LotIDs[0] != receipt.LotID || !excursion.MaxTemperature.Equal(decimal.New(12, 0)) {
+		t.Fatalf(`Unexpected excursion %+v`, excursion)
+	}
+
+	lot, _ := wh.ReadLot(receipt.LotID)
+	if lot.State != QUARANTINED {
+		t.Fatalf(`Lot is not quarantined after an excursion. State is %s.`, lot.State)
+	}
+
+	dispense := &Movement{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0), LotID: receipt.LotID}
+	if err := wh.RecordMovement(dispense); err == nil {
+		t.Fatalf(`RecordMovement dispenses from a quarantined lot`)
+	}
+
+	if err := lm.ReviewExcursion(excursion.ID, "pharmacist", "logger fault", true); err != nil {
+		t.Fatalf(`ReviewExcursion returns an error: %s`, err)
+	}
+	if err := lm.ReviewExcursion(excursion.ID, "pharmacist", "again", true); err == nil {
+		t.Fatalf(`ReviewExcursion reviews the same excursion twice`)
+	}
+
+	if err := wh.RecordMovement(dispense); err != nil {
+		t.Fatalf(`RecordMovement returns an error for a released lot: %s`, err)
+	}
+	lot, _ = wh.ReadLot(receipt.LotID)
+	if lot.State != AVAILABLE || !lot.Quantity.Equal(decimal.New(9, 0)) {
+		t.Fatalf(`Unexpected lot after review and dispense: %+v`, lot)
+	}
+
+	if err := lm.DeleteLocation(fridge.ID); err == nil {
+		t.Fatalf(`DeleteLocation removes a location that holds lots`)
+	}
+}
+
+func TestTemperatureIngestion(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	fridge := &StorageLocation{Name: "Fridge", HasTemperatureRange: true, MinTemperature: decimal.New(2, 0), MaxTemperature: decimal.New(8, 0)}
+	madminHandler.warehouse.Locations().CreateLocation(fridge)
+
+	requests := []struct {
+		body   string
+		status int
+	}{
+		{`{"temperature": "4.5", "loggerID": "fridge-1"}`, http.StatusAccepted},
+		{`[{"temperature": "4.5", "time": "2030-01-01T00:00:00.000Z"}, {"temperature": "9.1", "time": "2030-01-01T00:05:00.000Z"}]`, http.StatusAccepted},
+		{`{"temperature": "warm"}`, http.StatusBadRequest},
+	}
+
+	for _, req := range requests {
+		postURL := buildURL(s.URL, fmt.Sprintf("/data/locations/%s/temperature", fridge.ID))
+
+		resp, err := http.Post(postURL, "application/json", bytes.NewReader([]byte(req.body)))
+		if err != nil {
+			t.Fatalf("Error sending POST request: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != req.status {
+			t.Errorf("Expected %d but got %d for temperature readings: %s", req.status, resp.StatusCode, req.body)
+		}
+	}
+
+	if excursions := madminHandler.warehouse.Locations().Excursions(fridge.ID); len(excursions) != 1 {
+		t.Fatalf(`Expected 1 excursion, got %d`, len(excursions))
+	}
+}
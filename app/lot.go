@@ -0,0 +1,753 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type lotState string
+
+// AVAILABLE lots can be dispensed. QUARANTINED lots are blocked until a user reviews them.
+// EXPIRED and RECALLED lots can only be written off. WRITTEN_OFF lots are no longer in stock.
+const (
+	AVAILABLE   lotState = "available"
+	QUARANTINED lotState = "quarantined"
+	EXPIRED     lotState = "expired"
+	WRITTEN_OFF lotState = "written-off"
+	RECALLED    lotState = "recalled"
+)
+
+// lotTransitions lists the states each lot state can be changed to
+var lotTransitions = map[lotState][]lotState{
+	AVAILABLE:   {QUARANTINED, EXPIRED, WRITTEN_OFF, RECALLED},
+	QUARANTINED: {AVAILABLE, EXPIRED, WRITTEN_OFF, RECALLED},
+	EXPIRED:     {WRITTEN_OFF},
+	RECALLED:    {WRITTEN_OFF},
+	WRITTEN_OFF: {},
+}
+
+func (ls lotState) canChangeTo(to lotState) bool {
+	for _, state := range lotTransitions[ls] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// LotStateChange is an entry in the history of a lot's states
+type LotStateChange struct {
+	LotID  string
+	From   lotState
+	To     lotState
+	Time   time.Time
+	UserID string
+	Reason string
+}
+
+// Lot is a batch of a stock item received together.
+// The quantities of all lots of an item add up to the item's quantity.
+type Lot struct {
+	ID      string
+	StockID string
+	Number  string
+
+	// ExpirationDate is nil for lots of unexpirable stock
+	ExpirationDate *time.Time
+	Quantity       decimal.Decimal
+
+	// LocationID is the id of the storage location of the lot or an empty string.
+	// Lots split between locations by transfers have a Lot for each location.
+	LocationID string
+
+	// UnitCost is the purchase price of a single unit of the lot
+	UnitCost decimal.Decimal
+
+	State       lotState
+	StateReason string
+
+	Received time.Time
+}
+
+// lotsTable is the definition of the stock_lots table. A lot that is split
+// between storage locations has a row for each location with the same number.
+const lotsTable = `
+	%s (
+		id TEXT NOT NULL PRIMARY KEY,
+		stock_id TEXT NOT NULL,
+		number TEXT NOT NULL,
+		expiration_date DATETIME,
+		quantity NUMERIC NOT NULL,
+		location_id TEXT NOT NULL,
+		state TEXT NOT NULL,
+		state_reason TEXT NOT NULL,
+		received DATETIME NOT NULL,
+		unit_cost NUMERIC NOT NULL DEFAULT 0,
+		UNIQUE (stock_id, number, location_id),
+		FOREIGN KEY (stock_id) REFERENCES warehouse (id)
+	);
+`
+
+func (wh *dafaultWarehouse) initLotsTable() {
+	lotsTables := `
+	CREATE TABLE IF NOT EXISTS` + fmt.Sprintf(lotsTable, "stock_lots") + `
+	CREATE INDEX IF NOT EXISTS
+		stock_lots_location_id ON stock_lots (location_id);
+	CREATE TABLE IF NOT EXISTS
+		lot_state_history (
+			lot_id TEXT NOT NULL,
+			from_state TEXT NOT NULL,
+			to_state TEXT NOT NULL,
+			time DATETIME NOT NULL,
+			user_id TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			FOREIGN KEY (lot_id) REFERENCES stock_lots (id)
+	);
+	CREATE INDEX IF NOT EXISTS
+		lot_state_history_lot_id ON lot_state_history (lot_id, time);
+	`
+	_, err := wh.database.Exec(lotsTables)
+	if err != nil {
+		panic(err)
+	}
+
+	addColumnIfMissing(wh.database, "stock_movements", "lot_id", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(wh.database, "stock_lots", "unit_cost", "NUMERIC NOT NULL DEFAULT 0")
+	wh.migrateLotsTable()
+}
+
+// migrateLotsTable rebuilds the stock_lots table of older versions of madmin,
+// where a lot number could be used only once per stock item
+func (wh *dafaultWarehouse) migrateLotsTable() {
+	var tableSQL string
+	err := wh.database.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'stock_lots'`).Scan(&tableSQL)
+	if err != nil {
+		panic(err)
+	}
+	if !strings.Contains(tableSQL, "UNIQUE (stock_id, number),") {
+		return
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		CREATE TABLE` + fmt.Sprintf(lotsTable, "stock_lots_new") + `
+		INSERT INTO stock_lots_new (` + lotColumns + `) SELECT ` + lotColumns + ` FROM stock_lots;
+		DROP TABLE stock_lots;
+		ALTER TABLE stock_lots_new RENAME TO stock_lots;
+		CREATE INDEX IF NOT EXISTS
+			stock_lots_location_id ON stock_lots (location_id);
+	`)
+	if err != nil {
+		panic(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// lotColumns are the columns selected by the lot queries, in the order expected by scanLot
+const lotColumns = `
+	id,
+	stock_id,
+	number,
+	expiration_date,
+	quantity,
+	location_id,
+	state,
+	state_reason,
+	received,
+	unit_cost
+`
+
+type rowScanner interface {
+	Scan(...interface{}) error
+}
+
+func scanLot(row rowScanner) (*Lot, error) {
+	lot := &Lot{}
+	err := row.Scan(
+		&lot.ID,
+		&lot.StockID,
+		&lot.Number,
+		&lot.ExpirationDate,
+		&lot.Quantity,
+		&lot.LocationID,
+		&lot.State,
+		&lot.StateReason,
+		&lot.Received,
+		&lot.UnitCost)
+	return lot, err
+}
+
+func (wh *dafaultWarehouse) ReadLot(id string) (*Lot, bool) {
+	lot, err := scanLot(wh.database.QueryRow(`
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			id = ?
+	`, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	return lot, true
+}
+
+// Lots returns the lots of the stock item with the given id, the ones that expire first are first
+func (wh *dafaultWarehouse) Lots(stockID string) []*Lot {
+	return wh.queryLots(`
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			stock_id = ?
+		ORDER BY
+			expiration_date, received
+	`, stockID)
+}
+
+func (wh *dafaultWarehouse) queryLots(query string, args ...interface{}) []*Lot {
+	rows, err := wh.database.Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	lots := make([]*Lot, 0)
+	for rows.Next() {
+		lot, err := scanLot(rows)
+		if err != nil {
+			panic(err)
+		}
+		lots = append(lots, lot)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return lots
+}
+
+// MoveLot assigns the lot with the given id to a storage location
+// and moves its quantity to the stock level of the location
+func (wh *dafaultWarehouse) MoveLot(id, locationID string) error {
+	lot, ok := wh.ReadLot(id)
+	if !ok {
+		return errors.New("no such lot")
+	}
+	if locationID != "" {
+		if _, ok := wh.locations.ReadLocation(locationID); !ok {
+			return ValidationErrors{{"locationID", "no such storage location"}}
+		}
+	}
+	if locationID == lot.LocationID {
+		return nil
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	var split int
+	err = tx.QueryRow(`
+		SELECT
+			COUNT(*)
+		FROM
+			stock_lots
+		WHERE
+			stock_id = ? AND number = ? AND location_id = ?
+	`, lot.StockID, lot.Number, locationID).Scan(&split)
+	if err != nil {
+		panic(err)
+	}
+	if split > 0 {
+		return ValidationErrors{{"locationID", "part of the lot is already in the storage location, transfer the rest instead"}}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE
+			stock_lots
+		SET
+			location_id = ?
+		WHERE
+			id = ?
+	`, locationID, id)
+	if err != nil {
+		panic(err)
+	}
+
+	changeStockLevelTx(tx, lot.StockID, lot.LocationID, lot.Quantity.Neg())
+	changeStockLevelTx(tx, lot.StockID, locationID, lot.Quantity)
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// receiveLotTx finds the lot of the movement's stock item with the number and location of mv.Lot
+// or creates it if it does not exist yet and sets mv.LotID
+func (wh *dafaultWarehouse) receiveLotTx(tx *sql.Tx, mv *Movement, item Stock) error {
+	errs := ValidationErrors{}
+	if mv.Kind != RECEIPT {
+		errs = append(errs, ValidationError{"lot", "new lots can only be received"})
+	}
+	if mv.Lot.Number == "" {
+		errs = append(errs, ValidationError{"lot.number", "no lot number set"})
+	}
+	if item.IsExpirable() && mv.Lot.ExpirationDate == nil {
+		errs = append(errs, ValidationError{"lot.expirationDate", "expected expiration date but not set"})
+	}
+	if !item.IsExpirable() && mv.Lot.ExpirationDate != nil {
+		errs = append(errs, ValidationError{"lot.expirationDate", "expiration date set for unexpirable stock"})
+	}
+	if mv.Lot.LocationID != "" {
+		if _, ok := wh.locations.ReadLocation(mv.Lot.LocationID); !ok {
+			errs = append(errs, ValidationError{"lot.locationID", "no such storage location"})
+		}
+	}
+	if mv.Lot.UnitCost.Sign() < 0 {
+		errs = append(errs, ValidationError{"lot.unitCost", "unit cost must not be negative"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	err := tx.QueryRow(`
+		SELECT
+			id
+		FROM
+			stock_lots
+		WHERE
+			stock_id = ? AND number = ? AND location_id = ?
+	`, mv.StockID, mv.Lot.Number, mv.Lot.LocationID).Scan(&mv.LotID)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		panic(err)
+	default:
+		return nil
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	mv.Lot.ID = id
+	mv.Lot.StockID = mv.StockID
+	mv.Lot.Quantity = decimal.Zero
+	mv.Lot.State = AVAILABLE
+	mv.Lot.Received = time.Now().UTC()
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			stock_lots (`+lotColumns+`)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		mv.Lot.ID,
+		mv.Lot.StockID,
+		mv.Lot.Number,
+		mv.Lot.ExpirationDate,
+		mv.Lot.Quantity.String(),
+		mv.Lot.LocationID,
+		mv.Lot.State,
+		mv.Lot.StateReason,
+		mv.Lot.Received,
+		mv.Lot.UnitCost.String())
+	if err != nil {
+		panic(err)
+	}
+
+	mv.LotID = id
+	return nil
+}
+
+// updateLotQuantityTx applies the movement to the quantity of its lot
+func (wh *dafaultWarehouse) updateLotQuantityTx(tx *sql.Tx, mv *Movement, item Stock) error {
+	lot, err := scanLot(tx.QueryRow(`
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			id = ?
+	`, mv.LotID))
+	switch {
+	case err == sql.ErrNoRows:
+		return ValidationErrors{{"lotID", "no such lot"}}
+	case err != nil:
+		panic(err)
+	}
+
+	if lot.StockID != mv.StockID {
+		return ValidationErrors{{"lotID", "the lot belongs to a different stock item"}}
+	}
+	if mv.LocationID != "" && mv.LocationID != lot.LocationID {
+		return ValidationErrors{{"locationID", "the lot is in a different storage location"}}
+	}
+	mv.LocationID = lot.LocationID
+	if mv.Kind == DISPENSE && !lot.isAvailable(time.Now()) {
+		return ValidationErrors{{"lotID", "the lot is not available"}}
+	}
+	if lot.State == WRITTEN_OFF {
+		return ValidationErrors{{"lotID", "the lot is written off"}}
+	}
+
+	quantity := lot.Quantity.Add(mv.delta())
+	if item.QuantityRule().NonNegative && quantity.Sign() < 0 {
+		return ValidationErrors{errInsufficientLotStock}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE
+			stock_lots
+		SET
+			quantity = ?
+		WHERE
+			id = ?
+	`, quantity.String(), lot.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// lotAdjustmentsTx splits an adjustment of a stock item's quantity between its lots. Stock is taken
+// from the lots that expire first and then from the stock without lots. Stock is added to the
+// available lot that expires last, or without a lot if the item has no available lots.
+func lotAdjustmentsTx(tx *sql.Tx, stockID string, delta decimal.Decimal, userID string) []*Movement {
+	adjustment := func(lotID string, quantity decimal.Decimal) *Movement {
+		return &Movement{
+			StockID:   stockID,
+			Kind:      ADJUSTMENT,
+			Quantity:  quantity,
+			UserID:    userID,
+			Reference: stockUpdateReference,
+			LotID:     lotID,
+		}
+	}
+
+	lots := queryLotsTx(tx, `
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			stock_id = ? AND state != ?
+		ORDER BY
+			expiration_date IS NULL, expiration_date, received
+	`, stockID, WRITTEN_OFF)
+
+	if delta.Sign() > 0 {
+		for i := len(lots) - 1; i >= 0; i-- {
+			if lots[i].State == AVAILABLE {
+				return []*Movement{adjustment(lots[i].ID, delta)}
+			}
+		}
+		return []*Movement{adjustment("", delta)}
+	}
+
+	var (
+		adjustments = make([]*Movement, 0)
+		remaining   = delta.Neg()
+	)
+	for _, lot := range lots {
+		if remaining.IsZero() {
+			break
+		}
+		if lot.Quantity.Sign() <= 0 {
+			continue
+		}
+		quantity := decimal.Min(remaining, lot.Quantity)
+		adjustments = append(adjustments, adjustment(lot.ID, quantity.Neg()))
+		remaining = remaining.Sub(quantity)
+	}
+	if remaining.Sign() > 0 {
+		adjustments = append(adjustments, adjustment("", remaining.Neg()))
+	}
+	return adjustments
+}
+
+// isAvailable checks if the lot can be dispensed at the given time
+func (lot *Lot) isAvailable(now time.Time) bool {
+	return lot.State == AVAILABLE && (lot.ExpirationDate == nil || lot.ExpirationDate.After(now))
+}
+
+// setLotStateTx changes the state of the lot and records the change in the lot's history
+func setLotStateTx(tx *sql.Tx, lot *Lot, to lotState, userID, reason string) error {
+	if !lot.State.canChangeTo(to) {
+		return ValidationErrors{{"state", fmt.Sprintf("cannot change lot state from %s to %s", lot.State, to)}}
+	}
+
+	_, err := tx.Exec(`
+		UPDATE
+			stock_lots
+		SET
+			state = ?,
+			state_reason = ?
+		WHERE
+			id = ?
+	`, to, reason, lot.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			lot_state_history (
+				lot_id,
+				from_state,
+				to_state,
+				time,
+				user_id,
+				reason)
+		VALUES(?, ?, ?, ?, ?, ?)
+	`, lot.ID, lot.State, to, time.Now().UTC(), userID, reason)
+	if err != nil {
+		panic(err)
+	}
+
+	lot.State = to
+	lot.StateReason = reason
+	return nil
+}
+
+// queryLotsTx reads lots as part of a transaction
+func queryLotsTx(tx *sql.Tx, query string, args ...interface{}) []*Lot {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	lots := make([]*Lot, 0)
+	for rows.Next() {
+		lot, err := scanLot(rows)
+		if err != nil {
+			panic(err)
+		}
+		lots = append(lots, lot)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return lots
+}
+
+// ChangeLotState moves the lot with the given id to a new state and records the change in its history.
+// Writing off a lot also records a write-off movement for the quantity that is left in it,
+// which is signed by the user and the witness for controlled substances.
+func (wh *dafaultWarehouse) ChangeLotState(id string, to lotState, userID, witnessID, reason string) error {
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	lots := queryLotsTx(tx, `SELECT `+lotColumns+` FROM stock_lots WHERE id = ?`, id)
+	if len(lots) == 0 {
+		return errors.New("no such lot")
+	}
+	lot := lots[0]
+
+	if reason == "" {
+		return ValidationErrors{{"reason", "no reason set for the change of the lot state"}}
+	}
+
+	availableBefore := availableQuantityTx(tx, lot.StockID, time.Now())
+
+	var writeOff *Movement
+	if to == WRITTEN_OFF && lot.Quantity.Sign() > 0 {
+		writeOff = &Movement{
+			StockID:   lot.StockID,
+			Kind:      WRITE_OFF,
+			Quantity:  lot.Quantity,
+			UserID:    userID,
+			WitnessID: witnessID,
+			Reference: reason,
+			LotID:     lot.ID,
+		}
+		if err := wh.recordMovementTx(tx, writeOff); err != nil {
+			return err
+		}
+	}
+
+	if err := setLotStateTx(tx, lot, to, userID, reason); err != nil {
+		return err
+	}
+	availableAfter := availableQuantityTx(tx, lot.StockID, time.Now())
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	if writeOff != nil {
+		wh.publishMovement(writeOff)
+	} else {
+		wh.publishBelowMinimum(lot.StockID, availableBefore, availableAfter)
+	}
+	return nil
+}
+
+// LotHistory returns the state changes of the lot with the given id in chronological order
+func (wh *dafaultWarehouse) LotHistory(id string) []LotStateChange {
+	rows, err := wh.database.Query(`
+		SELECT
+			from_state,
+			to_state,
+			time,
+			user_id,
+			reason
+		FROM
+			lot_state_history
+		WHERE
+			lot_id = ?
+		ORDER BY
+			time, rowid
+	`, id)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	history := make([]LotStateChange, 0)
+	for rows.Next() {
+		change := LotStateChange{LotID: id}
+		err = rows.Scan(
+			&change.From,
+			&change.To,
+			&change.Time,
+			&change.UserID,
+			&change.Reason)
+		if err != nil {
+			panic(err)
+		}
+		history = append(history, change)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return history
+}
+
+// ExpireLots moves all available and quarantined lots that expired before now to the EXPIRED state.
+// It returns the expired lots.
+func (wh *dafaultWarehouse) ExpireLots(now time.Time) []*Lot {
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	lots := queryLotsTx(tx, `
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			state IN (?, ?) AND expiration_date IS NOT NULL AND expiration_date <= ?
+	`, AVAILABLE, QUARANTINED, now.UTC())
+
+	for _, lot := range lots {
+		if err := setLotStateTx(tx, lot, EXPIRED, "", "expiration date passed"); err != nil {
+			panic(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, lot := range lots {
+		wh.publish(LOT_EXPIRED, newLotDTO(lot))
+	}
+	return lots
+}
+
+// unavailableQuantityTx returns the quantity of the stock item's lots that cannot be dispensed
+func unavailableQuantityTx(tx *sql.Tx, stockID string, now time.Time) decimal.Decimal {
+	unavailable := decimal.Zero
+	for _, lot := range queryLotsTx(tx, `SELECT `+lotColumns+` FROM stock_lots WHERE stock_id = ?`, stockID) {
+		if !lot.isAvailable(now) {
+			unavailable = unavailable.Add(lot.Quantity)
+		}
+	}
+	return unavailable
+}
+
+// availableQuantityTx returns the quantity of the stock item that can be dispensed
+func availableQuantityTx(tx *sql.Tx, stockID string, now time.Time) decimal.Decimal {
+	var quantity decimal.Decimal
+	err := tx.QueryRow(`SELECT quantity FROM warehouse WHERE id = ?`, stockID).Scan(&quantity)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	return quantity.Sub(unavailableQuantityTx(tx, stockID, now))
+}
+
+// unavailableQuantity returns the quantity of the stock item's lots that cannot be dispensed
+func (wh *dafaultWarehouse) unavailableQuantity(stockID string) decimal.Decimal {
+	var (
+		now         = time.Now()
+		unavailable = decimal.Zero
+	)
+	for _, lot := range wh.queryLots(`SELECT `+lotColumns+` FROM stock_lots WHERE stock_id = ?`, stockID) {
+		if !lot.isAvailable(now) {
+			unavailable = unavailable.Add(lot.Quantity)
+		}
+	}
+	return unavailable
+}
+
+// AvailableQuantities returns the quantity that can be dispensed for every stock item.
+// Quantities in lots that are not available or already expired are not counted.
+// Bundles can also dispense the kits that can be assembled from their components.
+func (wh *dafaultWarehouse) AvailableQuantities() map[string]decimal.Decimal {
+	available := make(map[string]decimal.Decimal)
+	for id, item := range wh.Stock() {
+		available[id] = item.Quantity()
+	}
+
+	now := time.Now()
+	lots := wh.queryLots(`
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			state != ? OR (expiration_date IS NOT NULL AND expiration_date <= ?)
+	`, AVAILABLE, now.UTC())
+	for _, lot := range lots {
+		if quantity, ok := available[lot.StockID]; ok && !lot.isAvailable(now) {
+			available[lot.StockID] = quantity.Sub(lot.Quantity)
+		}
+	}
+	wh.buildableBundles(available)
+
+	return available
+}
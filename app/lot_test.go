@@ -0,0 +1,119 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestLotStates(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	var (
+		wh             = NewWarehouse(db)
+		expirationDate = time.Now().AddDate(1, 0, 0)
+		expired        = time.Now().AddDate(0, 0, -1)
+	)
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	item.SetQuantity(decimal.Zero)
+	item.SetMinQuantity(decimal.New(5, 0))
+	wh.CreateStock(item, nil)
+
+	first := &Movement{
+		StockID:  item.ID(),
+		Kind:     RECEIPT,
+		Quantity: decimal.New(10, 0),
+		Lot:      &Lot{Number: "A1", ExpirationDate: &expirationDate, UnitCost: decimal.New(25, -1)},
+	}
+	second := &Movement{
+		StockID:  item.ID(),
+		Kind:     RECEIPT,
+		Quantity: decimal.New(4, 0),
+		Lot:      &Lot{Number: "B2", ExpirationDate: &expired},
+	}
+	for _, mv := range []*Movement{first, second} {
+		if err := wh.RecordMovement(mv); err != nil {
+			t.Fatalf(`RecordMovement returns an error for a valid lot receipt: %s`, err)
+		}
+	}
+
+	belowMinimum := 0
+	wh.AddListener(func(e Event) {
+		if e.Type == STOCK_BELOW_MINIMUM {
+			belowMinimum++
+		}
+	})
+
+	if err := wh.ChangeLotState(first.LotID, QUARANTINED, "pharmacist", "", ""); err == nil {
+		t.Fatalf(`ChangeLotState changes the state without a reason`)
+	}
+	if err := wh.ChangeLotState(first.LotID, QUARANTINED, "pharmacist", "", "damaged packaging"); err != nil {
+		t.Fatalf(`ChangeLotState returns an error for a valid change: %s`, err)
+	}
+	if available := wh.AvailableQuantities()[item.ID()]; !available.IsZero() {
+		t.Fatalf(`Quarantined and expired lots are counted as available: %s`, available)
+	}
+	if belowMinimum != 1 {
+		t.Fatalf(`Expected quarantine to take the item below its minimum once, got %d events`, belowMinimum)
+	}
+
+	if lots := wh.ExpireLots(time.Now()); len(lots) != 1 || lots[0].ID != second.LotID {
+		t.Fatalf(`ExpireLots does not expire exactly the expired lot: %v`, lots)
+	}
+	if err := wh.ChangeLotState(second.LotID, AVAILABLE, "pharmacist", "", "mistake"); err == nil {
+		t.Fatalf(`ChangeLotState makes an expired lot available`)
+	}
+
+	if err := wh.ChangeLotState(first.LotID, WRITTEN_OFF, "pharmacist", "", "damaged packaging"); err != nil {
+		t.Fatalf(`ChangeLotState returns an error for a write-off: %s`, err)
+	}
+	lot, _ := wh.ReadLot(first.LotID)
+	if lot.State != WRITTEN_OFF || !lot.Quantity.IsZero() {
+		t.Fatalf(`Unexpected lot after a write-off: %+v`, lot)
+	}
+	if item, _ = wh.ReadStock(item.ID()); !item.Quantity().Equal(decimal.New(4, 0)) {
+		t.Fatalf(`Write-off does not reduce the stock quantity. Quantity is %s.`, item.Quantity())
+	}
+	if err := wh.ChangeLotState(first.LotID, AVAILABLE, "pharmacist", "", "mistake"); err == nil {
+		t.Fatalf(`ChangeLotState changes the state of a written-off lot`)
+	}
+
+	history := wh.LotHistory(first.LotID)
+	if len(history) != 2 || history[0].To != QUARANTINED || history[1].From != QUARANTINED || history[1].To != WRITTEN_OFF {
+		t.Fatalf(`Unexpected lot history %+v`, history)
+	}
+
+	writeOffs := wh.WriteOffs(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if len(writeOffs) != 1 || writeOffs[0].LotNumber != "A1" || !writeOffs[0].Value().Equal(decimal.New(25, 0)) {
+		t.Fatalf(`Unexpected write-offs %+v`, writeOffs)
+	}
+
+	controlled, _ := defaultExpirableStockItem(MEDICINE)
+	controlled.SetControlledSchedule("2")
+	controlled.SetQuantity(decimal.Zero)
+	wh.CreateStock(controlled, nil)
+	receipt := &Movement{
+		StockID:   controlled.ID(),
+		Kind:      RECEIPT,
+		Quantity:  decimal.New(3, 0),
+		UserID:    "pharmacist",
+		WitnessID: "vet",
+		Lot:       &Lot{Number: "C3", ExpirationDate: &expirationDate},
+	}
+	if err := wh.RecordMovement(receipt); err != nil {
+		t.Fatalf(`RecordMovement returns an error for a witnessed receipt: %s`, err)
+	}
+	if err := wh.ChangeLotState(receipt.LotID, WRITTEN_OFF, "pharmacist", "", "broken vials"); err == nil {
+		t.Fatalf(`ChangeLotState writes off a controlled lot without a witness`)
+	}
+	if err := wh.ChangeLotState(receipt.LotID, WRITTEN_OFF, "pharmacist", "vet", "broken vials"); err != nil {
+		t.Fatalf(`ChangeLotState returns an error for a witnessed write-off: %s`, err)
+	}
+	if controlled, _ = wh.ReadStock(controlled.ID()); !controlled.Quantity().IsZero() {
+		t.Fatalf(`Witnessed write-off does not reduce the stock quantity. Quantity is %s.`, controlled.Quantity())
+	}
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mailer sends emails with a plain text and an HTML body
+type Mailer interface {
+	Send(to, subject, text, html string) error
+}
+
+// SMTPConfig is the address of the SMTP server and the credentials used to send emails
+type SMTPConfig struct {
+	// Addr is the host:port of the SMTP server
+	Addr string
+	From string
+
+	// Username and Password are optional. Credentials are only sent over TLS or to localhost.
+	Username string
+	Password string
+}
+
+// smtpConfigFromEnv reads the SMTP configuration from the MADMIN_SMTP_* environment variables.
+// It returns false if no SMTP server is configured.
+func smtpConfigFromEnv() (SMTPConfig, bool) {
+	config := SMTPConfig{
+		Addr:     os.Getenv("MADMIN_SMTP_ADDR"),
+		From:     os.Getenv("MADMIN_SMTP_FROM"),
+		Username: os.Getenv("MADMIN_SMTP_USERNAME"),
+		Password: os.Getenv("MADMIN_SMTP_PASSWORD"),
+	}
+	return config, config.Addr != "" && config.From != ""
+}
+
+type smtpMailer struct {
+	config SMTPConfig
+}
+
+// NewSMTPMailer creates a mailer that sends emails through the SMTP server in the config
+func NewSMTPMailer(config SMTPConfig) Mailer {
+	return &smtpMailer{config: config}
+}
+
+func (sm *smtpMailer) Send(to, subject, text, html string) error {
+	msg, err := newMessage(sm.config.From, to, subject, text, html)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if sm.config.Username != "" {
+		host, _, err := net.SplitHostPort(sm.config.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", sm.config.Username, sm.config.Password, host)
+	}
+
+	return smtp.SendMail(sm.config.Addr, auth, sm.config.From, []string{to}, msg)
+}
+
+// newMessage builds a multipart/alternative email with the text and the HTML body
+func newMessage(from, to, subject, text, html string) ([]byte, error) {
+	var (
+		buf  = &bytes.Buffer{}
+		body = &bytes.Buffer{}
+		mw   = multipart.NewWriter(body)
+	)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	}
+	for _, part := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write([]byte(normalizeNewlines(part.content))); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	headers := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: multipart/alternative; boundary=%q", mw.Boundary()),
+	}
+	buf.WriteString(strings.Join(headers, "\r\n"))
+	buf.WriteString("\r\n\r\n")
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// normalizeNewlines makes all line endings CRLF as required by SMTP
+func normalizeNewlines(s string) string {
+	return strings.Replace(strings.Replace(s, "\r\n", "\n", -1), "\n", "\r\n", -1)
+}
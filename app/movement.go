@@ -0,0 +1,495 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type movementKind string
+
+// RECEIPT, DISPENSE, ADJUSTMENT, WRITE_OFF and ASSEMBLY are the kinds of stock movements.
+// Receipts add to the quantity of a stock item, dispenses take from it
+// and adjustments correct it in either direction.
+// Write-offs remove expired, damaged or recalled stock and need a reason as reference.
+// Assemblies add kits to a bundle and take their components, or the other way around
+// when kits are taken apart. They are only recorded by assembling bundles.
+const (
+	RECEIPT    movementKind = "receipt"
+	DISPENSE   movementKind = "dispense"
+	ADJUSTMENT movementKind = "adjustment"
+	WRITE_OFF  movementKind = "write-off"
+	ASSEMBLY   movementKind = "assembly"
+)
+
+// openingBalanceReference is the reference of the movement that records the quantity a stock item
+// is created with. stockUpdateReference is the reference of the adjustments that record a quantity
+// set by updating a stock item.
+const (
+	openingBalanceReference = "opening balance"
+	stockUpdateReference    = "stock item update"
+)
+
+// Movement is an entry in the ledger of stock movements.
+// Once recorded, a movement cannot be changed or removed.
+type Movement struct {
+	ID      string
+	StockID string
+	Kind    movementKind
+
+	// Quantity is positive for receipts and dispenses and signed for adjustments and assemblies
+	Quantity decimal.Decimal
+	// Balance is the quantity of the stock item after the movement
+	Balance decimal.Decimal
+
+	Time   time.Time
+	UserID string
+
+	// Reference is a free-text reference to a delivery note, invoice, etc.
+	Reference string
+
+	// PatientRef, Prescriber and WitnessID are required for movements of controlled substances
+	PatientRef string
+	Prescriber string
+	WitnessID  string
+
+	// LotID is the id of the lot the movement is for or an empty string for items without lots.
+	// Receipts of new lots set Lot instead and get their LotID when recorded.
+	LotID string
+	Lot   *Lot
+
+	// LocationID is the id of the storage location the movement is for. Movements of lots
+	// are always in the location of the lot. Movements without a location change
+	// the unassigned quantity of the stock item.
+	LocationID string
+
+	// PatientID is the id of the patient the stock was dispensed to or an empty string
+	PatientID string
+	// PrescriptionID is the id of the prescription the stock was dispensed against or an empty string
+	PrescriptionID string
+
+	// assembly are the movements that assembled the kits of a bundle for a dispense
+	assembly []*Movement
+	// availableBefore and availableAfter are the quantities of the stock item
+	// that could be dispensed before and after the movement
+	availableBefore, availableAfter decimal.Decimal
+}
+
+// delta returns the change of the stock item's quantity caused by the movement
+func (mv *Movement) delta() decimal.Decimal {
+	if mv.Kind == DISPENSE || mv.Kind == WRITE_OFF {
+		return mv.Quantity.Neg()
+	}
+	return mv.Quantity
+}
+
+// isOpeningBalance reports whether the movement records the quantity its stock item was created with
+func (mv *Movement) isOpeningBalance() bool {
+	return mv.Reference == openingBalanceReference && (mv.Kind == RECEIPT || mv.Kind == ADJUSTMENT)
+}
+
+// takesStock reports whether the movement uses up stock that must be available for dispensing
+func (mv *Movement) takesStock() bool {
+	return mv.Kind == DISPENSE || (mv.Kind == ASSEMBLY && mv.Quantity.Sign() < 0)
+}
+
+// validate checks the fields of a movement of the given item before it is recorded
+func (mv *Movement) validate(item Stock) error {
+	errs := ValidationErrors{}
+
+	switch mv.Kind {
+	case RECEIPT, DISPENSE:
+		if mv.Quantity.Sign() <= 0 {
+			errs = append(errs, ValidationError{"quantity", "quantity must be positive"})
+		}
+	case WRITE_OFF:
+		if mv.Quantity.Sign() <= 0 {
+			errs = append(errs, ValidationError{"quantity", "quantity must be positive"})
+		}
+		if mv.Reference == "" {
+			errs = append(errs, ValidationError{"reference", "no reason set for the write-off"})
+		}
+	case ADJUSTMENT, ASSEMBLY:
+		if mv.Quantity.Sign() == 0 {
+			errs = append(errs, ValidationError{"quantity", "quantity must not be zero"})
+		}
+	default:
+		errs = append(errs, ValidationError{"kind", "invalid movement kind"})
+	}
+	if mv.PatientID != "" && mv.Kind != DISPENSE {
+		errs = append(errs, ValidationError{"patientID", "only dispenses can be made to a patient"})
+	}
+	if mv.Kind == DISPENSE && item.PrescriptionRequired() && mv.PrescriptionID == "" {
+		errs = append(errs, ValidationError{"prescriptionID", "the item can only be dispensed against a prescription"})
+	}
+	if mv.PrescriptionID != "" && mv.Kind != DISPENSE {
+		errs = append(errs, ValidationError{"prescriptionID", "only dispenses can be made against a prescription"})
+	}
+
+	rule := item.QuantityRule()
+	rule.NonNegative = false
+	if err := rule.Validate("quantity", mv.Quantity); err != nil {
+		errs = append(errs, err.(ValidationErrors)...)
+	}
+
+	if item.IsControlled() {
+		if mv.UserID == "" {
+			errs = append(errs, ValidationError{"user", "movements of controlled substances must be signed by a user"})
+		}
+		if mv.WitnessID == "" {
+			errs = append(errs, ValidationError{"witness", "movements of controlled substances need a witness"})
+		} else if mv.WitnessID == mv.UserID {
+			errs = append(errs, ValidationError{"witness", "the witness must be a different user"})
+		}
+		if mv.Kind == DISPENSE || mv.Kind == ADJUSTMENT {
+			if mv.PatientRef == "" {
+				errs = append(errs, ValidationError{"patientRef", "movements of controlled substances need a patient or owner reference"})
+			}
+			if mv.Prescriber == "" {
+				errs = append(errs, ValidationError{"prescriber", "movements of controlled substances need a prescribing vet"})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (wh *dafaultWarehouse) initMovementsTable() {
+	movementsTable := `
+	CREATE TABLE IF NOT EXISTS
+		stock_movements (
+			id TEXT NOT NULL PRIMARY KEY,
+			stock_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			balance NUMERIC NOT NULL,
+			time DATETIME NOT NULL,
+			user_id TEXT NOT NULL,
+			reference TEXT NOT NULL,
+			patient_ref TEXT NOT NULL,
+			prescriber TEXT NOT NULL,
+			witness_id TEXT NOT NULL,
+			lot_id TEXT NOT NULL DEFAULT '',
+			location_id TEXT NOT NULL DEFAULT '',
+			patient_id TEXT NOT NULL DEFAULT '',
+			prescription_id TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id)
+	);
+	CREATE INDEX IF NOT EXISTS
+		stock_movements_stock_id ON stock_movements (stock_id, time);
+	CREATE TRIGGER IF NOT EXISTS
+		stock_movements_no_update BEFORE UPDATE ON stock_movements
+	BEGIN
+		SELECT RAISE(ABORT, 'stock movements cannot be changed');
+	END;
+	CREATE TRIGGER IF NOT EXISTS
+		stock_movements_no_delete BEFORE DELETE ON stock_movements
+	BEGIN
+		SELECT RAISE(ABORT, 'stock movements cannot be removed');
+	END;
+	`
+	_, err := wh.database.Exec(movementsTable)
+	if err != nil {
+		panic(err)
+	}
+
+	addColumnIfMissing(wh.database, "stock_movements", "location_id", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(wh.database, "stock_movements", "patient_id", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(wh.database, "stock_movements", "prescription_id", "TEXT NOT NULL DEFAULT ''")
+
+	_, err = wh.database.Exec(`
+	CREATE INDEX IF NOT EXISTS
+		stock_movements_patient_id ON stock_movements (patient_id, time);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// The validation errors of movements that take more stock than there is
+var (
+	errInsufficientStock                  = ValidationError{"quantity", "insufficient stock"}
+	errInsufficientAvailableStock         = ValidationError{"quantity", "insufficient available stock"}
+	errInsufficientLotStock               = ValidationError{"quantity", "insufficient stock in lot"}
+	errInsufficientLocationStock          = ValidationError{"quantity", "insufficient stock in the storage location"}
+	errInsufficientAvailableLocationStock = ValidationError{"quantity", "insufficient available stock in the storage location"}
+)
+
+// RecordMovement changes the quantity of the movement's stock item and adds the movement
+// to the ledger in a single transaction. The movement's ID, Time and Balance are set on success.
+func (wh *dafaultWarehouse) RecordMovement(mv *Movement) error {
+	if mv.Kind == ASSEMBLY {
+		return ValidationErrors{{"kind", "assemblies are recorded by assembling bundles"}}
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	err = wh.recordMovementTx(tx, mv)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	wh.publishMovement(mv)
+	return nil
+}
+
+// recordMovementTx records a movement as part of a bigger transaction
+func (wh *dafaultWarehouse) recordMovementTx(tx *sql.Tx, mv *Movement) error {
+	var (
+		sType        stockType
+		quantity     decimal.Decimal
+		schedule     string
+		prescription bool
+	)
+	err := tx.QueryRow(`
+		SELECT
+			type,
+			quantity,
+			controlled_schedule,
+			prescription_required
+		FROM
+			warehouse
+		WHERE
+			id = ?
+	`, mv.StockID).Scan(&sType, &quantity, &schedule, &prescription)
+	switch {
+	case err == sql.ErrNoRows:
+		return errors.New("no such stock item")
+	case err != nil:
+		panic(err)
+	}
+
+	kind, ok := wh.stockTypes.ReadStockType(sType)
+	if !ok {
+		panic("stock type of DB record is missing in the stock type registry")
+	}
+	item := &defaultStock{id: mv.StockID, kind: kind, quantity: quantity, controlledSchedule: schedule, prescriptionRequired: prescription}
+
+	if err := mv.validate(item); err != nil {
+		return err
+	}
+	mv.availableBefore = quantity.Sub(unavailableQuantityTx(tx, mv.StockID, time.Now()))
+	if mv.PrescriptionID != "" {
+		if err := usePrescriptionTx(tx, mv, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	if sType == BUNDLE && mv.Kind == DISPENSE && mv.Quantity.GreaterThan(quantity) {
+		// the kits that were not assembled in advance are assembled from their components
+		shortfall := mv.Quantity.Sub(decimal.Max(quantity, decimal.Zero))
+		assembly, err := wh.assembleBundleTx(tx, mv.StockID, shortfall, mv.UserID, mv.LocationID)
+		if err != nil {
+			return err
+		}
+		mv.assembly = assembly
+		quantity = quantity.Add(shortfall)
+	}
+
+	balance := quantity.Add(mv.delta())
+	if item.QuantityRule().NonNegative && balance.Sign() < 0 {
+		return ValidationErrors{errInsufficientStock}
+	}
+	if mv.takesStock() && mv.LotID == "" && item.QuantityRule().NonNegative {
+		// quarantined, expired and recalled lots cannot be dispensed
+		if balance.LessThan(unavailableQuantityTx(tx, mv.StockID, time.Now())) {
+			return ValidationErrors{errInsufficientAvailableStock}
+		}
+	}
+
+	if mv.LocationID != "" {
+		if _, ok := wh.locations.ReadLocation(mv.LocationID); !ok {
+			return ValidationErrors{{"locationID", "no such storage location"}}
+		}
+	}
+
+	if mv.Lot != nil {
+		if mv.Lot.LocationID == "" {
+			mv.Lot.LocationID = mv.LocationID
+		}
+		if mv.Lot.LocationID != mv.LocationID && mv.LocationID != "" {
+			return ValidationErrors{{"locationID", "the lot is received in a different storage location"}}
+		}
+		if err := wh.receiveLotTx(tx, mv, item); err != nil {
+			return err
+		}
+	}
+	if mv.LotID != "" {
+		if err := wh.updateLotQuantityTx(tx, mv, item); err != nil {
+			return err
+		}
+	}
+	if err := applyStockLevelTx(tx, mv, item); err != nil {
+		return err
+	}
+
+	if err := insertMovementTx(tx, mv, balance); err != nil {
+		return err
+	}
+	mv.availableAfter = availableQuantityTx(tx, mv.StockID, time.Now())
+
+	return nil
+}
+
+// insertMovementTx sets the quantity of the movement's stock item to the balance
+// and adds the movement to the ledger
+func insertMovementTx(tx *sql.Tx, mv *Movement, balance decimal.Decimal) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	mv.ID = id
+	mv.Time = time.Now().UTC()
+	mv.Balance = balance
+
+	_, err = tx.Exec(`
+		UPDATE
+			warehouse
+		SET
+			quantity = ?
+		WHERE
+			id = ?
+	`, balance.String(), mv.StockID)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			stock_movements (
+				id,
+				stock_id,
+				kind,
+				quantity,
+				balance,
+				time,
+				user_id,
+				reference,
+				patient_ref,
+				prescriber,
+				witness_id,
+				lot_id,
+				location_id,
+				patient_id,
+				prescription_id)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		mv.ID,
+		mv.StockID,
+		mv.Kind,
+		mv.Quantity.String(),
+		mv.Balance.String(),
+		mv.Time,
+		mv.UserID,
+		mv.Reference,
+		mv.PatientRef,
+		mv.Prescriber,
+		mv.WitnessID,
+		mv.LotID,
+		mv.LocationID,
+		mv.PatientID,
+		mv.PrescriptionID)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// movementColumns are the columns selected by the movement queries, in the order expected by queryMovements
+const movementColumns = `
+	id,
+	stock_id,
+	kind,
+	quantity,
+	balance,
+	time,
+	user_id,
+	reference,
+	patient_ref,
+	prescriber,
+	witness_id,
+	lot_id,
+	location_id,
+	patient_id,
+	prescription_id
+`
+
+// Movements returns the ledger entries of the stock item with the given id in chronological order
+func (wh *dafaultWarehouse) Movements(stockID string) []Movement {
+	return wh.queryMovements(`
+		SELECT `+movementColumns+`
+		FROM
+			stock_movements
+		WHERE
+			stock_id = ?
+		ORDER BY
+			time, rowid
+	`, stockID)
+}
+
+// PatientDispenses returns the dispenses to the patient with the given id in chronological order
+func (wh *dafaultWarehouse) PatientDispenses(patientID string) []Movement {
+	return wh.queryMovements(`
+		SELECT `+movementColumns+`
+		FROM
+			stock_movements
+		WHERE
+			patient_id = ? AND kind = ?
+		ORDER BY
+			time, rowid
+	`, patientID, DISPENSE)
+}
+
+func (wh *dafaultWarehouse) queryMovements(query string, args ...interface{}) []Movement {
+	rows, err := wh.database.Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	movements := make([]Movement, 0)
+	for rows.Next() {
+		mv := Movement{}
+		err = rows.Scan(
+			&mv.ID,
+			&mv.StockID,
+			&mv.Kind,
+			&mv.Quantity,
+			&mv.Balance,
+			&mv.Time,
+			&mv.UserID,
+			&mv.Reference,
+			&mv.PatientRef,
+			&mv.Prescriber,
+			&mv.WitnessID,
+			&mv.LotID,
+			&mv.LocationID,
+			&mv.PatientID,
+			&mv.PrescriptionID)
+		if err != nil {
+			panic(err)
+		}
+		movements = append(movements, mv)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return movements
+}
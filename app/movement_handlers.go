@@ -0,0 +1,158 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (m *madminHandler) movementsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listMovementsHandler(w, r)
+	case "POST":
+		m.addMovementHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /stock/<id>/movements
+//
+// Lists the ledger entries of the stock item with <id>.
+func (m *madminHandler) listMovementsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	movements := m.warehouse.Movements(id)
+
+	resp := make([]*MovementDTO, 0, len(movements))
+	for i := range movements {
+		resp = append(resp, newMovementDTO(&movements[i]))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /stock/<id>/movements
+//
+// Receives, dispenses or adjusts the quantity of the stock item with <id>
+// and returns the recorded ledger entry.
+func (m *madminHandler) addMovementHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &NewMovementDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	mv, err := m.newMovement(r, id, dto)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	err = m.warehouse.RecordMovement(mv)
+	if err != nil {
+		log.Printf("Error in recording movement: %s", err)
+		if mv.Kind == DISPENSE && isInsufficientStock(err) {
+			respondJSON(w, http.StatusBadRequest, &validationErrorsResponseDTO{
+				Errors:      err.(ValidationErrors),
+				Substitutes: newSubstituteDTOs(inStockSubstitutes(m.warehouse.Substitutes(id, m.warehouse.AvailableQuantities()))),
+			})
+			return
+		}
+		respondBadRequest(w, err)
+		return
+	}
+
+	resp := newMovementDTO(mv)
+	if mv.Kind == DISPENSE {
+		// suggest substitutes once the item is insufficient
+		item, _ := m.warehouse.ReadStock(id)
+		if available := m.warehouse.AvailableQuantities(); available[id].LessThan(item.MinQuantity()) {
+			resp.Substitutes = newSubstituteDTOs(inStockSubstitutes(m.warehouse.Substitutes(id, available)))
+		}
+	}
+
+	respondJSON(w, http.StatusCreated, resp)
+}
+
+// newMovement creates a movement of the stock item with the given id from a request DTO.
+// The witness of the movement, if any, must confirm it with their password.
+func (m *madminHandler) newMovement(r *http.Request, stockID string, dto *NewMovementDTO) (*Movement, error) {
+	quantity, err := validQuantityFromString(dto.Quantity)
+	if err != nil {
+		return nil, ValidationErrors{{"quantity", err.Error()}}
+	}
+
+	mv := &Movement{
+		StockID:    stockID,
+		Kind:       dto.Kind,
+		Quantity:   quantity,
+		UserID:     requestUserID(r),
+		Reference:  dto.Reference,
+		PatientRef: dto.PatientRef,
+		Prescriber: dto.Prescriber,
+		LotID:      dto.LotID,
+		LocationID: dto.LocationID,
+		PatientID:  dto.PatientID,
+
+		PrescriptionID: dto.PrescriptionID,
+	}
+
+	if mv.PrescriptionID != "" {
+		prescription, ok := m.warehouse.ReadPrescription(mv.PrescriptionID)
+		if !ok {
+			return nil, ValidationErrors{{"prescriptionID", "no such prescription"}}
+		}
+		if mv.PatientID == "" {
+			mv.PatientID = prescription.PatientID
+		}
+		if mv.Prescriber == "" {
+			mv.Prescriber = prescription.Prescriber
+		}
+	}
+
+	if mv.PatientID != "" {
+		patient, ok := m.clients.ReadPatient(mv.PatientID)
+		if !ok {
+			return nil, ValidationErrors{{"patientID", "no such patient"}}
+		}
+		mv.PatientID = patient.ID
+		if mv.PatientRef == "" {
+			mv.PatientRef = patient.Name
+			if owner, ok := m.clients.ReadOwner(patient.OwnerID); ok {
+				mv.PatientRef = fmt.Sprintf("%s (%s)", patient.Name, owner.Name())
+			}
+		}
+	}
+
+	if dto.Lot != nil {
+		lot, err := dto.Lot.lot()
+		if err != nil {
+			return nil, err
+		}
+		mv.Lot = lot
+	}
+
+	if dto.Witness != "" {
+		if !m.userManager.ValidateUser(dto.Witness, dto.WitnessPassword) {
+			return nil, ValidationErrors{{"witness", "invalid witness name or password"}}
+		}
+		witness, _ := m.userManager.ReadUserByName(dto.Witness)
+		mv.WitnessID = witness.ID()
+	}
+
+	return mv, nil
+}
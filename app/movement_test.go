@@ -0,0 +1,332 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRecordMovement(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	wh := NewWarehouse(db)
+
+	t.Run("ReceiveAndDispense", func(t *testing.T) {
+		item, _ := defaultUnexpirableStockItem(ACCESSORY)
+		wh.CreateStock(item, nil)
+
+		receipt := &Movement{StockID: item.ID(), Kind: RECEIPT, Quantity: decimal.New(5, 0)}
+		if err := wh.RecordMovement(receipt); err != nil {
+			t.Fatalf(`RecordMovement returns an error for a valid receipt: %s`, err)
+		}
+		dispense := &Movement{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(4, 0)}
+		if err := wh.RecordMovement(dispense); err != nil {
+			t.Fatalf(`RecordMovement returns an error for a valid dispense: %s`, err)
+		}
+
+		read, _ := wh.ReadStock(item.ID())
+		if !read.Quantity().Equal(decimal.New(2, 0)) || !dispense.Balance.Equal(read.Quantity()) {
+			t.Fatalf(`Unexpected quantity after movements. Got %s, balance %s.`, read.Quantity(), dispense.Balance)
+		}
+
+		// the quantity the item was created with is its opening balance
+		movements := wh.Movements(item.ID())
+		if len(movements) != 3 || !movements[0].isOpeningBalance() || !movements[0].Balance.Equal(item.Quantity()) ||
+			movements[1].ID != receipt.ID || movements[2].ID != dispense.ID {
+			t.Fatalf(`Movements returns unexpected ledger entries: %+v`, movements)
+		}
+	})
+	t.Run("InvalidQuantities", func(t *testing.T) {
+		item, _ := defaultUnexpirableStockItem(ACCESSORY)
+		wh.CreateStock(item, nil)
+
+		tests := []*Movement{
+			{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(2, 0)},
+			{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(5, -1)},
+			{StockID: item.ID(), Kind: RECEIPT, Quantity: decimal.New(-1, 0)},
+			{StockID: item.ID(), Kind: "theft", Quantity: decimal.New(1, 0)},
+		}
+		for _, mv := range tests {
+			if err := wh.RecordMovement(mv); err == nil {
+				t.Fatalf(`RecordMovement does not return error for invalid movement %+v`, mv)
+			}
+		}
+
+		read, _ := wh.ReadStock(item.ID())
+		if !read.Quantity().Equal(item.Quantity()) || len(wh.Movements(item.ID())) != 1 {
+			t.Fatalf(`RecordMovement changes the warehouse for invalid movements`)
+		}
+	})
+	t.Run("ControlledSubstance", func(t *testing.T) {
+		item, _ := defaultExpirableStockItem(MEDICINE)
+		item.SetControlledSchedule("2")
+		item.SetQuantity(decimal.Zero)
+		wh.CreateStock(item, nil)
+
+		receipt := &Movement{StockID: item.ID(), Kind: RECEIPT, Quantity: decimal.New(10, 0), UserID: "vet"}
+		if err := wh.RecordMovement(receipt); err == nil {
+			t.Fatalf(`RecordMovement does not require a witness for controlled substances`)
+		}
+		receipt.WitnessID = "nurse"
+		if err := wh.RecordMovement(receipt); err != nil {
+			t.Fatalf(`RecordMovement returns an error for a valid receipt: %s`, err)
+		}
+
+		dispense := &Movement{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0), UserID: "vet", WitnessID: "nurse"}
+		err := wh.RecordMovement(dispense)
+		ves, ok := err.(ValidationErrors)
+		if !ok || len(ves) != 2 {
+			t.Fatalf(`RecordMovement does not require patient and prescriber for controlled substances: %v`, err)
+		}
+
+		dispense.PatientRef = "Rex, owner J. Doe"
+		dispense.Prescriber = "Dr. Ivanova"
+		if err := wh.RecordMovement(dispense); err != nil {
+			t.Fatalf(`RecordMovement returns an error for a valid dispense: %s`, err)
+		}
+
+		_, err = db.Exec("UPDATE stock_movements SET quantity = 0 WHERE id = ?", dispense.ID)
+		if err == nil {
+			t.Fatalf(`register entries can be changed`)
+		}
+		_, err = db.Exec("DELETE FROM stock_movements WHERE id = ?", dispense.ID)
+		if err == nil {
+			t.Fatalf(`register entries can be removed`)
+		}
+	})
+}
+
+func TestControlledRegister(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	m := NewMAdminHandler(db)
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	item.SetControlledSchedule("2")
+	item.SetQuantity(decimal.Zero)
+	m.warehouse.CreateStock(item, nil)
+
+	m.warehouse.RecordMovement(&Movement{StockID: item.ID(), Kind: RECEIPT, Quantity: decimal.New(10, 0), UserID: "a", WitnessID: "b", Reference: "INV-1"})
+	m.warehouse.RecordMovement(&Movement{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(3, 0), UserID: "a", WitnessID: "b", PatientRef: "Rex", Prescriber: "Dr. Ivanova"})
+
+	register := m.newControlledRegister(item)
+	if len(register.Entries) != 2 {
+		t.Fatalf(`Expected 2 register entries, got %d`, len(register.Entries))
+	}
+	if register.Entries[0].Received != "10" || register.Entries[1].Supplied != "3" || register.Entries[1].Balance != "7" {
+		t.Fatalf(`Unexpected register entries: %+v`, register.Entries)
+	}
+
+	var buf bytes.Buffer
+	if err := register.writeCSV(&buf); err != nil {
+		t.Fatalf(`writeCSV returns an error: %s`, err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "Date,Entry,") {
+		t.Fatalf(`Unexpected CSV register: %s`, buf.String())
+	}
+
+	buf.Reset()
+	if err := register.writePDF(&buf); err != nil {
+		t.Fatalf(`writePDF returns an error: %s`, err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF")) {
+		t.Fatalf(`writePDF does not write a PDF document`)
+	}
+}
+
+func TestMarkStockControlled(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	m := NewMAdminHandler(db)
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	put := func(item Stock) int {
+		dto := newStockDTO(item)
+		dto.ControlledSchedule = "2"
+		data, _ := json.Marshal(dto)
+		req, _ := http.NewRequest("PUT", buildURL(s.URL, fmt.Sprintf("/data/stock/%s", item.ID())), bytes.NewReader(data))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending PUT request: %s", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	m.warehouse.CreateStock(item, nil)
+	if status := put(item); status != http.StatusBadRequest {
+		t.Fatalf(`Expected status %d for an item in stock becoming controlled, got %d`, http.StatusBadRequest, status)
+	}
+	if read, _ := m.warehouse.ReadStock(item.ID()); read.IsControlled() {
+		t.Fatalf(`item in stock became controlled without an opening register entry`)
+	}
+
+	empty, _ := defaultExpirableStockItem(MEDICINE)
+	empty.SetQuantity(decimal.Zero)
+	m.warehouse.CreateStock(empty, nil)
+	if status := put(empty); status != http.StatusAccepted {
+		t.Fatalf(`Expected status %d for an empty item becoming controlled, got %d`, http.StatusAccepted, status)
+	}
+	if read, _ := m.warehouse.ReadStock(empty.ID()); !read.IsControlled() {
+		t.Fatalf(`empty item did not become controlled`)
+	}
+}
+
+func TestUpdateStockQuantity(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	m := NewMAdminHandler(db)
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	put := func(item Stock, quantity string) int {
+		dto := newStockDTO(item)
+		dto.Quantity = quantity
+		data, _ := json.Marshal(dto)
+		req, _ := http.NewRequest("PUT", buildURL(s.URL, fmt.Sprintf("/data/stock/%s", item.ID())), bytes.NewReader(data))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending PUT request: %s", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	updates := 0
+	m.warehouse.AddListener(func(e Event) {
+		if e.Type == STOCK_UPDATED {
+			updates++
+		}
+	})
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	item.SetQuantity(decimal.Zero)
+	m.warehouse.CreateStock(item, nil)
+
+	var (
+		soon     = time.Now().UTC().AddDate(0, 1, 0)
+		later    = time.Now().UTC().AddDate(1, 0, 0)
+		receipts = []*Movement{
+			{StockID: item.ID(), Kind: RECEIPT, Quantity: decimal.New(4, 0), Lot: &Lot{Number: "A", ExpirationDate: &soon}},
+			{StockID: item.ID(), Kind: RECEIPT, Quantity: decimal.New(6, 0), Lot: &Lot{Number: "B", ExpirationDate: &later}},
+		}
+	)
+	for _, mv := range receipts {
+		if err := m.warehouse.RecordMovement(mv); err != nil {
+			t.Fatalf(`RecordMovement returns an error for a receipt: %s`, err)
+		}
+	}
+	lotQuantities := func() []decimal.Decimal {
+		a, _ := m.warehouse.ReadLot(receipts[0].LotID)
+		b, _ := m.warehouse.ReadLot(receipts[1].LotID)
+		return []decimal.Decimal{a.Quantity, b.Quantity}
+	}
+
+	updates = 0
+	if status := put(item, "7"); status != http.StatusAccepted {
+		t.Fatalf(`Expected status %d for a new quantity, got %d`, http.StatusAccepted, status)
+	}
+	movements := m.warehouse.Movements(item.ID())
+	if len(movements) != 3 || movements[2].Kind != ADJUSTMENT || movements[2].LotID != receipts[0].LotID ||
+		!movements[2].Quantity.Equal(decimal.New(-3, 0)) || !movements[2].Balance.Equal(decimal.New(7, 0)) {
+		t.Fatalf(`Expected an adjustment of the lot that expires first, got %+v`, movements)
+	}
+	if read, _ := m.warehouse.ReadStock(item.ID()); !read.Quantity().Equal(decimal.New(7, 0)) {
+		t.Fatalf(`Expected quantity 7 after the update, got %s`, read.Quantity())
+	}
+	if lots := lotQuantities(); !lots[0].Equal(decimal.New(1, 0)) || !lots[1].Equal(decimal.New(6, 0)) {
+		t.Fatalf(`Unexpected lot quantities after the update %v`, lots)
+	}
+	if updates != 1 {
+		t.Fatalf(`Expected a single stock update event, got %d`, updates)
+	}
+
+	// stock found is added to the lot that expires last
+	if status := put(item, "12"); status != http.StatusAccepted {
+		t.Fatalf(`Expected status %d for a new quantity, got %d`, http.StatusAccepted, status)
+	}
+	if lots := lotQuantities(); !lots[0].Equal(decimal.New(1, 0)) || !lots[1].Equal(decimal.New(11, 0)) {
+		t.Fatalf(`Unexpected lot quantities after the update %v`, lots)
+	}
+
+	// saving the item without a new quantity does not adjust it
+	if status := put(item, "12"); status != http.StatusAccepted || len(m.warehouse.Movements(item.ID())) != 4 {
+		t.Fatalf(`Unexpected adjustment without a new quantity: %d`, status)
+	}
+
+	// only the items that did not move since their opening balance can be removed
+	remove := func(item Stock) int {
+		req, _ := http.NewRequest("DELETE", buildURL(s.URL, fmt.Sprintf("/data/stock/%s", item.ID())), nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending DELETE request: %s", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := remove(item); status != http.StatusConflict {
+		t.Fatalf(`Expected status %d for removing an adjusted item, got %d`, http.StatusConflict, status)
+	}
+	unused, _ := defaultUnexpirableStockItem(ACCESSORY)
+	m.warehouse.CreateStock(unused, nil)
+	if status := remove(unused); status != http.StatusNoContent {
+		t.Fatalf(`Expected status %d for removing an item with its opening balance, got %d`, http.StatusNoContent, status)
+	}
+}
+
+func TestConcurrentMovements(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	wh := NewWarehouse(db)
+
+	collar, _ := defaultUnexpirableStockItem(ACCESSORY)
+	collar.SetQuantity(decimal.New(100, 0))
+	wh.CreateStock(collar, nil)
+
+	const dispenses = 20
+	var wg sync.WaitGroup
+	for i := 0; i < dispenses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf(`RecordMovement panics for concurrent movements: %v`, r)
+				}
+			}()
+
+			if err := wh.RecordMovement(&Movement{StockID: collar.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0)}); err != nil {
+				t.Errorf(`RecordMovement returns an error for concurrent movements: %s`, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if movements := wh.Movements(collar.ID()); len(movements) != dispenses+1 {
+		t.Fatalf(`Expected %d movements after the opening balance, got %d`, dispenses, len(movements)-1)
+	}
+	if item, _ := wh.ReadStock(collar.ID()); !item.Quantity().Equal(decimal.New(100-dispenses, 0)) {
+		t.Fatalf(`Expected quantity %d after the concurrent dispenses, got %s`, 100-dispenses, item.Quantity())
+	}
+}
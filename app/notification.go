@@ -0,0 +1,461 @@
+package app
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"net/mail"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+type noticeKind string
+
+// EXPIRING notices are about stock items that expire soon,
+// INSUFFICIENT notices are about stock items below their minimum quantity
+const (
+	EXPIRING     noticeKind = "expiring"
+	INSUFFICIENT noticeKind = "insufficient"
+)
+
+// StockNotice is a line of a notification digest about a stock item
+type StockNotice struct {
+	StockID string
+	Name    string
+	Detail  string
+}
+
+// NotificationPreferences are the address and the kinds of notices a user receives
+type NotificationPreferences struct {
+	UserID string
+	Email  string
+
+	Expiring     bool
+	Insufficient bool
+}
+
+func (np *NotificationPreferences) validate() error {
+	if np.Email == "" {
+		if np.Expiring || np.Insufficient {
+			return ValidationErrors{{"email", "no email set for the notifications"}}
+		}
+		return nil
+	}
+	if _, err := mail.ParseAddress(np.Email); err != nil {
+		return ValidationErrors{{"email", "invalid email address"}}
+	}
+	return nil
+}
+
+func (np *NotificationPreferences) wants(kind noticeKind) bool {
+	switch kind {
+	case EXPIRING:
+		return np.Expiring
+	case INSUFFICIENT:
+		return np.Insufficient
+	}
+	return false
+}
+
+// Notifier emails users digests of the stock that needs their attention
+type Notifier interface {
+	// Preferences() returns the preferences of the user with the given id.
+	// Users without saved preferences receive no notifications.
+	Preferences(string) NotificationPreferences
+	// SetPreferences() records the change in the audit trail and rolls it back if it cannot be recorded
+	SetPreferences(NotificationPreferences, *auditTrail) error
+
+	// SendDigests() emails every user the notices they have not received yet.
+	// A stock item is reported again only after it drops out of the notices and comes back.
+	// It returns the number of sent digests.
+	SendDigests(notices map[noticeKind][]StockNotice) (int, error)
+}
+
+type defaultNotifier struct {
+	database    *sql.DB
+	userManager UserManager
+	mailer      Mailer
+}
+
+// NewNotifier creates a notifier that keeps the users' preferences and the sent notices
+// in sqlite3 tables inside the db that is passed as an argument.
+// The mailer can be nil if no SMTP server is configured.
+func NewNotifier(db *sql.DB, um UserManager, mailer Mailer) Notifier {
+	n := &defaultNotifier{database: db, userManager: um, mailer: mailer}
+
+	n.initNotificationsTables()
+
+	return n
+}
+
+func (n *defaultNotifier) initNotificationsTables() {
+	notificationsTables := `
+	CREATE TABLE IF NOT EXISTS
+		notification_preferences (
+			user_id TEXT NOT NULL PRIMARY KEY,
+			email TEXT NOT NULL,
+			expiring BOOLEAN NOT NULL,
+			insufficient BOOLEAN NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS
+		sent_notices (
+			user_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			stock_id TEXT NOT NULL,
+			sent DATETIME NOT NULL,
+			PRIMARY KEY (user_id, kind, stock_id)
+	);
+	`
+	_, err := n.database.Exec(notificationsTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (n *defaultNotifier) Preferences(userID string) NotificationPreferences {
+	return notificationPreferences(n.database, userID)
+}
+
+func notificationPreferences(q querier, userID string) NotificationPreferences {
+	np := NotificationPreferences{UserID: userID}
+
+	err := q.QueryRow(`
+		SELECT
+			email,
+			expiring,
+			insufficient
+		FROM
+			notification_preferences
+		WHERE
+			user_id = ?
+	`, userID).Scan(&np.Email, &np.Expiring, &np.Insufficient)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+
+	return np
+}
+
+func (n *defaultNotifier) SetPreferences(np NotificationPreferences, trail *auditTrail) error {
+	if err := np.validate(); err != nil {
+		return err
+	}
+
+	tx, err := n.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	before := notificationPreferences(tx, np.UserID)
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO
+			notification_preferences (
+				user_id,
+				email,
+				expiring,
+				insufficient)
+		VALUES(?, ?, ?, ?)
+	`, np.UserID, np.Email, np.Expiring, np.Insufficient)
+	if err != nil {
+		panic(err)
+	}
+
+	after := notificationPreferences(tx, np.UserID)
+	if err := trail.recordTx(tx, AUDIT_NOTIFICATION_PREFERENCES, np.UserID, newNotificationPreferencesDTO(&before), newNotificationPreferencesDTO(&after)); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+func (n *defaultNotifier) subscribers() []NotificationPreferences {
+	rows, err := n.database.Query(`
+		SELECT
+			user_id,
+			email,
+			expiring,
+			insufficient
+		FROM
+			notification_preferences
+		WHERE
+			email != '' AND (expiring OR insufficient)
+	`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	subscribers := make([]NotificationPreferences, 0)
+	for rows.Next() {
+		np := NotificationPreferences{}
+		if err = rows.Scan(&np.UserID, &np.Email, &np.Expiring, &np.Insufficient); err != nil {
+			panic(err)
+		}
+		subscribers = append(subscribers, np)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return subscribers
+}
+
+// newNotices forgets the sent notices that are no longer current
+// and returns the current notices that were not sent to the user yet
+func (n *defaultNotifier) newNotices(userID string, kind noticeKind, current []StockNotice) []StockNotice {
+	sent := make(map[string]bool)
+
+	rows, err := n.database.Query(`SELECT stock_id FROM sent_notices WHERE user_id = ? AND kind = ?`, userID, kind)
+	if err != nil {
+		panic(err)
+	}
+	for rows.Next() {
+		var stockID string
+		if err = rows.Scan(&stockID); err != nil {
+			panic(err)
+		}
+		sent[stockID] = false
+	}
+	if err = rows.Err(); err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	notices := make([]StockNotice, 0)
+	for _, notice := range current {
+		if _, ok := sent[notice.StockID]; ok {
+			sent[notice.StockID] = true
+			continue
+		}
+		notices = append(notices, notice)
+	}
+
+	for stockID, isCurrent := range sent {
+		if isCurrent {
+			continue
+		}
+		_, err = n.database.Exec(`DELETE FROM sent_notices WHERE user_id = ? AND kind = ? AND stock_id = ?`, userID, kind, stockID)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return notices
+}
+
+func (n *defaultNotifier) markSent(userID string, kind noticeKind, notices []StockNotice, now time.Time) {
+	for _, notice := range notices {
+		_, err := n.database.Exec(`
+			INSERT OR REPLACE INTO
+				sent_notices (
+					user_id,
+					kind,
+					stock_id,
+					sent)
+			VALUES(?, ?, ?, ?)
+		`, userID, kind, notice.StockID, now.UTC())
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// digest is the data of the digest templates
+type digest struct {
+	Name         string
+	Expiring     []StockNotice
+	Insufficient []StockNotice
+}
+
+func (n *defaultNotifier) SendDigests(notices map[noticeKind][]StockNotice) (int, error) {
+	if n.mailer == nil {
+		return 0, errors.New("no SMTP server configured")
+	}
+
+	var (
+		sent   = 0
+		now    = time.Now()
+		failed = make([]string, 0)
+	)
+
+	for _, np := range n.subscribers() {
+		var (
+			d     = digest{Name: np.UserID}
+			fresh = make(map[noticeKind][]StockNotice)
+		)
+		if u, ok := n.userManager.ReadUserById(np.UserID); ok {
+			d.Name = u.Name()
+		} else {
+			// preferences of removed users are kept, but they get no emails
+			continue
+		}
+
+		for _, kind := range []noticeKind{EXPIRING, INSUFFICIENT} {
+			if np.wants(kind) {
+				fresh[kind] = n.newNotices(np.UserID, kind, notices[kind])
+			}
+		}
+		d.Expiring = fresh[EXPIRING]
+		d.Insufficient = fresh[INSUFFICIENT]
+		if len(d.Expiring) == 0 && len(d.Insufficient) == 0 {
+			continue
+		}
+
+		text, html, err := renderDigest(&d)
+		if err == nil {
+			err = n.mailer.Send(np.Email, digestSubject(&d), text, html)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", np.Email, err))
+			continue
+		}
+
+		for kind, kindNotices := range fresh {
+			n.markSent(np.UserID, kind, kindNotices, now)
+		}
+		sent++
+	}
+
+	if len(failed) > 0 {
+		return sent, fmt.Errorf("sending digests failed for %s", strings.Join(failed, "; "))
+	}
+	return sent, nil
+}
+
+func digestSubject(d *digest) string {
+	parts := make([]string, 0, 2)
+	if len(d.Expiring) > 0 {
+		parts = append(parts, fmt.Sprintf("%d expiring", len(d.Expiring)))
+	}
+	if len(d.Insufficient) > 0 {
+		parts = append(parts, fmt.Sprintf("%d below minimum", len(d.Insufficient)))
+	}
+	return fmt.Sprintf("madmin stock digest: %s", strings.Join(parts, ", "))
+}
+
+const digestText = `Hello {{.Name}},
+{{if .Expiring}}
+Stock items that expire soon:
+{{range .Expiring}}  - {{.Name}}: {{.Detail}}
+{{end}}{{end}}{{if .Insufficient}}
+Stock items below their minimum quantity:
+{{range .Insufficient}}  - {{.Name}}: {{.Detail}}
+{{end}}{{end}}
+You can change your notification preferences in madmin.
+`
+
+const digestHTML = `<!DOCTYPE html>
+<html>
+<body>
+<p>Hello {{.Name}},</p>
+{{if .Expiring}}<h3>Stock items that expire soon</h3>
+<ul>
+{{range .Expiring}}<li><strong>{{.Name}}</strong>: {{.Detail}}</li>
+{{end}}</ul>
+{{end}}{{if .Insufficient}}<h3>Stock items below their minimum quantity</h3>
+<ul>
+{{range .Insufficient}}<li><strong>{{.Name}}</strong>: {{.Detail}}</li>
+{{end}}</ul>
+{{end}}<p>You can change your notification preferences in madmin.</p>
+</body>
+</html>
+`
+
+var (
+	digestTextTemplate = texttemplate.Must(texttemplate.New("digest.txt").Parse(digestText))
+	digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest.html").Parse(digestHTML))
+)
+
+func renderDigest(d *digest) (text, html string, err error) {
+	var textBuf, htmlBuf bytes.Buffer
+
+	if err = digestTextTemplate.Execute(&textBuf, d); err != nil {
+		return "", "", err
+	}
+	if err = digestHTMLTemplate.Execute(&htmlBuf, d); err != nil {
+		return "", "", err
+	}
+	return textBuf.String(), htmlBuf.String(), nil
+}
+
+// stockNotices builds the notices about the expiring and the insufficient stock
+func stockNotices(wh Warehouse, now time.Time) map[noticeKind][]StockNotice {
+	var (
+		notices   = make(map[noticeKind][]StockNotice)
+		available = wh.AvailableQuantities()
+	)
+
+	for _, item := range expiringStock(wh, now.AddDate(0, 0, expiryWarningDays)) {
+		notices[EXPIRING] = append(notices[EXPIRING], StockNotice{
+			StockID: item.ID(),
+			Name:    item.Name(),
+			Detail:  fmt.Sprintf("expires on %s", item.ExpirationDate().Format("2006-01-02")),
+		})
+	}
+	for _, item := range insufficientStock(wh) {
+		notices[INSUFFICIENT] = append(notices[INSUFFICIENT], StockNotice{
+			StockID: item.ID(),
+			Name:    item.Name(),
+			Detail:  fmt.Sprintf("%s available, minimum is %s", available[item.ID()], item.MinQuantity()),
+		})
+	}
+
+	return notices
+}
+
+// stockDigestJob emails the users digests of the expiring and the insufficient stock
+func (m *madminHandler) stockDigestJob(now time.Time) (string, error) {
+	sent, err := m.notifier.SendDigests(stockNotices(m.warehouse, now))
+	return fmt.Sprintf("%d digests sent", sent), err
+}
+
+func (m *madminHandler) notificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getNotificationPreferencesHandler(w, r)
+	case "PUT":
+		m.updateNotificationPreferencesHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /notifications/preferences
+//
+// Returns the notification preferences of the current user.
+func (m *madminHandler) getNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	np := m.notifier.Preferences(requestUserID(r))
+	respondJSON(w, http.StatusOK, newNotificationPreferencesDTO(&np))
+}
+
+// Handler for PUT /notifications/preferences
+//
+// Sets the email address and the kinds of notices the current user receives.
+func (m *madminHandler) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NotificationPreferencesDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	np := dto.preferences()
+	np.UserID = requestUserID(r)
+
+	err := m.notifier.SetPreferences(np, m.auditTrail(r))
+	if err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
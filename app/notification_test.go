@@ -0,0 +1,147 @@
+package app
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// fakeSMTPServer is a minimal SMTP server that keeps the received messages
+type fakeSMTPServer struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	messages []string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake SMTP server: %s", err)
+	}
+
+	s := &fakeSMTPServer{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		r     = bufio.NewReader(conn)
+		reply = func(line string) { conn.Write([]byte(line + "\r\n")) }
+	)
+	reply("220 localhost fake SMTP")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+		case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			msg := &strings.Builder{}
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+			s.mutex.Lock()
+			s.messages = append(s.messages, msg.String())
+			s.mutex.Unlock()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) received() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.messages...)
+}
+
+func TestStockDigest(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	smtpServer := newFakeSMTPServer(t)
+	defer smtpServer.listener.Close()
+
+	var (
+		um       = NewUserManager(db)
+		wh       = NewWarehouse(db)
+		notifier = NewNotifier(db, um, NewSMTPMailer(SMTPConfig{Addr: smtpServer.listener.Addr().String(), From: "madmin@example.com"}))
+		now      = time.Now()
+	)
+
+	user, _ := NewUser("pharmacist", "secret")
+	um.CreateUser(user, nil)
+
+	if err := notifier.SetPreferences(NotificationPreferences{UserID: user.ID(), Email: "not an address", Expiring: true}, nil); err == nil {
+		t.Fatalf(`SetPreferences accepts an invalid email`)
+	}
+	if err := notifier.SetPreferences(NotificationPreferences{UserID: user.ID(), Email: "pharmacist@example.com", Insufficient: true}, nil); err != nil {
+		t.Fatalf(`SetPreferences returns an error for valid preferences: %s`, err)
+	}
+
+	item, _ := defaultUnexpirableStockItem(ACCESSORY)
+	item.SetName("Collar <large>")
+	item.SetMinQuantity(decimal.New(5, 0))
+	wh.CreateStock(item, nil)
+
+	if sent, err := notifier.SendDigests(stockNotices(wh, now)); sent != 1 || err != nil {
+		t.Fatalf(`Expected 1 digest, got %d: %v`, sent, err)
+	}
+	messages := smtpServer.received()
+	if len(messages) != 1 {
+		t.Fatalf(`Expected 1 received message, got %d`, len(messages))
+	}
+	for _, part := range []string{"To: pharmacist@example.com", "text/plain", "text/html", "Collar <large>", "Collar &lt;large&gt;", "1 available, minimum is 5"} {
+		if !strings.Contains(messages[0], part) {
+			t.Errorf(`Digest does not contain %q`, part)
+		}
+	}
+
+	if sent, err := notifier.SendDigests(stockNotices(wh, now)); sent != 0 || err != nil {
+		t.Fatalf(`The same item is reported again: %d, %v`, sent, err)
+	}
+
+	// an item is reported again after it is restocked and drops below the minimum again
+	item.SetQuantity(decimal.New(10, 0))
+	wh.UpdateStock(item, "", nil)
+	notifier.SendDigests(stockNotices(wh, now))
+	item.SetQuantity(decimal.New(2, 0))
+	wh.UpdateStock(item, "", nil)
+	if sent, err := notifier.SendDigests(stockNotices(wh, now)); sent != 1 || err != nil {
+		t.Fatalf(`Item is not reported after it drops below the minimum again: %d, %v`, sent, err)
+	}
+}
@@ -0,0 +1,273 @@
+package app
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Prescription is a vet's authorisation to dispense a stock item to a patient.
+// Stock items that require a prescription can only be dispensed against one.
+type Prescription struct {
+	ID        string
+	StockID   string
+	PatientID string
+	// Prescriber is the name of the prescribing vet
+	Prescriber string
+
+	// Dose is the dosage instructions for the owner
+	Dose string
+	// Quantity is the most that can be dispensed against the prescription at a time
+	Quantity decimal.Decimal
+	// Repeats is the number of times the prescription can be dispensed.
+	// Every dispense takes one of the remaining repeats.
+	Repeats   int
+	Remaining int
+
+	ValidFrom  time.Time
+	ValidUntil time.Time
+
+	Created time.Time
+	UserID  string
+}
+
+// isValid reports if stock can be dispensed against the prescription at the given time
+func (p *Prescription) isValid(now time.Time) bool {
+	return p.Remaining > 0 && !now.Before(p.ValidFrom) && !now.After(p.ValidUntil)
+}
+
+func (p *Prescription) validate(item Stock) error {
+	errs := ValidationErrors{}
+
+	if p.PatientID == "" {
+		errs = append(errs, ValidationError{"patientID", "no patient set for the prescription"})
+	}
+	if strings.TrimSpace(p.Prescriber) == "" {
+		errs = append(errs, ValidationError{"prescriber", "no prescribing vet set for the prescription"})
+	}
+	if strings.TrimSpace(p.Dose) == "" {
+		errs = append(errs, ValidationError{"dose", "no dose set for the prescription"})
+	}
+	if p.Quantity.Sign() <= 0 {
+		errs = append(errs, ValidationError{"quantity", "quantity must be positive"})
+	} else if err := item.QuantityRule().Validate("quantity", p.Quantity); err != nil {
+		errs = append(errs, err.(ValidationErrors)...)
+	}
+	if p.Repeats < 1 {
+		errs = append(errs, ValidationError{"repeats", "the prescription must allow at least one dispense"})
+	}
+	if !p.ValidUntil.After(p.ValidFrom) {
+		errs = append(errs, ValidationError{"validUntil", "the prescription must be valid until after its start"})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (wh *dafaultWarehouse) initPrescriptionsTable() {
+	prescriptionsTable := `
+	CREATE TABLE IF NOT EXISTS
+		prescriptions (
+			id TEXT NOT NULL PRIMARY KEY,
+			stock_id TEXT NOT NULL,
+			patient_id TEXT NOT NULL,
+			prescriber TEXT NOT NULL,
+			dose TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			repeats INTEGER NOT NULL,
+			remaining INTEGER NOT NULL,
+			valid_from DATETIME NOT NULL,
+			valid_until DATETIME NOT NULL,
+			created DATETIME NOT NULL,
+			user_id TEXT NOT NULL,
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id)
+	);
+	CREATE INDEX IF NOT EXISTS
+		prescriptions_patient_id ON prescriptions (patient_id);
+	`
+	_, err := wh.database.Exec(prescriptionsTable)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// CreatePrescription saves a new prescription with all its repeats remaining.
+// The prescription is valid from its creation if ValidFrom is not set.
+func (wh *dafaultWarehouse) CreatePrescription(p *Prescription) error {
+	item, ok := wh.ReadStock(p.StockID)
+	if !ok {
+		return ValidationErrors{{"stockID", "no such stock item"}}
+	}
+
+	now := time.Now().UTC()
+	if p.ValidFrom.IsZero() {
+		p.ValidFrom = now
+	}
+	if err := p.validate(item); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	p.ID = id
+	p.Remaining = p.Repeats
+	p.Created = now
+
+	_, err = wh.database.Exec(`
+		INSERT INTO
+			prescriptions (`+prescriptionColumns+`)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		p.ID,
+		p.StockID,
+		p.PatientID,
+		p.Prescriber,
+		p.Dose,
+		p.Quantity.String(),
+		p.Repeats,
+		p.Remaining,
+		p.ValidFrom,
+		p.ValidUntil,
+		p.Created,
+		p.UserID)
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// prescriptionColumns are the columns of the prescriptions table, in the order expected by scanPrescription
+const prescriptionColumns = `
+	id,
+	stock_id,
+	patient_id,
+	prescriber,
+	dose,
+	quantity,
+	repeats,
+	remaining,
+	valid_from,
+	valid_until,
+	created,
+	user_id
+`
+
+func scanPrescription(row rowScanner) (*Prescription, error) {
+	p := &Prescription{}
+	err := row.Scan(
+		&p.ID,
+		&p.StockID,
+		&p.PatientID,
+		&p.Prescriber,
+		&p.Dose,
+		&p.Quantity,
+		&p.Repeats,
+		&p.Remaining,
+		&p.ValidFrom,
+		&p.ValidUntil,
+		&p.Created,
+		&p.UserID)
+	return p, err
+}
+
+func (wh *dafaultWarehouse) ReadPrescription(id string) (*Prescription, bool) {
+	p, err := scanPrescription(wh.database.QueryRow(`SELECT `+prescriptionColumns+` FROM prescriptions WHERE id = ?`, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+	return p, true
+}
+
+// Prescriptions returns the prescriptions of the patient, the latest are first.
+// If stockID is set, only the prescriptions of the stock item are returned.
+func (wh *dafaultWarehouse) Prescriptions(patientID, stockID string) []*Prescription {
+	rows, err := wh.database.Query(`
+		SELECT `+prescriptionColumns+`
+		FROM
+			prescriptions
+		WHERE
+			patient_id = ? AND (? = '' OR stock_id = ?)
+		ORDER BY
+			created DESC, rowid DESC
+	`, patientID, stockID, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	prescriptions := make([]*Prescription, 0)
+	for rows.Next() {
+		p, err := scanPrescription(rows)
+		if err != nil {
+			panic(err)
+		}
+		prescriptions = append(prescriptions, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return prescriptions
+}
+
+// usePrescriptionTx checks that the dispense is allowed by its prescription
+// and takes one of the prescription's remaining repeats.
+// The movement's patient is set from the prescription if it is missing.
+func usePrescriptionTx(tx *sql.Tx, mv *Movement, now time.Time) error {
+	p, err := scanPrescription(tx.QueryRow(`SELECT `+prescriptionColumns+` FROM prescriptions WHERE id = ?`, mv.PrescriptionID))
+	switch {
+	case err == sql.ErrNoRows:
+		return ValidationErrors{{"prescriptionID", "no such prescription"}}
+	case err != nil:
+		panic(err)
+	}
+
+	errs := ValidationErrors{}
+	if p.StockID != mv.StockID {
+		errs = append(errs, ValidationError{"prescriptionID", "the prescription is for another stock item"})
+	}
+	if mv.PatientID == "" {
+		mv.PatientID = p.PatientID
+	} else if mv.PatientID != p.PatientID {
+		errs = append(errs, ValidationError{"patientID", "the prescription is for another patient"})
+	}
+	if !p.isValid(now) {
+		errs = append(errs, ValidationError{"prescriptionID", "the prescription is expired or has no repeats remaining"})
+	}
+	if mv.Quantity.GreaterThan(p.Quantity) {
+		errs = append(errs, ValidationError{"quantity", "the quantity exceeds the prescribed quantity"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	res, err := tx.Exec(`
+		UPDATE
+			prescriptions
+		SET
+			remaining = remaining - 1
+		WHERE
+			id = ? AND remaining > 0
+	`, p.ID)
+	if err != nil {
+		panic(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		panic(err)
+	} else if n == 0 {
+		return ValidationErrors{{"prescriptionID", "the prescription has no repeats remaining"}}
+	}
+
+	return nil
+}
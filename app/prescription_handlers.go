@@ -0,0 +1,181 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Handler for POST /prescriptions/
+//
+// Adds a prescription and returns it.
+func (m *madminHandler) addPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewPrescriptionDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	p, err := dto.prescription()
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+	if _, ok := m.clients.ReadPatient(p.PatientID); !ok {
+		respondBadRequest(w, ValidationErrors{{"patientID", "no such patient"}})
+		return
+	}
+	p.UserID = requestUserID(r)
+	if u, ok := requestUser(r); ok && p.Prescriber == "" {
+		p.Prescriber = u.Name()
+	}
+
+	if err := m.warehouse.CreatePrescription(p); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, newPrescriptionDTO(p))
+}
+
+// Handler for GET /prescriptions/<id>
+//
+// Returns JSON with data for the prescription with the given id.
+func (m *madminHandler) getPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.warehouse.ReadPrescription(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newPrescriptionDTO(p))
+}
+
+// Handler for GET /patients/<id>/prescriptions
+//
+// Lists the prescriptions of the patient with <id>, the latest are first.
+// The parameter stockID restricts the list to the prescriptions of a stock item.
+func (m *madminHandler) patientPrescriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.clients.ReadPatient(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	prescriptions := m.warehouse.Prescriptions(id, r.URL.Query().Get("stockID"))
+
+	resp := make([]*PrescriptionDTO, 0, len(prescriptions))
+	for _, p := range prescriptions {
+		resp = append(resp, newPrescriptionDTO(p))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for GET /prescriptions/<id>/document
+//
+// Returns the prescription with <id> as a printable A5 PDF document.
+func (m *madminHandler) prescriptionDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.warehouse.ReadPrescription(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	doc := &prescriptionDocument{Prescription: p}
+	if item, ok := m.warehouse.ReadStock(p.StockID); ok {
+		doc.Item = item.Name()
+	}
+	if patient, ok := m.clients.ReadPatient(p.PatientID); ok {
+		doc.Patient = patient
+		doc.Owner, _ = m.clients.ReadOwner(patient.OwnerID)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := doc.writePDF(buf); err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("Error while writing response: %s", err)
+	}
+}
+
+// prescriptionDocument is a prescription with the records it refers to
+type prescriptionDocument struct {
+	Prescription *Prescription
+	Item         string
+	Patient      *Patient
+	Owner        *Owner
+}
+
+// fields returns the labelled fields of the document in the order they are printed
+func (d *prescriptionDocument) fields() [][2]string {
+	const day = "2006-01-02"
+	p := d.Prescription
+
+	fields := [][2]string{
+		{"Prescription no.", p.ID},
+		{"Issued", p.Created.UTC().Format(day)},
+		{"Prescriber", p.Prescriber},
+	}
+	if d.Owner != nil {
+		fields = append(fields,
+			[2]string{"Owner", d.Owner.Name()},
+			[2]string{"Address", d.Owner.Address},
+			[2]string{"Phone", d.Owner.Phone})
+	}
+	if d.Patient != nil {
+		animal := d.Patient.Species
+		if d.Patient.Breed != "" {
+			animal += ", " + d.Patient.Breed
+		}
+		fields = append(fields,
+			[2]string{"Patient", d.Patient.Name},
+			[2]string{"Animal", animal},
+			[2]string{"Microchip", d.Patient.MicrochipNumber})
+	}
+	fields = append(fields,
+		[2]string{"Medicine", d.Item},
+		[2]string{"Quantity", p.Quantity.String()},
+		[2]string{"Dose", p.Dose},
+		[2]string{"Repeats", fmt.Sprintf("%d (%d remaining)", p.Repeats, p.Remaining)},
+		[2]string{"Valid", fmt.Sprintf("%s to %s", p.ValidFrom.UTC().Format(day), p.ValidUntil.UTC().Format(day))})
+
+	return fields
+}
+
+// writePDF writes the prescription as an A5 page with a line for the prescriber's signature
+func (d *prescriptionDocument) writePDF(w io.Writer) error {
+	var (
+		pdf = gofpdf.New("P", "mm", "A5", "")
+		tr  = pdf.UnicodeTranslatorFromDescriptor("")
+	)
+	pdf.SetMargins(15, 15, 15)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, tr("Veterinary prescription"), "B", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	for _, field := range d.fields() {
+		if field[1] == "" {
+			continue
+		}
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(35, 6, tr(field[0]), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.MultiCell(0, 6, tr(field[1]), "", "L", false)
+	}
+
+	pdf.Ln(15)
+	pdf.CellFormat(70, 6, tr(d.Prescription.Prescriber), "T", 1, "L", false, 0, "")
+
+	return pdf.Output(w)
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestPrescriptionDispense(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+		wh            = madminHandler.warehouse
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	item.SetQuantity(decimal.New(20, 0))
+	item.SetPrescriptionRequired(true)
+	wh.CreateStock(item, nil)
+	if stored, _ := wh.ReadStock(item.ID()); !stored.PrescriptionRequired() {
+		t.Fatalf(`The prescription-required flag of the stock item is not saved`)
+	}
+
+	owner := &Owner{FirstName: "Jane", LastName: "Doe"}
+	madminHandler.clients.CreateOwner(owner)
+	rex := &Patient{OwnerID: owner.ID, Name: "Rex", Species: "dog"}
+	madminHandler.clients.CreatePatient(rex)
+	tom := &Patient{OwnerID: owner.ID, Name: "Tom", Species: "cat"}
+	madminHandler.clients.CreatePatient(tom)
+
+	if err := wh.CreatePrescription(&Prescription{StockID: item.ID(), PatientID: rex.ID, Prescriber: "Dr. Who", Quantity: decimal.New(5, 0), Repeats: 0, ValidUntil: time.Now().Add(time.Hour)}); err == nil {
+		t.Fatalf(`CreatePrescription accepts a prescription without dose and repeats`)
+	}
+
+	body, _ := json.Marshal(&NewPrescriptionDTO{
+		StockID:    item.ID(),
+		PatientID:  rex.ID,
+		Prescriber: "Dr. Who",
+		Dose:       "1 tablet twice daily",
+		Quantity:   "5",
+		Repeats:    2,
+		ValidUntil: time.Now().AddDate(0, 1, 0).UTC().Format(dateLayout),
+	})
+	resp, err := http.Post(buildURL(s.URL, "/data/prescriptions/"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error sending POST request: %s", err)
+	}
+	prescription := &PrescriptionDTO{}
+	json.NewDecoder(resp.Body).Decode(prescription)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || prescription.Remaining != 2 || !prescription.Valid {
+		t.Fatalf("Unexpected response for a new prescription: %d %+v", resp.StatusCode, prescription)
+	}
+
+	movementsURL := buildURL(s.URL, fmt.Sprintf("/data/stock/%s/movements", item.ID()))
+	requests := []struct {
+		dto  NewMovementDTO
+		want int
+	}{
+		{NewMovementDTO{Kind: DISPENSE, Quantity: "1"}, http.StatusBadRequest},
+		{NewMovementDTO{Kind: DISPENSE, Quantity: "6", PrescriptionID: prescription.ID}, http.StatusBadRequest},
+		{NewMovementDTO{Kind: DISPENSE, Quantity: "5", PrescriptionID: prescription.ID, PatientID: tom.ID}, http.StatusBadRequest},
+		{NewMovementDTO{Kind: DISPENSE, Quantity: "5", PrescriptionID: prescription.ID}, http.StatusCreated},
+		{NewMovementDTO{Kind: DISPENSE, Quantity: "3", PrescriptionID: prescription.ID, PatientID: rex.ID}, http.StatusCreated},
+		{NewMovementDTO{Kind: DISPENSE, Quantity: "1", PrescriptionID: prescription.ID}, http.StatusBadRequest},
+		{NewMovementDTO{Kind: RECEIPT, Quantity: "1"}, http.StatusCreated},
+	}
+	for i, req := range requests {
+		body, _ := json.Marshal(&req.dto)
+		resp, err := http.Post(movementsURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Error sending POST request: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != req.want {
+			t.Errorf("Expected %d but got %d for request %d", req.want, resp.StatusCode, i)
+		}
+	}
+
+	p, _ := wh.ReadPrescription(prescription.ID)
+	if p.Remaining != 0 || p.isValid(time.Now()) {
+		t.Fatalf(`Expected the prescription to be used up, got %+v`, p)
+	}
+	dispenses := wh.PatientDispenses(rex.ID)
+	if len(dispenses) != 2 || dispenses[0].PrescriptionID != p.ID || dispenses[0].Prescriber != "Dr. Who" || dispenses[0].PatientRef != "Rex (Jane Doe)" {
+		t.Fatalf(`Unexpected dispenses against the prescription %+v`, dispenses)
+	}
+	if item, _ = wh.ReadStock(item.ID()); !item.Quantity().Equal(decimal.New(13, 0)) {
+		t.Fatalf(`Expected quantity 13 after the dispenses, got %s`, item.Quantity())
+	}
+
+	resp, err = http.Get(buildURL(s.URL, fmt.Sprintf("/data/prescriptions/%s/document", p.ID)))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	doc := &bytes.Buffer{}
+	doc.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.HasPrefix(doc.Bytes(), []byte("%PDF")) {
+		t.Fatalf("Expected a PDF document, got %d %q", resp.StatusCode, doc.Bytes())
+	}
+}
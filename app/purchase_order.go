@@ -0,0 +1,261 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PurchaseOrder is an order of stock from a distributor.
+// The deliveries of an order are received as lots and billed by the distributor's invoices.
+type PurchaseOrder struct {
+	ID            string
+	DistributorID string
+	// Reference is the order number that is sent to the distributor
+	Reference string
+
+	Lines []*PurchaseOrderLine
+
+	Created time.Time
+	UserID  string
+}
+
+// PurchaseOrderLine is an ordered quantity of a stock item at an agreed unit price
+type PurchaseOrderLine struct {
+	ID        string
+	StockID   string
+	Quantity  decimal.Decimal
+	UnitPrice decimal.Decimal
+}
+
+func (wh *dafaultWarehouse) initPurchaseOrdersTables() {
+	purchaseOrdersTables := `
+	CREATE TABLE IF NOT EXISTS
+		purchase_orders (
+			id TEXT NOT NULL PRIMARY KEY,
+			distributor_id TEXT NOT NULL,
+			reference TEXT NOT NULL,
+			created DATETIME NOT NULL,
+			user_id TEXT NOT NULL,
+			FOREIGN KEY (distributor_id) REFERENCES distributors (id)
+	);
+	CREATE TABLE IF NOT EXISTS
+		purchase_order_lines (
+			id TEXT NOT NULL PRIMARY KEY,
+			order_id TEXT NOT NULL,
+			stock_id TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			unit_price NUMERIC NOT NULL,
+			FOREIGN KEY (order_id) REFERENCES purchase_orders (id),
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id)
+	);
+	`
+	_, err := wh.database.Exec(purchaseOrdersTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (po *PurchaseOrder) validate(wh *dafaultWarehouse) error {
+	errs := ValidationErrors{}
+
+	if _, ok := wh.ReadDistributor(po.DistributorID); !ok {
+		errs = append(errs, ValidationError{"distributorID", "no such distributor"})
+	}
+	if len(po.Lines) == 0 {
+		errs = append(errs, ValidationError{"lines", "no stock ordered"})
+	}
+
+	for i, line := range po.Lines {
+		item, ok := wh.ReadStock(line.StockID)
+		if !ok {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].stockID", i), "no such stock item"})
+			continue
+		}
+		if line.Quantity.Sign() <= 0 {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].quantity", i), "quantity must be positive"})
+		} else if err := item.QuantityRule().Validate(fmt.Sprintf("lines[%d].quantity", i), line.Quantity); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+		if line.UnitPrice.Sign() < 0 {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].unitPrice", i), "price must not be negative"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CreatePurchaseOrder saves an order of stock from a distributor
+func (wh *dafaultWarehouse) CreatePurchaseOrder(po *PurchaseOrder) error {
+	if err := po.validate(wh); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	po.ID = id
+	po.Created = time.Now().UTC()
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			purchase_orders (
+				id,
+				distributor_id,
+				reference,
+				created,
+				user_id)
+		VALUES(?, ?, ?, ?, ?)
+	`, po.ID, po.DistributorID, po.Reference, po.Created, po.UserID)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, line := range po.Lines {
+		if line.ID, err = newUUID(); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO
+				purchase_order_lines (
+					id,
+					order_id,
+					stock_id,
+					quantity,
+					unit_price)
+			VALUES(?, ?, ?, ?, ?)
+		`, line.ID, po.ID, line.StockID, line.Quantity.String(), line.UnitPrice.String())
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// purchaseOrderColumns are the columns of the purchase_orders table, in the order expected by scanPurchaseOrder
+const purchaseOrderColumns = `
+	id,
+	distributor_id,
+	reference,
+	created,
+	user_id
+`
+
+func scanPurchaseOrder(row rowScanner) (*PurchaseOrder, error) {
+	po := &PurchaseOrder{}
+	err := row.Scan(&po.ID, &po.DistributorID, &po.Reference, &po.Created, &po.UserID)
+	return po, err
+}
+
+func (wh *dafaultWarehouse) ReadPurchaseOrder(id string) (*PurchaseOrder, bool) {
+	po, err := scanPurchaseOrder(wh.database.QueryRow(`SELECT `+purchaseOrderColumns+` FROM purchase_orders WHERE id = ?`, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	po.Lines = wh.purchaseOrderLines(`WHERE order_id = ? ORDER BY rowid`, po.ID)
+	return po, true
+}
+
+// readPurchaseOrderLine returns the line of a purchase order with its order
+func (wh *dafaultWarehouse) readPurchaseOrderLine(id string) (*PurchaseOrderLine, string, bool) {
+	var orderID string
+	err := wh.database.QueryRow(`SELECT order_id FROM purchase_order_lines WHERE id = ?`, id).Scan(&orderID)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, "", false
+	case err != nil:
+		panic(err)
+	}
+
+	lines := wh.purchaseOrderLines(`WHERE id = ?`, id)
+	return lines[0], orderID, true
+}
+
+func (wh *dafaultWarehouse) purchaseOrderLines(where string, args ...interface{}) []*PurchaseOrderLine {
+	rows, err := wh.database.Query(`
+		SELECT
+			id,
+			stock_id,
+			quantity,
+			unit_price
+		FROM
+			purchase_order_lines
+		`+where, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	lines := make([]*PurchaseOrderLine, 0)
+	for rows.Next() {
+		line := &PurchaseOrderLine{}
+		if err = rows.Scan(&line.ID, &line.StockID, &line.Quantity, &line.UnitPrice); err != nil {
+			panic(err)
+		}
+		lines = append(lines, line)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return lines
+}
+
+// PurchaseOrders returns the orders from the distributor, or from all distributors
+// for an empty distributor id. The latest orders are first.
+func (wh *dafaultWarehouse) PurchaseOrders(distributorID string) []*PurchaseOrder {
+	rows, err := wh.database.Query(`
+		SELECT `+purchaseOrderColumns+`
+		FROM
+			purchase_orders
+		WHERE
+			? = '' OR distributor_id = ?
+		ORDER BY
+			created DESC, rowid DESC
+	`, distributorID, distributorID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	orders := make([]*PurchaseOrder, 0)
+	for rows.Next() {
+		po, err := scanPurchaseOrder(rows)
+		if err != nil {
+			panic(err)
+		}
+		orders = append(orders, po)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	for _, po := range orders {
+		po.Lines = wh.purchaseOrderLines(`WHERE order_id = ? ORDER BY rowid`, po.ID)
+	}
+	return orders
+}
@@ -0,0 +1,309 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Recall is a recall of one or more lots of a stock item issued by its distributor.
+// The recalled lots are blocked from dispensing and have to be returned to the distributor.
+type Recall struct {
+	ID            string
+	StockID       string
+	DistributorID string
+
+	// LotNumbers are the numbers of the recalled lots as given in the recall notice
+	LotNumbers []string
+
+	// Reference is the distributor's reference of the recall notice
+	Reference string
+	Reason    string
+
+	Created time.Time
+	UserID  string
+}
+
+func (wh *dafaultWarehouse) initRecallsTables() {
+	recallsTables := `
+	CREATE TABLE IF NOT EXISTS
+		recalls (
+			id TEXT NOT NULL PRIMARY KEY,
+			stock_id TEXT NOT NULL,
+			distributor_id TEXT NOT NULL,
+			reference TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			created DATETIME NOT NULL,
+			user_id TEXT NOT NULL,
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id),
+			FOREIGN KEY (distributor_id) REFERENCES distributors (id)
+	);
+	CREATE TABLE IF NOT EXISTS
+		recall_lots (
+			recall_id TEXT NOT NULL,
+			lot_number TEXT NOT NULL,
+			PRIMARY KEY (recall_id, lot_number),
+			FOREIGN KEY (recall_id) REFERENCES recalls (id)
+	);
+	`
+	_, err := wh.database.Exec(recallsTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// recallReason is the state reason of the lots recalled by a recall
+func recallReason(r *Recall) string {
+	if r.Reference == "" {
+		return fmt.Sprintf("recall %s: %s", r.ID, r.Reason)
+	}
+	return fmt.Sprintf("recall %s (%s): %s", r.ID, r.Reference, r.Reason)
+}
+
+func (r *Recall) validate(wh *dafaultWarehouse) error {
+	errs := ValidationErrors{}
+
+	if _, ok := wh.ReadStock(r.StockID); !ok {
+		errs = append(errs, ValidationError{"stockID", "no such stock item"})
+	}
+	if _, ok := wh.ReadDistributor(r.DistributorID); !ok {
+		errs = append(errs, ValidationError{"distributorID", "no such distributor"})
+	}
+	if len(r.LotNumbers) == 0 {
+		errs = append(errs, ValidationError{"lotNumbers", "no lot numbers set for the recall"})
+	}
+	for _, number := range r.LotNumbers {
+		if strings.TrimSpace(number) == "" {
+			errs = append(errs, ValidationError{"lotNumbers", "cannot set empty string as lot number"})
+			break
+		}
+	}
+	if r.Reason == "" {
+		errs = append(errs, ValidationError{"reason", "no reason set for the recall"})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CreateRecall saves the recall and moves the matching available and quarantined lots
+// to the RECALLED state. If the recall has no distributor, the stock item's distributor is used.
+func (wh *dafaultWarehouse) CreateRecall(r *Recall) error {
+	if item, ok := wh.ReadStock(r.StockID); ok && r.DistributorID == "" {
+		r.DistributorID = item.DistributorID()
+	}
+	if err := r.validate(wh); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	r.ID = id
+	r.Created = time.Now().UTC()
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			recalls (
+				id,
+				stock_id,
+				distributor_id,
+				reference,
+				reason,
+				created,
+				user_id)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+	`,
+		r.ID,
+		r.StockID,
+		r.DistributorID,
+		r.Reference,
+		r.Reason,
+		r.Created,
+		r.UserID)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, number := range r.LotNumbers {
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO
+				recall_lots (
+					recall_id,
+					lot_number)
+			VALUES(?, ?)
+		`, r.ID, strings.TrimSpace(number))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	availableBefore := availableQuantityTx(tx, r.StockID, time.Now())
+	lots := queryLotsTx(tx, `
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			stock_id = ? AND state IN (?, ?) AND number IN (
+				SELECT lot_number FROM recall_lots WHERE recall_id = ?)
+	`, r.StockID, AVAILABLE, QUARANTINED, r.ID)
+	for _, lot := range lots {
+		if err := setLotStateTx(tx, lot, RECALLED, r.UserID, recallReason(r)); err != nil {
+			return err
+		}
+	}
+	availableAfter := availableQuantityTx(tx, r.StockID, time.Now())
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	wh.publishBelowMinimum(r.StockID, availableBefore, availableAfter)
+	return nil
+}
+
+// recallColumns are the columns selected by the recall queries, in the order expected by scanRecall
+const recallColumns = `
+	id,
+	stock_id,
+	distributor_id,
+	reference,
+	reason,
+	created,
+	user_id
+`
+
+func scanRecall(row rowScanner) (*Recall, error) {
+	r := &Recall{}
+	err := row.Scan(
+		&r.ID,
+		&r.StockID,
+		&r.DistributorID,
+		&r.Reference,
+		&r.Reason,
+		&r.Created,
+		&r.UserID)
+	return r, err
+}
+
+func (wh *dafaultWarehouse) recallLotNumbers(r *Recall) {
+	rows, err := wh.database.Query(`
+		SELECT
+			lot_number
+		FROM
+			recall_lots
+		WHERE
+			recall_id = ?
+		ORDER BY
+			lot_number
+	`, r.ID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	r.LotNumbers = make([]string, 0)
+	for rows.Next() {
+		var number string
+		if err = rows.Scan(&number); err != nil {
+			panic(err)
+		}
+		r.LotNumbers = append(r.LotNumbers, number)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (wh *dafaultWarehouse) ReadRecall(id string) (*Recall, bool) {
+	row := wh.database.QueryRow(`SELECT `+recallColumns+` FROM recalls WHERE id = ?`, id)
+
+	r, err := scanRecall(row)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	wh.recallLotNumbers(r)
+	return r, true
+}
+
+// Recalls returns all recalls, the latest are first
+func (wh *dafaultWarehouse) Recalls() []*Recall {
+	rows, err := wh.database.Query(`SELECT ` + recallColumns + ` FROM recalls ORDER BY created DESC`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	recalls := make([]*Recall, 0)
+	for rows.Next() {
+		r, err := scanRecall(rows)
+		if err != nil {
+			panic(err)
+		}
+		recalls = append(recalls, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	for _, r := range recalls {
+		wh.recallLotNumbers(r)
+	}
+	return recalls
+}
+
+// RecalledLots returns the lots of the stock item that match the lot numbers of the recall
+func (wh *dafaultWarehouse) RecalledLots(id string) []*Lot {
+	return wh.queryLots(`
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			stock_id = (SELECT stock_id FROM recalls WHERE id = ?) AND number IN (
+				SELECT lot_number FROM recall_lots WHERE recall_id = ?)
+		ORDER BY
+			number
+	`, id, id)
+}
+
+// RecalledDispenses returns the dispenses from the recalled lots in chronological order,
+// so the patients who received them can be followed up
+func (wh *dafaultWarehouse) RecalledDispenses(id string) []Movement {
+	return wh.queryMovements(`
+		SELECT `+movementColumns+`
+		FROM
+			stock_movements
+		WHERE
+			kind = ? AND lot_id IN (
+				SELECT
+					l.id
+				FROM
+					stock_lots l
+				JOIN
+					recalls r ON r.stock_id = l.stock_id
+				JOIN
+					recall_lots rl ON rl.recall_id = r.id AND rl.lot_number = l.number
+				WHERE
+					r.id = ?)
+		ORDER BY
+			time, rowid
+	`, DISPENSE, id)
+}
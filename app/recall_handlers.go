@@ -0,0 +1,239 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/shopspring/decimal"
+)
+
+func (m *madminHandler) recallsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listRecallsHandler(w, r)
+	case "POST":
+		m.addRecallHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /recalls/
+//
+// Lists the recalls, the latest are first.
+func (m *madminHandler) listRecallsHandler(w http.ResponseWriter, r *http.Request) {
+	recalls := m.warehouse.Recalls()
+
+	resp := &CollectionResponseDTO{"List of recalls", make([]string, 0, len(recalls))}
+	for _, recall := range recalls {
+		resp.URLs = append(resp.URLs, fmt.Sprintf("/data/recalls/%s", recall.ID))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /recalls/
+//
+// Creates a recall, blocks the recalled lots from dispensing
+// and returns the recall with the recalled lots.
+func (m *madminHandler) addRecallHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewRecallDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	recall := dto.recall()
+	recall.UserID = requestUserID(r)
+
+	err := m.warehouse.CreateRecall(recall)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, newRecallDTO(recall, m.warehouse.RecalledLots(recall.ID)))
+}
+
+// Handler for GET /recalls/<id>
+//
+// Returns JSON with data for the recall with <id> and the current state of the recalled lots.
+func (m *madminHandler) getRecallHandler(w http.ResponseWriter, r *http.Request) {
+	recall, ok := m.warehouse.ReadRecall(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newRecallDTO(recall, m.warehouse.RecalledLots(recall.ID)))
+}
+
+// Handler for GET /recalls/<id>/dispenses
+//
+// Lists the dispenses from the recalled lots for patient follow-up.
+func (m *madminHandler) recallDispensesHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.ReadRecall(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	dispenses := m.warehouse.RecalledDispenses(id)
+
+	resp := make([]*MovementDTO, 0, len(dispenses))
+	for i := range dispenses {
+		resp = append(resp, newMovementDTO(&dispenses[i]))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// returnLine is a line of a return-to-distributor document
+type returnLine struct {
+	LotNumber      string `json:"lotNumber"`
+	ExpirationDate string `json:"expirationDate,omitempty"`
+	Quantity       string `json:"quantity"`
+	UnitCost       string `json:"unitCost"`
+	Value          string `json:"value"`
+}
+
+// returnDocument lists the recalled stock that is returned to the distributor
+type returnDocument struct {
+	RecallID    string       `json:"recallID"`
+	Reference   string       `json:"reference"`
+	Reason      string       `json:"reason"`
+	Date        string       `json:"date"`
+	Distributor string       `json:"distributor"`
+	StockID     string       `json:"stockID"`
+	Name        string       `json:"name"`
+	Lines       []returnLine `json:"lines"`
+	TotalValue  string       `json:"totalValue"`
+}
+
+// newReturnDocument builds the return document of the recalled lots that are still in stock
+func (m *madminHandler) newReturnDocument(recall *Recall) *returnDocument {
+	doc := &returnDocument{
+		RecallID:  recall.ID,
+		Reference: recall.Reference,
+		Reason:    recall.Reason,
+		Date:      time.Now().UTC().Format("2006-01-02"),
+		StockID:   recall.StockID,
+		Lines:     make([]returnLine, 0),
+	}
+
+	if d, ok := m.warehouse.ReadDistributor(recall.DistributorID); ok {
+		doc.Distributor = d.Name()
+	}
+	if item, ok := m.warehouse.ReadStock(recall.StockID); ok {
+		doc.Name = item.Name()
+	}
+
+	total := decimal.Zero
+	for _, lot := range m.warehouse.RecalledLots(recall.ID) {
+		if lot.State == WRITTEN_OFF || lot.Quantity.Sign() <= 0 {
+			continue
+		}
+
+		line := returnLine{
+			LotNumber: lot.Number,
+			Quantity:  lot.Quantity.String(),
+			UnitCost:  lot.UnitCost.String(),
+			Value:     lot.Quantity.Mul(lot.UnitCost).String(),
+		}
+		if lot.ExpirationDate != nil {
+			line.ExpirationDate = lot.ExpirationDate.UTC().Format("2006-01-02")
+		}
+
+		total = total.Add(lot.Quantity.Mul(lot.UnitCost))
+		doc.Lines = append(doc.Lines, line)
+	}
+	doc.TotalValue = total.String()
+
+	return doc
+}
+
+func (rd *returnDocument) writePDF(w io.Writer) error {
+	var (
+		pdf       = gofpdf.New("P", "mm", "A4", "")
+		tr        = pdf.UnicodeTranslatorFromDescriptor("")
+		columns   = []string{"Lot number", "Expiration date", "Quantity", "Unit cost", "Value"}
+		widths    = []float64{50, 35, 30, 35, 30}
+		rowHeight = 7.0
+	)
+
+	pdf.SetTitle(fmt.Sprintf("Return to distributor - %s", rd.Name), true)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 10, "Return to distributor", "", 1, "L", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, field := range [][2]string{
+		{"Distributor", rd.Distributor},
+		{"Date", rd.Date},
+		{"Recall", rd.RecallID},
+		{"Recall notice", rd.Reference},
+		{"Reason", rd.Reason},
+		{"Product", rd.Name},
+	} {
+		pdf.CellFormat(35, rowHeight, field[0]+":", "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, rowHeight, tr(field[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(rowHeight)
+
+	pdf.SetFont("Helvetica", "B", 9)
+	for i, column := range columns {
+		pdf.CellFormat(widths[i], rowHeight, column, "1", 0, "C", false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 9)
+	for _, line := range rd.Lines {
+		for i, value := range []string{line.LotNumber, line.ExpirationDate, line.Quantity, line.UnitCost, line.Value} {
+			pdf.CellFormat(widths[i], rowHeight, tr(value), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.CellFormat(widths[0]+widths[1]+widths[2]+widths[3], rowHeight, "Total", "1", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[4], rowHeight, rd.TotalValue, "1", 1, "L", false, 0, "")
+
+	pdf.Ln(2 * rowHeight)
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(90, rowHeight, "Returned by: ____________________", "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, rowHeight, "Received by: ____________________", "", 1, "L", false, 0, "")
+
+	return pdf.Output(w)
+}
+
+// Handler for GET /recalls/<id>/return
+//
+// Returns the return-to-distributor document of the recall with <id>
+// as JSON or, with ?format=pdf, as a PDF document.
+func (m *madminHandler) recallReturnHandler(w http.ResponseWriter, r *http.Request) {
+	recall, ok := m.warehouse.ReadRecall(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	doc := m.newReturnDocument(recall)
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		respondJSON(w, http.StatusOK, doc)
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"return-%s.pdf\"", recall.ID))
+		if err := doc.writePDF(w); err != nil {
+			log.Printf("Error while writing response: %s", err)
+		}
+	default:
+		respondBadRequest(w, ValidationErrors{{"format", "supported formats are json and pdf"}})
+	}
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRecall(t *testing.T) {
+	var (
+		dbPath         = "./test_database.sqlite"
+		database       = newDB(dbPath)
+		madminHandler  = NewMAdminHandler(database)
+		wh             = madminHandler.warehouse
+		expirationDate = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+	defer cleanupDatabase(t, database, dbPath)
+
+	distributor, _ := NewDistributor("Vet Supplies")
+	wh.CreateDistributor(distributor, nil)
+
+	item, _ := NewStock(&NewStockDTO{
+		Name:           "Amoxicillin",
+		Type:           MEDICINE,
+		Quantity:       "0",
+		ExpirationDate: "2030-01-01T00:00:00.000Z",
+		DistributorID:  distributor.ID(),
+	}, builtinStockTypes)
+	wh.CreateStock(item, nil)
+
+	recalled := &Movement{
+		StockID:  item.ID(),
+		Kind:     RECEIPT,
+		Quantity: decimal.New(10, 0),
+		Lot:      &Lot{Number: "A1", ExpirationDate: &expirationDate, UnitCost: decimal.New(2, 0)},
+	}
+	other := &Movement{
+		StockID:  item.ID(),
+		Kind:     RECEIPT,
+		Quantity: decimal.New(5, 0),
+		Lot:      &Lot{Number: "B2", ExpirationDate: &expirationDate},
+	}
+	for _, mv := range []*Movement{recalled, other} {
+		if err := wh.RecordMovement(mv); err != nil {
+			t.Fatalf(`RecordMovement returns an error for a valid lot receipt: %s`, err)
+		}
+	}
+	dispense := &Movement{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(3, 0), LotID: recalled.LotID, PatientRef: "Rex"}
+	if err := wh.RecordMovement(dispense); err != nil {
+		t.Fatalf(`RecordMovement returns an error for a valid dispense: %s`, err)
+	}
+
+	if err := wh.CreateRecall(&Recall{StockID: item.ID(), Reason: "contamination"}); err == nil {
+		t.Fatalf(`CreateRecall creates a recall without lot numbers`)
+	}
+
+	recall := &Recall{StockID: item.ID(), LotNumbers: []string{"A1", "Z9"}, Reference: "RN-1", Reason: "contamination"}
+	if err := wh.CreateRecall(recall); err != nil {
+		t.Fatalf(`CreateRecall returns an error for a valid recall: %s`, err)
+	}
+	if recall.DistributorID != distributor.ID() {
+		t.Fatalf(`Recall does not default to the item's distributor`)
+	}
+
+	if lots := wh.RecalledLots(recall.ID); len(lots) != 1 || lots[0].ID != recalled.LotID || lots[0].State != RECALLED {
+		t.Fatalf(`Unexpected recalled lots %+v`, lots)
+	}
+	if lot, _ := wh.ReadLot(other.LotID); lot.State != AVAILABLE {
+		t.Fatalf(`Lot that is not recalled changed state to %s`, lot.State)
+	}
+
+	dispenses := wh.RecalledDispenses(recall.ID)
+	if len(dispenses) != 1 || dispenses[0].PatientRef != "Rex" {
+		t.Fatalf(`Unexpected recalled dispenses %+v`, dispenses)
+	}
+
+	again := &Movement{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0), LotID: recalled.LotID}
+	if err := wh.RecordMovement(again); err == nil {
+		t.Fatalf(`RecordMovement dispenses from a recalled lot`)
+	}
+
+	doc := madminHandler.newReturnDocument(recall)
+	if doc.Distributor != "Vet Supplies" || len(doc.Lines) != 1 || doc.Lines[0].Quantity != "7" || doc.TotalValue != "14" {
+		t.Fatalf(`Unexpected return document %+v`, doc)
+	}
+}
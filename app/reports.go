@@ -0,0 +1,151 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// WriteOff is a write-off movement with the data needed for the write-off report
+type WriteOff struct {
+	Movement
+
+	Name      string
+	LotNumber string
+	// UnitCost is the unit cost of the written-off lot or zero for stock without lots
+	UnitCost decimal.Decimal
+}
+
+// Value returns the purchase value of the written-off quantity
+func (wo *WriteOff) Value() decimal.Decimal {
+	return wo.Quantity.Mul(wo.UnitCost)
+}
+
+// WriteOffs returns the write-off movements recorded in the period [from, to)
+func (wh *dafaultWarehouse) WriteOffs(from, to time.Time) []WriteOff {
+	rows, err := wh.database.Query(`
+		SELECT
+			m.id,
+			m.stock_id,
+			m.quantity,
+			m.time,
+			m.user_id,
+			m.reference,
+			m.lot_id,
+			COALESCE(w.name, ''),
+			COALESCE(l.number, ''),
+			COALESCE(l.unit_cost, 0)
+		FROM
+			stock_movements m
+		LEFT JOIN
+			warehouse w ON w.id = m.stock_id
+		LEFT JOIN
+			stock_lots l ON l.id = m.lot_id
+		WHERE
+			m.kind = ? AND m.time >= ? AND m.time < ?
+		ORDER BY
+			m.time, m.rowid
+	`, WRITE_OFF, from.UTC(), to.UTC())
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	writeOffs := make([]WriteOff, 0)
+	for rows.Next() {
+		wo := WriteOff{Movement: Movement{Kind: WRITE_OFF}}
+		err = rows.Scan(
+			&wo.ID,
+			&wo.StockID,
+			&wo.Quantity,
+			&wo.Time,
+			&wo.UserID,
+			&wo.Reference,
+			&wo.LotID,
+			&wo.Name,
+			&wo.LotNumber,
+			&wo.UnitCost)
+		if err != nil {
+			panic(err)
+		}
+		writeOffs = append(writeOffs, wo)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return writeOffs
+}
+
+// reportPeriod reads the period of a report from the from and to query parameters.
+// The period defaults to the last 30 days.
+func reportPeriod(r *http.Request) (from, to time.Time, err error) {
+	var (
+		query = r.URL.Query()
+		errs  = ValidationErrors{}
+	)
+
+	to = time.Now().UTC()
+	if s := query.Get("to"); s != "" {
+		if to, err = time.Parse(dateLayout, s); err != nil {
+			errs = append(errs, ValidationError{"to", err.Error()})
+		}
+	}
+
+	from = to.AddDate(0, 0, -30)
+	if s := query.Get("from"); s != "" {
+		if from, err = time.Parse(dateLayout, s); err != nil {
+			errs = append(errs, ValidationError{"from", err.Error()})
+		}
+	}
+
+	if len(errs) > 0 {
+		return from, to, errs
+	}
+	if !from.Before(to) {
+		return from, to, ValidationErrors{{"from", "the period must start before it ends"}}
+	}
+	return from, to, nil
+}
+
+// Handler for GET /reports/write-offs?from=<date>&to=<date>
+//
+// Lists the write-offs in the period with their value and reason.
+func (m *madminHandler) writeOffReportHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	var (
+		writeOffs = m.warehouse.WriteOffs(from, to)
+		total     = decimal.Zero
+		resp      = &WriteOffReportDTO{
+			From:      from.Format(dateLayout),
+			To:        to.Format(dateLayout),
+			WriteOffs: make([]*WriteOffDTO, 0, len(writeOffs)),
+		}
+	)
+
+	for _, wo := range writeOffs {
+		total = total.Add(wo.Value())
+		resp.WriteOffs = append(resp.WriteOffs, &WriteOffDTO{
+			Time:      wo.Time.UTC().Format(dateLayout),
+			StockID:   wo.StockID,
+			Name:      wo.Name,
+			LotID:     wo.LotID,
+			LotNumber: wo.LotNumber,
+			Quantity:  wo.Quantity.String(),
+			UnitCost:  wo.UnitCost.String(),
+			Value:     wo.Value().String(),
+			Reason:    wo.Reference,
+			UserID:    wo.UserID,
+		})
+	}
+	resp.TotalValue = total.String()
+
+	respondJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,719 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type paymentMethod string
+
+// CASH, CARD and ACCOUNT are the payment methods of sales and refunds.
+// Sales on ACCOUNT are charged to the customer's account and need a customer.
+const (
+	CASH    paymentMethod = "cash"
+	CARD    paymentMethod = "card"
+	ACCOUNT paymentMethod = "account"
+)
+
+func (pm paymentMethod) isValid() bool {
+	return pm == CASH || pm == CARD || pm == ACCOUNT
+}
+
+var hundred = decimal.New(100, 0)
+
+// Sale is an over-the-counter sale. The stock of its lines is dispensed when the sale is completed.
+type Sale struct {
+	ID string
+	// Number is the sequential number of the sale that is printed on its receipt
+	Number int
+
+	Lines         []*SaleLine
+	PaymentMethod paymentMethod
+	// CustomerID is the id of the owner who bought the stock or an empty string
+	CustomerID string
+	// LocationID is the storage location of the till, the lines are dispensed from it
+	LocationID string
+
+	Created time.Time
+	UserID  string
+}
+
+// SaleLine is a quantity of a stock item in a sale. Prices include VAT.
+type SaleLine struct {
+	StockID string
+	// LotID is the lot the stock is taken from or an empty string
+	LotID string
+	// Name is the name of the stock item at the time of the sale
+	Name string
+
+	Quantity  decimal.Decimal
+	UnitPrice decimal.Decimal
+	// Discount is the percentage taken off the line's price
+	Discount decimal.Decimal
+	// VATRate is the percentage of VAT included in the price
+	VATRate decimal.Decimal
+
+	// Returned is the quantity that was refunded and Refunded is the refunded amount
+	Returned decimal.Decimal
+	Refunded decimal.Decimal
+
+	MovementID string
+}
+
+// Total returns the price of the line after its discount, rounded to cents
+func (l *SaleLine) Total() decimal.Decimal {
+	return l.Quantity.Mul(l.UnitPrice).Mul(hundred.Sub(l.Discount)).Div(hundred).Round(2)
+}
+
+// DiscountAmount returns the amount taken off the line's price
+func (l *SaleLine) DiscountAmount() decimal.Decimal {
+	return l.Quantity.Mul(l.UnitPrice).Round(2).Sub(l.Total())
+}
+
+// VAT returns the VAT included in the line's total
+func (l *SaleLine) VAT() decimal.Decimal {
+	return l.Total().Mul(l.VATRate).Div(hundred.Add(l.VATRate)).Round(2)
+}
+
+// refundAmount returns the amount that is refunded for returning the quantity of the line.
+// The last return of a line refunds the rest of its total, so the refunds add up to it.
+func (l *SaleLine) refundAmount(quantity decimal.Decimal) decimal.Decimal {
+	if l.Returned.Add(quantity).Equal(l.Quantity) {
+		return l.Total().Sub(l.Refunded)
+	}
+	return l.Total().Mul(quantity).Div(l.Quantity).Round(2)
+}
+
+// Total returns the amount paid for the sale
+func (s *Sale) Total() decimal.Decimal {
+	total := decimal.Zero
+	for _, l := range s.Lines {
+		total = total.Add(l.Total())
+	}
+	return total
+}
+
+// VAT returns the VAT included in the sale's total
+func (s *Sale) VAT() decimal.Decimal {
+	vat := decimal.Zero
+	for _, l := range s.Lines {
+		vat = vat.Add(l.VAT())
+	}
+	return vat
+}
+
+// Refunded returns the amount refunded for the sale's returns
+func (s *Sale) Refunded() decimal.Decimal {
+	refunded := decimal.Zero
+	for _, l := range s.Lines {
+		refunded = refunded.Add(l.Refunded)
+	}
+	return refunded
+}
+
+// Refund is a return of some of the stock of a sale for its money back
+type Refund struct {
+	ID     string
+	SaleID string
+
+	Lines         []RefundLine
+	PaymentMethod paymentMethod
+	Reason        string
+
+	Created time.Time
+	UserID  string
+}
+
+// RefundLine is a quantity that is returned from a line of a sale.
+// Restocked quantities are received back in the stock, the others are not sellable any more.
+type RefundLine struct {
+	// Line is the index of the sale's line
+	Line     int
+	Quantity decimal.Decimal
+	Restock  bool
+
+	// Amount and MovementID are set when the refund is recorded
+	Amount     decimal.Decimal
+	MovementID string
+}
+
+// Amount returns the amount of money refunded
+func (r *Refund) Amount() decimal.Decimal {
+	amount := decimal.Zero
+	for _, l := range r.Lines {
+		amount = amount.Add(l.Amount)
+	}
+	return amount
+}
+
+func (wh *dafaultWarehouse) initSalesTables() {
+	salesTables := `
+	CREATE TABLE IF NOT EXISTS
+		sales (
+			id TEXT NOT NULL PRIMARY KEY,
+			number INTEGER NOT NULL UNIQUE,
+			payment_method TEXT NOT NULL,
+			customer_id TEXT NOT NULL,
+			location_id TEXT NOT NULL,
+			created DATETIME NOT NULL,
+			user_id TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS
+		sales_created ON sales (created);
+	CREATE TABLE IF NOT EXISTS
+		sale_lines (
+			sale_id TEXT NOT NULL,
+			line INTEGER NOT NULL,
+			stock_id TEXT NOT NULL,
+			lot_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			unit_price NUMERIC NOT NULL,
+			discount NUMERIC NOT NULL,
+			vat_rate NUMERIC NOT NULL,
+			returned NUMERIC NOT NULL,
+			refunded NUMERIC NOT NULL,
+			movement_id TEXT NOT NULL,
+			PRIMARY KEY (sale_id, line),
+			FOREIGN KEY (sale_id) REFERENCES sales (id)
+	);
+	CREATE TABLE IF NOT EXISTS
+		refunds (
+			id TEXT NOT NULL PRIMARY KEY,
+			sale_id TEXT NOT NULL,
+			payment_method TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			created DATETIME NOT NULL,
+			user_id TEXT NOT NULL,
+			FOREIGN KEY (sale_id) REFERENCES sales (id)
+	);
+	CREATE TABLE IF NOT EXISTS
+		refund_lines (
+			refund_id TEXT NOT NULL,
+			line INTEGER NOT NULL,
+			quantity NUMERIC NOT NULL,
+			restock BOOLEAN NOT NULL,
+			amount NUMERIC NOT NULL,
+			movement_id TEXT NOT NULL,
+			FOREIGN KEY (refund_id) REFERENCES refunds (id)
+	);
+	`
+	_, err := wh.database.Exec(salesTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (s *Sale) validate(wh *dafaultWarehouse) error {
+	errs := ValidationErrors{}
+
+	if !s.PaymentMethod.isValid() {
+		errs = append(errs, ValidationError{"paymentMethod", "invalid payment method"})
+	}
+	if s.PaymentMethod == ACCOUNT && s.CustomerID == "" {
+		errs = append(errs, ValidationError{"customerID", "sales on account need a customer"})
+	}
+	if s.CustomerID != "" {
+		if _, ok := wh.clients.ReadOwner(s.CustomerID); !ok {
+			errs = append(errs, ValidationError{"customerID", "no such owner"})
+		}
+	}
+	if s.LocationID != "" {
+		if _, ok := wh.locations.ReadLocation(s.LocationID); !ok {
+			errs = append(errs, ValidationError{"locationID", "no such storage location"})
+		}
+	}
+	if len(s.Lines) == 0 {
+		errs = append(errs, ValidationError{"lines", "no stock sold"})
+	}
+
+	for i, line := range s.Lines {
+		field := func(name string) string { return fmt.Sprintf("lines[%d].%s", i, name) }
+
+		item, ok := wh.ReadStock(line.StockID)
+		if !ok {
+			errs = append(errs, ValidationError{field("stockID"), "no such stock item"})
+			continue
+		}
+		line.Name = item.Name()
+
+		if line.Quantity.Sign() <= 0 {
+			errs = append(errs, ValidationError{field("quantity"), "quantity must be positive"})
+		} else if err := item.QuantityRule().Validate(field("quantity"), line.Quantity); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+		if line.UnitPrice.Sign() < 0 {
+			errs = append(errs, ValidationError{field("unitPrice"), "price must not be negative"})
+		}
+		if line.Discount.Sign() < 0 || line.Discount.GreaterThan(hundred) {
+			errs = append(errs, ValidationError{field("discount"), "discount must be between 0 and 100 percent"})
+		}
+		if line.VATRate.Sign() < 0 {
+			errs = append(errs, ValidationError{field("vatRate"), "VAT rate must not be negative"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CreateSale completes a sale and dispenses the stock of its lines in a single transaction.
+// The sale's ID, Number, Created and the lines' names and movements are set on success.
+func (wh *dafaultWarehouse) CreateSale(s *Sale) error {
+	if err := s.validate(wh); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	s.ID = id
+	s.Created = time.Now().UTC()
+
+	// the number is taken by the first statement of the transaction, which also locks the DB
+	// for writing, so concurrent sales wait for each other instead of taking the same number
+	_, err = tx.Exec(`
+		INSERT INTO
+			sales (
+				id,
+				number,
+				payment_method,
+				customer_id,
+				location_id,
+				created,
+				user_id)
+		VALUES(?, (SELECT COALESCE(MAX(number), 0) + 1 FROM sales), ?, ?, ?, ?, ?)
+	`,
+		s.ID,
+		s.PaymentMethod,
+		s.CustomerID,
+		s.LocationID,
+		s.Created,
+		s.UserID)
+	if err != nil {
+		panic(err)
+	}
+	err = tx.QueryRow(`SELECT number FROM sales WHERE id = ?`, s.ID).Scan(&s.Number)
+	if err != nil {
+		panic(err)
+	}
+
+	movements := make([]*Movement, 0, len(s.Lines))
+	for i, line := range s.Lines {
+		mv := &Movement{
+			StockID:    line.StockID,
+			Kind:       DISPENSE,
+			Quantity:   line.Quantity,
+			UserID:     s.UserID,
+			Reference:  fmt.Sprintf("sale %d", s.Number),
+			LotID:      line.LotID,
+			LocationID: s.LocationID,
+		}
+		if err := wh.recordMovementTx(tx, mv); err != nil {
+			if errs, ok := err.(ValidationErrors); ok {
+				for j := range errs {
+					errs[j].Field = fmt.Sprintf("lines[%d].%s", i, errs[j].Field)
+				}
+			}
+			return err
+		}
+		movements = append(movements, mv)
+
+		line.Returned = decimal.Zero
+		line.Refunded = decimal.Zero
+		line.MovementID = mv.ID
+		_, err = tx.Exec(`
+			INSERT INTO
+				sale_lines (
+					sale_id,
+					line,
+					stock_id,
+					lot_id,
+					name,
+					quantity,
+					unit_price,
+					discount,
+					vat_rate,
+					returned,
+					refunded,
+					movement_id)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			s.ID,
+			i,
+			line.StockID,
+			line.LotID,
+			line.Name,
+			line.Quantity.String(),
+			line.UnitPrice.String(),
+			line.Discount.String(),
+			line.VATRate.String(),
+			line.Returned.String(),
+			line.Refunded.String(),
+			line.MovementID)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, mv := range movements {
+		wh.publishMovement(mv)
+	}
+	return nil
+}
+
+func saleLinesTx(q querier, saleID string) []*SaleLine {
+	rows, err := q.Query(`
+		SELECT
+			stock_id,
+			lot_id,
+			name,
+			quantity,
+			unit_price,
+			discount,
+			vat_rate,
+			returned,
+			refunded,
+			movement_id
+		FROM
+			sale_lines
+		WHERE
+			sale_id = ?
+		ORDER BY
+			line
+	`, saleID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	lines := make([]*SaleLine, 0)
+	for rows.Next() {
+		line := &SaleLine{}
+		err = rows.Scan(
+			&line.StockID,
+			&line.LotID,
+			&line.Name,
+			&line.Quantity,
+			&line.UnitPrice,
+			&line.Discount,
+			&line.VATRate,
+			&line.Returned,
+			&line.Refunded,
+			&line.MovementID)
+		if err != nil {
+			panic(err)
+		}
+		lines = append(lines, line)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return lines
+}
+
+// saleColumns are the columns of the sales table, in the order expected by scanSale
+const saleColumns = `
+	id,
+	number,
+	payment_method,
+	customer_id,
+	location_id,
+	created,
+	user_id
+`
+
+func scanSale(row rowScanner) (*Sale, error) {
+	s := &Sale{}
+	err := row.Scan(
+		&s.ID,
+		&s.Number,
+		&s.PaymentMethod,
+		&s.CustomerID,
+		&s.LocationID,
+		&s.Created,
+		&s.UserID)
+	return s, err
+}
+
+func (wh *dafaultWarehouse) ReadSale(id string) (*Sale, bool) {
+	s, err := scanSale(wh.database.QueryRow(`SELECT `+saleColumns+` FROM sales WHERE id = ?`, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	s.Lines = saleLinesTx(wh.database, s.ID)
+	return s, true
+}
+
+// Sales returns the sales in the period with their lines, the latest are first
+func (wh *dafaultWarehouse) Sales(from, to time.Time) []*Sale {
+	rows, err := wh.database.Query(`
+		SELECT `+saleColumns+`
+		FROM
+			sales
+		WHERE
+			created >= ? AND created < ?
+		ORDER BY
+			number DESC
+	`, from, to)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	sales := make([]*Sale, 0)
+	for rows.Next() {
+		s, err := scanSale(rows)
+		if err != nil {
+			panic(err)
+		}
+		sales = append(sales, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	for _, s := range sales {
+		s.Lines = saleLinesTx(wh.database, s.ID)
+	}
+	return sales
+}
+
+// RefundSale returns stock from the lines of a sale and records the refunded amounts.
+// Restocked quantities are received back into the location and lot they were sold from.
+func (wh *dafaultWarehouse) RefundSale(r *Refund) error {
+	s, ok := wh.ReadSale(r.SaleID)
+	if !ok {
+		return errors.New("no such sale")
+	}
+
+	errs := ValidationErrors{}
+	if !r.PaymentMethod.isValid() {
+		errs = append(errs, ValidationError{"paymentMethod", "invalid payment method"})
+	}
+	if len(r.Lines) == 0 {
+		errs = append(errs, ValidationError{"lines", "no stock returned"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	// the lines are read again in the transaction, so concurrent refunds cannot return more than was sold
+	saleLines := saleLinesTx(tx, s.ID)
+
+	r.ID = id
+	r.Created = time.Now().UTC()
+
+	movements := make([]*Movement, 0)
+	for i := range r.Lines {
+		rl := &r.Lines[i]
+		field := func(name string) string { return fmt.Sprintf("lines[%d].%s", i, name) }
+
+		if rl.Line < 0 || rl.Line >= len(saleLines) {
+			return ValidationErrors{{field("line"), "no such line in the sale"}}
+		}
+		line := saleLines[rl.Line]
+		if rl.Quantity.Sign() <= 0 {
+			return ValidationErrors{{field("quantity"), "quantity must be positive"}}
+		}
+		if rl.Quantity.GreaterThan(line.Quantity.Sub(line.Returned)) {
+			return ValidationErrors{{field("quantity"), "more than the sold quantity is returned"}}
+		}
+
+		rl.Amount = line.refundAmount(rl.Quantity)
+		line.Returned = line.Returned.Add(rl.Quantity)
+		line.Refunded = line.Refunded.Add(rl.Amount)
+
+		if rl.Restock {
+			mv := &Movement{
+				StockID:    line.StockID,
+				Kind:       RECEIPT,
+				Quantity:   rl.Quantity,
+				UserID:     r.UserID,
+				Reference:  fmt.Sprintf("return of sale %d", s.Number),
+				LotID:      line.LotID,
+				LocationID: s.LocationID,
+			}
+			if err := wh.recordMovementTx(tx, mv); err != nil {
+				if errs, ok := err.(ValidationErrors); ok {
+					for j := range errs {
+						errs[j].Field = field(errs[j].Field)
+					}
+				}
+				return err
+			}
+			rl.MovementID = mv.ID
+			movements = append(movements, mv)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE
+				sale_lines
+			SET
+				returned = ?,
+				refunded = ?
+			WHERE
+				sale_id = ? AND line = ?
+		`, line.Returned.String(), line.Refunded.String(), s.ID, rl.Line)
+		if err != nil {
+			panic(err)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO
+				refund_lines (
+					refund_id,
+					line,
+					quantity,
+					restock,
+					amount,
+					movement_id)
+			VALUES(?, ?, ?, ?, ?, ?)
+		`, r.ID, rl.Line, rl.Quantity.String(), rl.Restock, rl.Amount.String(), rl.MovementID)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			refunds (
+				id,
+				sale_id,
+				payment_method,
+				reason,
+				created,
+				user_id)
+		VALUES(?, ?, ?, ?, ?, ?)
+	`, r.ID, r.SaleID, r.PaymentMethod, r.Reason, r.Created, r.UserID)
+	if err != nil {
+		panic(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, mv := range movements {
+		wh.publishMovement(mv)
+	}
+	return nil
+}
+
+// Refunds returns the refunds of a sale in chronological order
+func (wh *dafaultWarehouse) Refunds(saleID string) []*Refund {
+	rows, err := wh.database.Query(`
+		SELECT
+			id,
+			sale_id,
+			payment_method,
+			reason,
+			created,
+			user_id
+		FROM
+			refunds
+		WHERE
+			sale_id = ?
+		ORDER BY
+			created, rowid
+	`, saleID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	refunds := make([]*Refund, 0)
+	for rows.Next() {
+		r := &Refund{}
+		err = rows.Scan(&r.ID, &r.SaleID, &r.PaymentMethod, &r.Reason, &r.Created, &r.UserID)
+		if err != nil {
+			panic(err)
+		}
+		refunds = append(refunds, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	for _, r := range refunds {
+		r.Lines = wh.refundLines(r.ID)
+	}
+	return refunds
+}
+
+func (wh *dafaultWarehouse) refundLines(refundID string) []RefundLine {
+	rows, err := wh.database.Query(`
+		SELECT
+			line,
+			quantity,
+			restock,
+			amount,
+			movement_id
+		FROM
+			refund_lines
+		WHERE
+			refund_id = ?
+		ORDER BY
+			rowid
+	`, refundID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	lines := make([]RefundLine, 0)
+	for rows.Next() {
+		l := RefundLine{}
+		if err = rows.Scan(&l.Line, &l.Quantity, &l.Restock, &l.Amount, &l.MovementID); err != nil {
+			panic(err)
+		}
+		lines = append(lines, l)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return lines
+}
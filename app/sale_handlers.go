@@ -0,0 +1,253 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/shopspring/decimal"
+)
+
+func (m *madminHandler) salesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listSalesHandler(w, r)
+	case "POST":
+		m.addSaleHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /sales/?from=<date>&to=<date>
+//
+// Lists the sales in the period, the latest are first. The period defaults to the last 30 days.
+func (m *madminHandler) listSalesHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	sales := m.warehouse.Sales(from, to)
+
+	resp := make([]*SaleDTO, 0, len(sales))
+	for _, s := range sales {
+		resp = append(resp, newSaleDTO(s, nil))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /sales/
+//
+// Completes a sale, dispenses its stock and returns the sale.
+func (m *madminHandler) addSaleHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewSaleDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	s, err := dto.sale()
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+	s.UserID = requestUserID(r)
+
+	if err := m.warehouse.CreateSale(s); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, newSaleDTO(s, nil))
+}
+
+// Handler for GET /sales/<id>
+//
+// Returns JSON with data for the sale with the given id and its refunds.
+func (m *madminHandler) getSaleHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := m.warehouse.ReadSale(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newSaleDTO(s, m.warehouse.Refunds(s.ID)))
+}
+
+// Handler for POST /sales/<id>/refunds
+//
+// Refunds returned stock of the sale with <id> and returns the refund.
+// Returned stock that can be sold again is restocked.
+func (m *madminHandler) refundSaleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &NewRefundDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadSale(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	refund, err := dto.refund()
+	if err == nil {
+		refund.SaleID = id
+		refund.UserID = requestUserID(r)
+		err = m.warehouse.RefundSale(refund)
+	}
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, newRefundDTO(refund))
+}
+
+// Handler for GET /sales/<id>/receipt?format=<text|pdf>
+//
+// Returns the receipt of the sale with <id> as plain text or as a PDF for 80mm receipt printers.
+func (m *madminHandler) saleReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := m.warehouse.ReadSale(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "text" && format != "pdf" {
+		respondBadRequest(w, ValidationErrors{{"format", "the format must be text or pdf"}})
+		return
+	}
+
+	rc := &receipt{Sale: s, Refunds: m.warehouse.Refunds(s.ID)}
+	if owner, ok := m.clients.ReadOwner(s.CustomerID); ok {
+		rc.Customer = owner.Name()
+	}
+
+	buf := &bytes.Buffer{}
+	if format == "pdf" {
+		if err := rc.writePDF(buf); err != nil {
+			panic(err)
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+	} else {
+		rc.writeText(buf)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("Error while writing response: %s", err)
+	}
+}
+
+// receiptWidth is the number of characters in a line of a receipt
+const receiptWidth = 40
+
+// receipt is a sale with the records that are printed on its receipt
+type receipt struct {
+	Sale     *Sale
+	Refunds  []*Refund
+	Customer string
+}
+
+// receiptLine returns a line of a receipt with left and right aligned text.
+// The left text is cut if both do not fit.
+func receiptLine(left, right string) string {
+	space := receiptWidth - utf8.RuneCountInString(right) - 1
+	if utf8.RuneCountInString(left) > space {
+		left = string([]rune(left)[:space])
+	}
+	return left + strings.Repeat(" ", receiptWidth-utf8.RuneCountInString(left)-utf8.RuneCountInString(right)) + right
+}
+
+// lines returns the lines of the receipt's text
+func (rc *receipt) lines() []string {
+	var (
+		s     = rc.Sale
+		rule  = strings.Repeat("-", receiptWidth)
+		title = "SALE RECEIPT"
+		lines = []string{
+			strings.Repeat(" ", (receiptWidth-len(title))/2) + title,
+			receiptLine(fmt.Sprintf("Sale #%d", s.Number), s.Created.UTC().Format("2006-01-02 15:04")),
+			rule,
+		}
+		vat = make(map[string]decimal.Decimal)
+	)
+
+	for _, l := range s.Lines {
+		lines = append(lines,
+			receiptLine(l.Name, ""),
+			receiptLine(fmt.Sprintf("  %s x %s", l.Quantity, l.UnitPrice.StringFixed(2)), l.Quantity.Mul(l.UnitPrice).StringFixed(2)))
+		if l.Discount.Sign() > 0 {
+			lines = append(lines, receiptLine(fmt.Sprintf("  Discount %s%%", l.Discount), "-"+l.DiscountAmount().StringFixed(2)))
+		}
+		vat[l.VATRate.String()] = vat[l.VATRate.String()].Add(l.VAT())
+	}
+
+	lines = append(lines, rule, receiptLine("TOTAL", s.Total().StringFixed(2)))
+
+	rates := make([]string, 0, len(vat))
+	for rate := range vat {
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		return decimal.RequireFromString(rates[i]).LessThan(decimal.RequireFromString(rates[j]))
+	})
+	for _, rate := range rates {
+		lines = append(lines, receiptLine(fmt.Sprintf("  incl. VAT %s%%", rate), vat[rate].StringFixed(2)))
+	}
+	lines = append(lines, receiptLine("Paid by "+string(s.PaymentMethod), s.Total().StringFixed(2)))
+
+	if rc.Customer != "" {
+		lines = append(lines, receiptLine("Customer: "+rc.Customer, ""))
+	}
+	for _, r := range rc.Refunds {
+		lines = append(lines, receiptLine(fmt.Sprintf("Refund %s (%s)", r.Created.UTC().Format("2006-01-02"), r.PaymentMethod), "-"+r.Amount().StringFixed(2)))
+	}
+
+	return append(lines, rule)
+}
+
+func (rc *receipt) writeText(w io.Writer) {
+	for _, line := range rc.lines() {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// writePDF writes the receipt on a single page of an 80mm receipt roll
+func (rc *receipt) writePDF(w io.Writer) error {
+	const (
+		width      = 80.0
+		margin     = 4.0
+		lineHeight = 3.5
+	)
+	lines := rc.lines()
+
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		UnitStr: "mm",
+		Size:    gofpdf.SizeType{Wd: width, Ht: 2*margin + float64(len(lines))*lineHeight},
+	})
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+
+	// 40 characters of 8pt Courier fit in the printable width of the roll
+	pdf.SetFont("Courier", "", 8)
+	for _, line := range lines {
+		pdf.CellFormat(width-2*margin, lineHeight, tr(line), "", 1, "L", false, 0, "")
+	}
+
+	return pdf.Output(w)
+}
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestSale(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+		wh            = madminHandler.warehouse
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	feed, _ := defaultExpirableStockItem(FEED)
+	feed.SetName("Dog food 10kg")
+	feed.SetQuantity(decimal.New(10, 0))
+	wh.CreateStock(feed, nil)
+
+	collar, _ := defaultUnexpirableStockItem(ACCESSORY)
+	collar.SetName("Collar")
+	collar.SetQuantity(decimal.New(5, 0))
+	wh.CreateStock(collar, nil)
+
+	if err := wh.CreateSale(&Sale{PaymentMethod: ACCOUNT, Lines: []*SaleLine{{StockID: feed.ID(), Quantity: decimal.New(1, 0)}}}); err == nil {
+		t.Fatalf(`CreateSale accepts a sale on account without a customer`)
+	}
+	if err := wh.CreateSale(&Sale{PaymentMethod: ACCOUNT, CustomerID: "missing", Lines: []*SaleLine{{StockID: feed.ID(), Quantity: decimal.New(1, 0)}}}); err == nil {
+		t.Fatalf(`CreateSale accepts a sale to an unknown customer`)
+	}
+
+	// the sale is not completed if any of its lines cannot be dispensed
+	failed := &Sale{PaymentMethod: CASH, Lines: []*SaleLine{
+		{StockID: feed.ID(), Quantity: decimal.New(1, 0), UnitPrice: decimal.New(25, 0)},
+		{StockID: collar.ID(), Quantity: decimal.New(10, 0), UnitPrice: decimal.New(399, -2)},
+	}}
+	if err := wh.CreateSale(failed); err == nil {
+		t.Fatalf(`CreateSale sells more than the stock`)
+	}
+	if item, _ := wh.ReadStock(feed.ID()); !item.Quantity().Equal(decimal.New(10, 0)) {
+		t.Fatalf(`A failed sale dispensed stock, quantity is %s`, item.Quantity())
+	}
+
+	sale := &Sale{PaymentMethod: CARD, Lines: []*SaleLine{
+		{StockID: feed.ID(), Quantity: decimal.New(2, 0), UnitPrice: decimal.New(25, 0), Discount: decimal.New(10, 0), VATRate: decimal.New(20, 0)},
+		{StockID: collar.ID(), Quantity: decimal.New(1, 0), UnitPrice: decimal.New(399, -2), VATRate: decimal.New(20, 0)},
+	}}
+	if err := wh.CreateSale(sale); err != nil {
+		t.Fatalf(`CreateSale returns an error for a valid sale: %s`, err)
+	}
+	if sale.Number != 1 || !sale.Total().Equal(decimal.New(4899, -2)) || !sale.VAT().Equal(decimal.New(817, -2)) {
+		t.Fatalf(`Unexpected sale #%d with total %s and VAT %s`, sale.Number, sale.Total(), sale.VAT())
+	}
+	if item, _ := wh.ReadStock(feed.ID()); !item.Quantity().Equal(decimal.New(8, 0)) {
+		t.Fatalf(`Expected quantity 8 after the sale, got %s`, item.Quantity())
+	}
+
+	refunds := []struct {
+		refund *Refund
+		valid  bool
+		amount decimal.Decimal
+		stock  decimal.Decimal
+	}{
+		{&Refund{PaymentMethod: CARD, Lines: []RefundLine{{Line: 0, Quantity: decimal.New(1, 0), Restock: true}}}, true, decimal.New(225, -1), decimal.New(9, 0)},
+		{&Refund{PaymentMethod: CASH, Reason: "torn bag", Lines: []RefundLine{{Line: 0, Quantity: decimal.New(1, 0)}}}, true, decimal.New(225, -1), decimal.New(9, 0)},
+		{&Refund{PaymentMethod: CASH, Lines: []RefundLine{{Line: 0, Quantity: decimal.New(1, 0), Restock: true}}}, false, decimal.Zero, decimal.New(9, 0)},
+		{&Refund{PaymentMethod: CASH, Lines: []RefundLine{{Line: 2, Quantity: decimal.New(1, 0)}}}, false, decimal.Zero, decimal.New(9, 0)},
+	}
+	for i, test := range refunds {
+		test.refund.SaleID = sale.ID
+		err := wh.RefundSale(test.refund)
+		if (err == nil) != test.valid {
+			t.Fatalf(`RefundSale returns %v for refund %d`, err, i)
+		}
+		if test.valid && !test.refund.Amount().Equal(test.amount) {
+			t.Errorf(`Expected refund %d of %s, got %s`, i, test.amount, test.refund.Amount())
+		}
+		if item, _ := wh.ReadStock(feed.ID()); !item.Quantity().Equal(test.stock) {
+			t.Errorf(`Expected quantity %s after refund %d, got %s`, test.stock, i, item.Quantity())
+		}
+	}
+
+	stored, _ := wh.ReadSale(sale.ID)
+	if !stored.Refunded().Equal(decimal.New(45, 0)) || len(wh.Refunds(sale.ID)) != 2 {
+		t.Fatalf(`Unexpected refunds of the sale: %s`, stored.Refunded())
+	}
+
+	resp, err := http.Get(buildURL(s.URL, fmt.Sprintf("/data/sales/%s/receipt", sale.ID)))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	text := &bytes.Buffer{}
+	text.ReadFrom(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{"Sale #1", "Dog food 10kg", "Discount 10%", "48.99", "incl. VAT 20%", "-22.50"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("Expected %q in the receipt:\n%s", want, text)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(text.String()), "\n") {
+		if len([]rune(line)) > receiptWidth {
+			t.Errorf("The receipt line %q is wider than the receipt", line)
+		}
+	}
+
+	resp, err = http.Get(buildURL(s.URL, fmt.Sprintf("/data/sales/%s/receipt?format=pdf", sale.ID)))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	pdf := &bytes.Buffer{}
+	pdf.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.HasPrefix(pdf.Bytes(), []byte("%PDF")) {
+		t.Fatalf("Expected a PDF receipt, got %d", resp.StatusCode)
+	}
+}
+
+func TestConcurrentSales(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	wh := NewWarehouse(db)
+
+	collar, _ := defaultUnexpirableStockItem(ACCESSORY)
+	collar.SetQuantity(decimal.New(100, 0))
+	wh.CreateStock(collar, nil)
+
+	const sales = 10
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		numbers = make(map[int]bool)
+	)
+	for i := 0; i < sales; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf(`CreateSale panics for concurrent sales: %v`, r)
+				}
+			}()
+
+			sale := &Sale{PaymentMethod: CASH, Lines: []*SaleLine{{StockID: collar.ID(), Quantity: decimal.New(1, 0)}}}
+			if err := wh.CreateSale(sale); err != nil {
+				t.Errorf(`CreateSale returns an error for concurrent sales: %s`, err)
+				return
+			}
+			mutex.Lock()
+			numbers[sale.Number] = true
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(numbers) != sales {
+		t.Fatalf(`Expected %d different sale numbers, got %v`, sales, numbers)
+	}
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is a parsed cron expression with the fields
+// minute, hour, day of month, month and day of week
+type schedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+
+	// anyDay and anyWeekday are set if the day fields are *.
+	// As in cron, if both day fields are restricted, a time matches either of them.
+	anyDay     bool
+	anyWeekday bool
+}
+
+// scheduleAliases are the supported shorthands for common schedules
+var scheduleAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseSchedule parses a cron expression like "30 2 * * 1-5" or one of the aliases.
+// Fields can be *, numbers, ranges (1-5), lists (1,15) and steps (*/15, 0-30/10).
+func parseSchedule(spec string) (*schedule, error) {
+	if alias, ok := scheduleAliases[strings.TrimSpace(spec)]; ok {
+		spec = alias
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q must have 5 fields", spec)
+	}
+
+	var (
+		s      = &schedule{}
+		err    error
+		bounds = []struct {
+			field    *uint64
+			min, max int
+		}{
+			{&s.minutes, 0, 59},
+			{&s.hours, 0, 23},
+			{&s.days, 1, 31},
+			{&s.months, 1, 12},
+			{&s.weekdays, 0, 7},
+		}
+	)
+	for i, b := range bounds {
+		if *b.field, err = parseScheduleField(fields[i], b.min, b.max); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s", spec, err)
+		}
+	}
+
+	// 7 is Sunday as well as 0
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	s.anyDay = fields[2] == "*"
+	s.anyWeekday = fields[4] == "*"
+
+	return s, nil
+}
+
+func parseScheduleField(field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		var (
+			rng  = part
+			step = 1
+			err  error
+		)
+		if i := strings.Index(part, "/"); i >= 0 {
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		from, to := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (s *schedule) dayMatches(t time.Time) bool {
+	var (
+		day     = s.days&(1<<uint(t.Day())) != 0
+		weekday = s.weekdays&(1<<uint(t.Weekday())) != 0
+	)
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// matches checks if the schedule runs in the minute of t
+func (s *schedule) matches(t time.Time) bool {
+	return s.minutes&(1<<uint(t.Minute())) != 0 &&
+		s.hours&(1<<uint(t.Hour())) != 0 &&
+		s.months&(1<<uint(t.Month())) != 0 &&
+		s.dayMatches(t)
+}
+
+// next returns the first minute after t in which the schedule runs
+// or the zero time if there is no such minute in the next five years
+func (s *schedule) next(t time.Time) time.Time {
+	var (
+		limit = t.AddDate(5, 0, 0)
+		n     = t.Truncate(time.Minute).Add(time.Minute)
+	)
+
+	for n.Before(limit) {
+		switch {
+		case s.months&(1<<uint(n.Month())) == 0:
+			n = time.Date(n.Year(), n.Month()+1, 1, 0, 0, 0, 0, n.Location())
+		case !s.dayMatches(n):
+			n = time.Date(n.Year(), n.Month(), n.Day()+1, 0, 0, 0, 0, n.Location())
+		case s.hours&(1<<uint(n.Hour())) == 0:
+			n = time.Date(n.Year(), n.Month(), n.Day(), n.Hour()+1, 0, 0, 0, n.Location())
+		case s.minutes&(1<<uint(n.Minute())) == 0:
+			n = n.Add(time.Minute)
+		default:
+			return n
+		}
+	}
+
+	return time.Time{}
+}
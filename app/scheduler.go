@@ -0,0 +1,405 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobFunc is the work done by a scheduled job. It returns a short description of the outcome.
+type JobFunc func(now time.Time) (string, error)
+
+// ErrJobRunning is returned when a job is triggered while it is still running
+var ErrJobRunning = errors.New("the job is already running")
+
+// JobStatus is the schedule and the last run of a job
+type JobStatus struct {
+	Name     string
+	Schedule string
+	Enabled  bool
+	Running  bool
+
+	// LastRun is zero if the job has never run
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastOutcome  string
+	LastError    string
+
+	// NextRun is zero for disabled jobs
+	NextRun time.Time
+}
+
+// Scheduler runs jobs in the background on cron-like schedules.
+// A job never runs more than once at the same time.
+type Scheduler interface {
+	// AddJob() registers a job with its default schedule.
+	// A schedule saved with SetSchedule() overrides the default one.
+	AddJob(name, spec string, run JobFunc) error
+
+	// SetSchedule() changes and saves the schedule of a job and enables or disables it.
+	// The change is recorded in the audit trail and rolled back if it cannot be recorded.
+	SetSchedule(name, spec string, enabled bool, trail *auditTrail) error
+
+	Job(string) (JobStatus, bool)
+	// Jobs() returns the status of all jobs ordered by name
+	Jobs() []JobStatus
+
+	// Trigger() runs a job immediately and waits for it to finish
+	Trigger(string) (JobStatus, error)
+
+	// Start() starts running the jobs on their schedules, Stop() stops it
+	Start()
+	Stop()
+}
+
+type scheduledJob struct {
+	name     string
+	spec     string
+	schedule *schedule
+	enabled  bool
+	run      JobFunc
+	running  bool
+}
+
+type defaultScheduler struct {
+	database *sql.DB
+
+	mutex sync.Mutex
+	jobs  map[string]*scheduledJob
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler that keeps the job schedules and the last runs
+// in a sqlite3 table inside the db that is passed as an argument
+func NewScheduler(db *sql.DB) Scheduler {
+	s := &defaultScheduler{
+		database: db,
+		jobs:     make(map[string]*scheduledJob),
+	}
+
+	s.initJobsTable()
+
+	return s
+}
+
+func (s *defaultScheduler) initJobsTable() {
+	jobsTable := `
+	CREATE TABLE IF NOT EXISTS
+		scheduled_jobs (
+			name TEXT NOT NULL PRIMARY KEY,
+			schedule TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL,
+			last_run DATETIME,
+			last_duration INTEGER NOT NULL DEFAULT 0,
+			last_outcome TEXT NOT NULL DEFAULT '',
+			last_error TEXT NOT NULL DEFAULT ''
+	);
+	`
+	_, err := s.database.Exec(jobsTable)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (s *defaultScheduler) AddJob(name, spec string, run JobFunc) error {
+	_, err := s.database.Exec(`
+		INSERT OR IGNORE INTO
+			scheduled_jobs (
+				name,
+				schedule,
+				enabled)
+		VALUES(?, ?, 1)
+	`, name, spec)
+	if err != nil {
+		panic(err)
+	}
+
+	var enabled bool
+	err = s.database.QueryRow(`
+		SELECT
+			schedule,
+			enabled
+		FROM
+			scheduled_jobs
+		WHERE
+			name = ?
+	`, name).Scan(&spec, &enabled)
+	if err != nil {
+		panic(err)
+	}
+
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.jobs[name] = &scheduledJob{
+		name:     name,
+		spec:     spec,
+		schedule: sched,
+		enabled:  enabled,
+		run:      run,
+	}
+	return nil
+}
+
+func (s *defaultScheduler) SetSchedule(name, spec string, enabled bool, trail *auditTrail) error {
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		return ValidationErrors{{"schedule", err.Error()}}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[name]
+	if !ok {
+		return errors.New("no such job")
+	}
+
+	tx, err := s.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE
+			scheduled_jobs
+		SET
+			schedule = ?,
+			enabled = ?
+		WHERE
+			name = ?
+	`, spec, enabled, name)
+	if err != nil {
+		panic(err)
+	}
+
+	// only the schedule is a setting, the runs of the job are not audited
+	err = trail.recordTx(tx, AUDIT_JOB, name,
+		&JobScheduleDTO{Schedule: job.spec, Enabled: job.enabled},
+		&JobScheduleDTO{Schedule: spec, Enabled: enabled})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	job.spec = spec
+	job.schedule = sched
+	job.enabled = enabled
+	return nil
+}
+
+// status returns the status of the job. The scheduler's mutex must be held.
+func (s *defaultScheduler) status(job *scheduledJob) JobStatus {
+	var (
+		st = JobStatus{
+			Name:     job.name,
+			Schedule: job.spec,
+			Enabled:  job.enabled,
+			Running:  job.running,
+		}
+		lastRun  *time.Time
+		duration int64
+	)
+
+	err := s.database.QueryRow(`
+		SELECT
+			last_run,
+			last_duration,
+			last_outcome,
+			last_error
+		FROM
+			scheduled_jobs
+		WHERE
+			name = ?
+	`, job.name).Scan(&lastRun, &duration, &st.LastOutcome, &st.LastError)
+	if err != nil {
+		panic(err)
+	}
+
+	if lastRun != nil {
+		st.LastRun = *lastRun
+	}
+	st.LastDuration = time.Duration(duration)
+	if job.enabled {
+		st.NextRun = job.schedule.next(time.Now())
+	}
+
+	return st
+}
+
+func (s *defaultScheduler) Job(name string) (JobStatus, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[name]
+	if !ok {
+		return JobStatus{}, false
+	}
+	return s.status(job), true
+}
+
+func (s *defaultScheduler) Jobs() []JobStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	jobs := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, s.status(job))
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+
+	return jobs
+}
+
+// acquire marks the job as running. It returns ErrJobRunning if the job is already running.
+func (s *defaultScheduler) acquire(name string) (*scheduledJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[name]
+	if !ok {
+		return nil, errors.New("no such job")
+	}
+	if job.running {
+		return nil, ErrJobRunning
+	}
+
+	job.running = true
+	return job, nil
+}
+
+// runJob runs the job and records the outcome of the run
+func (s *defaultScheduler) runJob(job *scheduledJob, now time.Time) {
+	var (
+		start        = time.Now()
+		outcome, err = safeRun(job.run, now)
+		errMsg       = ""
+	)
+	if err != nil {
+		errMsg = err.Error()
+		log.Printf("Error in job %s: %s", job.name, err)
+	}
+
+	_, dbErr := s.database.Exec(`
+		UPDATE
+			scheduled_jobs
+		SET
+			last_run = ?,
+			last_duration = ?,
+			last_outcome = ?,
+			last_error = ?
+		WHERE
+			name = ?
+	`, start.UTC(), int64(time.Since(start)), outcome, errMsg, job.name)
+	if dbErr != nil {
+		log.Printf("Error in recording run of job %s: %s", job.name, dbErr)
+	}
+
+	s.mutex.Lock()
+	job.running = false
+	s.mutex.Unlock()
+}
+
+// safeRun runs the job and turns a panic in it into an error,
+// so a failing job does not stop the server
+func safeRun(run JobFunc, now time.Time) (outcome string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("job panicked")
+			log.Printf("Panic in job: %v", r)
+		}
+	}()
+
+	return run(now)
+}
+
+func (s *defaultScheduler) Trigger(name string) (JobStatus, error) {
+	job, err := s.acquire(name)
+	if err != nil {
+		return JobStatus{}, err
+	}
+
+	s.runJob(job, time.Now())
+
+	st, _ := s.Job(name)
+	return st, nil
+}
+
+// tick starts the enabled jobs that are scheduled in the minute of now
+// and are not running already
+func (s *defaultScheduler) tick(now time.Time) {
+	s.mutex.Lock()
+	due := make([]string, 0)
+	for name, job := range s.jobs {
+		if job.enabled && !job.running && job.schedule.matches(now) {
+			due = append(due, name)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, name := range due {
+		job, err := s.acquire(name)
+		if err != nil {
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runJob(job, now)
+		}()
+	}
+}
+
+func (s *defaultScheduler) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+
+	s.wg.Add(1)
+	go func(stop chan struct{}) {
+		defer s.wg.Done()
+		for {
+			now := time.Now()
+			timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+			select {
+			case t := <-timer.C:
+				s.tick(t.Truncate(time.Minute))
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}(s.stop)
+}
+
+// Stop stops scheduling the jobs and waits for the running ones to finish
+func (s *defaultScheduler) Stop() {
+	s.mutex.Lock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
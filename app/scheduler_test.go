@@ -0,0 +1,144 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	var (
+		monday = time.Date(2030, 1, 7, 2, 30, 0, 0, time.UTC)
+		sunday = time.Date(2030, 1, 6, 2, 30, 0, 0, time.UTC)
+	)
+
+	tests := []struct {
+		spec    string
+		valid   bool
+		time    time.Time
+		matches bool
+	}{
+		{"30 2 * * *", true, monday, true},
+		{"*/15 * * * *", true, monday, true},
+		{"*/20 * * * *", true, monday, false},
+		{"30 2 * * 1-5", true, sunday, false},
+		{"30 2 * * 7", true, sunday, true},
+		{"30 2 15 * 1", true, monday, true},
+		{"@daily", true, monday, false},
+		{"60 * * * *", false, monday, false},
+		{"* * *", false, monday, false},
+		{"*/0 * * * *", false, monday, false},
+	}
+
+	for _, test := range tests {
+		s, err := parseSchedule(test.spec)
+		if (err == nil) != test.valid {
+			t.Errorf(`parseSchedule(%q) returns error %v`, test.spec, err)
+			continue
+		}
+		if err == nil && s.matches(test.time) != test.matches {
+			t.Errorf(`Schedule %q matches %s: %t`, test.spec, test.time, !test.matches)
+		}
+	}
+
+	s, _ := parseSchedule("0 2 * * *")
+	if next := s.next(monday); !next.Equal(time.Date(2030, 1, 8, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf(`Unexpected next run %s`, next)
+	}
+	s, _ = parseSchedule("0 0 29 2 *")
+	if next := s.next(monday); !next.Equal(time.Date(2032, 2, 29, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf(`Unexpected next run %s`, next)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	var (
+		scheduler = NewScheduler(db)
+		started   = make(chan struct{})
+		release   = make(chan struct{})
+	)
+
+	if err := scheduler.AddJob("failing", "@daily", func(time.Time) (string, error) { return "", errors.New("broken") }); err != nil {
+		t.Fatalf(`AddJob returns an error for a valid schedule: %s`, err)
+	}
+	scheduler.AddJob("slow", "@hourly", func(time.Time) (string, error) {
+		close(started)
+		<-release
+		return "done", nil
+	})
+
+	job, err := scheduler.Trigger("failing")
+	if err != nil || job.LastError != "broken" || job.LastRun.IsZero() {
+		t.Fatalf(`Unexpected status after a failing run: %+v, %v`, job, err)
+	}
+
+	done := make(chan JobStatus)
+	go func() {
+		job, _ := scheduler.Trigger("slow")
+		done <- job
+	}()
+	<-started
+	if _, err := scheduler.Trigger("slow"); err != ErrJobRunning {
+		t.Fatalf(`Trigger runs a job that is already running: %v`, err)
+	}
+	close(release)
+	if job := <-done; job.LastOutcome != "done" || job.Running {
+		t.Fatalf(`Unexpected status after a run: %+v`, job)
+	}
+
+	if err := scheduler.SetSchedule("slow", "not a schedule", true, nil); err == nil {
+		t.Fatalf(`SetSchedule accepts an invalid schedule`)
+	}
+	if err := scheduler.SetSchedule("slow", "0 3 * * *", false, nil); err != nil {
+		t.Fatalf(`SetSchedule returns an error for a valid schedule: %s`, err)
+	}
+
+	// the saved schedule overrides the default one
+	scheduler = NewScheduler(db)
+	scheduler.AddJob("slow", "@hourly", func(time.Time) (string, error) { return "", nil })
+	if job, _ := scheduler.Job("slow"); job.Schedule != "0 3 * * *" || job.Enabled || job.LastOutcome != "done" {
+		t.Fatalf(`Schedule or last run is not saved: %+v`, job)
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	requests := []struct {
+		path   string
+		status int
+	}{
+		{"/data/jobs/expiry-scan/run", http.StatusOK},
+		{"/data/jobs/low-stock-scan/run", http.StatusOK},
+		{"/data/jobs/unknown/run", http.StatusNotFound},
+	}
+
+	for _, req := range requests {
+		resp, err := http.Post(buildURL(s.URL, req.path), "application/json", nil)
+		if err != nil {
+			t.Fatalf("Error sending POST request: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != req.status {
+			t.Errorf("Expected %d but got %d for %s", req.status, resp.StatusCode, req.path)
+		}
+	}
+
+	if job, _ := madminHandler.scheduler.Job("expiry-scan"); job.LastOutcome == "" {
+		t.Fatalf(`Triggered job has no outcome`)
+	}
+}
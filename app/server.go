@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -29,7 +30,10 @@ func NewMAdminServer(port, dbPath string) *madminServer {
 	r.Handle("/data/{path:.*}", authMiddleware(maHandler, maUserManager))
 	r.Handle("/{path:.*}", authMiddleware(http.FileServer(http.Dir("static/")), maUserManager))
 
-	registerCleanUp(database)
+	maHandler.scheduler.Start()
+	maHandler.webhooks.Start()
+
+	registerCleanUp(database, maHandler)
 
 	return &madminServer{
 		&http.Server{Addr: port, Handler: r},
@@ -37,12 +41,14 @@ func NewMAdminServer(port, dbPath string) *madminServer {
 }
 
 // todo: move functionalities to the (m madminServer) Shutdown() method
-func registerCleanUp(db *sql.DB) {
+func registerCleanUp(db *sql.DB, m *madminHandler) {
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		for range c {
 			// todo: gracefully shut down http server and close db
+			m.scheduler.Stop()
+			m.webhooks.Stop()
 			db.Close()
 
 			os.Exit(0)
@@ -56,27 +62,167 @@ type madminHandler struct {
 	userManager UserManager
 
 	warehouse Warehouse
+	clients   ClientManager
+	scheduler Scheduler
+	notifier  Notifier
+	webhooks  WebhookManager
+	events    *eventBroker
+	audit     AuditLog
 	database  *sql.DB
 }
 
+// ServeHTTP routes the request with its id, which is taken from the X-Request-ID header
+// or generated if it is not set, and sends the id back in the same header
 func (m madminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	m.router.ServeHTTP(w, r)
+	id := r.Header.Get("X-Request-ID")
+	if id == "" {
+		id, _ = newUUID()
+	}
+	w.Header().Set("X-Request-ID", id)
+
+	m.router.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id)))
 }
 
+// idPattern matches the UUIDs used as ids of the stock items and the other entities in madmin
+const idPattern = "[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}"
+
 func NewMAdminHandler(db *sql.DB) *madminHandler {
 	maHandler := &madminHandler{}
 
 	maHandler.database = db
 	maHandler.userManager = NewUserManager(maHandler.database)
 	maHandler.warehouse = NewWarehouse(maHandler.database)
+	maHandler.clients = maHandler.warehouse.Clients()
+	maHandler.scheduler = NewScheduler(maHandler.database)
+	maHandler.audit = NewAuditLog(maHandler.database)
+
+	var mailer Mailer
+	if config, ok := smtpConfigFromEnv(); ok {
+		mailer = NewSMTPMailer(config)
+	}
+	maHandler.notifier = NewNotifier(maHandler.database, maHandler.userManager, mailer)
+
+	maHandler.webhooks = NewWebhookManager(maHandler.database)
+	maHandler.warehouse.AddListener(maHandler.webhooks.Publish)
+	maHandler.events = newEventBroker()
+	maHandler.warehouse.AddListener(maHandler.events.Publish)
+
+	maHandler.registerJobs()
 
 	maHandler.router = mux.NewRouter()
 
-	maHandler.router.HandleFunc("/data/stock/{id:....-..-..-..-......}", maHandler.stockItemHandler).Methods("GET", "DELETE", "PUT")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}", maHandler.stockItemHandler).Methods("GET", "DELETE", "PUT")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/movements", maHandler.movementsHandler).Methods("GET", "POST")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/lots", maHandler.listLotsHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/barcodes", maHandler.barcodesHandler).Methods("GET", "POST")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/barcodes/{code:[0-9]+}", maHandler.removeBarcodeHandler).Methods("DELETE")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/label", maHandler.labelHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/dosing", maHandler.dosingHandler).Methods("GET", "PUT")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/dose", maHandler.doseHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/equivalence", maHandler.equivalenceHandler).Methods("GET", "PUT")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/substitutes", maHandler.substitutesHandler).Methods("GET", "POST")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/substitutes/{substituteID:"+idPattern+"}", maHandler.removeSubstituteHandler).Methods("DELETE")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/bundle", maHandler.bundleHandler).Methods("GET", "PUT")
+	maHandler.router.HandleFunc("/data/stock/{id:"+idPattern+"}/assemble", maHandler.assembleBundleHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/stock/by-barcode/{code}", maHandler.stockByBarcodeHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/stock/scan", maHandler.scanHandler).Methods("POST")
 	maHandler.router.HandleFunc("/data/stock/", maHandler.stockHandler).Methods("GET", "POST")
 	maHandler.router.HandleFunc("/data/stock/insufficient/", maHandler.insufficientStockHandler).Methods("GET")
 	maHandler.router.HandleFunc("/data/stock/expiring/", maHandler.expiringStockHandler).Methods("GET")
 
+	maHandler.router.HandleFunc("/data/controlled/{id:"+idPattern+"}/register", maHandler.controlledRegisterHandler).Methods("GET")
+
+	maHandler.router.HandleFunc("/data/lots/{id:"+idPattern+"}", maHandler.lotHandler).Methods("GET", "PUT")
+	maHandler.router.HandleFunc("/data/lots/{id:"+idPattern+"}/state", maHandler.lotStateHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/lots/{id:"+idPattern+"}/history", maHandler.lotHistoryHandler).Methods("GET")
+
+	maHandler.router.HandleFunc("/data/locations/{id:"+idPattern+"}", maHandler.locationHandler).Methods("GET", "DELETE", "PUT")
+	maHandler.router.HandleFunc("/data/locations/", maHandler.locationsHandler).Methods("GET", "POST")
+	maHandler.router.HandleFunc("/data/locations/{id:"+idPattern+"}/temperature", maHandler.temperatureHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/locations/{id:"+idPattern+"}/excursions", maHandler.listExcursionsHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/locations/{id:"+idPattern+"}/excursions/{excursionID:"+idPattern+"}/review", maHandler.reviewExcursionHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/locations/{id:"+idPattern+"}/stock", maHandler.locationStockHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/locations/{id:"+idPattern+"}/stock/{stockID:"+idPattern+"}", maHandler.locationMinQuantityHandler).Methods("PUT")
+
+	maHandler.router.HandleFunc("/data/transfers/{id:"+idPattern+"}", maHandler.getTransferHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/transfers/{id:"+idPattern+"}/{action:dispatch|receive|decline}", maHandler.transferStateHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/transfers/", maHandler.transfersHandler).Methods("GET", "POST")
+
+	maHandler.router.HandleFunc("/data/owners/{id:"+idPattern+"}", maHandler.ownerHandler).Methods("GET", "DELETE", "PUT")
+	maHandler.router.HandleFunc("/data/owners/{id:"+idPattern+"}/patients", maHandler.ownerPatientsHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/owners/", maHandler.ownersHandler).Methods("GET", "POST")
+	maHandler.router.HandleFunc("/data/patients/{id:"+idPattern+"}", maHandler.patientHandler).Methods("GET", "DELETE", "PUT")
+	maHandler.router.HandleFunc("/data/patients/{id:"+idPattern+"}/medications", maHandler.patientMedicationsHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/patients/{id:"+idPattern+"}/prescriptions", maHandler.patientPrescriptionsHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/patients/", maHandler.patientsHandler).Methods("GET", "POST")
+
+	maHandler.router.HandleFunc("/data/prescriptions/{id:"+idPattern+"}", maHandler.getPrescriptionHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/prescriptions/{id:"+idPattern+"}/document", maHandler.prescriptionDocumentHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/prescriptions/", maHandler.addPrescriptionHandler).Methods("POST")
+
+	maHandler.router.HandleFunc("/data/sales/{id:"+idPattern+"}", maHandler.getSaleHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/sales/{id:"+idPattern+"}/receipt", maHandler.saleReceiptHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/sales/{id:"+idPattern+"}/refunds", maHandler.refundSaleHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/sales/", maHandler.salesHandler).Methods("GET", "POST")
+
+	maHandler.router.HandleFunc("/data/purchase-orders/{id:"+idPattern+"}", maHandler.getPurchaseOrderHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/purchase-orders/", maHandler.purchaseOrdersHandler).Methods("GET", "POST")
+
+	maHandler.router.HandleFunc("/data/invoices/{id:"+idPattern+"}", maHandler.getInvoiceHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/invoices/{id:"+idPattern+"}/match", maHandler.invoiceMatchHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/invoices/{id:"+idPattern+"}/approve", maHandler.approveInvoiceHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/invoices/{id:"+idPattern+"}/pay", maHandler.payInvoiceHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/invoices/", maHandler.invoicesHandler).Methods("GET", "POST")
+	maHandler.router.HandleFunc("/data/distributors/{id:"+idPattern+"}/payables", maHandler.payablesHandler).Methods("GET")
+
+	maHandler.router.HandleFunc("/data/recalls/{id:"+idPattern+"}", maHandler.getRecallHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/recalls/{id:"+idPattern+"}/dispenses", maHandler.recallDispensesHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/recalls/{id:"+idPattern+"}/return", maHandler.recallReturnHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/recalls/", maHandler.recallsHandler).Methods("GET", "POST")
+
+	maHandler.router.HandleFunc("/data/stocktakes/{id:"+idPattern+"}", maHandler.getStocktakeHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/stocktakes/{id:"+idPattern+"}/sheet", maHandler.stocktakeSheetHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/stocktakes/{id:"+idPattern+"}/counts", maHandler.stocktakeCountsHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/stocktakes/{id:"+idPattern+"}/variances", maHandler.stocktakeVariancesHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/stocktakes/{id:"+idPattern+"}/approve", maHandler.approveStocktakeHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/stocktakes/{id:"+idPattern+"}/cancel", maHandler.cancelStocktakeHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/stocktakes/", maHandler.stocktakesHandler).Methods("GET", "POST")
+
+	maHandler.router.HandleFunc("/data/reports/write-offs", maHandler.writeOffReportHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/reports/reorder-points", maHandler.reorderReportHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/reports/reorder-points/apply", maHandler.applyReorderPointsHandler).Methods("POST")
+	maHandler.router.HandleFunc("/data/reports/abc", maHandler.abcReportHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/reports/turnover", maHandler.turnoverReportHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/reports/dead-stock", maHandler.deadStockReportHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/reports/expiry-losses", maHandler.expiryLossReportHandler).Methods("GET")
+
+	maHandler.router.HandleFunc("/data/snapshots/", maHandler.listSnapshotsHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/snapshots/{id:"+idPattern+"}", maHandler.getSnapshotHandler).Methods("GET")
+
+	maHandler.router.HandleFunc("/data/distributors/{id:"+idPattern+"}", maHandler.distributorHandler).Methods("GET", "DELETE", "PUT")
+	maHandler.router.HandleFunc("/data/distributors/", maHandler.distributorsHandler).Methods("GET", "POST")
+
+	maHandler.router.HandleFunc("/data/users/{id:"+idPattern+"}", maHandler.userHandler).Methods("GET", "DELETE", "PUT")
+	maHandler.router.HandleFunc("/data/users/", maHandler.usersHandler).Methods("GET", "POST")
+
+	maHandler.router.HandleFunc("/data/audit", maHandler.auditHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/audit/verify", maHandler.verifyAuditHandler).Methods("GET")
+
+	maHandler.router.HandleFunc("/data/events", maHandler.eventsHandler).Methods("GET")
+
+	maHandler.router.HandleFunc("/data/webhooks/{id:"+idPattern+"}", maHandler.webhookHandler).Methods("GET", "DELETE", "PUT")
+	maHandler.router.HandleFunc("/data/webhooks/{id:"+idPattern+"}/deliveries", maHandler.webhookDeliveriesHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/webhooks/", maHandler.webhooksHandler).Methods("GET", "POST")
+
+	maHandler.router.HandleFunc("/data/notifications/preferences", maHandler.notificationPreferencesHandler).Methods("GET", "PUT")
+
+	maHandler.router.HandleFunc("/data/jobs/", maHandler.listJobsHandler).Methods("GET")
+	maHandler.router.HandleFunc("/data/jobs/{name:[a-z-]+}", maHandler.jobHandler).Methods("GET", "PUT")
+	maHandler.router.HandleFunc("/data/jobs/{name:[a-z-]+}/run", maHandler.runJobHandler).Methods("POST")
+
+	maHandler.router.HandleFunc("/data/stock-types/{id:[0-9]+}", maHandler.stockTypeHandler).Methods("GET", "DELETE", "PUT")
+	maHandler.router.HandleFunc("/data/stock-types/", maHandler.stockTypesHandler).Methods("GET", "POST")
+
 	return maHandler
 }
 
@@ -97,26 +243,49 @@ func (m *madminHandler) stockItemHandler(w http.ResponseWriter, r *http.Request)
 		m.getStockItemHandler(w, r)
 	case "DELETE":
 		m.removeStockItemHandler(w, r)
-	case "PUt":
+	case "PUT":
 		m.updateStockItemHandler(w, r)
 	default:
 		respondMethodNotAllowed(w, r)
 	}
 }
 
-// Handler for GET /stock/
+// Handler for GET /stock/?location=<id>&asOf=<date>
 //
-// Lists existing stock items.
+// Lists existing stock items. With location, only the stock items
+// in the storage location or with a minimum quantity for it are listed.
+// With asOf, the stock items are returned as they were at that time.
 func (m *madminHandler) listStockHandler(w http.ResponseWriter, r *http.Request) {
+	asOf, ok := asOfParam(w, r)
+	if !ok {
+		return
+	}
+	if asOf != nil {
+		m.inventoryAsOfHandler(w, r, *asOf)
+		return
+	}
+
+	locationID, ok := m.locationScope(w, r)
+	if !ok {
+		return
+	}
+
 	var (
 		resp = &CollectionResponseDTO{"List of existing stock items", make([]string, 0, m.warehouse.Size())}
 
 		itemURL string
 	)
 
-	for _, item := range m.warehouse.Stock() {
-		itemURL = fmt.Sprintf("/data/stock/%s", item.ID())
-		resp.URLs = append(resp.URLs, itemURL)
+	if locationID != "" {
+		for _, level := range m.warehouse.StockLevels(locationID) {
+			itemURL = fmt.Sprintf("/data/stock/%s", level.StockID)
+			resp.URLs = append(resp.URLs, itemURL)
+		}
+	} else {
+		for _, item := range m.warehouse.Stock() {
+			itemURL = fmt.Sprintf("/data/stock/%s", item.ID())
+			resp.URLs = append(resp.URLs, itemURL)
+		}
 	}
 
 	respBytes, err := json.Marshal(resp)
@@ -132,11 +301,19 @@ func (m *madminHandler) listStockHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-// Handler for GET /stock/<id>
+// Handler for GET /stock/<id>?asOf=<date>
 //
 // Returns JSON with data for the stock item with the given id (if such item exists in the warehouse)
 // or an emptry response with status code 204 (if there is no such item in the warehouse).
+// With asOf, the quantity and the lots of the item are returned as they were at that time.
 func (m *madminHandler) getStockItemHandler(w http.ResponseWriter, r *http.Request) {
+	if asOf, valid := asOfParam(w, r); !valid {
+		return
+	} else if asOf != nil {
+		m.stockItemAsOfHandler(w, r, *asOf)
+		return
+	}
+
 	var (
 		query = r.URL
 		_, id = path.Split(query.String())
@@ -149,16 +326,8 @@ func (m *madminHandler) getStockItemHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	resp := &StockDTO{}
-
-	resp.ID = item.ID()
-	resp.Name = item.Name()
-	resp.Type = item.Type()
-	if item.IsExpirable() {
-		resp.ExpirationDate = item.ExpirationDate().String()
-	}
-	resp.MinQuantity = item.MinQuantity().String()
-	resp.DistributorID = item.DistributorID()
+	resp := newStockDTO(item)
+	resp.Barcodes = m.warehouse.Barcodes(item.ID())
 
 	respBytes, err := json.Marshal(resp)
 	if err != nil {
@@ -190,13 +359,20 @@ func (m *madminHandler) addStockHandler(w http.ResponseWriter, r *http.Request)
 	}
 	defer r.Body.Close()
 
-	stockItem, err := NewStock(newItem)
+	stockItem, err := NewStock(newItem, m.warehouse.StockTypes())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("Error in creating stock item: %s", err)
+		respondBadRequest(w, err)
+		return
+	}
+	if stockItem.IsControlled() && !stockItem.Quantity().IsZero() {
+		respondBadRequest(w, ValidationErrors{{"quantity", "controlled substances can only be received through the register"}})
+		return
+	}
+	if err := m.warehouse.CreateStock(stockItem, m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
 		return
 	}
-	m.warehouse.CreateStock(stockItem)
 
 	w.WriteHeader(http.StatusCreated)
 	if _, err := w.Write([]byte(stockItem.ID())); err != nil {
@@ -212,7 +388,22 @@ func (m *madminHandler) removeStockItemHandler(w http.ResponseWriter, r *http.Re
 		query = r.URL
 		_, id = path.Split(query.String())
 	)
-	m.warehouse.DeleteStock(id)
+	item, ok := m.warehouse.ReadStock(id)
+	if ok && item.IsControlled() {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "Error in removing stock item: controlled substances cannot be removed from the register")
+		return
+	}
+	// an item that did not move since it was created can be removed with its opening balance
+	if movements := m.warehouse.Movements(id); len(movements) > 1 || len(movements) == 1 && !movements[0].isOpeningBalance() {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "Error in removing stock item: the item has movements, write off its stock instead")
+		return
+	}
+	if err := m.warehouse.DeleteStock(id, m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -242,35 +433,72 @@ func (m *madminHandler) updateStockItemHandler(w http.ResponseWriter, r *http.Re
 	stockItem, ok := m.warehouse.ReadStock(id)
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
+		return
 	}
 
+	var (
+		wasControlled = stockItem.IsControlled()
+		quantity      = stockItem.Quantity()
+	)
+
 	err = stockItem.Update(*updateDto)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		respondBadRequest(w, err)
+		return
+	}
+
+	if (wasControlled || stockItem.IsControlled()) && !stockItem.Quantity().Equal(quantity) {
+		respondBadRequest(w, ValidationErrors{{"quantity", "the quantity of controlled substances can only be changed through the register"}})
+		return
+	}
+	if wasControlled && !stockItem.IsControlled() {
+		respondBadRequest(w, ValidationErrors{{"controlledSchedule", "controlled substances cannot be removed from the register"}})
+		return
+	}
+	// the register of a new controlled substance has no opening entry, so it must start from zero
+	if !wasControlled && stockItem.IsControlled() && !quantity.IsZero() {
+		respondBadRequest(w, ValidationErrors{{"controlledSchedule", "only stock items with zero quantity can become controlled substances"}})
+		return
 	}
 
-	m.warehouse.UpdateStock(stockItem)
+	// a new quantity is recorded as adjustments so that the ledger matches the stock item
+	if err := m.warehouse.UpdateStock(stockItem, requestUserID(r), m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
+		return
+	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
 
-// Handler for GET /stock/insufficient/
+// Handler for GET /stock/insufficient/?location=<id>
 //
-// Lists insufficient stock items
+// Lists insufficient stock items. With location, the stock items
+// below their minimum quantity in the storage location are listed.
+// The in-stock substitutes of the items are suggested by the items' ids.
 func (m *madminHandler) insufficientStockHandler(w http.ResponseWriter, r *http.Request) {
+	locationID, ok := m.locationScope(w, r)
+	if !ok {
+		return
+	}
 
-	stockItems := m.warehouse.Stock()
+	stockItems := insufficientStock(m.warehouse)
+	if locationID != "" {
+		stockItems = insufficientStockAt(m.warehouse, locationID)
+	}
 	insufficientStockItems := make([]string, 0, len(stockItems))
+	substitutes := make(map[string][]*SubstituteDTO)
+	available := m.warehouse.AvailableQuantities()
 
 	for _, stock := range stockItems {
-		if stock.Quantity().Cmp(stock.MinQuantity()) == -1 {
+		itemURL := fmt.Sprintf("/data/stock/%s", stock.ID())
+		insufficientStockItems = append(insufficientStockItems, itemURL)
 
-			itemURL := fmt.Sprintf("/data/stock/%s", stock.ID())
-			insufficientStockItems = append(insufficientStockItems, itemURL)
+		if inStock := inStockSubstitutes(m.warehouse.Substitutes(stock.ID(), available)); len(inStock) > 0 {
+			substitutes[stock.ID()] = newSubstituteDTOs(inStock)
 		}
 	}
 
-	resp := &CollectionResponseDTO{"List of insufficient stock items", insufficientStockItems}
+	resp := &InsufficientStockDTO{"List of insufficient stock items", insufficientStockItems, substitutes}
 
 	respBytes, err := json.Marshal(resp)
 	if err != nil {
@@ -288,24 +516,25 @@ func (m *madminHandler) insufficientStockHandler(w http.ResponseWriter, r *http.
 
 // TODO: add list of insufficient stock items for concrete distributor
 
-// Handler for GET /stock/expiring/
+// Handler for GET /stock/expiring/?location=<id>
 //
-// Lists expiring stock items
+// Lists expiring stock items. With location, only the stock items in the storage location are listed.
 func (m *madminHandler) expiringStockHandler(w http.ResponseWriter, r *http.Request) {
+	locationID, ok := m.locationScope(w, r)
+	if !ok {
+		return
+	}
 
-	var (
-		stockItems         = m.warehouse.Stock()
-		expiringStockItems = make([]string, 0, len(stockItems))
-		dateLimit          = time.Now().AddDate(0, 0, 7)
-	)
+	limit := time.Now().AddDate(0, 0, expiryWarningDays)
+	stockItems := expiringStock(m.warehouse, limit)
+	if locationID != "" {
+		stockItems = expiringStockAt(m.warehouse, locationID, limit)
+	}
+	expiringStockItems := make([]string, 0, len(stockItems))
 
 	for _, stock := range stockItems {
-		if stock.IsExpirable() {
-			if !stock.ExpirationDate().Before(dateLimit) {
-				itemURL := fmt.Sprintf("/data/stock/%s", stock.ID())
-				expiringStockItems = append(expiringStockItems, itemURL)
-			}
-		}
+		itemURL := fmt.Sprintf("/data/stock/%s", stock.ID())
+		expiringStockItems = append(expiringStockItems, itemURL)
 	}
 
 	resp := &CollectionResponseDTO{"List of existing stock items", expiringStockItems}
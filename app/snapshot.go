@@ -0,0 +1,682 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// InventorySnapshot is the inventory as it was at a point in time.
+// Snapshots are reconstructed from the ledger of stock movements on request,
+// or taken at the end of every month and kept in the DB to speed up the reconstruction.
+type InventorySnapshot struct {
+	// ID is empty for snapshots that are not kept in the DB
+	ID   string
+	Time time.Time
+
+	// Created is when a kept snapshot was taken. A snapshot is closed
+	// once all its items are saved and cannot be changed after that.
+	Created time.Time
+	Closed  bool
+
+	// Items are ordered by name
+	Items []*SnapshotItem
+}
+
+// SnapshotItem is a stock item with its quantity and lots at the time of a snapshot.
+// Items deleted after the snapshot are kept with the name they had when the snapshot was taken.
+type SnapshotItem struct {
+	StockID  string
+	Name     string
+	Quantity decimal.Decimal
+
+	// Lots are the lots with stock left, ordered by expiration date
+	Lots []*SnapshotLot
+}
+
+// SnapshotLot is the quantity of a lot at the time of a snapshot.
+// The parts of a lot split between storage locations are counted together.
+type SnapshotLot struct {
+	Number         string
+	ExpirationDate *time.Time
+	Quantity       decimal.Decimal
+}
+
+// snapshotChildTriggers keep the items and lots of closed snapshots from being changed
+const snapshotChildTriggers = `
+	CREATE TRIGGER IF NOT EXISTS
+		%[1]s_closed_insert BEFORE INSERT ON %[1]s
+		WHEN (SELECT closed FROM inventory_snapshots WHERE id = NEW.snapshot_id)
+	BEGIN
+		SELECT RAISE(ABORT, 'closed snapshots cannot be changed');
+	END;
+	CREATE TRIGGER IF NOT EXISTS
+		%[1]s_closed_update BEFORE UPDATE ON %[1]s
+		WHEN (SELECT closed FROM inventory_snapshots WHERE id = OLD.snapshot_id)
+	BEGIN
+		SELECT RAISE(ABORT, 'closed snapshots cannot be changed');
+	END;
+	CREATE TRIGGER IF NOT EXISTS
+		%[1]s_closed_delete BEFORE DELETE ON %[1]s
+		WHEN (SELECT closed FROM inventory_snapshots WHERE id = OLD.snapshot_id)
+	BEGIN
+		SELECT RAISE(ABORT, 'closed snapshots cannot be changed');
+	END;
+`
+
+func (wh *dafaultWarehouse) initSnapshotsTables() {
+	snapshotsTables := `
+	CREATE TABLE IF NOT EXISTS
+		inventory_snapshots (
+			id TEXT NOT NULL PRIMARY KEY,
+			time DATETIME NOT NULL UNIQUE,
+			created DATETIME NOT NULL,
+			closed BOOLEAN NOT NULL DEFAULT 0
+	);
+	CREATE TABLE IF NOT EXISTS
+		snapshot_items (
+			snapshot_id TEXT NOT NULL,
+			stock_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			PRIMARY KEY (snapshot_id, stock_id),
+			FOREIGN KEY (snapshot_id) REFERENCES inventory_snapshots (id)
+	);
+	CREATE TABLE IF NOT EXISTS
+		snapshot_lots (
+			snapshot_id TEXT NOT NULL,
+			stock_id TEXT NOT NULL,
+			number TEXT NOT NULL,
+			expiration_date DATETIME,
+			quantity NUMERIC NOT NULL,
+			PRIMARY KEY (snapshot_id, stock_id, number),
+			FOREIGN KEY (snapshot_id) REFERENCES inventory_snapshots (id)
+	);
+	CREATE TRIGGER IF NOT EXISTS
+		inventory_snapshots_closed_update BEFORE UPDATE ON inventory_snapshots
+		WHEN OLD.closed
+	BEGIN
+		SELECT RAISE(ABORT, 'closed snapshots cannot be changed');
+	END;
+	CREATE TRIGGER IF NOT EXISTS
+		inventory_snapshots_closed_delete BEFORE DELETE ON inventory_snapshots
+		WHEN OLD.closed
+	BEGIN
+		SELECT RAISE(ABORT, 'closed snapshots cannot be removed');
+	END;
+	` + fmt.Sprintf(snapshotChildTriggers, "snapshot_items") + fmt.Sprintf(snapshotChildTriggers, "snapshot_lots")
+
+	_, err := wh.database.Exec(snapshotsTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// lotKey identifies a lot across the storage locations it is split between
+type lotKey struct {
+	stockID string
+	number  string
+}
+
+// InventoryAsOf reconstructs the stock items with their quantities and lots at the given time
+func (wh *dafaultWarehouse) InventoryAsOf(t time.Time) *InventorySnapshot {
+	return &InventorySnapshot{Time: t, Items: wh.itemsAsOf(t, "")}
+}
+
+// StockAsOf reconstructs the stock item with the given id with its quantity and lots at the given time.
+// It returns false if the item did not exist at that time.
+func (wh *dafaultWarehouse) StockAsOf(id string, t time.Time) (*SnapshotItem, bool) {
+	items := wh.itemsAsOf(t, id)
+	if len(items) == 0 {
+		return nil, false
+	}
+	return items[0], true
+}
+
+// itemsAsOf reconstructs the stock items at time t, or only the one with stockID if it is not empty.
+// It starts from the last closed snapshot before t and replays the movements after it.
+// The quantity of an item is the balance after its last movement up to t. Items that did not
+// move since the snapshot keep the quantity in the snapshot or, if they are not in it, the quantity
+// before their next movement or their latest quantity. Items that were removed by t are left out.
+func (wh *dafaultWarehouse) itemsAsOf(t time.Time, stockID string) []*SnapshotItem {
+	var (
+		base  = wh.lastSnapshotBefore(t, stockID)
+		since time.Time
+		items = make(map[string]*SnapshotItem)
+	)
+	if base != nil {
+		since = base.Time
+		for _, item := range base.Items {
+			items[item.StockID] = item
+		}
+	}
+
+	var (
+		balances = wh.balancesBetween(since, t, stockID)
+		before   = wh.quantitiesBeforeFirstMovement(t, stockID)
+		existing = wh.existingItems(t, stockID)
+		removed  = wh.removedItems(t, stockID)
+	)
+	for id := range removed {
+		delete(items, id)
+		delete(balances, id)
+	}
+
+	for id, balance := range balances {
+		item, ok := items[id]
+		if !ok {
+			item = &SnapshotItem{StockID: id}
+			items[id] = item
+		}
+		item.Quantity = balance
+	}
+
+	// the items that did not move since the snapshot were not changed by movements after t either
+	for id, c := range existing {
+		item, ok := items[id]
+		if ok {
+			item.Name = c.Name
+			continue
+		}
+		item = &SnapshotItem{StockID: id, Name: c.Name, Quantity: c.Quantity}
+		if quantity, ok := before[id]; ok {
+			item.Quantity = quantity
+		}
+		items[id] = item
+	}
+
+	lots := make(map[lotKey]*SnapshotLot)
+	if base != nil {
+		for _, item := range base.Items {
+			for _, lot := range item.Lots {
+				lots[lotKey{item.StockID, lot.Number}] = lot
+			}
+			item.Lots = nil
+		}
+	}
+	wh.replayLotMovements(lots, since, t, stockID)
+
+	for key, lot := range lots {
+		if item, ok := items[key.stockID]; ok && !lot.Quantity.IsZero() {
+			item.Lots = append(item.Lots, lot)
+		}
+	}
+
+	result := make([]*SnapshotItem, 0, len(items))
+	for _, item := range items {
+		sortSnapshotLots(item.Lots)
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].StockID < result[j].StockID
+	})
+	return result
+}
+
+func sortSnapshotLots(lots []*SnapshotLot) {
+	sort.Slice(lots, func(i, j int) bool {
+		a, b := lots[i].ExpirationDate, lots[j].ExpirationDate
+		switch {
+		case a != nil && b != nil && !a.Equal(*b):
+			return a.Before(*b)
+		case (a == nil) != (b == nil):
+			return a != nil
+		}
+		return lots[i].Number < lots[j].Number
+	})
+}
+
+// lastSnapshotBefore returns the last closed snapshot taken at or before t, with only
+// the item with stockID if it is not empty, or nil if there is no such snapshot
+func (wh *dafaultWarehouse) lastSnapshotBefore(t time.Time, stockID string) *InventorySnapshot {
+	snapshot := &InventorySnapshot{}
+	err := wh.database.QueryRow(`
+		SELECT
+			id,
+			time,
+			created,
+			closed
+		FROM
+			inventory_snapshots
+		WHERE
+			closed AND time <= ?
+		ORDER BY
+			time DESC
+		LIMIT 1
+	`, t.UTC()).Scan(&snapshot.ID, &snapshot.Time, &snapshot.Created, &snapshot.Closed)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		panic(err)
+	}
+
+	snapshot.Items = wh.snapshotItems(snapshot.ID, stockID)
+	return snapshot
+}
+
+// balancesBetween returns the balances of the stock items after their last movement in (from, to]
+func (wh *dafaultWarehouse) balancesBetween(from, to time.Time, stockID string) map[string]decimal.Decimal {
+	// the bare balance column comes from the row with the largest rowid, which is the last movement
+	rows, err := wh.database.Query(`
+		SELECT
+			stock_id,
+			balance,
+			MAX(rowid)
+		FROM
+			stock_movements
+		WHERE
+			time > ? AND time <= ? AND (? = '' OR stock_id = ?)
+		GROUP BY
+			stock_id
+	`, from.UTC(), to.UTC(), stockID, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	balances := make(map[string]decimal.Decimal)
+	for rows.Next() {
+		var (
+			id      string
+			balance decimal.Decimal
+			rowID   int64
+		)
+		if err = rows.Scan(&id, &balance, &rowID); err != nil {
+			panic(err)
+		}
+		balances[id] = balance
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return balances
+}
+
+// quantitiesBeforeFirstMovement returns the quantities of the stock items before their first movement after t
+func (wh *dafaultWarehouse) quantitiesBeforeFirstMovement(t time.Time, stockID string) map[string]decimal.Decimal {
+	rows, err := wh.database.Query(`
+		SELECT
+			stock_id,
+			kind,
+			quantity,
+			balance,
+			MIN(rowid)
+		FROM
+			stock_movements
+		WHERE
+			time > ? AND (? = '' OR stock_id = ?)
+		GROUP BY
+			stock_id
+	`, t.UTC(), stockID, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	quantities := make(map[string]decimal.Decimal)
+	for rows.Next() {
+		var (
+			mv    = &Movement{}
+			rowID int64
+		)
+		if err = rows.Scan(&mv.StockID, &mv.Kind, &mv.Quantity, &mv.Balance, &rowID); err != nil {
+			panic(err)
+		}
+		quantities[mv.StockID] = mv.Balance.Sub(mv.delta())
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return quantities
+}
+
+// existingItems returns the names and the latest quantities of the stock items that existed at t,
+// which are the items created at or before t that were not removed by then. The latest quantity
+// of a removed item is its quantity when it was removed. Items created before creation times
+// were kept are included.
+func (wh *dafaultWarehouse) existingItems(t time.Time, stockID string) map[string]*SnapshotItem {
+	rows, err := wh.database.Query(`
+		SELECT
+			id,
+			COALESCE(name, ''),
+			quantity
+		FROM
+			warehouse
+		WHERE
+			(created IS NULL OR created <= ?) AND (? = '' OR id = ?)
+		UNION ALL
+		SELECT
+			stock_id,
+			name,
+			quantity
+		FROM
+			stock_deletions
+		WHERE
+			(created IS NULL OR created <= ?) AND deleted > ? AND (? = '' OR stock_id = ?)
+	`, t.UTC(), stockID, stockID, t.UTC(), t.UTC(), stockID, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	items := make(map[string]*SnapshotItem)
+	for rows.Next() {
+		item := &SnapshotItem{}
+		if err = rows.Scan(&item.StockID, &item.Name, &item.Quantity); err != nil {
+			panic(err)
+		}
+		items[item.StockID] = item
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return items
+}
+
+// removedItems returns the ids of the stock items that were removed at or before t
+func (wh *dafaultWarehouse) removedItems(t time.Time, stockID string) map[string]bool {
+	rows, err := wh.database.Query(`
+		SELECT
+			stock_id
+		FROM
+			stock_deletions
+		WHERE
+			deleted <= ? AND (? = '' OR stock_id = ?)
+	`, t.UTC(), stockID, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	removed := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			panic(err)
+		}
+		removed[id] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return removed
+}
+
+// replayLotMovements applies the movements of lots in (from, to] to the lot quantities
+func (wh *dafaultWarehouse) replayLotMovements(lots map[lotKey]*SnapshotLot, from, to time.Time, stockID string) {
+	rows, err := wh.database.Query(`
+		SELECT
+			l.stock_id,
+			l.number,
+			l.expiration_date,
+			m.kind,
+			m.quantity
+		FROM
+			stock_movements m
+		JOIN
+			stock_lots l ON l.id = m.lot_id
+		WHERE
+			m.time > ? AND m.time <= ? AND (? = '' OR m.stock_id = ?)
+	`, from.UTC(), to.UTC(), stockID, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key            lotKey
+			expirationDate *time.Time
+			mv             = &Movement{}
+		)
+		if err = rows.Scan(&key.stockID, &key.number, &expirationDate, &mv.Kind, &mv.Quantity); err != nil {
+			panic(err)
+		}
+
+		lot, ok := lots[key]
+		if !ok {
+			lot = &SnapshotLot{Number: key.number, ExpirationDate: expirationDate}
+			lots[key] = lot
+		}
+		lot.Quantity = lot.Quantity.Add(mv.delta())
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// TakeSnapshot reconstructs the inventory at the given time and keeps it in the DB as a closed snapshot
+func (wh *dafaultWarehouse) TakeSnapshot(t time.Time) (*InventorySnapshot, error) {
+	now := time.Now().UTC()
+	if t.After(now) {
+		return nil, ValidationErrors{{"time", "snapshots cannot be taken of the future"}}
+	}
+
+	var exists bool
+	err := wh.database.QueryRow(`SELECT EXISTS (SELECT 1 FROM inventory_snapshots WHERE time = ?)`, t.UTC()).Scan(&exists)
+	if err != nil {
+		panic(err)
+	}
+	if exists {
+		return nil, ValidationErrors{{"time", "a snapshot at this time already exists"}}
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &InventorySnapshot{ID: id, Time: t.UTC(), Created: now, Items: wh.itemsAsOf(t, "")}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			inventory_snapshots (
+				id,
+				time,
+				created)
+		VALUES(?, ?, ?)
+	`, snapshot.ID, snapshot.Time, snapshot.Created)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, item := range snapshot.Items {
+		_, err = tx.Exec(`
+			INSERT INTO
+				snapshot_items (
+					snapshot_id,
+					stock_id,
+					name,
+					quantity)
+			VALUES(?, ?, ?, ?)
+		`, snapshot.ID, item.StockID, item.Name, item.Quantity.String())
+		if err != nil {
+			panic(err)
+		}
+
+		for _, lot := range item.Lots {
+			_, err = tx.Exec(`
+				INSERT INTO
+					snapshot_lots (
+						snapshot_id,
+						stock_id,
+						number,
+						expiration_date,
+						quantity)
+				VALUES(?, ?, ?, ?, ?)
+			`, snapshot.ID, item.StockID, lot.Number, lot.ExpirationDate, lot.Quantity.String())
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE inventory_snapshots SET closed = 1 WHERE id = ?`, snapshot.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	snapshot.Closed = true
+	return snapshot, nil
+}
+
+// Snapshots returns the snapshots kept in the DB without their items, the latest first
+func (wh *dafaultWarehouse) Snapshots() []*InventorySnapshot {
+	rows, err := wh.database.Query(`
+		SELECT
+			id,
+			time,
+			created,
+			closed
+		FROM
+			inventory_snapshots
+		ORDER BY
+			time DESC
+	`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	snapshots := make([]*InventorySnapshot, 0)
+	for rows.Next() {
+		snapshot := &InventorySnapshot{}
+		if err = rows.Scan(&snapshot.ID, &snapshot.Time, &snapshot.Created, &snapshot.Closed); err != nil {
+			panic(err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return snapshots
+}
+
+// ReadSnapshot returns the snapshot with the given id with its items if it exists
+func (wh *dafaultWarehouse) ReadSnapshot(id string) (*InventorySnapshot, bool) {
+	snapshot := &InventorySnapshot{}
+	err := wh.database.QueryRow(`
+		SELECT
+			id,
+			time,
+			created,
+			closed
+		FROM
+			inventory_snapshots
+		WHERE
+			id = ?
+	`, id).Scan(&snapshot.ID, &snapshot.Time, &snapshot.Created, &snapshot.Closed)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	snapshot.Items = wh.snapshotItems(id, "")
+	return snapshot, true
+}
+
+// snapshotItems returns the items of the snapshot with their lots,
+// or only the item with stockID if it is not empty
+func (wh *dafaultWarehouse) snapshotItems(snapshotID, stockID string) []*SnapshotItem {
+	rows, err := wh.database.Query(`
+		SELECT
+			stock_id,
+			name,
+			quantity
+		FROM
+			snapshot_items
+		WHERE
+			snapshot_id = ? AND (? = '' OR stock_id = ?)
+		ORDER BY
+			name, stock_id
+	`, snapshotID, stockID, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var (
+		items = make([]*SnapshotItem, 0)
+		byID  = make(map[string]*SnapshotItem)
+	)
+	for rows.Next() {
+		item := &SnapshotItem{}
+		if err = rows.Scan(&item.StockID, &item.Name, &item.Quantity); err != nil {
+			panic(err)
+		}
+		items = append(items, item)
+		byID[item.StockID] = item
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	lotRows, err := wh.database.Query(`
+		SELECT
+			stock_id,
+			number,
+			expiration_date,
+			quantity
+		FROM
+			snapshot_lots
+		WHERE
+			snapshot_id = ? AND (? = '' OR stock_id = ?)
+	`, snapshotID, stockID, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer lotRows.Close()
+
+	for lotRows.Next() {
+		var (
+			id  string
+			lot = &SnapshotLot{}
+		)
+		if err = lotRows.Scan(&id, &lot.Number, &lot.ExpirationDate, &lot.Quantity); err != nil {
+			panic(err)
+		}
+		if item, ok := byID[id]; ok {
+			item.Lots = append(item.Lots, lot)
+		}
+	}
+	err = lotRows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, item := range items {
+		sortSnapshotLots(item.Lots)
+	}
+	return items
+}
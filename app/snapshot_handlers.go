@@ -0,0 +1,100 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// asOfParam reads the optional asOf query parameter of the stock queries. If it is
+// invalid or in the future it responds with status code 400 and returns false.
+func asOfParam(w http.ResponseWriter, r *http.Request) (asOf *time.Time, ok bool) {
+	s := r.URL.Query().Get("asOf")
+	if s == "" {
+		return nil, true
+	}
+
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		respondBadRequest(w, ValidationErrors{{"asOf", err.Error()}})
+		return nil, false
+	}
+	if t.After(time.Now()) {
+		respondBadRequest(w, ValidationErrors{{"asOf", "the inventory of the future is not known"}})
+		return nil, false
+	}
+	return &t, true
+}
+
+// Handler for GET /stock/?asOf=<date>
+//
+// Returns the stock items with their quantities and lots as they were at <date>.
+func (m *madminHandler) inventoryAsOfHandler(w http.ResponseWriter, r *http.Request, asOf time.Time) {
+	if r.URL.Query().Get("location") != "" {
+		respondBadRequest(w, ValidationErrors{{"location", "the stock of a location cannot be listed as of a past time"}})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newInventorySnapshotDTO(m.warehouse.InventoryAsOf(asOf)))
+}
+
+// Handler for GET /stock/<id>?asOf=<date>
+//
+// Returns the quantity and the lots of the stock item with <id> as they were at <date>
+// or an empty response with status code 204 if the item did not exist at that time.
+func (m *madminHandler) stockItemAsOfHandler(w http.ResponseWriter, r *http.Request, asOf time.Time) {
+	item, ok := m.warehouse.StockAsOf(mux.Vars(r)["id"], asOf)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newSnapshotItemDTO(item))
+}
+
+// Handler for GET /snapshots/
+//
+// Lists the kept inventory snapshots without their items, the latest first.
+func (m *madminHandler) listSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	snapshots := m.warehouse.Snapshots()
+
+	resp := make([]*InventorySnapshotDTO, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		resp = append(resp, newInventorySnapshotDTO(snapshot))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for GET /snapshots/<id>
+//
+// Returns the kept inventory snapshot with <id> with its items.
+func (m *madminHandler) getSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := m.warehouse.ReadSnapshot(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newInventorySnapshotDTO(snapshot))
+}
+
+// monthEndSnapshotJob keeps the inventory at the start of the current month,
+// which is the inventory at the end of the previous one
+func (m *madminHandler) monthEndSnapshotJob(now time.Time) (string, error) {
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	for _, snapshot := range m.warehouse.Snapshots() {
+		if snapshot.Time.Equal(monthStart) {
+			return fmt.Sprintf("the snapshot of %s is already taken", monthStart.Format("2006-01-02")), nil
+		}
+	}
+
+	snapshot, err := m.warehouse.TakeSnapshot(monthStart)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("snapshot of %d stock items taken as of %s", len(snapshot.Items), monthStart.Format("2006-01-02")), nil
+}
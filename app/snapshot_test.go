@@ -0,0 +1,128 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestInventorySnapshots(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+		wh            = madminHandler.warehouse
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	beforeCreation := time.Now().UTC()
+	time.Sleep(5 * time.Millisecond)
+
+	item, _ := defaultExpirableStockItem(MEDICINE)
+	item.SetQuantity(decimal.Zero)
+	wh.CreateStock(item, nil)
+
+	removed, _ := defaultUnexpirableStockItem(ACCESSORY)
+	wh.CreateStock(removed, nil)
+
+	expiration := time.Now().UTC().AddDate(1, 0, 0)
+	receipt := &Movement{StockID: item.ID(), Kind: RECEIPT, Quantity: decimal.New(10, 0), Lot: &Lot{Number: "A", ExpirationDate: &expiration}}
+	if err := wh.RecordMovement(receipt); err != nil {
+		t.Fatalf(`RecordMovement returns an error for a receipt: %s`, err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	afterReceipt := time.Now().UTC().Truncate(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	wh.RecordMovement(&Movement{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(4, 0), LotID: receipt.LotID})
+
+	if _, ok := wh.StockAsOf(item.ID(), beforeCreation); ok {
+		t.Fatalf(`StockAsOf returns a stock item before it was created`)
+	}
+	if past, ok := wh.StockAsOf(item.ID(), afterReceipt); !ok || !past.Quantity.Equal(decimal.New(10, 0)) ||
+		len(past.Lots) != 1 || !past.Lots[0].Quantity.Equal(decimal.New(10, 0)) {
+		t.Fatalf(`Unexpected stock item after the receipt %+v`, past)
+	}
+
+	snapshot, err := wh.TakeSnapshot(afterReceipt)
+	if err != nil {
+		t.Fatalf(`TakeSnapshot returns an error for a past time: %s`, err)
+	}
+	if len(snapshot.Items) != 2 || !snapshot.Closed {
+		t.Fatalf(`Unexpected snapshot %+v`, snapshot)
+	}
+	if _, err := wh.TakeSnapshot(afterReceipt); err == nil {
+		t.Fatalf(`TakeSnapshot takes a second snapshot at the same time`)
+	}
+	if _, err := wh.TakeSnapshot(time.Now().Add(time.Hour)); err == nil {
+		t.Fatalf(`TakeSnapshot takes a snapshot of the future`)
+	}
+	if _, err := database.Exec(`DELETE FROM snapshot_items WHERE snapshot_id = ?`, snapshot.ID); err == nil {
+		t.Fatalf(`The items of a closed snapshot can be removed`)
+	}
+	if _, err := database.Exec(`UPDATE inventory_snapshots SET time = ? WHERE id = ?`, time.Now(), snapshot.ID); err == nil {
+		t.Fatalf(`A closed snapshot can be changed`)
+	}
+
+	// a removed item of the snapshot is gone after it was removed, but not before
+	time.Sleep(5 * time.Millisecond)
+	beforeRemoval := time.Now().UTC()
+	time.Sleep(5 * time.Millisecond)
+	wh.DeleteStock(removed.ID(), nil)
+	if inventory := wh.InventoryAsOf(time.Now()); len(inventory.Items) != 1 || inventory.Items[0].StockID != item.ID() {
+		t.Fatalf(`Unexpected inventory after an item was removed %+v`, inventory.Items)
+	}
+	if past, ok := wh.StockAsOf(removed.ID(), beforeRemoval); !ok || !past.Quantity.Equal(removed.Quantity()) || past.Name != removed.Name() {
+		t.Fatalf(`Unexpected removed item before it was removed %+v`, past)
+	}
+	if _, ok := wh.StockAsOf(removed.ID(), beforeCreation); ok {
+		t.Fatalf(`StockAsOf returns a removed stock item before it was created`)
+	}
+
+	// the lots are replayed from the snapshot
+	if now, ok := wh.StockAsOf(item.ID(), time.Now()); !ok || !now.Quantity.Equal(decimal.New(6, 0)) ||
+		len(now.Lots) != 1 || !now.Lots[0].Quantity.Equal(decimal.New(6, 0)) {
+		t.Fatalf(`Unexpected stock item after the dispense %+v`, now)
+	}
+
+	query := url.Values{}
+	query.Set("asOf", afterReceipt.Format(dateLayout))
+	resp, err := http.Get(buildURL(s.URL, fmt.Sprintf("/data/stock/%s?%s", item.ID(), query.Encode())))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	past := &SnapshotItemDTO{}
+	json.NewDecoder(resp.Body).Decode(past)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || past.Quantity != "10" || len(past.Lots) != 1 || past.Lots[0].Number != "A" {
+		t.Fatalf("Unexpected stock item as of %s: %d %+v", query.Get("asOf"), resp.StatusCode, past)
+	}
+
+	query.Set("asOf", beforeCreation.Format(dateLayout))
+	resp, err = http.Get(buildURL(s.URL, fmt.Sprintf("/data/stock/?%s", query.Encode())))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	inventory := &InventorySnapshotDTO{}
+	json.NewDecoder(resp.Body).Decode(inventory)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(inventory.Items) != 0 {
+		t.Fatalf("Unexpected inventory before the item was created: %d %+v", resp.StatusCode, inventory)
+	}
+
+	if _, err := madminHandler.monthEndSnapshotJob(time.Now()); err != nil {
+		t.Fatalf(`The month-end snapshot job returns an error: %s`, err)
+	}
+	if outcome, err := madminHandler.monthEndSnapshotJob(time.Now()); err != nil || len(wh.Snapshots()) != 2 {
+		t.Fatalf(`The month-end snapshot job took a second snapshot: %s %v`, outcome, err)
+	}
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sseEvent is an event of the server-sent events stream
+type sseEvent struct {
+	ID   uint64
+	Type EventType
+	Data []byte
+}
+
+func (e *sseEvent) write(w http.ResponseWriter) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+	return err
+}
+
+const (
+	// eventBufferSize is the number of the latest events kept for clients that resume the stream
+	eventBufferSize = 256
+	// subscriberBufferSize is the number of events a slow client can fall behind before it is disconnected
+	subscriberBufferSize = 64
+)
+
+// eventBroker fans out the warehouse's events to the clients of the event stream
+// and keeps the latest events, so clients can resume the stream after a reconnect
+type eventBroker struct {
+	mutex       sync.Mutex
+	nextID      uint64
+	buffer      []sseEvent
+	subscribers map[chan sseEvent]bool
+
+	heartbeat time.Duration
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		// the ids continue to grow after a restart, so resuming clients notice the gap
+		nextID:      uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+		buffer:      make([]sseEvent, 0, eventBufferSize),
+		subscribers: make(map[chan sseEvent]bool),
+		heartbeat:   15 * time.Second,
+	}
+}
+
+// Publish sends the event to all clients. It can be added as a listener of the warehouse.
+func (eb *eventBroker) Publish(e Event) {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		log.Printf("Error in marshaling event: %s", err)
+		return
+	}
+
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	se := sseEvent{ID: eb.nextID, Type: e.Type, Data: data}
+	eb.nextID++
+
+	if len(eb.buffer) == eventBufferSize {
+		eb.buffer = append(eb.buffer[:0], eb.buffer[1:]...)
+	}
+	eb.buffer = append(eb.buffer, se)
+
+	for ch := range eb.subscribers {
+		select {
+		case ch <- se:
+		default:
+			// the client is too slow, it resumes the stream after it reconnects
+			delete(eb.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a client. If the client resumes the stream after the event with lastID,
+// the buffered events after it are returned. missed is set if some of the events are not
+// in the buffer anymore.
+func (eb *eventBroker) subscribe(lastID uint64, resume bool) (ch chan sseEvent, replay []sseEvent, missed bool) {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	ch = make(chan sseEvent, subscriberBufferSize)
+	eb.subscribers[ch] = true
+
+	if !resume {
+		return ch, nil, false
+	}
+
+	if lastID >= eb.nextID {
+		return ch, nil, true
+	}
+	first := eb.nextID
+	if len(eb.buffer) > 0 {
+		first = eb.buffer[0].ID
+	}
+	if lastID+1 < first {
+		missed = true
+	}
+
+	for _, se := range eb.buffer {
+		if se.ID > lastID {
+			replay = append(replay, se)
+		}
+	}
+	return ch, replay, missed
+}
+
+func (eb *eventBroker) unsubscribe(ch chan sseEvent) {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	if eb.subscribers[ch] {
+		delete(eb.subscribers, ch)
+		close(ch)
+	}
+}
+
+// lastEventID reads the id of the last event the client received
+// from the Last-Event-ID header or the lastEventID query parameter
+func lastEventID(r *http.Request) (uint64, bool) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("lastEventID")
+	}
+	if s == "" {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// Handler for GET /events
+//
+// Streams the changes committed through the warehouse as server-sent events.
+// Clients that reconnect with Last-Event-ID get the events they missed. If some of them
+// are not buffered anymore, a resync event tells the client to reload its data.
+// Comments are sent as heartbeats while there are no events.
+func (m *madminHandler) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Error in streaming events: streaming is not supported")
+		return
+	}
+
+	lastID, resume := lastEventID(r)
+	ch, replay, missed := m.events.subscribe(lastID, resume)
+	defer m.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if missed {
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+	}
+	for i := range replay {
+		if err := replay[i].write(w); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(m.events.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case se, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := se.write(w); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent reads the stream until the next event or heartbeat and returns its lines
+func readEvent(t *testing.T, r *bufio.Reader) []string {
+	lines := make([]string, 0)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading event stream: %s", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			if len(lines) == 0 || strings.HasPrefix(lines[0], "retry:") {
+				lines = lines[:0]
+				continue
+			}
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func openEventStream(t *testing.T, url, lastID string) (*http.Response, *bufio.Reader) {
+	req, _ := http.NewRequest("GET", url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func TestEventStream(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+		url           = buildURL(s.URL, "/data/events")
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	madminHandler.events.heartbeat = 50 * time.Millisecond
+
+	resp, r := openEventStream(t, url, "")
+
+	distributor, _ := NewDistributor("Vet Supplies")
+	madminHandler.warehouse.CreateDistributor(distributor, nil)
+	item, _ := defaultUnexpirableStockItem(ACCESSORY)
+	madminHandler.warehouse.CreateStock(item, nil)
+
+	first := readEvent(t, r)
+	if len(first) != 3 || first[1] != "event: distributor.created" || !strings.Contains(first[2], distributor.ID()) {
+		t.Fatalf("Unexpected event %v", first)
+	}
+	if second := readEvent(t, r); second[1] != "event: stock.created" {
+		t.Fatalf("Unexpected event %v", second)
+	}
+	if heartbeat := readEvent(t, r); heartbeat[0] != ": heartbeat" {
+		t.Fatalf("Expected heartbeat, got %v", heartbeat)
+	}
+	resp.Body.Close()
+
+	// a client that resumes after the first event gets the second one again
+	resp, r = openEventStream(t, url, strings.TrimPrefix(first[0], "id: "))
+	if replayed := readEvent(t, r); replayed[1] != "event: stock.created" {
+		t.Fatalf("Unexpected replayed event %v", replayed)
+	}
+	resp.Body.Close()
+
+	// a client that missed events that are not buffered has to resync
+	resp, r = openEventStream(t, url, "1")
+	if resync := readEvent(t, r); resync[0] != "event: resync" {
+		t.Fatalf("Expected resync, got %v", resync)
+	}
+	resp.Body.Close()
+}
@@ -2,30 +2,12 @@ package app
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/shopspring/decimal"
 )
 
-type stockType int
-
-// MEDICINE, FEED and ACCESSORY are the default stock types in madmin
-const (
-	MEDICINE stockType = iota
-	FEED
-	ACCESSORY
-)
-
-// StockTyper is an interface that wraps the StockType method.
-// StockType returns the type of a stock item
-type StockTyper interface {
-	StockType() stockType
-}
-
-func (st stockType) StockType() stockType {
-	return st
-}
-
 // Stock is a stock item interface
 type Stock interface {
 	ID() string
@@ -50,40 +32,75 @@ type Stock interface {
 	DistributorID() string
 	SetDistributorID(string)
 
+	// ControlledSchedule returns the controlled-substance schedule of the item
+	// or an empty string if the item is not a controlled substance.
+	// Movements of controlled substances are kept in a register and need a witness.
+	ControlledSchedule() string
+	SetControlledSchedule(string)
+	IsControlled() bool
+
+	// PrescriptionRequired returns true if the item can only be dispensed against a prescription
+	PrescriptionRequired() bool
+	SetPrescriptionRequired(bool)
+
+	// QuantityRule returns the rule that all quantities of the item must follow
+	QuantityRule() quantityRule
+
 	Update(StockDTO) error
 }
 
 // NewStock creates a new valid Stock object.
-// For now it only works for stock items of MEDICINE, FEED or ACCESSORY type.
-func NewStock(dto *NewStockDTO) (Stock, error) {
+// The stock type of the new item is looked up in the given stock types
+// and defines if the item is expirable and what quantities are valid for it.
+func NewStock(dto *NewStockDTO, types StockTypeReader) (Stock, error) {
+	kind, ok := types.ReadStockType(dto.Type)
+	if !ok {
+		return nil, ValidationErrors{{"type", "invalid stock type"}}
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
 
-	switch dto.Type {
-	case MEDICINE:
-		m, err := NewMedicine(dto)
-		return m, err
-	case FEED:
-		f, err := NewFeed(dto)
-		return f, err
-	case ACCESSORY:
-		a, err := NewAccessory(dto)
-		return a, err
-	default:
-		return nil, errors.New("invalid stock type")
+	fields, err := validStockFields(dto.Quantity, dto.MinQuantity, dto.ExpirationDate, kind)
+	if err != nil {
+		return nil, err
 	}
+
+	return &defaultStock{
+		id:             id,
+		kind:           kind,
+		name:           dto.Name,
+		quantity:       fields.quantity,
+		minQuantity:    fields.minQuantity,
+		expirationDate: fields.expirationDate,
+		distributorID:  dto.DistributorID,
+
+		controlledSchedule:   dto.ControlledSchedule,
+		prescriptionRequired: dto.PrescriptionRequired,
+	}, nil
 }
 
 type defaultStock struct {
 	id             string
+	kind           StockTypeInfo
 	name           string
 	minQuantity    decimal.Decimal
 	quantity       decimal.Decimal
 	expirationDate time.Time
 	distributorID  string
+
+	controlledSchedule   string
+	prescriptionRequired bool
 }
 
 func (ds *defaultStock) ID() string {
 	return ds.id
 }
+func (ds *defaultStock) Type() stockType {
+	return ds.kind.ID
+}
 func (ds *defaultStock) Name() string {
 	return ds.name
 }
@@ -91,12 +108,18 @@ func (ds *defaultStock) SetName(name string) {
 	ds.name = name
 }
 func (ds *defaultStock) IsExpirable() bool {
-	return true
+	return ds.kind.Expirable
 }
 func (ds *defaultStock) ExpirationDate() time.Time {
+	if !ds.IsExpirable() {
+		panic(fmt.Sprintf("Error - trying to read expiration date of %s. It does not expire.", ds.kind.Name))
+	}
 	return ds.expirationDate
 }
 func (ds *defaultStock) SetExpirationDate(expirationDate time.Time) {
+	if !ds.IsExpirable() {
+		panic(fmt.Sprintf("Error - trying to set expiration date of %s. It does not expire.", ds.kind.Name))
+	}
 	ds.expirationDate = expirationDate
 }
 func (ds *defaultStock) MinQuantity() decimal.Decimal {
@@ -117,213 +140,110 @@ func (ds *defaultStock) DistributorID() string {
 func (ds *defaultStock) SetDistributorID(id string) {
 	ds.distributorID = id
 }
+func (ds *defaultStock) ControlledSchedule() string {
+	return ds.controlledSchedule
+}
+func (ds *defaultStock) SetControlledSchedule(schedule string) {
+	ds.controlledSchedule = schedule
+}
+func (ds *defaultStock) IsControlled() bool {
+	return ds.controlledSchedule != ""
+}
+func (ds *defaultStock) PrescriptionRequired() bool {
+	return ds.prescriptionRequired
+}
+func (ds *defaultStock) SetPrescriptionRequired(required bool) {
+	ds.prescriptionRequired = required
+}
+func (ds *defaultStock) QuantityRule() quantityRule {
+	return ds.kind.QuantityRule
+}
 
+// Update validates the DTO according to the rules of the item's stock type
+// and sets the new values only if all fields are valid.
 func (ds *defaultStock) Update(dto StockDTO) error {
 	if ds.ID() != dto.ID {
 		return errors.New("trying to update stock with different id")
 	}
 
-	ds.SetName(dto.Name)
-
-	if ds.IsExpirable() {
-		date, err := validDateFromString(dto.ExpirationDate)
-		if err != nil {
-			return err
-		}
-		ds.SetExpirationDate(date)
-	}
-
-	quantity, err := validQuantityFromString(dto.Quantity)
+	fields, err := validStockFields(dto.Quantity, dto.MinQuantity, dto.ExpirationDate, ds.kind)
 	if err != nil {
 		return err
 	}
-	ds.SetQuantity(quantity)
-
-	if dto.MinQuantity == "" {
-		ds.SetMinQuantity(decimal.Zero)
-	} else {
-		minQuantity, err := validQuantityFromString(dto.MinQuantity)
-		if err != nil {
-			return err
-		}
-		ds.SetMinQuantity(minQuantity)
-	}
-
-	ds.SetDistributorID(dto.DistributorID)
-
-	return nil
-}
-
-type medicine struct {
-	defaultStock
-}
-
-// NewMedicine creates a new stock item of medicine type (expirable stock item)
-func NewMedicine(dto *NewStockDTO) (Stock, error) {
-	id, err := newUUID()
-	if err != nil {
-		return nil, err
-	}
-
-	ds := &defaultStock{id: id}
 
 	ds.SetName(dto.Name)
-
-	date, err := validDateFromString(dto.ExpirationDate)
-	if err != nil {
-		return nil, err
-	}
-	ds.SetExpirationDate(date)
-
-	quantity, err := validQuantityFromString(dto.Quantity)
-	if err != nil {
-		return nil, err
-	}
-	ds.SetQuantity(quantity)
-
-	if dto.MinQuantity == "" {
-		ds.SetMinQuantity(decimal.Zero)
-	} else {
-		minQuantity, err := validQuantityFromString(dto.MinQuantity)
-		if err != nil {
-			return nil, err
-		}
-		ds.SetMinQuantity(minQuantity)
+	if ds.IsExpirable() {
+		ds.SetExpirationDate(fields.expirationDate)
 	}
-
+	ds.SetQuantity(fields.quantity)
+	ds.SetMinQuantity(fields.minQuantity)
 	ds.SetDistributorID(dto.DistributorID)
+	ds.SetControlledSchedule(dto.ControlledSchedule)
+	ds.SetPrescriptionRequired(dto.PrescriptionRequired)
 
-	return &medicine{defaultStock: *ds}, err
-}
-
-func (m *medicine) Type() stockType {
-	return MEDICINE
+	return nil
 }
 
-type feed struct {
-	defaultStock
+// stockFields holds the parsed values of the string fields of a stock DTO
+type stockFields struct {
+	quantity       decimal.Decimal
+	minQuantity    decimal.Decimal
+	expirationDate time.Time
 }
 
-// NewFeed creates a new stock item of feed type (expirable stock item)
-func NewFeed(dto *NewStockDTO) (Stock, error) {
-	id, err := newUUID()
-	if err != nil {
-		return nil, err
-	}
-
-	name := dto.Name
-
-	quantityString := dto.Quantity
-	if quantityString == "" {
-		return nil, errors.New("no quantity set for stock")
-	}
-
-	quantity, err := decimal.NewFromString(quantityString)
-	if err != nil {
-		return nil, err
-	}
-
+// validStockFields parses the quantity, minimum quantity and expiration date of a stock item
+// and checks them against the rules of its stock type.
+// All invalid fields are reported together in ValidationErrors.
+func validStockFields(quantityString, minQuantityString, dateString string, kind StockTypeInfo) (fields stockFields, err error) {
 	var (
-		minQuantityString = dto.MinQuantity
-		minQuantity       decimal.Decimal
+		errs = ValidationErrors{}
+		rule = kind.QuantityRule
 	)
-	if minQuantityString != "" {
-		minQuantity, err = decimal.NewFromString(minQuantityString)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		minQuantity = decimal.New(0, 0)
-	}
-
-	dateString := dto.ExpirationDate
-	if dateString == "" {
-		return nil, errors.New("no expiration date set for feed")
-	}
-	layout := "2006-01-02T15:04:05.000Z"
-	date, err := time.Parse(layout, dateString)
-	if err != nil {
-		return nil, err
-	}
-
-	distributorID := dto.DistributorID
-
-	return &feed{defaultStock{id: id, name: name, quantity: quantity, minQuantity: minQuantity, expirationDate: date, distributorID: distributorID}}, err
-}
-
-func (f *feed) Type() stockType {
-	return FEED
-}
-
-type accessory struct {
-	defaultStock
-}
-
-// NewAccessory creates a new stock item of accessory type (unexpirable stock item).
-// TODO: minimum quantity should be an integer, represented as decimal
-// (should not have non-zero values after floating point)
-func NewAccessory(dto *NewStockDTO) (Stock, error) {
-	id, err := newUUID()
-	if err != nil {
-		return nil, err
-	}
-
-	name := dto.Name
-
-	quantityString := dto.Quantity
-	if quantityString == "" {
-		return nil, errors.New("no quantity set for stock")
-	}
 
-	quantity, err := decimal.NewFromString(quantityString)
+	quantity, err := validQuantityFromString(quantityString)
 	if err != nil {
-		return nil, err
+		errs = append(errs, ValidationError{"quantity", err.Error()})
+	} else if msg := rule.check(quantity); msg != "" {
+		errs = append(errs, ValidationError{"quantity", msg})
 	}
+	fields.quantity = quantity
 
-	var (
-		minQuantityString = dto.MinQuantity
-		minQuantity       decimal.Decimal
-	)
+	fields.minQuantity = decimal.Zero
 	if minQuantityString != "" {
-		minQuantity, err = decimal.NewFromString(minQuantityString)
+		minQuantity, err := validQuantityFromString(minQuantityString)
 		if err != nil {
-			return nil, err
+			errs = append(errs, ValidationError{"minQuantity", err.Error()})
+		} else if msg := rule.check(minQuantity); msg != "" {
+			errs = append(errs, ValidationError{"minQuantity", msg})
 		}
-	} else {
-		minQuantity = decimal.New(0, 0)
+		fields.minQuantity = minQuantity
 	}
 
-	dateString := dto.ExpirationDate
-	if dateString != "" {
-		if dateString != "" {
-			return nil, errors.New("error in creating stock item: expiration date set for an accessory")
+	if kind.Expirable {
+		date, err := validDateFromString(dateString)
+		if err != nil {
+			errs = append(errs, ValidationError{"expirationDate", err.Error()})
 		}
+		fields.expirationDate = date
+	} else if dateString != "" {
+		errs = append(errs, ValidationError{"expirationDate", fmt.Sprintf("expiration date set for %s, which does not expire", kind.Name)})
 	}
 
-	distributorID := dto.DistributorID
-
-	return &accessory{defaultStock{id: id, name: name, quantity: quantity, minQuantity: minQuantity, distributorID: distributorID}}, err
-}
-
-func (a *accessory) Type() stockType {
-	return ACCESSORY
-}
-func (a *accessory) IsExpirable() bool {
-	return false
-}
-func (a *accessory) ExpirationDate() time.Time {
-	panic("Error - trying to read accessory's expiration date. Accessories do not expire.")
-}
-func (a *accessory) SetExpirationDate(time.Time) {
-	panic("Error - trying to set accessory's expiration date. Accessories do not expire.")
+	if len(errs) > 0 {
+		return fields, errs
+	}
+	return fields, nil
 }
 
 func compareStock(first, second Stock) bool {
 	return first.ID() == second.ID() &&
 		first.Type() == second.Type() &&
 		first.Name() == second.Name() &&
-		first.ExpirationDate() == second.ExpirationDate() &&
+		first.IsExpirable() == second.IsExpirable() &&
+		(!first.IsExpirable() || first.ExpirationDate() == second.ExpirationDate()) &&
 		first.Quantity().Cmp(second.Quantity()) == 0 &&
 		first.MinQuantity().Cmp(second.MinQuantity()) == 0 &&
-		first.DistributorID() == second.DistributorID()
+		first.DistributorID() == second.DistributorID() &&
+		first.ControlledSchedule() == second.ControlledSchedule() &&
+		first.PrescriptionRequired() == second.PrescriptionRequired()
 }
@@ -0,0 +1,336 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// StockLevel is the quantity of a stock item in a storage location.
+// The quantity of a stock item that is in none of the locations is unassigned.
+type StockLevel struct {
+	StockID    string
+	LocationID string
+
+	Quantity decimal.Decimal
+	// Available is the quantity without the lots in the location that cannot be dispensed
+	Available decimal.Decimal
+	// MinQuantity is the minimum quantity of the stock item in the location or nil if it is not set
+	MinQuantity *decimal.Decimal
+}
+
+func (wh *dafaultWarehouse) initStockLevelsTable() {
+	stockLevelsTable := `
+	CREATE TABLE IF NOT EXISTS
+		stock_levels (
+			stock_id TEXT NOT NULL,
+			location_id TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			min_quantity NUMERIC,
+			PRIMARY KEY (stock_id, location_id),
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id),
+			FOREIGN KEY (location_id) REFERENCES storage_locations (id)
+	);
+	`
+	_, err := wh.database.Exec(stockLevelsTable)
+	if err != nil {
+		panic(err)
+	}
+
+	// lots that were assigned to locations before the stock levels were kept
+	lots := wh.queryLots(`SELECT ` + lotColumns + ` FROM stock_lots WHERE location_id != '' AND quantity != 0`)
+	levels := make(map[[2]string]decimal.Decimal)
+	for _, lot := range lots {
+		key := [2]string{lot.StockID, lot.LocationID}
+		levels[key] = levels[key].Add(lot.Quantity)
+	}
+	for key, quantity := range levels {
+		_, err = wh.database.Exec(`
+			INSERT OR IGNORE INTO
+				stock_levels (
+					stock_id,
+					location_id,
+					quantity)
+			VALUES(?, ?, ?)
+		`, key[0], key[1], quantity.String())
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// stockLevelTx returns the quantity of the stock item in the location.
+// For an empty location id it returns the unassigned quantity, which is
+// the item's quantity without the quantities in locations and in transit.
+func stockLevelTx(tx *sql.Tx, stockID, locationID string) decimal.Decimal {
+	if locationID != "" {
+		var quantity decimal.Decimal
+		err := tx.QueryRow(`
+			SELECT
+				quantity
+			FROM
+				stock_levels
+			WHERE
+				stock_id = ? AND location_id = ?
+		`, stockID, locationID).Scan(&quantity)
+		switch {
+		case err == sql.ErrNoRows:
+			return decimal.Zero
+		case err != nil:
+			panic(err)
+		}
+		return quantity
+	}
+
+	var quantity decimal.Decimal
+	err := tx.QueryRow(`SELECT quantity FROM warehouse WHERE id = ?`, stockID).Scan(&quantity)
+	switch {
+	case err == sql.ErrNoRows:
+		return decimal.Zero
+	case err != nil:
+		panic(err)
+	}
+
+	rows, err := tx.Query(`
+		SELECT
+			quantity
+		FROM
+			stock_levels
+		WHERE
+			stock_id = ?
+		UNION ALL
+		SELECT
+			p.quantity
+		FROM
+			transfer_picks p
+		JOIN
+			transfers t ON t.id = p.transfer_id
+		WHERE
+			p.stock_id = ? AND t.state = ?
+	`, stockID, stockID, DISPATCHED)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var assigned decimal.Decimal
+		if err = rows.Scan(&assigned); err != nil {
+			panic(err)
+		}
+		quantity = quantity.Sub(assigned)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return quantity
+}
+
+// changeStockLevelTx adds delta to the quantity of the stock item in the location.
+// Unassigned quantities are not kept, so nothing changes for an empty location id.
+func changeStockLevelTx(tx *sql.Tx, stockID, locationID string, delta decimal.Decimal) {
+	if locationID == "" || delta.IsZero() {
+		return
+	}
+
+	quantity := stockLevelTx(tx, stockID, locationID).Add(delta)
+	_, err := tx.Exec(`
+		INSERT INTO
+			stock_levels (
+				stock_id,
+				location_id,
+				quantity)
+		VALUES(?, ?, ?)
+		ON CONFLICT (stock_id, location_id) DO UPDATE SET
+			quantity = excluded.quantity
+	`, stockID, locationID, quantity.String())
+	if err != nil {
+		panic(err)
+	}
+}
+
+// unavailableAtTx returns the quantity of the stock item's lots in the location that cannot be dispensed
+func unavailableAtTx(tx *sql.Tx, stockID, locationID string, now time.Time) decimal.Decimal {
+	unavailable := decimal.Zero
+	lots := queryLotsTx(tx, `
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			stock_id = ? AND location_id = ?
+	`, stockID, locationID)
+	for _, lot := range lots {
+		if !lot.isAvailable(now) {
+			unavailable = unavailable.Add(lot.Quantity)
+		}
+	}
+	return unavailable
+}
+
+// applyStockLevelTx checks that the movement leaves enough stock in its location
+// and changes the stock level of the location
+func applyStockLevelTx(tx *sql.Tx, mv *Movement, item Stock) error {
+	if mv.LocationID == "" {
+		return nil
+	}
+
+	level := stockLevelTx(tx, mv.StockID, mv.LocationID).Add(mv.delta())
+	if item.QuantityRule().NonNegative {
+		if level.Sign() < 0 {
+			return ValidationErrors{errInsufficientLocationStock}
+		}
+		if mv.takesStock() && mv.LotID == "" && level.LessThan(unavailableAtTx(tx, mv.StockID, mv.LocationID, time.Now())) {
+			return ValidationErrors{errInsufficientAvailableLocationStock}
+		}
+	}
+
+	changeStockLevelTx(tx, mv.StockID, mv.LocationID, mv.delta())
+	return nil
+}
+
+// StockLevels returns the stock levels of the stock items that are in the location
+// or have a minimum quantity set for it
+func (wh *dafaultWarehouse) StockLevels(locationID string) []*StockLevel {
+	rows, err := wh.database.Query(`
+		SELECT
+			s.stock_id,
+			s.location_id,
+			s.quantity,
+			s.min_quantity
+		FROM
+			stock_levels s
+		LEFT JOIN
+			warehouse w ON w.id = s.stock_id
+		WHERE
+			s.location_id = ? AND (s.quantity != 0 OR s.min_quantity IS NOT NULL)
+		ORDER BY
+			w.name, s.stock_id
+	`, locationID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var (
+		levels  = make([]*StockLevel, 0)
+		byStock = make(map[string]*StockLevel)
+	)
+	for rows.Next() {
+		var (
+			level       = &StockLevel{}
+			minQuantity decimal.NullDecimal
+		)
+		err = rows.Scan(
+			&level.StockID,
+			&level.LocationID,
+			&level.Quantity,
+			&minQuantity)
+		if err != nil {
+			panic(err)
+		}
+		if minQuantity.Valid {
+			level.MinQuantity = &minQuantity.Decimal
+		}
+		level.Available = level.Quantity
+		levels = append(levels, level)
+		byStock[level.StockID] = level
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now()
+	for _, lot := range wh.LocationLots(locationID) {
+		if level, ok := byStock[lot.StockID]; ok && !lot.isAvailable(now) {
+			level.Available = level.Available.Sub(lot.Quantity)
+		}
+	}
+
+	return levels
+}
+
+// LocationLots returns the lots in the location that are still in stock,
+// the ones that expire first are first
+func (wh *dafaultWarehouse) LocationLots(locationID string) []*Lot {
+	return wh.queryLots(`
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			location_id = ? AND quantity != 0
+		ORDER BY
+			expiration_date IS NULL, expiration_date, received
+	`, locationID)
+}
+
+// SetMinQuantity sets the minimum quantity of the stock item in the location.
+// A nil minimum quantity removes it.
+func (wh *dafaultWarehouse) SetMinQuantity(stockID, locationID string, minQuantity *decimal.Decimal, trail *auditTrail) error {
+	item, ok := wh.ReadStock(stockID)
+	if !ok {
+		return errors.New("no such stock item")
+	}
+	if _, ok := wh.locations.ReadLocation(locationID); !ok {
+		return errors.New("no such storage location")
+	}
+
+	var value interface{}
+	if minQuantity != nil {
+		rule := item.QuantityRule()
+		rule.NonNegative = true
+		if err := rule.Validate("minQuantity", *minQuantity); err != nil {
+			return err
+		}
+		value = minQuantity.String()
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	var previous decimal.NullDecimal
+	err = tx.QueryRow(`SELECT min_quantity FROM stock_levels WHERE stock_id = ? AND location_id = ?`, stockID, locationID).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			stock_levels (
+				stock_id,
+				location_id,
+				quantity,
+				min_quantity)
+		VALUES(?, ?, 0, ?)
+		ON CONFLICT (stock_id, location_id) DO UPDATE SET
+			min_quantity = excluded.min_quantity
+	`, stockID, locationID, value)
+	if err != nil {
+		panic(err)
+	}
+
+	before, after := &MinQuantityDTO{}, &MinQuantityDTO{}
+	if previous.Valid {
+		before.MinQuantity = previous.Decimal.String()
+	}
+	if minQuantity != nil {
+		after.MinQuantity = minQuantity.String()
+	}
+	if err := trail.recordTx(tx, AUDIT_STOCK_LEVEL, locationID+"/"+stockID, before, after); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
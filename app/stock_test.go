@@ -2,6 +2,8 @@ package app
 
 import (
 	"testing"
+
+	"github.com/shopspring/decimal"
 )
 
 func expectTypeAndExpirationDate(t *testing.T, aStockType stockType) {
@@ -39,14 +41,11 @@ func TestNewStock_WithValidData(t *testing.T) {
 
 func expectExpirationDate(t *testing.T, aStockType stockType) {
 	dto := NewStockDTO{
-		"name",
-		aStockType,
-		"1",
-		"",
-		"",
-		"",
+		Name:     "name",
+		Type:     aStockType,
+		Quantity: "1",
 	}
-	stockItem, err := NewStock(&dto)
+	stockItem, err := NewStock(&dto, builtinStockTypes)
 
 	checkNewItemCreating(t, stockItem, err)
 
@@ -67,14 +66,12 @@ func TestNewFeedStock_WithNoExpirationDate(t *testing.T) {
 
 func expectNoExpirationDate(t *testing.T, aStockType stockType) {
 	dto := NewStockDTO{
-		"name",
-		aStockType,
-		"1",
-		"2030-01-01T00:00:00.000Z",
-		"",
-		"",
+		Name:           "name",
+		Type:           aStockType,
+		Quantity:       "1",
+		ExpirationDate: "2030-01-01T00:00:00.000Z",
 	}
-	stockItem, err := NewStock(&dto)
+	stockItem, err := NewStock(&dto, builtinStockTypes)
 
 	checkNewItemCreating(t, stockItem, err)
 
@@ -91,14 +88,12 @@ func TestNewAccessory_WithExpirationDate(t *testing.T) {
 
 func TestNewStock_WithInvalidType(t *testing.T) {
 	dto := NewStockDTO{
-		"name",
-		stockType(5),
-		"1",
-		"2030-01-01T00:00:00.000Z",
-		"",
-		"",
+		Name:           "name",
+		Type:           stockType(5),
+		Quantity:       "1",
+		ExpirationDate: "2030-01-01T00:00:00.000Z",
 	}
-	stockItem, err := NewStock(&dto)
+	stockItem, err := NewStock(&dto, builtinStockTypes)
 
 	checkNewItemCreating(t, stockItem, err)
 
@@ -108,3 +103,70 @@ func TestNewStock_WithInvalidType(t *testing.T) {
 		`)
 	}
 }
+
+func TestNewStock_QuantityRules(t *testing.T) {
+	tests := []struct {
+		stockType        stockType
+		expirationDate   string
+		quantity         string
+		minQuantity      string
+		shouldCauseError bool
+		invalidField     string
+	}{
+		{MEDICINE, "2030-01-01T00:00:00.000Z", "2.5", "1.125", false, ""},
+		{MEDICINE, "2030-01-01T00:00:00.000Z", "2.0001", "", true, "quantity"},
+		{MEDICINE, "2030-01-01T00:00:00.000Z", "-1", "", true, "quantity"},
+		{FEED, "2030-01-01T00:00:00.000Z", "12.5", "-0.5", true, "minQuantity"},
+		{ACCESSORY, "", "3", "2", false, ""},
+		{ACCESSORY, "", "3.0", "2.00", false, ""},
+		{ACCESSORY, "", "3.5", "", true, "quantity"},
+		{ACCESSORY, "", "3", "0.5", true, "minQuantity"},
+	}
+
+	for _, test := range tests {
+		dto := NewStockDTO{
+			Name:           "name",
+			Type:           test.stockType,
+			Quantity:       test.quantity,
+			ExpirationDate: test.expirationDate,
+			MinQuantity:    test.minQuantity,
+		}
+		stockItem, err := NewStock(&dto, builtinStockTypes)
+
+		checkNewItemCreating(t, stockItem, err)
+
+		if !test.shouldCauseError && err != nil {
+			t.Fatalf(`NewStock returns an error for valid quantities: %s`, err)
+		}
+		if test.shouldCauseError {
+			ves, ok := err.(ValidationErrors)
+			if !ok || len(ves) != 1 || ves[0].Field != test.invalidField {
+				t.Fatalf(`
+					Expected a validation error for field %s, got %v.
+					Quantity=\"%s\", MinQuantity=\"%s\"`,
+					test.invalidField,
+					err,
+					test.quantity,
+					test.minQuantity)
+			}
+		}
+	}
+}
+
+func TestUpdateStock_QuantityRules(t *testing.T) {
+	stockItem, err := defaultUnexpirableStockItem(ACCESSORY)
+	checkNewItemCreating(t, stockItem, err)
+
+	err = stockItem.Update(StockDTO{ID: stockItem.ID(), Name: "collar", Quantity: "1.5"})
+	if err == nil {
+		t.Fatal(`Update does not return error for a fractional accessory quantity`)
+	}
+	if !stockItem.Quantity().Equal(decimal.New(1, 0)) {
+		t.Fatalf(`Update changes the quantity despite the validation error. Got %s.`, stockItem.Quantity())
+	}
+
+	err = stockItem.Update(StockDTO{ID: stockItem.ID(), Name: "collar", Quantity: "4"})
+	if err != nil {
+		t.Fatalf(`Update returns an error for a valid accessory: %s`, err)
+	}
+}
@@ -0,0 +1,584 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+type stockType int
+
+// MEDICINE, FEED, ACCESSORY and BUNDLE are the default stock types in madmin.
+// They are always present in the stock type registry, other types can be added to it.
+// BUNDLE stock items are kits made of other stock items.
+const (
+	MEDICINE stockType = iota
+	FEED
+	ACCESSORY
+	BUNDLE
+)
+
+// StockTyper is an interface that wraps the StockType method.
+// StockType returns the type of a stock item
+type StockTyper interface {
+	StockType() stockType
+}
+
+func (st stockType) StockType() stockType {
+	return st
+}
+
+// quantityRule describes which quantities are valid for the stock items of a given type
+type quantityRule struct {
+	// IntegerOnly is set for countable stock (e.g. accessories or medicines sold per tablet)
+	IntegerOnly bool
+	// MaxDecimalPlaces limits the precision of the quantity if it is not IntegerOnly
+	MaxDecimalPlaces int32
+	// NonNegative forbids quantities below zero
+	NonNegative bool
+}
+
+// check returns a description of the broken rule or an empty string if the quantity is valid
+func (qr quantityRule) check(quantity decimal.Decimal) string {
+	if qr.NonNegative && quantity.Sign() < 0 {
+		return "quantity must not be negative"
+	}
+	if qr.IntegerOnly && !quantity.Equal(quantity.Truncate(0)) {
+		return "quantity must be an integer"
+	}
+	if !qr.IntegerOnly && !quantity.Equal(quantity.Truncate(qr.MaxDecimalPlaces)) {
+		return fmt.Sprintf("quantity must have at most %d decimal places", qr.MaxDecimalPlaces)
+	}
+	return ""
+}
+
+// Validate checks a quantity against the rule and returns a ValidationError for the given field
+// if the quantity is invalid. It should be used everywhere quantities of stock are changed.
+func (qr quantityRule) Validate(field string, quantity decimal.Decimal) error {
+	if msg := qr.check(quantity); msg != "" {
+		return ValidationErrors{{field, msg}}
+	}
+	return nil
+}
+
+// StockTypeInfo describes a stock type from the stock type registry
+type StockTypeInfo struct {
+	ID   stockType
+	Name string
+
+	Expirable    bool
+	QuantityRule quantityRule
+
+	// ParentID is the id of the category of the stock type or nil if it has no parent
+	ParentID *stockType
+}
+
+// StockTypeReader is an interface that wraps the ReadStockType method.
+// ReadStockType returns the stock type with the given id if it exists.
+type StockTypeReader interface {
+	ReadStockType(stockType) (StockTypeInfo, bool)
+}
+
+// stockTypeMap is a simple in-memory StockTypeReader
+type stockTypeMap map[stockType]StockTypeInfo
+
+func (stm stockTypeMap) ReadStockType(id stockType) (StockTypeInfo, bool) {
+	info, ok := stm[id]
+	return info, ok
+}
+
+// firstCustomStockType is the id of the first stock type added to a registry.
+// The ids below it are reserved for the built-in stock types.
+const firstCustomStockType stockType = 1000
+
+// builtinStockTypes are the stock types every registry starts with
+var builtinStockTypes = stockTypeMap{
+	MEDICINE: {
+		ID:           MEDICINE,
+		Name:         "MEDICINE",
+		Expirable:    true,
+		QuantityRule: quantityRule{MaxDecimalPlaces: 3, NonNegative: true},
+	},
+	FEED: {
+		ID:           FEED,
+		Name:         "FEED",
+		Expirable:    true,
+		QuantityRule: quantityRule{MaxDecimalPlaces: 3, NonNegative: true},
+	},
+	ACCESSORY: {
+		ID:           ACCESSORY,
+		Name:         "ACCESSORY",
+		Expirable:    false,
+		QuantityRule: quantityRule{IntegerOnly: true, NonNegative: true},
+	},
+	BUNDLE: {
+		ID:           BUNDLE,
+		Name:         "BUNDLE",
+		Expirable:    false,
+		QuantityRule: quantityRule{IntegerOnly: true, NonNegative: true},
+	},
+}
+
+// StockTypeRegistry manages the stock types that stock items can have.
+// The changes of stock types are recorded in the audit trail that is passed with them.
+// A change fails and is rolled back if it cannot be recorded.
+type StockTypeRegistry interface {
+	StockTypeReader
+
+	CreateStockType(StockTypeInfo, *auditTrail) (stockType, error)
+	UpdateStockType(StockTypeInfo, *auditTrail) error
+	DeleteStockType(stockType, *auditTrail) error
+
+	// StockTypes() returns a map with all registered stock types
+	StockTypes() map[stockType]StockTypeInfo
+}
+
+type defaultStockTypeRegistry struct {
+	database *sql.DB
+}
+
+// NewStockTypeRegistry creates a stock type registry that keeps the stock types
+// in a sqlite3 table inside the db that is passed as an argument.
+// The built-in stock types are added to the table if they are missing.
+func NewStockTypeRegistry(db *sql.DB) StockTypeRegistry {
+	str := &defaultStockTypeRegistry{database: db}
+
+	str.initStockTypesTable()
+
+	return str
+}
+
+func (str *defaultStockTypeRegistry) initStockTypesTable() {
+	stockTypesTable := `
+	CREATE TABLE IF NOT EXISTS
+		stock_types (
+			id INTEGER NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			expirable BOOLEAN NOT NULL,
+			integer_only BOOLEAN NOT NULL,
+			max_decimal_places INTEGER NOT NULL,
+			non_negative BOOLEAN NOT NULL,
+			parent_id INTEGER,
+			builtin BOOLEAN NOT NULL DEFAULT 0,
+			FOREIGN KEY (parent_id) REFERENCES stock_types (id)
+	);
+	`
+	_, err := str.database.Exec(stockTypesTable)
+	if err != nil {
+		panic(err)
+	}
+
+	addColumnIfMissing(str.database, "stock_types", "builtin", "BOOLEAN NOT NULL DEFAULT 0")
+	str.moveCustomStockTypes()
+
+	stmt, err := str.database.Prepare(`
+		INSERT OR IGNORE INTO
+			stock_types (
+				id,
+				name,
+				expirable,
+				integer_only,
+				max_decimal_places,
+				non_negative,
+				builtin)
+		VALUES(?, ?, ?, ?, ?, ?, 1)
+	`)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+
+	for _, info := range builtinStockTypes {
+		_, err = stmt.Exec(
+			info.ID,
+			info.Name,
+			info.Expirable,
+			info.QuantityRule.IntegerOnly,
+			info.QuantityRule.MaxDecimalPlaces,
+			info.QuantityRule.NonNegative)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// moveCustomStockTypes gives the custom stock types with reserved ids new ids from firstCustomStockType on.
+// Custom stock types used to take the ids right after the built-in ones, so on DBs created before
+// the BUNDLE stock type was added, the first custom stock type has its id. The built-in stock types
+// that were added before they were marked as built-in are marked on the way.
+func (str *defaultStockTypeRegistry) moveCustomStockTypes() {
+	tx, err := str.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, name FROM stock_types WHERE builtin = 0 AND id < ? ORDER BY id`, firstCustomStockType)
+	if err != nil {
+		panic(err)
+	}
+	types := make([]StockTypeInfo, 0)
+	for rows.Next() {
+		var info StockTypeInfo
+		if err = rows.Scan(&info.ID, &info.Name); err != nil {
+			panic(err)
+		}
+		types = append(types, info)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	for _, t := range types {
+		id, name := t.ID, t.Name
+		// MEDICINE, FEED and ACCESSORY were always added with the table, but a renamed BUNDLE
+		// cannot be told apart from a custom stock type
+		if info, ok := builtinStockTypes[id]; ok && (id != BUNDLE || name == info.Name) {
+			_, err = tx.Exec(`UPDATE stock_types SET builtin = 1 WHERE id = ?`, id)
+			if err != nil {
+				panic(err)
+			}
+			continue
+		}
+
+		var newID stockType
+		err = tx.QueryRow(`SELECT MAX(COALESCE(MAX(id) + 1, 0), ?) FROM stock_types`, firstCustomStockType).Scan(&newID)
+		if err != nil {
+			panic(err)
+		}
+		// the names of the built-in stock types are kept free for them
+		if isBuiltinStockTypeName(name) {
+			name += " (custom)"
+		}
+
+		_, err = tx.Exec(`UPDATE stock_types SET id = ?, name = ? WHERE id = ?`, newID, name, id)
+		if err != nil {
+			panic(err)
+		}
+		for _, query := range []string{
+			`UPDATE stock_types SET parent_id = ? WHERE parent_id = ?`,
+			`UPDATE warehouse SET type = ? WHERE type = ?`,
+		} {
+			_, err = tx.Exec(query, newID, id)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func isBuiltinStockTypeName(name string) bool {
+	for _, info := range builtinStockTypes {
+		if info.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateStockType checks the fields of a stock type that is about to be written in the DB
+func (str *defaultStockTypeRegistry) validateStockType(info StockTypeInfo) error {
+	errs := ValidationErrors{}
+
+	if info.Name == "" {
+		errs = append(errs, ValidationError{"name", "cannot set empty string as name"})
+	}
+	if info.QuantityRule.MaxDecimalPlaces < 0 {
+		errs = append(errs, ValidationError{"maxDecimalPlaces", "must not be negative"})
+	}
+	if info.ParentID != nil {
+		if _, ok := str.ReadStockType(*info.ParentID); !ok {
+			errs = append(errs, ValidationError{"parentID", "no such stock type"})
+		} else if str.isDescendant(*info.ParentID, info.ID) {
+			errs = append(errs, ValidationError{"parentID", "stock type cannot be its own parent"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// isDescendant checks if ancestor is on the path from the stock type with id to its root category
+func (str *defaultStockTypeRegistry) isDescendant(id, ancestor stockType) bool {
+	types := str.StockTypes()
+
+	for visited := 0; visited <= len(types); visited++ {
+		if id == ancestor {
+			return true
+		}
+		info, ok := types[id]
+		if !ok || info.ParentID == nil {
+			return false
+		}
+		id = *info.ParentID
+	}
+	return true
+}
+
+// insert in DB
+func (str *defaultStockTypeRegistry) CreateStockType(info StockTypeInfo, trail *auditTrail) (stockType, error) {
+	// a new stock type has no id yet, so it cannot be an ancestor of anything
+	info.ID = -1
+	if err := str.validateStockType(info); err != nil {
+		return 0, err
+	}
+
+	tx, err := str.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	// custom stock types never take the ids reserved for the built-in ones
+	result, err := tx.Exec(`
+		INSERT INTO
+			stock_types (
+				id,
+				name,
+				expirable,
+				integer_only,
+				max_decimal_places,
+				non_negative,
+				parent_id)
+		VALUES((SELECT MAX(COALESCE(MAX(id) + 1, 0), ?) FROM stock_types), ?, ?, ?, ?, ?, ?)
+	`,
+		firstCustomStockType,
+		info.Name,
+		info.Expirable,
+		info.QuantityRule.IntegerOnly,
+		info.QuantityRule.MaxDecimalPlaces,
+		info.QuantityRule.NonNegative,
+		info.ParentID)
+	if err != nil {
+		return 0, err
+	}
+
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		panic(err)
+	}
+	id := stockType(lastID)
+
+	created, _ := readStockType(tx, id)
+	if err := trail.recordTx(tx, AUDIT_STOCK_TYPE, fmt.Sprintf("%d", id), nil, newStockTypeDTO(created)); err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	return id, nil
+}
+
+// read from DB
+func (str *defaultStockTypeRegistry) ReadStockType(id stockType) (StockTypeInfo, bool) {
+	return readStockType(str.database, id)
+}
+
+func readStockType(q querier, id stockType) (StockTypeInfo, bool) {
+	info := StockTypeInfo{ID: id}
+	err := q.QueryRow(`
+	SELECT
+		name,
+		expirable,
+		integer_only,
+		max_decimal_places,
+		non_negative,
+		parent_id
+	FROM
+		stock_types
+	WHERE
+		id = ?
+	`, id).Scan(
+		&info.Name,
+		&info.Expirable,
+		&info.QuantityRule.IntegerOnly,
+		&info.QuantityRule.MaxDecimalPlaces,
+		&info.QuantityRule.NonNegative,
+		&info.ParentID)
+	switch {
+	case err == sql.ErrNoRows:
+		return StockTypeInfo{}, false
+	case err != nil:
+		panic(err)
+	}
+
+	return info, true
+}
+
+// usedByStockItems checks if any stock item in the warehouse has the stock type with id
+func (str *defaultStockTypeRegistry) usedByStockItems(id stockType) bool {
+	var items int
+	err := str.database.QueryRow(`SELECT COUNT(*) FROM warehouse WHERE type = ?`, id).Scan(&items)
+	if err != nil {
+		panic(err)
+	}
+	return items > 0
+}
+
+// update in DB
+// The expiration and quantity rules of a stock type cannot be changed while stock items use it,
+// since the existing items were validated against the old rules.
+func (str *defaultStockTypeRegistry) UpdateStockType(info StockTypeInfo, trail *auditTrail) error {
+	current, ok := str.ReadStockType(info.ID)
+	if !ok {
+		return errors.New("trying to update stock type that does not exist")
+	}
+	if err := str.validateStockType(info); err != nil {
+		return err
+	}
+	if (current.Expirable != info.Expirable || current.QuantityRule != info.QuantityRule) && str.usedByStockItems(info.ID) {
+		return ValidationErrors{{"quantityRule", "cannot change the expiration or quantity rules of a stock type used by stock items"}}
+	}
+
+	tx, err := str.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE
+		stock_types
+	SET
+		name = ?,
+		expirable = ?,
+		integer_only = ?,
+		max_decimal_places = ?,
+		non_negative = ?,
+		parent_id = ?
+	WHERE
+		id = ?
+	`,
+		info.Name,
+		info.Expirable,
+		info.QuantityRule.IntegerOnly,
+		info.QuantityRule.MaxDecimalPlaces,
+		info.QuantityRule.NonNegative,
+		info.ParentID,
+		info.ID)
+	if err != nil {
+		return err
+	}
+
+	updated, _ := readStockType(tx, info.ID)
+	if err := trail.recordTx(tx, AUDIT_STOCK_TYPE, fmt.Sprintf("%d", info.ID), newStockTypeDTO(current), newStockTypeDTO(updated)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// remove from DB
+// Built-in stock types and stock types that are still used by stock items
+// or other stock types cannot be removed.
+func (str *defaultStockTypeRegistry) DeleteStockType(id stockType, trail *auditTrail) error {
+	if _, ok := builtinStockTypes[id]; ok {
+		return errors.New("built-in stock types cannot be removed")
+	}
+
+	var usages int
+	err := str.database.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM warehouse WHERE type = ?) +
+			(SELECT COUNT(*) FROM stock_types WHERE parent_id = ?)
+	`, id, id).Scan(&usages)
+	if err != nil {
+		panic(err)
+	}
+	if usages > 0 {
+		return errors.New("stock type is still used by stock items or other stock types")
+	}
+
+	tx, err := str.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	current, ok := readStockType(tx, id)
+	if !ok {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM
+			stock_types
+		WHERE
+			id = ?
+	`, id)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := trail.recordTx(tx, AUDIT_STOCK_TYPE, fmt.Sprintf("%d", id), newStockTypeDTO(current), nil); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+// Returns a map with all stock types with ids as keys and the stock types as their values.
+func (str *defaultStockTypeRegistry) StockTypes() (types map[stockType]StockTypeInfo) {
+	types = make(map[stockType]StockTypeInfo)
+
+	query := `
+		SELECT
+			id,
+			name,
+			expirable,
+			integer_only,
+			max_decimal_places,
+			non_negative,
+			parent_id
+		FROM
+			stock_types
+	`
+
+	rows, err := str.database.Query(query)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var info StockTypeInfo
+		err = rows.Scan(
+			&info.ID,
+			&info.Name,
+			&info.Expirable,
+			&info.QuantityRule.IntegerOnly,
+			&info.QuantityRule.MaxDecimalPlaces,
+			&info.QuantityRule.NonNegative,
+			&info.ParentID)
+		if err != nil {
+			panic(err)
+		}
+
+		types[info.ID] = info
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (m *madminHandler) stockTypesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listStockTypesHandler(w, r)
+	case "POST":
+		m.addStockTypeHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+func (m *madminHandler) stockTypeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getStockTypeHandler(w, r)
+	case "DELETE":
+		m.removeStockTypeHandler(w, r)
+	case "PUT":
+		m.updateStockTypeHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// stockTypeID returns the id of the stock type from the request path
+func stockTypeID(r *http.Request) stockType {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		// the route only matches numeric ids
+		panic(err)
+	}
+	return stockType(id)
+}
+
+// Handler for GET /stock-types/
+//
+// Lists the registered stock types.
+func (m *madminHandler) listStockTypesHandler(w http.ResponseWriter, r *http.Request) {
+	types := m.warehouse.StockTypes().StockTypes()
+
+	resp := &CollectionResponseDTO{"List of stock types", make([]string, 0, len(types))}
+	for id := range types {
+		resp.URLs = append(resp.URLs, fmt.Sprintf("/data/stock-types/%d", id))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for GET /stock-types/<id>
+//
+// Returns JSON with data for the stock type with the given id
+// or an empty response with status code 404 if there is no such stock type.
+func (m *madminHandler) getStockTypeHandler(w http.ResponseWriter, r *http.Request) {
+	info, ok := m.warehouse.StockTypes().ReadStockType(stockTypeID(r))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newStockTypeDTO(info))
+}
+
+// Handler for POST /stock-types/
+//
+// Adds a stock type to the registry and returns its id.
+func (m *madminHandler) addStockTypeHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &StockTypeDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	id, err := m.warehouse.StockTypes().CreateStockType(dto.stockTypeInfo(), m.auditTrail(r))
+	if err != nil {
+		log.Printf("Error in creating stock type: %s", err)
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "%d", id)
+}
+
+// Handler for PUT /stock-types/<id>
+//
+// Updates the stock type with <id>.
+func (m *madminHandler) updateStockTypeHandler(w http.ResponseWriter, r *http.Request) {
+	id := stockTypeID(r)
+
+	dto := &StockTypeDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+	dto.ID = id
+
+	if _, ok := m.warehouse.StockTypes().ReadStockType(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err := m.warehouse.StockTypes().UpdateStockType(dto.stockTypeInfo(), m.auditTrail(r))
+	if err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for DELETE /stock-types/<id>
+//
+// Removes the stock type with <id> if no stock items or other stock types use it.
+func (m *madminHandler) removeStockTypeHandler(w http.ResponseWriter, r *http.Request) {
+	id := stockTypeID(r)
+
+	err := m.warehouse.StockTypes().DeleteStockType(id, m.auditTrail(r))
+	if errors.As(err, new(*auditError)) {
+		respondChangeError(w, err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Error in removing stock type: %s", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,217 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestStockTypeRegistry(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	wh := NewWarehouse(db)
+	registry := wh.StockTypes()
+
+	t.Run("HasBuiltinTypes", func(t *testing.T) {
+		checkIfTableExists(t, db, "stock_types")
+
+		for id, expected := range builtinStockTypes {
+			info, ok := registry.ReadStockType(id)
+			if !ok {
+				t.Fatalf(`built-in stock type %s not found in registry`, expected.Name)
+			}
+			if info.Name != expected.Name || info.Expirable != expected.Expirable || info.QuantityRule != expected.QuantityRule {
+				t.Fatalf(`
+					Read stock type is different from expected.
+					Expected %+v, got %+v.`,
+					expected,
+					info)
+			}
+		}
+	})
+	t.Run("CreateStockType", func(t *testing.T) {
+		parent := MEDICINE
+		id, err := registry.CreateStockType(StockTypeInfo{
+			Name:         "VACCINE",
+			Expirable:    true,
+			QuantityRule: quantityRule{IntegerOnly: true, NonNegative: true},
+			ParentID:     &parent,
+		}, nil)
+		if err != nil {
+			t.Fatalf(`CreateStockType returns an error for a valid stock type: %s`, err)
+		}
+		if id < firstCustomStockType {
+			t.Fatalf(`CreateStockType gives the reserved id %d to a custom stock type`, id)
+		}
+
+		dto := NewStockDTO{
+			Name:           "Rabies vaccine",
+			Type:           id,
+			Quantity:       "10",
+			ExpirationDate: "2030-01-01T00:00:00.000Z",
+		}
+		item, err := NewStock(&dto, registry)
+		checkNewItemCreating(t, item, err)
+		if err != nil {
+			t.Fatalf(`NewStock returns an error for a registered stock type: %s`, err)
+		}
+
+		wh.CreateStock(item, nil)
+		read, ok := wh.ReadStock(item.ID())
+		if !ok || !compareStock(read, item) {
+			t.Fatalf(`
+				Read item is different from expected.
+				Expected %+v, got %+v.`,
+				item,
+				read)
+		}
+
+		dto.Quantity = "2.5"
+		_, err = NewStock(&dto, registry)
+		if err == nil {
+			t.Fatalf(`NewStock does not use the quantity rule of the registered stock type`)
+		}
+
+		err = registry.DeleteStockType(id, nil)
+		if err == nil {
+			t.Fatalf(`DeleteStockType removes a stock type used by stock items`)
+		}
+
+		info, _ := registry.ReadStockType(id)
+		info.QuantityRule = quantityRule{MaxDecimalPlaces: 1}
+		if err := registry.UpdateStockType(info, nil); err == nil {
+			t.Fatalf(`UpdateStockType changes the quantity rule of a stock type used by stock items`)
+		}
+		info, _ = registry.ReadStockType(id)
+		info.Expirable = false
+		if err := registry.UpdateStockType(info, nil); err == nil {
+			t.Fatalf(`UpdateStockType changes the expiration rule of a stock type used by stock items`)
+		}
+		info, _ = registry.ReadStockType(id)
+		info.Name = "VACCINES"
+		if err := registry.UpdateStockType(info, nil); err != nil {
+			t.Fatalf(`UpdateStockType returns an error for renaming a used stock type: %s`, err)
+		}
+	})
+	t.Run("CreateStockType_WithInvalidParent", func(t *testing.T) {
+		parent := firstCustomStockType - 1
+		_, err := registry.CreateStockType(StockTypeInfo{Name: "KIT", ParentID: &parent}, nil)
+		if err == nil {
+			t.Fatalf(`CreateStockType does not return error for a missing parent`)
+		}
+	})
+	t.Run("DeleteStockType_Builtin", func(t *testing.T) {
+		for id := range builtinStockTypes {
+			if err := registry.DeleteStockType(id, nil); err == nil {
+				t.Fatalf(`DeleteStockType removes the built-in stock type %d`, id)
+			}
+			if _, ok := registry.ReadStockType(id); !ok {
+				t.Fatalf(`built-in stock type %d was removed`, id)
+			}
+		}
+	})
+	t.Run("UpdateStockType_WithCycle", func(t *testing.T) {
+		id, err := registry.CreateStockType(StockTypeInfo{Name: "COSMETICS"}, nil)
+		if err != nil {
+			t.Fatalf(`CreateStockType returns an error for a valid stock type: %s`, err)
+		}
+		childParent := id
+		child, err := registry.CreateStockType(StockTypeInfo{Name: "SHAMPOO", ParentID: &childParent}, nil)
+		if err != nil {
+			t.Fatalf(`CreateStockType returns an error for a valid stock type: %s`, err)
+		}
+
+		info, _ := registry.ReadStockType(id)
+		info.ParentID = &child
+		if err := registry.UpdateStockType(info, nil); err == nil {
+			t.Fatalf(`UpdateStockType allows cycles in stock type categories`)
+		}
+
+		if err := registry.DeleteStockType(child, nil); err != nil {
+			t.Fatalf(`DeleteStockType returns an error for an unused stock type: %s`, err)
+		}
+		if _, ok := registry.ReadStockType(child); ok {
+			t.Fatalf(`reads deleted stock type from database ???`)
+		}
+	})
+}
+
+func TestStockTypeRegistryUpgrade(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	// before BUNDLE was added, the first custom stock type took its id
+	_, err := db.Exec(`
+	CREATE TABLE warehouse(
+		id BLOB NOT NULL PRIMARY KEY,
+		type TEXT NOT NULL,
+		name TEXT,
+		quantity NUMERIC NOT NULL,
+		min_quantity NUMERIC,
+		expiration_date DATETIME,
+		distributor_id BLOB
+	);
+	CREATE TABLE stock_types (
+		id INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		expirable BOOLEAN NOT NULL,
+		integer_only BOOLEAN NOT NULL,
+		max_decimal_places INTEGER NOT NULL,
+		non_negative BOOLEAN NOT NULL,
+		parent_id INTEGER
+	);
+	INSERT INTO stock_types VALUES
+		(0, 'MEDICINE', 1, 0, 3, 1, NULL),
+		(1, 'FEED', 1, 0, 3, 1, NULL),
+		(2, 'ACCESSORY', 0, 1, 0, 1, NULL);
+	INSERT INTO stock_types (name, expirable, integer_only, max_decimal_places, non_negative, parent_id) VALUES
+		('VACCINE', 1, 1, 0, 1, 0);
+	INSERT INTO stock_types (name, expirable, integer_only, max_decimal_places, non_negative, parent_id) VALUES
+		('RABIES VACCINE', 1, 1, 0, 1, 3);
+	INSERT INTO warehouse VALUES ('vaccine-item', '3', 'Rabies vaccine', '10', '0', '2030-01-01 00:00:00+00:00', '');
+	`)
+	if err != nil {
+		t.Fatalf(`Error in creating the old schema: %s`, err)
+	}
+
+	wh := NewWarehouse(db)
+	registry := wh.StockTypes()
+
+	if info, ok := registry.ReadStockType(BUNDLE); !ok || info.Name != "BUNDLE" {
+		t.Fatalf(`BUNDLE is not added with its reserved id: %+v`, info)
+	}
+
+	var vaccine, rabies StockTypeInfo
+	for _, info := range registry.StockTypes() {
+		switch info.Name {
+		case "VACCINE":
+			vaccine = info
+		case "RABIES VACCINE":
+			rabies = info
+		}
+	}
+	if vaccine.ID < firstCustomStockType || !vaccine.Expirable || vaccine.ParentID == nil || *vaccine.ParentID != MEDICINE {
+		t.Fatalf(`The custom stock type is not moved out of the reserved ids: %+v`, vaccine)
+	}
+	if rabies.ParentID == nil || *rabies.ParentID != vaccine.ID {
+		t.Fatalf(`The child of the moved stock type does not follow it: %+v`, rabies)
+	}
+	if item, ok := wh.ReadStock("vaccine-item"); !ok || item.Type() != vaccine.ID {
+		t.Fatalf(`The stock item of the moved stock type does not follow it: %+v`, item)
+	}
+
+	// a renamed BUNDLE stays in place when the DB is opened again
+	bundle, _ := registry.ReadStockType(BUNDLE)
+	bundle.Name = "KIT"
+	if err := registry.UpdateStockType(bundle, nil); err != nil {
+		t.Fatalf(`UpdateStockType returns an error for renaming BUNDLE: %s`, err)
+	}
+	registry = NewWarehouse(db).StockTypes()
+	if info, ok := registry.ReadStockType(BUNDLE); !ok || info.Name != "KIT" {
+		t.Fatalf(`The renamed BUNDLE is moved when the DB is opened again: %+v`, info)
+	}
+	if info, ok := registry.ReadStockType(vaccine.ID); !ok || info.Name != "VACCINE" {
+		t.Fatalf(`The moved stock type is moved again when the DB is opened again: %+v`, info)
+	}
+}
@@ -0,0 +1,582 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type stocktakeState string
+
+// OPEN stocktakes take counts until they are APPROVED or CANCELLED.
+// Approving a stocktake posts an adjustment for every discrepancy.
+const (
+	OPEN      stocktakeState = "open"
+	APPROVED  stocktakeState = "approved"
+	CANCELLED stocktakeState = "cancelled"
+)
+
+// Stocktake is a physical count of the whole warehouse.
+// The expected quantities of all stock items and lots are saved when the stocktake is created.
+type Stocktake struct {
+	ID   string
+	Name string
+
+	// Blind stocktakes hide the expected quantities on the count sheet until they are closed
+	Blind bool
+	State stocktakeState
+
+	Created time.Time
+	UserID  string
+
+	// Closed and ClosedBy are set when the stocktake is approved or cancelled
+	Closed   *time.Time
+	ClosedBy string
+}
+
+// StocktakeLine is a line of the count sheet of a stocktake. Stock items have a line
+// for their total quantity and a line for each of their lots.
+type StocktakeLine struct {
+	StockID string
+	// LotID is empty for the line with the total quantity of the stock item
+	LotID string
+
+	Name       string
+	LotNumber  string
+	LocationID string
+	// UnitCost is the unit cost of the lot or zero for the lines of stock items
+	UnitCost decimal.Decimal
+
+	// Expected is the quantity when the stocktake was created
+	Expected decimal.Decimal
+	// Counted is the latest count of the line or nil if the line is not counted yet
+	Counted   *decimal.Decimal
+	CountedBy string
+	// Counts is the number of counts submitted for the line
+	Counts int
+
+	controlled bool
+}
+
+// Variance returns the difference between the counted and the expected quantity
+// or zero if the line is not counted
+func (sl *StocktakeLine) Variance() decimal.Decimal {
+	if sl.Counted == nil {
+		return decimal.Zero
+	}
+	return sl.Counted.Sub(sl.Expected)
+}
+
+// Value returns the purchase value of the variance
+func (sl *StocktakeLine) Value() decimal.Decimal {
+	return sl.Variance().Mul(sl.UnitCost)
+}
+
+// StocktakeCount is a count of a line of a stocktake submitted by a user
+type StocktakeCount struct {
+	StockID  string
+	LotID    string
+	Quantity decimal.Decimal
+	UserID   string
+}
+
+// StocktakeApproval is the signature of the adjustments posted by a stocktake.
+// Adjustments of controlled substances need a witness and are authorised
+// by the prescribing vet named in Authorizer.
+type StocktakeApproval struct {
+	UserID     string
+	WitnessID  string
+	Authorizer string
+}
+
+func (wh *dafaultWarehouse) initStocktakeTables() {
+	stocktakeTables := `
+	CREATE TABLE IF NOT EXISTS
+		stocktakes (
+			id TEXT NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL,
+			blind BOOLEAN NOT NULL,
+			state TEXT NOT NULL,
+			created DATETIME NOT NULL,
+			user_id TEXT NOT NULL,
+			closed DATETIME,
+			closed_by TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS
+		stocktake_lines (
+			stocktake_id TEXT NOT NULL,
+			stock_id TEXT NOT NULL,
+			lot_id TEXT NOT NULL,
+			expected NUMERIC NOT NULL,
+			PRIMARY KEY (stocktake_id, stock_id, lot_id),
+			FOREIGN KEY (stocktake_id) REFERENCES stocktakes (id)
+	);
+	CREATE TABLE IF NOT EXISTS
+		stocktake_counts (
+			stocktake_id TEXT NOT NULL,
+			stock_id TEXT NOT NULL,
+			lot_id TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			user_id TEXT NOT NULL,
+			time DATETIME NOT NULL,
+			FOREIGN KEY (stocktake_id, stock_id, lot_id) REFERENCES stocktake_lines (stocktake_id, stock_id, lot_id)
+	);
+	`
+	_, err := wh.database.Exec(stocktakeTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// stocktakeReference is the reference of the adjustments posted by a stocktake
+func stocktakeReference(st *Stocktake) string {
+	return fmt.Sprintf("stocktake %s: %s", st.ID, st.Name)
+}
+
+// CreateStocktake opens a stocktake and saves the current quantities of all stock items
+// and of their lots that are not written off. Only one stocktake can be open at a time.
+func (wh *dafaultWarehouse) CreateStocktake(st *Stocktake) error {
+	if st.Name == "" {
+		return ValidationErrors{{"name", "cannot set empty string as name"}}
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	st.ID = id
+	st.State = OPEN
+	st.Created = time.Now().UTC()
+	st.Closed = nil
+	st.ClosedBy = ""
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	var open int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM stocktakes WHERE state = ?`, OPEN).Scan(&open)
+	if err != nil {
+		panic(err)
+	}
+	if open > 0 {
+		return ValidationErrors{{"state", "another stocktake is still open"}}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			stocktakes (
+				id,
+				name,
+				blind,
+				state,
+				created,
+				user_id,
+				closed_by)
+		VALUES(?, ?, ?, ?, ?, ?, '')
+	`,
+		st.ID,
+		st.Name,
+		st.Blind,
+		st.State,
+		st.Created,
+		st.UserID)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			stocktake_lines (
+				stocktake_id,
+				stock_id,
+				lot_id,
+				expected)
+		SELECT ?, id, '', quantity FROM warehouse
+		UNION ALL
+		SELECT ?, stock_id, id, quantity FROM stock_lots WHERE state != ? AND quantity != 0
+	`, st.ID, st.ID, WRITTEN_OFF)
+	if err != nil {
+		panic(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// stocktakeColumns are the columns selected by the stocktake queries, in the order expected by scanStocktake
+const stocktakeColumns = `
+	id,
+	name,
+	blind,
+	state,
+	created,
+	user_id,
+	closed,
+	closed_by
+`
+
+func scanStocktake(row rowScanner) (*Stocktake, error) {
+	st := &Stocktake{}
+	err := row.Scan(
+		&st.ID,
+		&st.Name,
+		&st.Blind,
+		&st.State,
+		&st.Created,
+		&st.UserID,
+		&st.Closed,
+		&st.ClosedBy)
+	return st, err
+}
+
+func (wh *dafaultWarehouse) ReadStocktake(id string) (*Stocktake, bool) {
+	st, err := scanStocktake(wh.database.QueryRow(`SELECT `+stocktakeColumns+` FROM stocktakes WHERE id = ?`, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+	return st, true
+}
+
+// Stocktakes returns all stocktakes, the latest are first
+func (wh *dafaultWarehouse) Stocktakes() []*Stocktake {
+	rows, err := wh.database.Query(`SELECT ` + stocktakeColumns + ` FROM stocktakes ORDER BY created DESC`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	stocktakes := make([]*Stocktake, 0)
+	for rows.Next() {
+		st, err := scanStocktake(rows)
+		if err != nil {
+			panic(err)
+		}
+		stocktakes = append(stocktakes, st)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return stocktakes
+}
+
+// StocktakeLines returns the count sheet of a stocktake with the latest count of every line.
+// The lines are sorted by name. The line of a stock item's total is followed by the lines of its lots.
+func (wh *dafaultWarehouse) StocktakeLines(id string) []*StocktakeLine {
+	return stocktakeLinesTx(wh.database, id)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func stocktakeLinesTx(q querier, id string) []*StocktakeLine {
+	rows, err := q.Query(`
+		SELECT
+			sl.stock_id,
+			sl.lot_id,
+			COALESCE(w.name, ''),
+			COALESCE(l.number, ''),
+			COALESCE(l.location_id, ''),
+			COALESCE(l.unit_cost, 0),
+			sl.expected,
+			c.quantity,
+			COALESCE(c.user_id, ''),
+			COALESCE(w.controlled_schedule, '') != '',
+			(SELECT COUNT(*) FROM stocktake_counts n
+				WHERE n.stocktake_id = sl.stocktake_id AND n.stock_id = sl.stock_id AND n.lot_id = sl.lot_id)
+		FROM
+			stocktake_lines sl
+		LEFT JOIN
+			warehouse w ON w.id = sl.stock_id
+		LEFT JOIN
+			stock_lots l ON l.id = sl.lot_id
+		LEFT JOIN
+			stocktake_counts c ON c.rowid = (
+				SELECT MAX(rowid) FROM stocktake_counts n
+				WHERE n.stocktake_id = sl.stocktake_id AND n.stock_id = sl.stock_id AND n.lot_id = sl.lot_id)
+		WHERE
+			sl.stocktake_id = ?
+		ORDER BY
+			w.name, sl.stock_id, sl.lot_id != '', l.location_id, l.number
+	`, id)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	lines := make([]*StocktakeLine, 0)
+	for rows.Next() {
+		var (
+			line    = &StocktakeLine{}
+			counted decimal.NullDecimal
+		)
+		err = rows.Scan(
+			&line.StockID,
+			&line.LotID,
+			&line.Name,
+			&line.LotNumber,
+			&line.LocationID,
+			&line.UnitCost,
+			&line.Expected,
+			&counted,
+			&line.CountedBy,
+			&line.controlled,
+			&line.Counts)
+		if err != nil {
+			panic(err)
+		}
+		if counted.Valid {
+			line.Counted = &counted.Decimal
+		}
+		lines = append(lines, line)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return lines
+}
+
+// RecordCounts saves counts of the lines of an open stocktake.
+// A line can be counted many times, the latest count is used for the adjustment.
+func (wh *dafaultWarehouse) RecordCounts(id string, counts []StocktakeCount) error {
+	st, ok := wh.ReadStocktake(id)
+	if !ok {
+		return errors.New("no such stocktake")
+	}
+	if st.State != OPEN {
+		return ValidationErrors{{"state", fmt.Sprintf("the stocktake is %s", st.State)}}
+	}
+
+	errs := ValidationErrors{}
+	for i, count := range counts {
+		field := fmt.Sprintf("counts[%d].quantity", i)
+
+		item, ok := wh.ReadStock(count.StockID)
+		if !ok {
+			errs = append(errs, ValidationError{fmt.Sprintf("counts[%d].stockID", i), "no such stock item"})
+			continue
+		}
+		rule := item.QuantityRule()
+		rule.NonNegative = true
+		if err := rule.Validate(field, count.Quantity); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for i, count := range counts {
+		var lines int
+		err = tx.QueryRow(`
+			SELECT
+				COUNT(*)
+			FROM
+				stocktake_lines
+			WHERE
+				stocktake_id = ? AND stock_id = ? AND lot_id = ?
+		`, id, count.StockID, count.LotID).Scan(&lines)
+		if err != nil {
+			panic(err)
+		}
+		if lines == 0 {
+			errs = append(errs, ValidationError{fmt.Sprintf("counts[%d].lotID", i), "the lot is not on the count sheet"})
+			continue
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO
+				stocktake_counts (
+					stocktake_id,
+					stock_id,
+					lot_id,
+					quantity,
+					user_id,
+					time)
+			VALUES(?, ?, ?, ?, ?, ?)
+		`, id, count.StockID, count.LotID, count.Quantity.String(), count.UserID, now)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// closeStocktakeTx moves an open stocktake to the given state
+func closeStocktakeTx(tx *sql.Tx, st *Stocktake, to stocktakeState, userID string) error {
+	if st.State != OPEN {
+		return ValidationErrors{{"state", fmt.Sprintf("the stocktake is %s", st.State)}}
+	}
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(`
+		UPDATE
+			stocktakes
+		SET
+			state = ?,
+			closed = ?,
+			closed_by = ?
+		WHERE
+			id = ? AND state = ?
+	`, to, now, userID, st.ID, OPEN)
+	if err != nil {
+		panic(err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ValidationErrors{{"state", "the stocktake is already closed"}}
+	}
+
+	st.State = to
+	st.Closed = &now
+	st.ClosedBy = userID
+	return nil
+}
+
+// stocktakeAdjustments returns the adjustments that reconcile the counted lines of a stocktake.
+// Lots are adjusted by their variance. The total of a stock item is adjusted by the part
+// of its variance that is not covered by the adjustments of its lots.
+func stocktakeAdjustments(st *Stocktake, lines []*StocktakeLine, approval *StocktakeApproval) []*Movement {
+	var (
+		adjustments = make([]*Movement, 0)
+		lotVariance = make(map[string]decimal.Decimal)
+		totals      = make([]*StocktakeLine, 0)
+	)
+	adjustment := func(line *StocktakeLine, quantity decimal.Decimal) *Movement {
+		mv := &Movement{
+			StockID:   line.StockID,
+			Kind:      ADJUSTMENT,
+			Quantity:  quantity,
+			UserID:    approval.UserID,
+			Reference: stocktakeReference(st),
+			LotID:     line.LotID,
+		}
+		if line.controlled {
+			// the register shows the stocktake in place of the owner
+			mv.PatientRef = stocktakeReference(st)
+			mv.Prescriber = approval.Authorizer
+			mv.WitnessID = approval.WitnessID
+		}
+		return mv
+	}
+
+	for _, line := range lines {
+		if line.LotID == "" {
+			totals = append(totals, line)
+			continue
+		}
+		if variance := line.Variance(); !variance.IsZero() {
+			adjustments = append(adjustments, adjustment(line, variance))
+			lotVariance[line.StockID] = lotVariance[line.StockID].Add(variance)
+		}
+	}
+	for _, line := range totals {
+		if line.Counted == nil {
+			continue
+		}
+		if rest := line.Variance().Sub(lotVariance[line.StockID]); !rest.IsZero() {
+			adjustments = append(adjustments, adjustment(line, rest))
+		}
+	}
+
+	return adjustments
+}
+
+// ApproveStocktake closes an open stocktake and posts an adjustment for every discrepancy
+// in a single transaction. Lines that are not counted are not adjusted.
+// The adjustments change the current quantities by the variances, so movements recorded
+// during the stocktake are kept. If an adjustment is not allowed, nothing is posted.
+func (wh *dafaultWarehouse) ApproveStocktake(id string, approval *StocktakeApproval) ([]*Movement, error) {
+	st, ok := wh.ReadStocktake(id)
+	if !ok {
+		return nil, errors.New("no such stocktake")
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	if err := closeStocktakeTx(tx, st, APPROVED, approval.UserID); err != nil {
+		return nil, err
+	}
+
+	adjustments := stocktakeAdjustments(st, stocktakeLinesTx(tx, id), approval)
+	for _, mv := range adjustments {
+		if err := wh.recordMovementTx(tx, mv); err != nil {
+			if errs, ok := err.(ValidationErrors); ok {
+				for i := range errs {
+					errs[i].Field = fmt.Sprintf("%s.%s", mv.StockID, errs[i].Field)
+				}
+			}
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, mv := range adjustments {
+		wh.publishMovement(mv)
+	}
+	return adjustments, nil
+}
+
+// CancelStocktake closes an open stocktake without changing any quantities
+func (wh *dafaultWarehouse) CancelStocktake(id, userID string) error {
+	st, ok := wh.ReadStocktake(id)
+	if !ok {
+		return errors.New("no such stocktake")
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	if err := closeStocktakeTx(tx, st, CANCELLED, userID); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+func (m *madminHandler) stocktakesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listStocktakesHandler(w, r)
+	case "POST":
+		m.addStocktakeHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /stocktakes/
+//
+// Lists the stocktakes, the latest are first.
+func (m *madminHandler) listStocktakesHandler(w http.ResponseWriter, r *http.Request) {
+	stocktakes := m.warehouse.Stocktakes()
+
+	resp := &CollectionResponseDTO{"List of stocktakes", make([]string, 0, len(stocktakes))}
+	for _, st := range stocktakes {
+		resp.URLs = append(resp.URLs, fmt.Sprintf("/data/stocktakes/%s", st.ID))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /stocktakes/
+//
+// Opens a stocktake with the current quantities of all stock items and lots.
+func (m *madminHandler) addStocktakeHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewStocktakeDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	st := &Stocktake{Name: dto.Name, Blind: dto.Blind, UserID: requestUserID(r)}
+	if err := m.warehouse.CreateStocktake(st); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, newStocktakeDTO(st))
+}
+
+// Handler for GET /stocktakes/<id>
+//
+// Returns JSON with data for the stocktake with <id>.
+func (m *madminHandler) getStocktakeHandler(w http.ResponseWriter, r *http.Request) {
+	st, ok := m.warehouse.ReadStocktake(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newStocktakeDTO(st))
+}
+
+// Handler for GET /stocktakes/<id>/sheet
+//
+// Returns the count sheet of the stocktake with <id>.
+// The quantities are hidden while a blind stocktake is open.
+func (m *madminHandler) stocktakeSheetHandler(w http.ResponseWriter, r *http.Request) {
+	st, ok := m.warehouse.ReadStocktake(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var (
+		lines = m.warehouse.StocktakeLines(st.ID)
+		blind = st.Blind && st.State == OPEN
+		resp  = make([]*StocktakeLineDTO, 0, len(lines))
+	)
+	for _, line := range lines {
+		resp = append(resp, newStocktakeLineDTO(line, blind))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /stocktakes/<id>/counts
+//
+// Saves counts of the lines of the open stocktake with <id>. The body is a list of counts.
+// Lines can be recounted, the latest count replaces the previous ones.
+func (m *madminHandler) stocktakeCountsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dtos := []StocktakeCountDTO{}
+	if !decodeJSONBody(w, r, &dtos) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadStocktake(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var (
+		errs   = ValidationErrors{}
+		counts = make([]StocktakeCount, 0, len(dtos))
+		userID = requestUserID(r)
+	)
+	for i, dto := range dtos {
+		quantity, err := validQuantityFromString(dto.Quantity)
+		if err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("counts[%d].quantity", i), err.Error()})
+			continue
+		}
+		counts = append(counts, StocktakeCount{StockID: dto.StockID, LotID: dto.LotID, Quantity: quantity, UserID: userID})
+	}
+	if len(errs) > 0 {
+		respondBadRequest(w, errs)
+		return
+	}
+
+	if err := m.warehouse.RecordCounts(id, counts); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for GET /stocktakes/<id>/variances
+//
+// Returns the variance report of the stocktake with <id>:
+// the counted lines with a discrepancy and the total value of the discrepancies.
+func (m *madminHandler) stocktakeVariancesHandler(w http.ResponseWriter, r *http.Request) {
+	st, ok := m.warehouse.ReadStocktake(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var (
+		total = decimal.Zero
+		resp  = &VarianceReportDTO{
+			Stocktake: newStocktakeDTO(st),
+			Lines:     make([]*StocktakeLineDTO, 0),
+		}
+	)
+	for _, line := range m.warehouse.StocktakeLines(st.ID) {
+		switch {
+		case line.Counted == nil:
+			resp.Uncounted++
+		case !line.Variance().IsZero():
+			total = total.Add(line.Value())
+			resp.Lines = append(resp.Lines, newStocktakeLineDTO(line, false))
+		}
+	}
+	resp.TotalValue = total.String()
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /stocktakes/<id>/approve
+//
+// Closes the stocktake with <id> and posts an adjustment for every discrepancy.
+// Returns the posted adjustments.
+func (m *madminHandler) approveStocktakeHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &StocktakeApprovalDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadStocktake(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	approval := &StocktakeApproval{}
+	if u, ok := requestUser(r); ok {
+		approval.UserID = u.ID()
+		approval.Authorizer = u.Name()
+	}
+	if dto.Witness != "" {
+		if !m.userManager.ValidateUser(dto.Witness, dto.WitnessPassword) {
+			respondBadRequest(w, ValidationErrors{{"witness", "invalid witness name or password"}})
+			return
+		}
+		witness, _ := m.userManager.ReadUserByName(dto.Witness)
+		approval.WitnessID = witness.ID()
+	}
+
+	adjustments, err := m.warehouse.ApproveStocktake(id, approval)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	resp := make([]*MovementDTO, 0, len(adjustments))
+	for _, mv := range adjustments {
+		resp = append(resp, newMovementDTO(mv))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /stocktakes/<id>/cancel
+//
+// Closes the stocktake with <id> without changing any quantities.
+func (m *madminHandler) cancelStocktakeHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.ReadStocktake(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := m.warehouse.CancelStocktake(id, requestUserID(r)); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	st, _ := m.warehouse.ReadStocktake(id)
+	respondJSON(w, http.StatusOK, newStocktakeDTO(st))
+}
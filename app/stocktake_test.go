@@ -0,0 +1,188 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestStocktake(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	var (
+		wh             = NewWarehouse(db)
+		expirationDate = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	medicine, _ := defaultExpirableStockItem(MEDICINE)
+	medicine.SetQuantity(decimal.Zero)
+	wh.CreateStock(medicine, nil)
+
+	accessory, _ := defaultUnexpirableStockItem(ACCESSORY)
+	wh.CreateStock(accessory, nil)
+
+	receipt := &Movement{
+		StockID:  medicine.ID(),
+		Kind:     RECEIPT,
+		Quantity: decimal.New(10, 0),
+		Lot:      &Lot{Number: "L1", ExpirationDate: &expirationDate},
+	}
+	if err := wh.RecordMovement(receipt); err != nil {
+		t.Fatalf(`RecordMovement returns an error for a valid lot receipt: %s`, err)
+	}
+
+	st := &Stocktake{Name: "Spring count", Blind: true}
+	if err := wh.CreateStocktake(st); err != nil {
+		t.Fatalf(`CreateStocktake returns an error: %s`, err)
+	}
+	if err := wh.CreateStocktake(&Stocktake{Name: "Another count"}); err == nil {
+		t.Fatalf(`CreateStocktake opens a second stocktake`)
+	}
+	if lines := wh.StocktakeLines(st.ID); len(lines) != 3 {
+		t.Fatalf(`Expected 3 lines on the count sheet, got %d`, len(lines))
+	}
+
+	invalid := [][]StocktakeCount{
+		{{StockID: accessory.ID(), Quantity: decimal.New(15, -1)}},
+		{{StockID: accessory.ID(), Quantity: decimal.New(-1, 0)}},
+		{{StockID: accessory.ID(), LotID: receipt.LotID, Quantity: decimal.New(1, 0)}},
+	}
+	for _, counts := range invalid {
+		if err := wh.RecordCounts(st.ID, counts); err == nil {
+			t.Errorf(`RecordCounts accepts invalid counts %+v`, counts)
+		}
+	}
+
+	counts := []StocktakeCount{
+		{StockID: medicine.ID(), LotID: receipt.LotID, Quantity: decimal.New(8, 0), UserID: "counter-1"},
+		{StockID: medicine.ID(), Quantity: decimal.New(9, 0), UserID: "counter-1"},
+		{StockID: accessory.ID(), Quantity: decimal.New(3, 0), UserID: "counter-1"},
+	}
+	if err := wh.RecordCounts(st.ID, counts); err != nil {
+		t.Fatalf(`RecordCounts returns an error for valid counts: %s`, err)
+	}
+	// the recount replaces the first count
+	if err := wh.RecordCounts(st.ID, []StocktakeCount{{StockID: accessory.ID(), Quantity: decimal.New(4, 0), UserID: "counter-2"}}); err != nil {
+		t.Fatalf(`RecordCounts returns an error for a recount: %s`, err)
+	}
+
+	// movements during the stocktake are kept
+	dispense := &Movement{StockID: medicine.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0), LotID: receipt.LotID}
+	if err := wh.RecordMovement(dispense); err != nil {
+		t.Fatalf(`RecordMovement returns an error during a stocktake: %s`, err)
+	}
+
+	adjustments, err := wh.ApproveStocktake(st.ID, &StocktakeApproval{UserID: "pharmacist"})
+	if err != nil {
+		t.Fatalf(`ApproveStocktake returns an error: %s`, err)
+	}
+	// the lot is adjusted by -2, the rest of the total's variance of -1 is adjusted without a lot
+	expected := []struct {
+		stockID  string
+		lotID    string
+		quantity int64
+	}{
+		{medicine.ID(), receipt.LotID, -2},
+		{medicine.ID(), "", 1},
+		{accessory.ID(), "", 3},
+	}
+	if len(adjustments) != len(expected) {
+		t.Fatalf(`Expected %d adjustments, got %d`, len(expected), len(adjustments))
+	}
+	posted := make(map[string]*Movement)
+	for _, mv := range adjustments {
+		posted[mv.StockID+mv.LotID] = mv
+	}
+	for _, e := range expected {
+		mv, ok := posted[e.stockID+e.lotID]
+		if !ok || mv.Kind != ADJUSTMENT || !mv.Quantity.Equal(decimal.New(e.quantity, 0)) {
+			t.Errorf(`Unexpected adjustment of %s %s: %+v`, e.stockID, e.lotID, mv)
+		}
+	}
+
+	if item, _ := wh.ReadStock(medicine.ID()); !item.Quantity().Equal(decimal.New(8, 0)) {
+		t.Errorf(`Expected quantity 8 after the stocktake, got %s`, item.Quantity())
+	}
+	if lot, _ := wh.ReadLot(receipt.LotID); !lot.Quantity.Equal(decimal.New(7, 0)) {
+		t.Errorf(`Expected lot quantity 7 after the stocktake, got %s`, lot.Quantity)
+	}
+	if item, _ := wh.ReadStock(accessory.ID()); !item.Quantity().Equal(decimal.New(4, 0)) {
+		t.Errorf(`Expected quantity 4 after the stocktake, got %s`, item.Quantity())
+	}
+
+	if err := wh.RecordCounts(st.ID, counts); err == nil {
+		t.Errorf(`RecordCounts counts an approved stocktake`)
+	}
+	if _, err := wh.ApproveStocktake(st.ID, &StocktakeApproval{}); err == nil {
+		t.Errorf(`ApproveStocktake approves a stocktake twice`)
+	}
+	if err := wh.CreateStocktake(&Stocktake{Name: "Autumn count"}); err != nil {
+		t.Errorf(`CreateStocktake returns an error after the previous stocktake is closed: %s`, err)
+	}
+}
+
+func TestBlindCountSheet(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	item, _ := defaultUnexpirableStockItem(ACCESSORY)
+	madminHandler.warehouse.CreateStock(item, nil)
+
+	body, _ := json.Marshal(&NewStocktakeDTO{Name: "Blind count", Blind: true})
+	resp, err := http.Post(buildURL(s.URL, "/data/stocktakes/"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error sending POST request: %s", err)
+	}
+	st := &StocktakeDTO{}
+	json.NewDecoder(resp.Body).Decode(st)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || st.State != OPEN {
+		t.Fatalf("Unexpected stocktake %d %+v", resp.StatusCode, st)
+	}
+
+	body, _ = json.Marshal([]StocktakeCountDTO{{StockID: item.ID(), Quantity: "2"}})
+	resp, err = http.Post(buildURL(s.URL, fmt.Sprintf("/data/stocktakes/%s/counts", st.ID)), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error sending POST request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected %d but got %d for valid counts", http.StatusNoContent, resp.StatusCode)
+	}
+
+	resp, err = http.Get(buildURL(s.URL, fmt.Sprintf("/data/stocktakes/%s/sheet", st.ID)))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	sheet := []*StocktakeLineDTO{}
+	json.NewDecoder(resp.Body).Decode(&sheet)
+	resp.Body.Close()
+	if len(sheet) != 1 || sheet[0].Expected != "" || sheet[0].Counted != "" || sheet[0].Counts != 1 {
+		t.Fatalf("Unexpected blind count sheet %+v", sheet)
+	}
+
+	resp, err = http.Get(buildURL(s.URL, fmt.Sprintf("/data/stocktakes/%s/variances", st.ID)))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	report := &VarianceReportDTO{}
+	json.NewDecoder(resp.Body).Decode(report)
+	resp.Body.Close()
+	if len(report.Lines) != 1 || report.Lines[0].Variance != "1" {
+		t.Fatalf("Unexpected variance report %+v", report)
+	}
+}
@@ -0,0 +1,297 @@
+package app
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type substituteReason string
+
+// EQUIVALENT substitutes have the same active ingredient, strength and form as the stock item.
+// MANUAL substitutes are linked to the stock item by a user.
+const (
+	EQUIVALENT substituteReason = "equivalent"
+	MANUAL     substituteReason = "manual"
+)
+
+// Equivalence describes a medicine by its active ingredient, strength and form.
+// Stock items with the same equivalence are generic equivalents of each other.
+type Equivalence struct {
+	StockID          string
+	ActiveIngredient string
+	// Strength is the amount of the active ingredient, e.g. "50 mg" or "2.5 mg/ml"
+	Strength string
+	// Form is the dosage form, e.g. "tablet" or "oral suspension"
+	Form string
+}
+
+// groupKey returns the key of the equivalence group, it ignores case and whitespace
+func (e *Equivalence) groupKey() string {
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(strings.ToLower(s)), "")
+	}
+	return normalize(e.ActiveIngredient) + "|" + normalize(e.Strength) + "|" + normalize(e.Form)
+}
+
+// Substitute is a stock item that can replace another one with its available quantity
+type Substitute struct {
+	Stock  Stock
+	Reason substituteReason
+
+	Available decimal.Decimal
+	// ExpirationDate is the date of the available lot that expires first,
+	// or nil for unexpirable stock
+	ExpirationDate *time.Time
+}
+
+func (wh *dafaultWarehouse) initSubstitutesTables() {
+	substitutesTables := `
+	CREATE TABLE IF NOT EXISTS
+		stock_equivalences (
+			stock_id TEXT NOT NULL PRIMARY KEY,
+			active_ingredient TEXT NOT NULL,
+			strength TEXT NOT NULL,
+			form TEXT NOT NULL,
+			group_key TEXT NOT NULL,
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id)
+	);
+	CREATE INDEX IF NOT EXISTS
+		stock_equivalences_group_key ON stock_equivalences (group_key);
+	CREATE TABLE IF NOT EXISTS
+		stock_substitutes (
+			stock_id TEXT NOT NULL,
+			substitute_id TEXT NOT NULL,
+			PRIMARY KEY (stock_id, substitute_id),
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id),
+			FOREIGN KEY (substitute_id) REFERENCES warehouse (id)
+	);
+	CREATE INDEX IF NOT EXISTS
+		stock_substitutes_substitute_id ON stock_substitutes (substitute_id);
+	`
+	_, err := wh.database.Exec(substitutesTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// SetEquivalence sets the active ingredient, strength and form of a stock item
+func (wh *dafaultWarehouse) SetEquivalence(e *Equivalence) error {
+	errs := ValidationErrors{}
+	if _, ok := wh.ReadStock(e.StockID); !ok {
+		errs = append(errs, ValidationError{"stockID", "no such stock item"})
+	}
+	if strings.TrimSpace(e.ActiveIngredient) == "" {
+		errs = append(errs, ValidationError{"activeIngredient", "no active ingredient set"})
+	}
+	if strings.TrimSpace(e.Strength) == "" {
+		errs = append(errs, ValidationError{"strength", "no strength set"})
+	}
+	if strings.TrimSpace(e.Form) == "" {
+		errs = append(errs, ValidationError{"form", "no form set"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	_, err := wh.database.Exec(`
+		INSERT OR REPLACE INTO
+			stock_equivalences (
+				stock_id,
+				active_ingredient,
+				strength,
+				form,
+				group_key)
+		VALUES(?, ?, ?, ?, ?)
+	`, e.StockID, strings.TrimSpace(e.ActiveIngredient), strings.TrimSpace(e.Strength), strings.TrimSpace(e.Form), e.groupKey())
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+func (wh *dafaultWarehouse) ReadEquivalence(stockID string) (*Equivalence, bool) {
+	e := &Equivalence{StockID: stockID}
+	err := wh.database.QueryRow(`
+		SELECT
+			active_ingredient,
+			strength,
+			form
+		FROM
+			stock_equivalences
+		WHERE
+			stock_id = ?
+	`, stockID).Scan(&e.ActiveIngredient, &e.Strength, &e.Form)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	return e, true
+}
+
+// AddSubstitute links two stock items that can replace each other
+func (wh *dafaultWarehouse) AddSubstitute(stockID, substituteID string) error {
+	errs := ValidationErrors{}
+	if _, ok := wh.ReadStock(stockID); !ok {
+		errs = append(errs, ValidationError{"stockID", "no such stock item"})
+	}
+	if _, ok := wh.ReadStock(substituteID); !ok {
+		errs = append(errs, ValidationError{"substituteID", "no such stock item"})
+	} else if substituteID == stockID {
+		errs = append(errs, ValidationError{"substituteID", "a stock item cannot substitute itself"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// links are stored once for both directions
+	if substituteID < stockID {
+		stockID, substituteID = substituteID, stockID
+	}
+	_, err := wh.database.Exec(`
+		INSERT OR IGNORE INTO
+			stock_substitutes (
+				stock_id,
+				substitute_id)
+		VALUES(?, ?)
+	`, stockID, substituteID)
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+func (wh *dafaultWarehouse) RemoveSubstitute(stockID, substituteID string) {
+	_, err := wh.database.Exec(`
+		DELETE FROM
+			stock_substitutes
+		WHERE
+			(stock_id = ? AND substitute_id = ?) OR (stock_id = ? AND substitute_id = ?)
+	`, stockID, substituteID, substituteID, stockID)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Substitutes returns the stock items that can replace the stock item with the given id.
+// The manually linked substitutes are first, then the generic equivalents, each ordered by name.
+// available are the quantities of AvailableQuantities(), so they can be computed once for many items.
+func (wh *dafaultWarehouse) Substitutes(stockID string, available map[string]decimal.Decimal) []*Substitute {
+	rows, err := wh.database.Query(`
+		SELECT
+			CASE WHEN stock_id = ? THEN substitute_id ELSE stock_id END,
+			?
+		FROM
+			stock_substitutes
+		WHERE
+			stock_id = ? OR substitute_id = ?
+		UNION
+		SELECT
+			other.stock_id,
+			?
+		FROM
+			stock_equivalences item
+			JOIN stock_equivalences other ON other.group_key = item.group_key AND other.stock_id != item.stock_id
+		WHERE
+			item.stock_id = ?
+	`, stockID, MANUAL, stockID, stockID, EQUIVALENT, stockID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	reasons := make(map[string]substituteReason)
+	for rows.Next() {
+		var (
+			id     string
+			reason substituteReason
+		)
+		if err = rows.Scan(&id, &reason); err != nil {
+			panic(err)
+		}
+		// an equivalent that is also linked manually is listed once
+		if reasons[id] != MANUAL {
+			reasons[id] = reason
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	if len(reasons) == 0 {
+		return []*Substitute{}
+	}
+
+	var (
+		substitutes = make([]*Substitute, 0, len(reasons))
+		now         = time.Now()
+	)
+	for id, reason := range reasons {
+		item, ok := wh.ReadStock(id)
+		if !ok {
+			continue
+		}
+
+		s := &Substitute{Stock: item, Reason: reason, Available: available[id]}
+		for _, lot := range wh.Lots(id) {
+			if lot.ExpirationDate != nil && lot.Quantity.Sign() > 0 && lot.isAvailable(now) {
+				s.ExpirationDate = lot.ExpirationDate
+				break
+			}
+		}
+		if s.ExpirationDate == nil && item.IsExpirable() {
+			expiration := item.ExpirationDate()
+			s.ExpirationDate = &expiration
+		}
+		substitutes = append(substitutes, s)
+	}
+
+	sort.Slice(substitutes, func(i, j int) bool {
+		if substitutes[i].Reason != substitutes[j].Reason {
+			return substitutes[i].Reason == MANUAL
+		}
+		if substitutes[i].Stock.Name() != substitutes[j].Stock.Name() {
+			return substitutes[i].Stock.Name() < substitutes[j].Stock.Name()
+		}
+		return substitutes[i].Stock.ID() < substitutes[j].Stock.ID()
+	})
+	return substitutes
+}
+
+// inStockSubstitutes returns the substitutes of a stock item that can be dispensed
+func inStockSubstitutes(substitutes []*Substitute) []*Substitute {
+	inStock := make([]*Substitute, 0, len(substitutes))
+	for _, s := range substitutes {
+		if s.Available.Sign() > 0 {
+			inStock = append(inStock, s)
+		}
+	}
+	return inStock
+}
+
+// isInsufficientStock reports whether a movement was refused because there is not enough stock
+func isInsufficientStock(err error) bool {
+	ves, ok := err.(ValidationErrors)
+	if !ok {
+		return false
+	}
+	for _, ve := range ves {
+		switch ve {
+		case errInsufficientStock,
+			errInsufficientAvailableStock,
+			errInsufficientLotStock,
+			errInsufficientLocationStock,
+			errInsufficientAvailableLocationStock:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (m *madminHandler) equivalenceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getEquivalenceHandler(w, r)
+	case "PUT":
+		m.setEquivalenceHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /stock/<id>/equivalence
+//
+// Returns the active ingredient, strength and form of the stock item with <id>.
+func (m *madminHandler) getEquivalenceHandler(w http.ResponseWriter, r *http.Request) {
+	e, ok := m.warehouse.ReadEquivalence(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, &EquivalenceDTO{e.StockID, e.ActiveIngredient, e.Strength, e.Form})
+}
+
+// Handler for PUT /stock/<id>/equivalence
+//
+// Sets the active ingredient, strength and form of the stock item with <id>.
+// Stock items with the same ones are suggested as substitutes of each other.
+func (m *madminHandler) setEquivalenceHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &EquivalenceDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	e := &Equivalence{StockID: id, ActiveIngredient: dto.ActiveIngredient, Strength: dto.Strength, Form: dto.Form}
+	if err := m.warehouse.SetEquivalence(e); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	e, _ = m.warehouse.ReadEquivalence(id)
+	respondJSON(w, http.StatusOK, &EquivalenceDTO{e.StockID, e.ActiveIngredient, e.Strength, e.Form})
+}
+
+func (m *madminHandler) substitutesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listSubstitutesHandler(w, r)
+	case "POST":
+		m.addSubstituteHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /stock/<id>/substitutes?inStock=true
+//
+// Lists the stock items that can replace the stock item with <id> with their
+// available quantities and expiration dates. With inStock=true, only the substitutes
+// that can be dispensed are listed.
+func (m *madminHandler) listSubstitutesHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	substitutes := m.warehouse.Substitutes(id, m.warehouse.AvailableQuantities())
+	if r.URL.Query().Get("inStock") == "true" {
+		substitutes = inStockSubstitutes(substitutes)
+	}
+
+	respondJSON(w, http.StatusOK, newSubstituteDTOs(substitutes))
+}
+
+// Handler for POST /stock/<id>/substitutes
+//
+// Links a substitute to the stock item with <id>. The items can replace each other.
+func (m *madminHandler) addSubstituteHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &NewSubstituteDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	if _, ok := m.warehouse.ReadStock(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := m.warehouse.AddSubstitute(id, dto.SubstituteID); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Handler for DELETE /stock/<id>/substitutes/<substituteID>
+//
+// Removes the link between the stock item with <id> and a substitute.
+// Generic equivalents remain substitutes.
+func (m *madminHandler) removeSubstituteHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m.warehouse.RemoveSubstitute(vars["id"], vars["substituteID"])
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestSubstitutes(t *testing.T) {
+	var (
+		dbPath        = "./test_database.sqlite"
+		database      = newDB(dbPath)
+		madminHandler = NewMAdminHandler(database)
+		s             = httptest.NewServer(madminHandler)
+		wh            = madminHandler.warehouse
+	)
+	defer cleanupDatabase(t, database, dbPath)
+	defer s.Close()
+
+	newMedicine := func(name string, quantity int64) Stock {
+		item, _ := defaultExpirableStockItem(MEDICINE)
+		item.SetName(name)
+		item.SetQuantity(decimal.New(quantity, 0))
+		item.SetMinQuantity(decimal.New(2, 0))
+		wh.CreateStock(item, nil)
+		return item
+	}
+	var (
+		brand    = newMedicine("Brand 50 mg", 1)
+		generic  = newMedicine("Generic 50 mg", 10)
+		stronger = newMedicine("Generic 100 mg", 10)
+		manual   = newMedicine("Other tablets", 4)
+		empty    = newMedicine("Another generic", 0)
+	)
+
+	equivalences := []*Equivalence{
+		{StockID: brand.ID(), ActiveIngredient: "Carprofen", Strength: "50 mg", Form: "tablet"},
+		{StockID: generic.ID(), ActiveIngredient: "carprofen ", Strength: "50mg", Form: "Tablet"},
+		{StockID: stronger.ID(), ActiveIngredient: "carprofen", Strength: "100 mg", Form: "tablet"},
+		{StockID: empty.ID(), ActiveIngredient: "carprofen", Strength: "50 mg", Form: "tablet"},
+	}
+	for _, e := range equivalences {
+		if err := wh.SetEquivalence(e); err != nil {
+			t.Fatalf(`SetEquivalence returns an error for a valid equivalence: %s`, err)
+		}
+	}
+	if err := wh.AddSubstitute(manual.ID(), brand.ID()); err != nil {
+		t.Fatalf(`AddSubstitute returns an error for a valid link: %s`, err)
+	}
+	if err := wh.AddSubstitute(brand.ID(), brand.ID()); err == nil {
+		t.Fatalf(`AddSubstitute links a stock item to itself`)
+	}
+
+	substitutes := wh.Substitutes(brand.ID(), wh.AvailableQuantities())
+	if len(substitutes) != 3 || substitutes[0].Stock.ID() != manual.ID() || substitutes[0].Reason != MANUAL ||
+		substitutes[1].Stock.ID() != empty.ID() || substitutes[2].Stock.ID() != generic.ID() || !substitutes[2].Available.Equal(decimal.New(10, 0)) {
+		t.Fatalf(`Unexpected substitutes %+v`, substitutes)
+	}
+	if len(inStockSubstitutes(substitutes)) != 2 {
+		t.Fatalf(`Expected the substitute without stock not to be suggested`)
+	}
+
+	body, _ := json.Marshal(&NewMovementDTO{Kind: DISPENSE, Quantity: "3"})
+	resp, err := http.Post(buildURL(s.URL, fmt.Sprintf("/data/stock/%s/movements", brand.ID())), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error sending POST request: %s", err)
+	}
+	refused := &validationErrorsResponseDTO{}
+	json.NewDecoder(resp.Body).Decode(refused)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || len(refused.Substitutes) != 2 || refused.Substitutes[1].Stock.ID != generic.ID() {
+		t.Fatalf("Expected in-stock substitutes for a refused dispense, got %d %+v", resp.StatusCode, refused)
+	}
+
+	resp, err = http.Get(buildURL(s.URL, "/data/stock/insufficient/"))
+	if err != nil {
+		t.Fatalf("Error sending GET request: %s", err)
+	}
+	insufficient := &InsufficientStockDTO{}
+	json.NewDecoder(resp.Body).Decode(insufficient)
+	resp.Body.Close()
+	if len(insufficient.Substitutes[brand.ID()]) != 2 || len(insufficient.Substitutes[empty.ID()]) != 2 {
+		t.Fatalf("Unexpected substitutes of insufficient stock %+v", insufficient.Substitutes)
+	}
+
+	wh.RemoveSubstitute(brand.ID(), manual.ID())
+	if substitutes := wh.Substitutes(manual.ID(), wh.AvailableQuantities()); len(substitutes) != 0 {
+		t.Fatalf(`Expected no substitutes after removing the link, got %d`, len(substitutes))
+	}
+}
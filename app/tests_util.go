@@ -30,24 +30,19 @@ func checkNewItemCreatingWithOKStatus(t *testing.T, item interface{}, ok bool) {
 
 func defaultExpirableStockItem(aStockType stockType) (Stock, error) {
 	dto := NewStockDTO{
-		"name",
-		aStockType,
-		"1",
-		"2030-01-01T00:00:00.000Z",
-		"",
-		"",
+		Name:           "name",
+		Type:           aStockType,
+		Quantity:       "1",
+		ExpirationDate: "2030-01-01T00:00:00.000Z",
 	}
-	return NewStock(&dto)
+	return NewStock(&dto, builtinStockTypes)
 }
 
 func defaultUnexpirableStockItem(aStockType stockType) (Stock, error) {
 	dto := NewStockDTO{
-		"name",
-		aStockType,
-		"1",
-		"",
-		"",
-		"",
+		Name:     "name",
+		Type:     aStockType,
+		Quantity: "1",
 	}
-	return NewStock(&dto)
+	return NewStock(&dto, builtinStockTypes)
 }
@@ -0,0 +1,594 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type transferState string
+
+// REQUESTED transfers are DISPATCHED by the location that sends the stock or DECLINED.
+// Dispatched stock is in transit until the receiving location marks the transfer as RECEIVED.
+const (
+	REQUESTED  transferState = "requested"
+	DISPATCHED transferState = "dispatched"
+	RECEIVED   transferState = "received"
+	DECLINED   transferState = "declined"
+)
+
+// Transfer moves stock between storage locations, e.g. from the clinic to a vet van
+type Transfer struct {
+	ID string
+
+	// FromLocationID is empty for transfers of unassigned stock
+	FromLocationID string
+	ToLocationID   string
+
+	State transferState
+	Note  string
+
+	// Lines are the requested stock items and lots
+	Lines []TransferLine
+	// Picks are the dispatched lots and quantities of stock without lots
+	Picks []TransferLine
+
+	Created time.Time
+	UserID  string
+
+	Dispatched   *time.Time
+	DispatchedBy string
+	// Closed and ClosedBy are set when the transfer is received or declined
+	Closed   *time.Time
+	ClosedBy string
+}
+
+// TransferLine is a quantity of a stock item in a transfer.
+// LotID is empty for requests of any lot and for picks of stock without lots.
+type TransferLine struct {
+	StockID  string
+	LotID    string
+	Quantity decimal.Decimal
+}
+
+func (wh *dafaultWarehouse) initTransfersTables() {
+	transfersTables := `
+	CREATE TABLE IF NOT EXISTS
+		transfers (
+			id TEXT NOT NULL PRIMARY KEY,
+			from_location_id TEXT NOT NULL,
+			to_location_id TEXT NOT NULL,
+			state TEXT NOT NULL,
+			note TEXT NOT NULL,
+			created DATETIME NOT NULL,
+			user_id TEXT NOT NULL,
+			dispatched DATETIME,
+			dispatched_by TEXT NOT NULL,
+			closed DATETIME,
+			closed_by TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS
+		transfer_lines (
+			transfer_id TEXT NOT NULL,
+			stock_id TEXT NOT NULL,
+			lot_id TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			FOREIGN KEY (transfer_id) REFERENCES transfers (id)
+	);
+	CREATE TABLE IF NOT EXISTS
+		transfer_picks (
+			transfer_id TEXT NOT NULL,
+			stock_id TEXT NOT NULL,
+			lot_id TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			FOREIGN KEY (transfer_id) REFERENCES transfers (id)
+	);
+	`
+	_, err := wh.database.Exec(transfersTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (t *Transfer) validate(wh *dafaultWarehouse) error {
+	errs := ValidationErrors{}
+
+	if t.FromLocationID != "" {
+		if _, ok := wh.locations.ReadLocation(t.FromLocationID); !ok {
+			errs = append(errs, ValidationError{"fromLocationID", "no such storage location"})
+		}
+	}
+	if _, ok := wh.locations.ReadLocation(t.ToLocationID); !ok {
+		errs = append(errs, ValidationError{"toLocationID", "no such storage location"})
+	}
+	if t.FromLocationID == t.ToLocationID {
+		errs = append(errs, ValidationError{"toLocationID", "stock cannot be transferred to the same location"})
+	}
+	if len(t.Lines) == 0 {
+		errs = append(errs, ValidationError{"lines", "no stock requested"})
+	}
+
+	for i, line := range t.Lines {
+		item, ok := wh.ReadStock(line.StockID)
+		if !ok {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].stockID", i), "no such stock item"})
+			continue
+		}
+		if line.Quantity.Sign() <= 0 {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].quantity", i), "quantity must be positive"})
+		} else if err := item.QuantityRule().Validate(fmt.Sprintf("lines[%d].quantity", i), line.Quantity); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+		if line.LotID != "" {
+			if lot, ok := wh.ReadLot(line.LotID); !ok || lot.StockID != line.StockID || lot.LocationID != t.FromLocationID {
+				errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].lotID", i), "the lot is not in the location"})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CreateTransfer saves a request for stock from one location to another
+func (wh *dafaultWarehouse) CreateTransfer(t *Transfer) error {
+	if err := t.validate(wh); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	t.ID = id
+	t.State = REQUESTED
+	t.Created = time.Now().UTC()
+	t.Picks = make([]TransferLine, 0)
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			transfers (
+				id,
+				from_location_id,
+				to_location_id,
+				state,
+				note,
+				created,
+				user_id,
+				dispatched_by,
+				closed_by)
+		VALUES(?, ?, ?, ?, ?, ?, ?, '', '')
+	`,
+		t.ID,
+		t.FromLocationID,
+		t.ToLocationID,
+		t.State,
+		t.Note,
+		t.Created,
+		t.UserID)
+	if err != nil {
+		panic(err)
+	}
+
+	insertTransferLinesTx(tx, "transfer_lines", t.ID, t.Lines)
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+func insertTransferLinesTx(tx *sql.Tx, table, transferID string, lines []TransferLine) {
+	for _, line := range lines {
+		_, err := tx.Exec(`
+			INSERT INTO `+table+` (
+				transfer_id,
+				stock_id,
+				lot_id,
+				quantity)
+			VALUES(?, ?, ?, ?)
+		`, transferID, line.StockID, line.LotID, line.Quantity.String())
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (wh *dafaultWarehouse) transferLines(table, transferID string) []TransferLine {
+	rows, err := wh.database.Query(`
+		SELECT
+			stock_id,
+			lot_id,
+			quantity
+		FROM
+			`+table+`
+		WHERE
+			transfer_id = ?
+		ORDER BY
+			rowid
+	`, transferID)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	lines := make([]TransferLine, 0)
+	for rows.Next() {
+		line := TransferLine{}
+		if err = rows.Scan(&line.StockID, &line.LotID, &line.Quantity); err != nil {
+			panic(err)
+		}
+		lines = append(lines, line)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return lines
+}
+
+// transferColumns are the columns selected by the transfer queries, in the order expected by scanTransfer
+const transferColumns = `
+	id,
+	from_location_id,
+	to_location_id,
+	state,
+	note,
+	created,
+	user_id,
+	dispatched,
+	dispatched_by,
+	closed,
+	closed_by
+`
+
+func scanTransfer(row rowScanner) (*Transfer, error) {
+	t := &Transfer{}
+	err := row.Scan(
+		&t.ID,
+		&t.FromLocationID,
+		&t.ToLocationID,
+		&t.State,
+		&t.Note,
+		&t.Created,
+		&t.UserID,
+		&t.Dispatched,
+		&t.DispatchedBy,
+		&t.Closed,
+		&t.ClosedBy)
+	return t, err
+}
+
+func (wh *dafaultWarehouse) ReadTransfer(id string) (*Transfer, bool) {
+	t, err := scanTransfer(wh.database.QueryRow(`SELECT `+transferColumns+` FROM transfers WHERE id = ?`, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+
+	t.Lines = wh.transferLines("transfer_lines", id)
+	t.Picks = wh.transferLines("transfer_picks", id)
+	return t, true
+}
+
+// Transfers returns the transfers from or to the location, the latest are first.
+// All transfers are returned for an empty location id.
+func (wh *dafaultWarehouse) Transfers(locationID string) []*Transfer {
+	rows, err := wh.database.Query(`
+		SELECT
+			id
+		FROM
+			transfers
+		WHERE
+			? = '' OR from_location_id = ? OR to_location_id = ?
+		ORDER BY
+			created DESC
+	`, locationID, locationID, locationID)
+	if err != nil {
+		panic(err)
+	}
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			panic(err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	transfers := make([]*Transfer, 0, len(ids))
+	for _, id := range ids {
+		t, _ := wh.ReadTransfer(id)
+		transfers = append(transfers, t)
+	}
+	return transfers
+}
+
+// setTransferStateTx moves the transfer from one state to another
+func setTransferStateTx(tx *sql.Tx, t *Transfer, from, to transferState, userID string) error {
+	if t.State != from {
+		return ValidationErrors{{"state", fmt.Sprintf("the transfer is %s", t.State)}}
+	}
+
+	var (
+		now = time.Now().UTC()
+		res sql.Result
+		err error
+	)
+	if to == DISPATCHED {
+		res, err = tx.Exec(`
+			UPDATE
+				transfers
+			SET
+				state = ?,
+				dispatched = ?,
+				dispatched_by = ?
+			WHERE
+				id = ? AND state = ?
+		`, to, now, userID, t.ID, from)
+		t.Dispatched, t.DispatchedBy = &now, userID
+	} else {
+		res, err = tx.Exec(`
+			UPDATE
+				transfers
+			SET
+				state = ?,
+				closed = ?,
+				closed_by = ?
+			WHERE
+				id = ? AND state = ?
+		`, to, now, userID, t.ID, from)
+		t.Closed, t.ClosedBy = &now, userID
+	}
+	if err != nil {
+		panic(err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ValidationErrors{{"state", "the transfer was changed by another user"}}
+	}
+
+	t.State = to
+	return nil
+}
+
+// pickTx takes the quantity of a requested line from the source location.
+// Lines without a lot are picked from the available lots that expire first
+// and then from the stock without lots in the location.
+func pickTx(tx *sql.Tx, locationID string, line TransferLine) ([]TransferLine, error) {
+	var (
+		picks     = make([]TransferLine, 0)
+		remaining = line.Quantity
+		now       = time.Now()
+		inLots    = decimal.Zero
+	)
+
+	lots := queryLotsTx(tx, `
+		SELECT `+lotColumns+`
+		FROM
+			stock_lots
+		WHERE
+			stock_id = ? AND location_id = ?
+		ORDER BY
+			expiration_date IS NULL, expiration_date, received
+	`, line.StockID, locationID)
+
+	for _, lot := range lots {
+		inLots = inLots.Add(lot.Quantity)
+		if line.LotID != "" && lot.ID != line.LotID {
+			continue
+		}
+		if remaining.IsZero() || !lot.isAvailable(now) || lot.Quantity.Sign() <= 0 {
+			continue
+		}
+
+		quantity := decimal.Min(remaining, lot.Quantity)
+		_, err := tx.Exec(`
+			UPDATE
+				stock_lots
+			SET
+				quantity = ?
+			WHERE
+				id = ?
+		`, lot.Quantity.Sub(quantity).String(), lot.ID)
+		if err != nil {
+			panic(err)
+		}
+
+		picks = append(picks, TransferLine{StockID: line.StockID, LotID: lot.ID, Quantity: quantity})
+		remaining = remaining.Sub(quantity)
+	}
+
+	if remaining.Sign() > 0 && line.LotID == "" {
+		withoutLots := stockLevelTx(tx, line.StockID, locationID).Sub(inLots)
+		if withoutLots.Sign() > 0 {
+			quantity := decimal.Min(remaining, withoutLots)
+			picks = append(picks, TransferLine{StockID: line.StockID, Quantity: quantity})
+			remaining = remaining.Sub(quantity)
+		}
+	}
+
+	if remaining.Sign() > 0 {
+		return nil, errors.New("insufficient available stock in the location")
+	}
+
+	for _, pick := range picks {
+		changeStockLevelTx(tx, pick.StockID, locationID, pick.Quantity.Neg())
+	}
+	return picks, nil
+}
+
+// DispatchTransfer takes the requested stock from the source location.
+// The stock is in transit and in none of the locations until it is received.
+func (wh *dafaultWarehouse) DispatchTransfer(id, userID string) error {
+	t, ok := wh.ReadTransfer(id)
+	if !ok {
+		return errors.New("no such transfer")
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	if err := setTransferStateTx(tx, t, REQUESTED, DISPATCHED, userID); err != nil {
+		return err
+	}
+
+	errs := ValidationErrors{}
+	for i, line := range t.Lines {
+		picks, err := pickTx(tx, t.FromLocationID, line)
+		if err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("lines[%d].quantity", i), err.Error()})
+			continue
+		}
+		// the picks are in transit for the next lines of the same stock item
+		insertTransferLinesTx(tx, "transfer_picks", t.ID, picks)
+		t.Picks = append(t.Picks, picks...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// receiveLotPickTx adds the picked quantity of a lot to the part of the lot
+// in the location or splits the lot if no part of it is in the location yet.
+// A new part has the state of the lot it is split from.
+func receiveLotPickTx(tx *sql.Tx, pick TransferLine, locationID string) {
+	lot, err := scanLot(tx.QueryRow(`SELECT `+lotColumns+` FROM stock_lots WHERE id = ?`, pick.LotID))
+	if err != nil {
+		panic(err)
+	}
+
+	var (
+		partID       string
+		partQuantity decimal.Decimal
+	)
+	err = tx.QueryRow(`
+		SELECT
+			id,
+			quantity
+		FROM
+			stock_lots
+		WHERE
+			stock_id = ? AND number = ? AND location_id = ?
+	`, lot.StockID, lot.Number, locationID).Scan(&partID, &partQuantity)
+	switch {
+	case err == sql.ErrNoRows:
+		partID, err = newUUID()
+		if err != nil {
+			panic(err)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO
+				stock_lots (`+lotColumns+`)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			partID,
+			lot.StockID,
+			lot.Number,
+			lot.ExpirationDate,
+			pick.Quantity.String(),
+			locationID,
+			lot.State,
+			lot.StateReason,
+			lot.Received,
+			lot.UnitCost.String())
+	case err != nil:
+		panic(err)
+	default:
+		_, err = tx.Exec(`
+			UPDATE
+				stock_lots
+			SET
+				quantity = ?
+			WHERE
+				id = ?
+		`, partQuantity.Add(pick.Quantity).String(), partID)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// ReceiveTransfer adds the dispatched stock to the receiving location
+func (wh *dafaultWarehouse) ReceiveTransfer(id, userID string) error {
+	t, ok := wh.ReadTransfer(id)
+	if !ok {
+		return errors.New("no such transfer")
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	if err := setTransferStateTx(tx, t, DISPATCHED, RECEIVED, userID); err != nil {
+		return err
+	}
+
+	for _, pick := range t.Picks {
+		if pick.LotID != "" {
+			receiveLotPickTx(tx, pick, t.ToLocationID)
+		}
+		changeStockLevelTx(tx, pick.StockID, t.ToLocationID, pick.Quantity)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// DeclineTransfer closes a requested transfer without moving any stock
+func (wh *dafaultWarehouse) DeclineTransfer(id, userID string) error {
+	t, ok := wh.ReadTransfer(id)
+	if !ok {
+		return errors.New("no such transfer")
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	if err := setTransferStateTx(tx, t, REQUESTED, DECLINED, userID); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
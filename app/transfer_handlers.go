@@ -0,0 +1,109 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (m *madminHandler) transfersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listTransfersHandler(w, r)
+	case "POST":
+		m.addTransferHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /transfers/?location=<id>
+//
+// Lists the transfers, the latest are first. With location, only the transfers
+// from or to the storage location are listed.
+func (m *madminHandler) listTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	locationID, ok := m.locationScope(w, r)
+	if !ok {
+		return
+	}
+
+	transfers := m.warehouse.Transfers(locationID)
+
+	resp := &CollectionResponseDTO{"List of transfers", make([]string, 0, len(transfers))}
+	for _, t := range transfers {
+		resp.URLs = append(resp.URLs, fmt.Sprintf("/data/transfers/%s", t.ID))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /transfers/
+//
+// Requests stock from one storage location for another.
+func (m *madminHandler) addTransferHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewTransferDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	t, err := dto.transfer()
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+	t.UserID = requestUserID(r)
+
+	if err := m.warehouse.CreateTransfer(t); err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, newTransferDTO(t))
+}
+
+// Handler for GET /transfers/<id>
+//
+// Returns JSON with data for the transfer with <id>.
+func (m *madminHandler) getTransferHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := m.warehouse.ReadTransfer(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newTransferDTO(t))
+}
+
+// Handler for POST /transfers/<id>/dispatch, /transfers/<id>/receive and /transfers/<id>/decline
+//
+// Moves the transfer with <id> to its next state and returns the transfer.
+// Dispatching picks the lots that expire first unless the request names the lots.
+func (m *madminHandler) transferStateHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars   = mux.Vars(r)
+		id     = vars["id"]
+		userID = requestUserID(r)
+	)
+	if _, ok := m.warehouse.ReadTransfer(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var err error
+	switch vars["action"] {
+	case "dispatch":
+		err = m.warehouse.DispatchTransfer(id, userID)
+	case "receive":
+		err = m.warehouse.ReceiveTransfer(id, userID)
+	case "decline":
+		err = m.warehouse.DeclineTransfer(id, userID)
+	}
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+
+	t, _ := m.warehouse.ReadTransfer(id)
+	respondJSON(w, http.StatusOK, newTransferDTO(t))
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestTransfer(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	var (
+		wh     = NewWarehouse(db)
+		clinic = &StorageLocation{Name: "Main clinic"}
+		van    = &StorageLocation{Name: "Vet van"}
+		early  = time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+		late   = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+	wh.Locations().CreateLocation(clinic)
+	wh.Locations().CreateLocation(van)
+
+	medicine, _ := defaultExpirableStockItem(MEDICINE)
+	medicine.SetQuantity(decimal.Zero)
+	wh.CreateStock(medicine, nil)
+
+	accessory, _ := defaultUnexpirableStockItem(ACCESSORY)
+	accessory.SetQuantity(decimal.Zero)
+	wh.CreateStock(accessory, nil)
+
+	receipts := []*Movement{
+		{StockID: medicine.ID(), Kind: RECEIPT, Quantity: decimal.New(10, 0), Lot: &Lot{Number: "A", ExpirationDate: &late, LocationID: clinic.ID}},
+		{StockID: medicine.ID(), Kind: RECEIPT, Quantity: decimal.New(5, 0), Lot: &Lot{Number: "B", ExpirationDate: &early}, LocationID: clinic.ID},
+		{StockID: accessory.ID(), Kind: RECEIPT, Quantity: decimal.New(20, 0), LocationID: clinic.ID},
+	}
+	for _, mv := range receipts {
+		if err := wh.RecordMovement(mv); err != nil {
+			t.Fatalf(`RecordMovement returns an error for a valid receipt: %s`, err)
+		}
+	}
+
+	dispense := &Movement{StockID: accessory.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0), LocationID: van.ID}
+	if err := wh.RecordMovement(dispense); err == nil {
+		t.Fatalf(`RecordMovement dispenses from a location without stock`)
+	}
+
+	minQuantity := decimal.New(4, 0)
+	if err := wh.SetMinQuantity(medicine.ID(), van.ID, &minQuantity, nil); err != nil {
+		t.Fatalf(`SetMinQuantity returns an error: %s`, err)
+	}
+	if items := insufficientStockAt(wh, van.ID); len(items) != 1 || items[0].ID() != medicine.ID() {
+		t.Fatalf(`Expected the medicine to be insufficient in the van, got %v`, items)
+	}
+
+	transfer := &Transfer{
+		FromLocationID: clinic.ID,
+		ToLocationID:   van.ID,
+		Lines: []TransferLine{
+			{StockID: medicine.ID(), Quantity: decimal.New(7, 0)},
+			{StockID: accessory.ID(), Quantity: decimal.New(5, 0)},
+		},
+	}
+	if err := wh.CreateTransfer(transfer); err != nil {
+		t.Fatalf(`CreateTransfer returns an error for a valid request: %s`, err)
+	}
+	if err := wh.ReceiveTransfer(transfer.ID, "van-driver"); err == nil {
+		t.Fatalf(`ReceiveTransfer receives a transfer that is not dispatched`)
+	}
+	if err := wh.DispatchTransfer(transfer.ID, "pharmacist"); err != nil {
+		t.Fatalf(`DispatchTransfer returns an error: %s`, err)
+	}
+
+	// the lot that expires first is picked first
+	transfer, _ = wh.ReadTransfer(transfer.ID)
+	if len(transfer.Picks) != 3 || transfer.Picks[0].LotID != receipts[1].LotID || !transfer.Picks[1].Quantity.Equal(decimal.New(2, 0)) {
+		t.Fatalf(`Unexpected picks %+v`, transfer.Picks)
+	}
+
+	if err := wh.ReceiveTransfer(transfer.ID, "van-driver"); err != nil {
+		t.Fatalf(`ReceiveTransfer returns an error: %s`, err)
+	}
+
+	expected := map[string]map[string]int64{
+		clinic.ID: {medicine.ID(): 8, accessory.ID(): 15},
+		van.ID:    {medicine.ID(): 7, accessory.ID(): 5},
+	}
+	for locationID, quantities := range expected {
+		levels := wh.StockLevels(locationID)
+		if len(levels) != len(quantities) {
+			t.Fatalf(`Expected %d stock levels, got %d`, len(quantities), len(levels))
+		}
+		for _, level := range levels {
+			if !level.Quantity.Equal(decimal.New(quantities[level.StockID], 0)) {
+				t.Errorf(`Unexpected quantity %s of %s in %s`, level.Quantity, level.StockID, locationID)
+			}
+		}
+	}
+
+	vanLots := wh.LocationLots(van.ID)
+	if len(vanLots) != 2 || vanLots[0].Number != "B" || !vanLots[1].Quantity.Equal(decimal.New(2, 0)) {
+		t.Fatalf(`Unexpected lots in the van %+v`, vanLots)
+	}
+	if items := insufficientStockAt(wh, van.ID); len(items) != 0 {
+		t.Fatalf(`Expected no insufficient stock in the van, got %d items`, len(items))
+	}
+
+	dispense = &Movement{StockID: medicine.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0), LotID: vanLots[0].ID}
+	if err := wh.RecordMovement(dispense); err != nil || dispense.LocationID != van.ID {
+		t.Fatalf(`RecordMovement does not dispense from the lot in the van: %v`, err)
+	}
+	if err := wh.DeclineTransfer(transfer.ID, "pharmacist"); err == nil {
+		t.Fatalf(`DeclineTransfer declines a received transfer`)
+	}
+
+	tooMuch := &Transfer{
+		FromLocationID: clinic.ID,
+		ToLocationID:   van.ID,
+		Lines:          []TransferLine{{StockID: accessory.ID(), Quantity: decimal.New(100, 0)}},
+	}
+	wh.CreateTransfer(tooMuch)
+	if err := wh.DispatchTransfer(tooMuch.ID, "pharmacist"); err == nil {
+		t.Fatalf(`DispatchTransfer dispatches more than the location holds`)
+	}
+	if err := wh.DeclineTransfer(tooMuch.ID, "pharmacist"); err != nil {
+		t.Fatalf(`DeclineTransfer returns an error for a requested transfer: %s`, err)
+	}
+
+	if err := wh.Locations().DeleteLocation(van.ID); err == nil {
+		t.Fatalf(`DeleteLocation removes a location that holds stock`)
+	}
+}
+
+func TestLotsMigration(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	_, err := db.Exec(`
+	CREATE TABLE
+		stock_lots (
+			id TEXT NOT NULL PRIMARY KEY,
+			stock_id TEXT NOT NULL,
+			number TEXT NOT NULL,
+			expiration_date DATETIME,
+			quantity NUMERIC NOT NULL,
+			location_id TEXT NOT NULL,
+			state TEXT NOT NULL,
+			state_reason TEXT NOT NULL,
+			received DATETIME NOT NULL,
+			UNIQUE (stock_id, number),
+			FOREIGN KEY (stock_id) REFERENCES warehouse (id)
+	);
+	INSERT INTO stock_lots VALUES('lot', 'item', 'L1', NULL, 3, '', 'available', '', '2020-01-01 00:00:00');
+	`)
+	if err != nil {
+		t.Fatalf(`Error creating the stock_lots table of an older version: %s`, err)
+	}
+
+	wh := NewWarehouse(db)
+
+	var tableSQL string
+	db.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'stock_lots'`).Scan(&tableSQL)
+	if !strings.Contains(tableSQL, "UNIQUE (stock_id, number, location_id)") {
+		t.Fatalf(`The stock_lots table is not migrated: %s`, tableSQL)
+	}
+	if lot, ok := wh.ReadLot("lot"); !ok || !lot.Quantity.Equal(decimal.New(3, 0)) {
+		t.Fatalf(`The lots are not kept by the migration`)
+	}
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// userAuditDTO is the user as it is recorded in the audit log.
+// Passwords are never recorded, only that a password was changed.
+type userAuditDTO struct {
+	UserDTO
+	PasswordChanged bool `json:"passwordChanged,omitempty"`
+}
+
+func newUserAuditDTO(u User, passwordChanged bool) *userAuditDTO {
+	return &userAuditDTO{UserDTO: *newUserDTO(u), PasswordChanged: passwordChanged}
+}
+
+func (m *madminHandler) usersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listUsersHandler(w, r)
+	case "POST":
+		m.addUserHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+func (m *madminHandler) userHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getUserHandler(w, r)
+	case "DELETE":
+		m.removeUserHandler(w, r)
+	case "PUT":
+		m.updateUserHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /users/
+//
+// Lists the users ordered by name.
+func (m *madminHandler) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users := m.userManager.Users()
+
+	resp := make([]*UserDTO, 0, len(users))
+	for _, u := range users {
+		resp = append(resp, newUserDTO(u))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /users/
+//
+// Adds a user and returns its id.
+func (m *madminHandler) addUserHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewUserDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+	if _, exists := m.userManager.ReadUserByName(dto.Name); exists {
+		respondBadRequest(w, ValidationErrors{{"name", "a user with this name already exists"}})
+		return
+	}
+
+	u, err := NewUser(dto.Name, dto.Password)
+	if err != nil {
+		respondBadRequest(w, err)
+		return
+	}
+	if err := m.userManager.CreateUser(u, m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, u.ID())
+}
+
+// Handler for GET /users/<id>
+//
+// Returns JSON with the name of the user with the given id.
+func (m *madminHandler) getUserHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userManager.ReadUserById(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newUserDTO(u))
+}
+
+// Handler for PUT /users/<id>
+//
+// Renames the user with <id> and changes its password if a new one is set.
+func (m *madminHandler) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &NewUserDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	u, ok := m.userManager.ReadUserById(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	errs := ValidationErrors{}
+	if dto.Name == "" {
+		errs = append(errs, ValidationError{"name", "no name set"})
+	} else if other, exists := m.userManager.ReadUserByName(dto.Name); exists && other.ID() != u.ID() {
+		errs = append(errs, ValidationError{"name", "a user with this name already exists"})
+	}
+	if len(errs) > 0 {
+		respondBadRequest(w, errs)
+		return
+	}
+
+	u.SetName(dto.Name)
+	if dto.Password != "" {
+		if err := u.SetPassword(dto.Password); err != nil {
+			respondBadRequest(w, err)
+			return
+		}
+	}
+	if err := m.userManager.UpdateUser(u, m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for DELETE /users/<id>
+//
+// Removes the user with <id>. Users cannot remove themselves.
+func (m *madminHandler) removeUserHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userManager.ReadUserById(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if u.ID() == requestUserID(r) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "Error in removing user: users cannot remove themselves")
+		return
+	}
+
+	if err := m.userManager.RemoveUser(u.ID(), m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,13 +1,20 @@
 package app
 
-import "database/sql"
+import (
+	"database/sql"
+	"errors"
+)
 
+// UserManager manages the users of madmin. The changes of users are recorded in the audit trail
+// that is passed with them. A change fails and is rolled back if it cannot be recorded.
 type UserManager interface {
-	CreateUser(User)
+	CreateUser(User, *auditTrail) error
 	ReadUserById(string) (User, bool)
 	ReadUserByName(string) (User, bool)
-	UpdateUser(User)
-	RemoveUser(string)
+	UpdateUser(User, *auditTrail) error
+	RemoveUser(string, *auditTrail) error
+	// Users() returns all users ordered by name
+	Users() []User
 
 	ValidateUser(string, string) bool
 }
@@ -45,8 +52,14 @@ func (um *defaultUserManager) initUsersTable() {
 	}
 }
 
-func (um *defaultUserManager) CreateUser(u User) {
-	stmt, err := um.database.Prepare(`
+func (um *defaultUserManager) CreateUser(u User, trail *auditTrail) error {
+	tx, err := um.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
 		INSERT INTO
 			users (
 				id,
@@ -54,13 +67,7 @@ func (um *defaultUserManager) CreateUser(u User) {
 				password,
 				salt)
 		VALUES(?, ?, ?, ?)
-	`)
-	if err != nil {
-		panic(err)
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(
+	`,
 		u.ID(),
 		u.Name(),
 		u.Password(),
@@ -68,6 +75,16 @@ func (um *defaultUserManager) CreateUser(u User) {
 	if err != nil {
 		panic(err)
 	}
+
+	if err := trail.recordTx(tx, AUDIT_USER, u.ID(), nil, newUserAuditDTO(u, false)); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
 }
 
 func (um *defaultUserManager) ReadUserById(id string) (User, bool) {
@@ -132,8 +149,23 @@ func (um *defaultUserManager) ReadUserByName(name string) (User, bool) {
 	return &u, true
 }
 
-func (um *defaultUserManager) UpdateUser(u User) {
-	stmt, err := um.database.Prepare(`
+func (um *defaultUserManager) UpdateUser(u User, trail *auditTrail) error {
+	tx, err := um.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	previous := &defaultUser{id: u.ID()}
+	err = tx.QueryRow(`SELECT name, password FROM users WHERE id = ?`, u.ID()).Scan(&previous.name, &previous.password)
+	switch {
+	case err == sql.ErrNoRows:
+		return errors.New("no such user")
+	case err != nil:
+		panic(err)
+	}
+
+	_, err = tx.Exec(`
 	UPDATE
 		users
 	SET
@@ -142,13 +174,7 @@ func (um *defaultUserManager) UpdateUser(u User) {
 		salt = ?
 	WHERE
 		id = ?
-	`)
-	if err != nil {
-		panic(err)
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(
+	`,
 		u.Name(),
 		u.Password(),
 		u.Salt(),
@@ -156,24 +182,87 @@ func (um *defaultUserManager) UpdateUser(u User) {
 	if err != nil {
 		panic(err)
 	}
+
+	passwordChanged := u.Password() != previous.Password()
+	if err := trail.recordTx(tx, AUDIT_USER, u.ID(), newUserAuditDTO(previous, false), newUserAuditDTO(u, passwordChanged)); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
 }
 
-func (um *defaultUserManager) RemoveUser(id string) {
-	stmt, err := um.database.Prepare(`
+func (um *defaultUserManager) RemoveUser(id string, trail *auditTrail) error {
+	tx, err := um.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	previous := &defaultUser{id: id}
+	err = tx.QueryRow(`SELECT name FROM users WHERE id = ?`, id).Scan(&previous.name)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		panic(err)
+	}
+
+	_, err = tx.Exec(`
 		DELETE FROM
 			users
 		WHERE
 			id = ?
+	`, id)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := trail.recordTx(tx, AUDIT_USER, id, newUserAuditDTO(previous, false), nil); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+func (um *defaultUserManager) Users() []User {
+	rows, err := um.database.Query(`
+	SELECT
+		id,
+		name,
+		password,
+		salt
+	FROM
+		users
+	ORDER BY
+		name
 	`)
 	if err != nil {
 		panic(err)
 	}
-	defer stmt.Close()
+	defer rows.Close()
 
-	_, err = stmt.Exec(id)
+	users := make([]User, 0)
+	for rows.Next() {
+		u := &defaultUser{}
+		if err = rows.Scan(&u.id, &u.name, &u.password, &u.salt); err != nil {
+			panic(err)
+		}
+		users = append(users, u)
+	}
+	err = rows.Err()
 	if err != nil {
 		panic(err)
 	}
+
+	return users
 }
 
 func (um *defaultUserManager) ValidateUser(name, password string) bool {
@@ -2,10 +2,12 @@ package app
 
 import (
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/shopspring/decimal"
 	"io"
+	"log"
 	"net/http"
 	"time"
 )
@@ -36,12 +38,14 @@ func respondStatusUnauthorized(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// dateLayout is the format of all dates in the API
+const dateLayout = "2006-01-02T15:04:05.000Z"
+
 func validDateFromString(dateString string) (date time.Time, err error) {
 	if dateString == "" {
 		return time.Unix(0, 0), errors.New("expected expiration dateString but not set")
 	}
-	layout := "2006-01-02T15:04:05.000Z"
-	date, err = time.Parse(layout, dateString)
+	date, err = time.Parse(dateLayout, dateString)
 	if err != nil {
 		return
 	}
@@ -58,3 +62,33 @@ func validQuantityFromString(quantityString string) (quantity decimal.Decimal, e
 	}
 	return
 }
+
+// respondJSON marshals v and writes it with the given status code
+func respondJSON(w http.ResponseWriter, status int, v interface{}) {
+	respBytes, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Error in marshalling results: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(respBytes); err != nil {
+		log.Printf("Error while writing response: %s", err)
+	}
+}
+
+// decodeJSONBody unmarshals the request body into v.
+// If the body is invalid it responds with status code 400 and returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	defer r.Body.Close()
+
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Printf("Error in unmarshaling request body: %s", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ValidationError describes an invalid field in a request to the API
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (ve ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", ve.Field, ve.Message)
+}
+
+// ValidationErrors is a list of all invalid fields found while validating a request
+type ValidationErrors []ValidationError
+
+func (ves ValidationErrors) Error() string {
+	messages := make([]string, 0, len(ves))
+	for _, ve := range ves {
+		messages = append(messages, ve.Error())
+	}
+	return fmt.Sprintf("invalid fields - %s", strings.Join(messages, "; "))
+}
+
+// validationErrorsResponseDTO is the body of a response to a request with invalid fields
+type validationErrorsResponseDTO struct {
+	Errors ValidationErrors `json:"errors"`
+
+	// Substitutes are suggested when there is not enough stock for a dispense
+	Substitutes []*SubstituteDTO `json:"substitutes,omitempty"`
+}
+
+// respondBadRequest writes status code 400 with the field-level validation errors as JSON
+// or with the plain error message if err is not ValidationErrors.
+func respondBadRequest(w http.ResponseWriter, err error) {
+	ves, ok := err.(ValidationErrors)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Error in request: %s", err)
+		return
+	}
+
+	respondJSON(w, http.StatusBadRequest, &validationErrorsResponseDTO{Errors: ves})
+}
@@ -2,24 +2,35 @@ package app
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"time"
 
-	// used for decimal operations
-	_ "github.com/shopspring/decimal"
+	"github.com/shopspring/decimal"
 )
 
 // Warehouse is a warehouse interface.
 // A warehouse must manage two datasets -
 // one with the existing stock items and one with the stock items' distributors.
+//
+// The audited changes are recorded in the audit trail that is passed with them.
+// A change fails and is rolled back if it cannot be recorded.
 type Warehouse interface {
-	CreateStock(Stock)
+	// CreateStock() adds a stock item and records its quantity as its opening balance in the ledger
+	CreateStock(Stock, *auditTrail) error
 	ReadStock(string) (Stock, bool)
-	UpdateStock(Stock)
-	DeleteStock(string)
+	// UpdateStock() updates a stock item. A new quantity is recorded in the ledger as adjustments
+	// by the user with the given id. It returns ValidationErrors if the adjustments are not allowed.
+	UpdateStock(Stock, string, *auditTrail) error
+	// DeleteStock() removes a stock item and keeps when it was removed
+	DeleteStock(string, *auditTrail) error
 
-	CreateDistributor(Distributor)
+	CreateDistributor(Distributor, *auditTrail) error
 	ReadDistributor(string) (Distributor, bool)
-	UpdateDistributor(Distributor)
-	DeleteDistributor(string)
+	UpdateDistributor(Distributor, *auditTrail) error
+	DeleteDistributor(string, *auditTrail) error
+	// Distributors() returns all distributors ordered by name
+	Distributors() []Distributor
 
 	// Stock() returns a map with the ids of the current stock items in the DB,
 	// mapped to the corresponding stock items
@@ -28,24 +39,243 @@ type Warehouse interface {
 	// Size() returns number of unique stock items in DB
 	// TODO: should return number of all stock items in DB
 	Size() int
+
+	// RecordMovement() changes the quantity of a stock item and records the change in the ledger.
+	// It returns ValidationErrors if the movement is not allowed.
+	RecordMovement(*Movement) error
+	// Movements() returns the ledger entries for a stock item in chronological order
+	Movements(string) []Movement
+	// PatientDispenses() returns the dispenses to a patient in chronological order
+	PatientDispenses(string) []Movement
+
+	ReadLot(string) (*Lot, bool)
+	// Lots() returns the lots of a stock item, the ones that expire first are first
+	Lots(string) []*Lot
+	// MoveLot() assigns a lot to a storage location
+	MoveLot(id, locationID string) error
+	// ChangeLotState() moves a lot to a new state and records the change in the lot's history
+	ChangeLotState(id string, to lotState, userID, witnessID, reason string) error
+	LotHistory(string) []LotStateChange
+	// ExpireLots() moves the lots that expired before the given time to the EXPIRED state
+	ExpireLots(time.Time) []*Lot
+
+	// AvailableQuantities() returns the quantity that can be dispensed for every stock item
+	AvailableQuantities() map[string]decimal.Decimal
+	// WriteOffs() returns the write-offs in the given period with their value
+	WriteOffs(from, to time.Time) []WriteOff
+
+	// CreateRecall() saves a recall and blocks the recalled lots from dispensing
+	CreateRecall(*Recall) error
+	ReadRecall(string) (*Recall, bool)
+	// Recalls() returns all recalls, the latest are first
+	Recalls() []*Recall
+	// RecalledLots() returns the lots matching the lot numbers of a recall
+	RecalledLots(string) []*Lot
+	// RecalledDispenses() returns the dispenses from the lots of a recall
+	RecalledDispenses(string) []Movement
+
+	// CreateStocktake() opens a stocktake with the current quantities of all stock items and lots
+	CreateStocktake(*Stocktake) error
+	ReadStocktake(string) (*Stocktake, bool)
+	// Stocktakes() returns all stocktakes, the latest are first
+	Stocktakes() []*Stocktake
+	// StocktakeLines() returns the count sheet of a stocktake with the latest counts
+	StocktakeLines(string) []*StocktakeLine
+	// RecordCounts() saves counts of the lines of an open stocktake
+	RecordCounts(string, []StocktakeCount) error
+	// ApproveStocktake() closes a stocktake and posts the adjustments of its discrepancies
+	ApproveStocktake(string, *StocktakeApproval) ([]*Movement, error)
+	// CancelStocktake() closes a stocktake without any adjustments
+	CancelStocktake(id, userID string) error
+
+	// CreatePrescription() saves a prescription that stock can be dispensed against
+	CreatePrescription(*Prescription) error
+	ReadPrescription(string) (*Prescription, bool)
+	// Prescriptions() returns the prescriptions of a patient, optionally only for one stock item
+	Prescriptions(patientID, stockID string) []*Prescription
+
+	// CreateSale() completes a sale and dispenses its stock
+	CreateSale(*Sale) error
+	ReadSale(string) (*Sale, bool)
+	// Sales() returns the sales in the given period, the latest are first
+	Sales(from, to time.Time) []*Sale
+	// RefundSale() records a refund of a sale and restocks the returned stock
+	RefundSale(*Refund) error
+	// Refunds() returns the refunds of a sale in chronological order
+	Refunds(string) []*Refund
+
+	// CreatePurchaseOrder() saves an order of stock from a distributor
+	CreatePurchaseOrder(*PurchaseOrder) error
+	ReadPurchaseOrder(string) (*PurchaseOrder, bool)
+	// PurchaseOrders() returns the orders from a distributor or from all distributors, the latest are first
+	PurchaseOrders(string) []*PurchaseOrder
+
+	// CreateInvoice() records a distributor's invoice for received lots of purchase orders
+	CreateInvoice(*Invoice) error
+	ReadInvoice(string) (*Invoice, bool)
+	// Invoices() returns the invoices of a distributor in a state, the ones that are due first are first
+	Invoices(distributorID string, state invoiceState) []*Invoice
+	// MatchInvoice() compares an invoice with its purchase orders and received lots
+	MatchInvoice(string) ([]*InvoiceLineMatch, bool)
+	// ApproveInvoice() approves an invoice for payment
+	ApproveInvoice(id, userID string, acceptDiscrepancies bool) error
+	// PayInvoice() marks an approved invoice as paid
+	PayInvoice(id, userID string) error
+	// Payables() returns the unpaid invoices of a distributor with their amounts
+	Payables(string) *Payables
+
+	// StockLevels() returns the quantities and minimum quantities of the stock items in a storage location
+	StockLevels(string) []*StockLevel
+	// LocationLots() returns the lots in a storage location, the ones that expire first are first
+	LocationLots(string) []*Lot
+	// SetMinQuantity() sets the minimum quantity of a stock item in a storage location
+	SetMinQuantity(stockID, locationID string, minQuantity *decimal.Decimal, trail *auditTrail) error
+
+	// CreateTransfer() saves a request for stock from one storage location to another
+	CreateTransfer(*Transfer) error
+	ReadTransfer(string) (*Transfer, bool)
+	// Transfers() returns the transfers from or to a storage location, the latest are first
+	Transfers(string) []*Transfer
+	// DispatchTransfer() takes the requested stock from the source location
+	DispatchTransfer(id, userID string) error
+	// ReceiveTransfer() adds the dispatched stock to the receiving location
+	ReceiveTransfer(id, userID string) error
+	// DeclineTransfer() closes a requested transfer without moving stock
+	DeclineTransfer(id, userID string) error
+
+	// Locations() returns the manager of the storage locations of the warehouse's lots
+	Locations() LocationManager
+	// Clients() returns the manager of the owners that stock is sold to
+	Clients() ClientManager
+
+	// StockTypes() returns the registry with the stock types of the warehouse's stock items
+	StockTypes() StockTypeRegistry
+
+	// AddBarcode() assigns a GTIN/EAN barcode to a stock item
+	AddBarcode(stockID, code string) error
+	RemoveBarcode(stockID, code string)
+	// Barcodes() returns the barcodes of a stock item as 14 digit GTINs
+	Barcodes(string) []string
+	ReadStockByBarcode(string) (Stock, bool)
+
+	// SetDosing() replaces the dosing rules of a stock item
+	SetDosing(*Dosing) error
+	ReadDosing(string) (*Dosing, bool)
+	// CalculateDose() returns the quantity of a stock item for a patient's species and weight
+	CalculateDose(stockID, species string, weight decimal.Decimal, mgPerKg *decimal.Decimal) (*Dose, error)
+
+	// SetEquivalence() sets the active ingredient, strength and form of a stock item
+	SetEquivalence(*Equivalence) error
+	ReadEquivalence(string) (*Equivalence, bool)
+	// AddSubstitute() links two stock items that can replace each other
+	AddSubstitute(stockID, substituteID string) error
+	RemoveSubstitute(stockID, substituteID string)
+	// Substitutes() returns the linked and equivalent stock items with their available quantities
+	Substitutes(stockID string, available map[string]decimal.Decimal) []*Substitute
+
+	// SetBundle() replaces the components of a bundle
+	SetBundle(*Bundle) error
+	ReadBundle(string) (*Bundle, bool)
+	// BundleAvailability() returns the assembled kits of a bundle and the kits its components are enough for
+	BundleAvailability(string) *BundleAvailability
+	// AssembleBundle() assembles kits of a bundle from its components, or takes them apart for negative quantities
+	AssembleBundle(stockID string, quantity decimal.Decimal, userID, locationID string) ([]*Movement, error)
+
+	// ReorderSuggestions() calculates reorder points and order quantities from the consumption of the stock items
+	ReorderSuggestions(ForecastOptions) ([]*ReorderSuggestion, error)
+	// ApplyReorderPoints() sets the minimum quantities of stock items to their suggested reorder points
+	ApplyReorderPoints(opts ForecastOptions, stockIDs []string, trail *auditTrail) ([]*ReorderSuggestion, error)
+
+	// ABCClassification() classifies the stock items by their consumption value in a period
+	ABCClassification(from, to time.Time) []*ABCItem
+	// ItemTurnover() returns the inventory turnover of every stock item in a period
+	ItemTurnover(from, to time.Time) []*Turnover
+	// StockTypeTurnover() returns the inventory turnover of every stock type in a period
+	StockTypeTurnover(from, to time.Time) []*Turnover
+	// DeadStock() returns the stock items in stock that have not moved since the given time
+	DeadStock(since time.Time) []*DeadStockItem
+	// ExpiryLosses() returns the monthly value of the expired stock written off in a period
+	ExpiryLosses(from, to time.Time) []*ExpiryLoss
+
+	// InventoryAsOf() reconstructs the stock items with their quantities and lots at a point in time
+	InventoryAsOf(time.Time) *InventorySnapshot
+	// StockAsOf() reconstructs a stock item with its quantity and lots at a point in time
+	StockAsOf(id string, t time.Time) (*SnapshotItem, bool)
+	// TakeSnapshot() keeps the inventory at a point in time in the DB as a closed snapshot
+	TakeSnapshot(time.Time) (*InventorySnapshot, error)
+	// Snapshots() returns the kept snapshots without their items, the latest first
+	Snapshots() []*InventorySnapshot
+	ReadSnapshot(string) (*InventorySnapshot, bool)
+
+	// AddListener() adds a listener that is called for every change committed through the warehouse
+	AddListener(EventListener)
 }
 
 type dafaultWarehouse struct {
+	eventPublisher
+
 	database *sql.DB
+
+	stockTypes StockTypeRegistry
+	locations  LocationManager
+	clients    ClientManager
 }
 
 // NewWarehouse creates a warehouse that holds the stock items'
 // and distriubutors' data in two separate sqlite3 tables inside the db
-// that is passed as an argument.
+// that is passed as an argument. The stock movements, lots, recalls, barcodes,
+// stocktakes, stock types, storage locations with their stock levels,
+// transfers, prescriptions, sales, purchase orders, invoices, dosing rules,
+// substitutes, bundles and inventory snapshots are kept in the same db.
 func NewWarehouse(db *sql.DB) Warehouse {
 	wh := &dafaultWarehouse{database: db}
 
 	wh.initStockTable()
 	wh.initDistributorsTable()
+	wh.initMovementsTable()
+	wh.initLotsTable()
+	wh.initRecallsTables()
+	wh.initBarcodesTable()
+	wh.initStocktakeTables()
+	wh.initTransfersTables()
+	wh.initStockLevelsTable()
+	wh.initPrescriptionsTable()
+	wh.initSalesTables()
+	wh.initPurchaseOrdersTables()
+	wh.initInvoicesTables()
+	wh.initDosingTables()
+	wh.initSubstitutesTables()
+	wh.initBundlesTable()
+	wh.initSnapshotsTables()
+
+	wh.stockTypes = NewStockTypeRegistry(db)
+	wh.locations = NewLocationManager(db)
+	wh.clients = NewClientManager(db)
 
 	return wh
 }
 
+func (wh *dafaultWarehouse) StockTypes() StockTypeRegistry {
+	return wh.stockTypes
+}
+
+func (wh *dafaultWarehouse) Locations() LocationManager {
+	return wh.locations
+}
+
+func (wh *dafaultWarehouse) Clients() ClientManager {
+	return wh.clients
+}
+
+// expirationDateOrNil returns the expiration date of the item
+// or nil for unexpirable items, so it can be written in the DB
+func expirationDateOrNil(item Stock) interface{} {
+	if !item.IsExpirable() {
+		return nil
+	}
+	return item.ExpirationDate()
+}
+
 func (wh *dafaultWarehouse) initStockTable() {
 	stockTable := `
 	CREATE TABLE IF NOT EXISTS warehouse(
@@ -56,6 +286,8 @@ func (wh *dafaultWarehouse) initStockTable() {
 		min_quantity NUMERIC,
 		expiration_date DATETIME,
 		distributor_id BLOB,
+		controlled_schedule TEXT NOT NULL DEFAULT '',
+		prescription_required BOOLEAN NOT NULL DEFAULT 0,
 		FOREIGN KEY (distributor_id) REFERENCES distributors (Id)
 	);
 	`
@@ -64,6 +296,28 @@ func (wh *dafaultWarehouse) initStockTable() {
 	if err != nil {
 		panic(err)
 	}
+
+	addColumnIfMissing(wh.database, "warehouse", "controlled_schedule", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(wh.database, "warehouse", "prescription_required", "BOOLEAN NOT NULL DEFAULT 0")
+	// stock items created before the creation time was kept have no creation time
+	addColumnIfMissing(wh.database, "warehouse", "created", "DATETIME")
+
+	// the removed stock items are kept with their last name and quantity for the inventory at past times
+	_, err = wh.database.Exec(`
+	CREATE TABLE IF NOT EXISTS
+		stock_deletions (
+			stock_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			quantity NUMERIC NOT NULL,
+			created DATETIME,
+			deleted DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS
+		stock_deletions_stock_id ON stock_deletions (stock_id);
+	`)
+	if err != nil {
+		panic(err)
+	}
 }
 
 func (wh *dafaultWarehouse) initDistributorsTable() {
@@ -82,8 +336,15 @@ func (wh *dafaultWarehouse) initDistributorsTable() {
 
 // Database CRUD methods for stock items
 // insert in DB
-func (wh *dafaultWarehouse) CreateStock(item Stock) {
-	stmt, err := wh.database.Prepare(`
+func (wh *dafaultWarehouse) CreateStock(item Stock, trail *auditTrail) error {
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	// the quantity is set by the opening balance
+	_, err = tx.Exec(`
 		INSERT INTO
 			warehouse (
 				id,
@@ -92,25 +353,46 @@ func (wh *dafaultWarehouse) CreateStock(item Stock) {
 				quantity,
 				min_quantity,
 				expiration_date,
-				distributor_id)
-		VALUES(?, ?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		panic(err)
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(
+				distributor_id,
+				controlled_schedule,
+				prescription_required,
+				created)
+		VALUES(?, ?, ?, 0, ?, ?, ?, ?, ?, ?)
+	`,
 		item.ID(),
 		item.Type(),
 		item.Name(),
-		item.Quantity().String(),
 		item.MinQuantity().String(),
-		item.ExpirationDate(),
-		item.DistributorID())
+		expirationDateOrNil(item),
+		item.DistributorID(),
+		item.ControlledSchedule(),
+		item.PrescriptionRequired(),
+		time.Now().UTC())
+	if err != nil {
+		panic(err)
+	}
+
+	if !item.Quantity().IsZero() {
+		opening := &Movement{StockID: item.ID(), Kind: RECEIPT, Quantity: item.Quantity(), Reference: openingBalanceReference}
+		if item.Quantity().Sign() < 0 {
+			opening.Kind = ADJUSTMENT
+		}
+		if err := insertMovementTx(tx, opening, item.Quantity()); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := trail.recordTx(tx, AUDIT_STOCK, item.ID(), nil, newStockDTO(item)); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		panic(err)
 	}
+
+	wh.publishStockChange(STOCK_CREATED, item, nil)
+	return nil
 }
 
 // read from DB
@@ -122,7 +404,9 @@ func (wh *dafaultWarehouse) ReadStock(id string) (item Stock, ok bool) {
 		quantity,
 		min_quantity,
 		expiration_date,
-		distributor_id
+		distributor_id,
+		controlled_schedule,
+		prescription_required
 	FROM
 		warehouse
 	WHERE
@@ -134,16 +418,19 @@ func (wh *dafaultWarehouse) ReadStock(id string) (item Stock, ok bool) {
 	defer stmt.Close()
 
 	var (
-		stockItem = defaultStock{id: id}
-		sType     int8
+		stockItem      = defaultStock{id: id}
+		sType          stockType
+		expirationDate *time.Time
 	)
 	err = stmt.QueryRow(id).Scan(
 		&sType,
 		&stockItem.name,
 		&stockItem.quantity,
 		&stockItem.minQuantity,
-		&stockItem.expirationDate,
-		&stockItem.distributorID)
+		&expirationDate,
+		&stockItem.distributorID,
+		&stockItem.controlledSchedule,
+		&stockItem.prescriptionRequired)
 	switch {
 	case err == sql.ErrNoRows:
 		return nil, false
@@ -151,91 +438,178 @@ func (wh *dafaultWarehouse) ReadStock(id string) (item Stock, ok bool) {
 		panic(err)
 	}
 
-	switch stockType(sType) {
-	case MEDICINE:
-		return &medicine{stockItem}, true
-	case FEED:
-		return &feed{stockItem}, true
-	case ACCESSORY:
-		return &accessory{stockItem}, true
-	default:
-		panic("invalid stock type in DB record")
+	kind, ok := wh.stockTypes.ReadStockType(sType)
+	if !ok {
+		panic(fmt.Sprintf("stock type %d of DB record is missing in the stock type registry", sType))
+	}
+	stockItem.kind = kind
+	if expirationDate != nil {
+		stockItem.expirationDate = *expirationDate
 	}
+
+	return &stockItem, true
 }
 
 // update in DB
-func (wh *dafaultWarehouse) UpdateStock(item Stock) {
-	stmt, err := wh.database.Prepare(`
+func (wh *dafaultWarehouse) UpdateStock(item Stock, userID string, trail *auditTrail) error {
+	previous, ok := wh.ReadStock(item.ID())
+	if !ok {
+		return errors.New("no such stock item")
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	if delta := item.Quantity().Sub(previous.Quantity()); !delta.IsZero() {
+		for _, adjustment := range lotAdjustmentsTx(tx, item.ID(), delta, userID) {
+			if err := wh.recordMovementTx(tx, adjustment); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the quantity is only changed by the movements
+	_, err = tx.Exec(`
 	UPDATE
 		warehouse
 	SET
 		type = ?,
 		name = ?,
-		quantity = ?,
 		min_quantity = ?,
 		expiration_date = ?,
-		distributor_id = ?
+		distributor_id = ?,
+		controlled_schedule = ?,
+		prescription_required = ?
 	WHERE
 		id = ?
-	`)
-	if err != nil {
-		panic(err)
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(
+	`,
 		item.Type(),
 		item.Name(),
-		item.Quantity().String(),
 		item.MinQuantity().String(),
-		item.ExpirationDate(),
+		expirationDateOrNil(item),
 		item.DistributorID(),
+		item.ControlledSchedule(),
+		item.PrescriptionRequired(),
 		item.ID())
 	if err != nil {
 		panic(err)
 	}
+
+	var quantity decimal.Decimal
+	err = tx.QueryRow(`SELECT quantity FROM warehouse WHERE id = ?`, item.ID()).Scan(&quantity)
+	if err != nil {
+		panic(err)
+	}
+	item.SetQuantity(quantity)
+
+	if err := trail.recordTx(tx, AUDIT_STOCK, item.ID(), newStockDTO(previous), newStockDTO(item)); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	wh.publishStockChange(STOCK_UPDATED, item, previous)
+	return nil
 }
 
 // remove from DB
-func (wh *dafaultWarehouse) DeleteStock(id string) {
-	stmt, err := wh.database.Prepare(`
-		DELETE FROM
+func (wh *dafaultWarehouse) DeleteStock(id string, trail *auditTrail) error {
+	item, ok := wh.ReadStock(id)
+	if !ok {
+		return nil
+	}
+
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			stock_deletions (
+				stock_id,
+				name,
+				quantity,
+				created,
+				deleted)
+		SELECT
+			id,
+			COALESCE(name, ''),
+			quantity,
+			created,
+			?
+		FROM
 			warehouse
 		WHERE
 			id = ?
-	`)
+	`, time.Now().UTC(), id)
 	if err != nil {
 		panic(err)
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	for _, query := range []string{
+		`DELETE FROM warehouse WHERE id = ?`,
+		`DELETE FROM stock_barcodes WHERE stock_id = ?`,
+		`DELETE FROM stock_levels WHERE stock_id = ?`,
+	} {
+		if _, err = tx.Exec(query, id); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := trail.recordTx(tx, AUDIT_STOCK, id, newStockDTO(item), nil); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		panic(err)
 	}
+
+	wh.publish(STOCK_DELETED, &StockDeletedDTO{ID: id})
+	return nil
 }
 
 // Database CRUD methods for distributors
 // insert in DB
-func (wh *dafaultWarehouse) CreateDistributor(d Distributor) {
-	stmt, err := wh.database.Prepare(`
+func (wh *dafaultWarehouse) CreateDistributor(d Distributor, trail *auditTrail) error {
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
 		INSERT INTO
 			distributors (
 				id,
 				name)
 		VALUES (?, ?)
-	`)
+	`,
+		d.ID(),
+		d.Name())
 	if err != nil {
 		panic(err)
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(
-		d.ID(),
-		d.Name())
+	if err := trail.recordTx(tx, AUDIT_DISTRIBUTOR, d.ID(), nil, newDistributorDTO(d)); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		panic(err)
 	}
+
+	wh.publish(DISTRIBUTOR_CREATED, newDistributorDTO(d))
+	return nil
 }
 
 // read from DB
@@ -263,49 +637,122 @@ func (wh *dafaultWarehouse) ReadDistributor(id string) (Distributor, bool) {
 		panic(err)
 	}
 
-	return d, true
+	return &d, true
 }
 
 // update in DB
-func (wh *dafaultWarehouse) UpdateDistributor(d Distributor) {
-	stmt, err := wh.database.Prepare(`
+func (wh *dafaultWarehouse) UpdateDistributor(d Distributor, trail *auditTrail) error {
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	before := &DistributorDTO{ID: d.ID()}
+	err = tx.QueryRow(`SELECT name FROM distributors WHERE id = ?`, d.ID()).Scan(&before.Name)
+	switch {
+	case err == sql.ErrNoRows:
+		return errors.New("no such distributor")
+	case err != nil:
+		panic(err)
+	}
+
+	_, err = tx.Exec(`
 	UPDATE
 		distributors
 	SET
-		name = ?,
+		name = ?
 	WHERE
 		id = ?
-	`)
+	`,
+		d.Name(),
+		d.ID())
 	if err != nil {
 		panic(err)
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(
-		d.Name(),
-		d.ID())
+	if err := trail.recordTx(tx, AUDIT_DISTRIBUTOR, d.ID(), before, newDistributorDTO(d)); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		panic(err)
 	}
+
+	wh.publish(DISTRIBUTOR_UPDATED, newDistributorDTO(d))
+	return nil
 }
 
 // remove from DB
-func (wh *dafaultWarehouse) DeleteDistributor(id string) {
-	stmt, err := wh.database.Prepare(`
+func (wh *dafaultWarehouse) DeleteDistributor(id string, trail *auditTrail) error {
+	tx, err := wh.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	before := &DistributorDTO{ID: id}
+	err = tx.QueryRow(`SELECT name FROM distributors WHERE id = ?`, id).Scan(&before.Name)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		panic(err)
+	}
+
+	_, err = tx.Exec(`
 		DELETE FROM
 			distributors
 		WHERE
 			id = ?
+	`, id)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := trail.recordTx(tx, AUDIT_DISTRIBUTOR, id, before, nil); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	wh.publish(DISTRIBUTOR_DELETED, &DistributorDTO{ID: id})
+	return nil
+}
+
+func (wh *dafaultWarehouse) Distributors() []Distributor {
+	rows, err := wh.database.Query(`
+		SELECT
+			id,
+			name
+		FROM
+			distributors
+		ORDER BY
+			name, id
 	`)
 	if err != nil {
 		panic(err)
 	}
-	defer stmt.Close()
+	defer rows.Close()
 
-	_, err = stmt.Exec(id)
+	distributors := make([]Distributor, 0)
+	for rows.Next() {
+		d := &defaultDistributor{}
+		if err = rows.Scan(&d.id, &d.name); err != nil {
+			panic(err)
+		}
+		distributors = append(distributors, d)
+	}
+	err = rows.Err()
 	if err != nil {
 		panic(err)
 	}
+
+	return distributors
 }
 
 // Returns a map with the items in the warehouse with ids as keys and stock items as their values.
@@ -320,11 +767,15 @@ func (wh *dafaultWarehouse) Stock() (stock map[string]Stock) {
 			quantity,
 			min_quantity,
 			expiration_date,
-			distributor_id
+			distributor_id,
+			controlled_schedule,
+			prescription_required
 		FROM
 			warehouse
 	`
 
+	types := wh.stockTypes.StockTypes()
+
 	rows, err := wh.database.Query(query)
 	if err != nil {
 		panic(err)
@@ -333,8 +784,9 @@ func (wh *dafaultWarehouse) Stock() (stock map[string]Stock) {
 
 	for rows.Next() {
 		var (
-			stockItem = defaultStock{}
-			sType     int8
+			stockItem      = &defaultStock{}
+			sType          stockType
+			expirationDate *time.Time
 		)
 		err = rows.Scan(
 			&stockItem.id,
@@ -342,23 +794,25 @@ func (wh *dafaultWarehouse) Stock() (stock map[string]Stock) {
 			&stockItem.name,
 			&stockItem.quantity,
 			&stockItem.minQuantity,
-			&stockItem.expirationDate,
-			&stockItem.distributorID)
+			&expirationDate,
+			&stockItem.distributorID,
+			&stockItem.controlledSchedule,
+			&stockItem.prescriptionRequired)
 
 		if err != nil {
 			panic(err)
 		}
 
-		switch stockType(sType) {
-		case MEDICINE:
-			stock[stockItem.ID()] = &medicine{stockItem}
-		case FEED:
-			stock[stockItem.ID()] = &feed{stockItem}
-		case ACCESSORY:
-			stock[stockItem.ID()] = &accessory{stockItem}
-		default:
-			panic("invalid stock type in DB record")
+		kind, ok := types[sType]
+		if !ok {
+			panic(fmt.Sprintf("stock type %d of DB record is missing in the stock type registry", sType))
 		}
+		stockItem.kind = kind
+		if expirationDate != nil {
+			stockItem.expirationDate = *expirationDate
+		}
+
+		stock[stockItem.ID()] = stockItem
 	}
 	err = rows.Err()
 	if err != nil {
@@ -58,7 +58,7 @@ func TestWarehouse(t *testing.T) {
 		wh := NewWarehouse(db)
 
 		item, _ := defaultExpirableStockItem(MEDICINE)
-		wh.CreateStock(item)
+		wh.CreateStock(item, nil)
 
 		read, ok := wh.ReadStock(item.ID())
 
@@ -77,7 +77,7 @@ func TestWarehouse(t *testing.T) {
 		wh := NewWarehouse(db)
 
 		item, _ := defaultExpirableStockItem(MEDICINE)
-		wh.CreateStock(item)
+		wh.CreateStock(item, nil)
 
 		read, ok := wh.ReadStock(item.ID())
 		checkNewItemCreatingWithOKStatus(t, read, ok)
@@ -106,10 +106,10 @@ func TestWarehouse(t *testing.T) {
 		wh := NewWarehouse(db)
 
 		item, _ := defaultExpirableStockItem(MEDICINE)
-		wh.CreateStock(item)
+		wh.CreateStock(item, nil)
 
 		item.SetName("Aspirin")
-		wh.UpdateStock(item)
+		wh.UpdateStock(item, "", nil)
 
 		read, ok := wh.ReadStock(item.ID())
 		if !ok || read == nil {
@@ -124,13 +124,31 @@ func TestWarehouse(t *testing.T) {
 				read)
 		}
 	})
+	t.Run("CreateUnexpirableStock", func(t *testing.T) {
+		wh := NewWarehouse(db)
+
+		item, _ := defaultUnexpirableStockItem(ACCESSORY)
+		wh.CreateStock(item, nil)
+
+		read, ok := wh.ReadStock(item.ID())
+		if !ok {
+			t.Fatalf(`new item not found in database`)
+		}
+		if compareStock(read, item) == false {
+			t.Fatalf(`
+				Read item is different from expected.
+				Expected %+v, got %+v.`,
+				item,
+				read)
+		}
+	})
 	t.Run("DeleteStock", func(t *testing.T) {
 		wh := NewWarehouse(db)
 
 		item, _ := defaultExpirableStockItem(MEDICINE)
-		wh.CreateStock(item)
+		wh.CreateStock(item, nil)
 
-		wh.DeleteStock(item.ID())
+		wh.DeleteStock(item.ID(), nil)
 
 		read, ok := wh.ReadStock(item.ID())
 		if ok || read != nil {
@@ -0,0 +1,667 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook is a subscription of an external service to the warehouse's events
+type Webhook struct {
+	ID  string
+	URL string
+
+	// Secret is the key of the HMAC-SHA256 signature of the payloads
+	Secret string
+
+	// Events are the event types sent to the webhook, all events are sent if it is empty
+	Events []EventType
+	Active bool
+
+	Created time.Time
+}
+
+func (wh *Webhook) validate() error {
+	errs := ValidationErrors{}
+
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, ValidationError{"url", "the target must be an absolute http or https URL"})
+	}
+	for _, et := range wh.Events {
+		if !et.isValid() {
+			errs = append(errs, ValidationError{"events", fmt.Sprintf("unknown event type %s", et)})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (wh *Webhook) wants(et EventType) bool {
+	if !wh.Active {
+		return false
+	}
+	if len(wh.Events) == 0 {
+		return true
+	}
+	for _, t := range wh.Events {
+		if t == et {
+			return true
+		}
+	}
+	return false
+}
+
+type deliveryStatus string
+
+// PENDING deliveries are sent (again) at their next attempt time.
+// DELIVERED and FAILED deliveries are final.
+const (
+	PENDING   deliveryStatus = "pending"
+	DELIVERED deliveryStatus = "delivered"
+	FAILED    deliveryStatus = "failed"
+)
+
+// Delivery is the delivery of an event to a webhook
+type Delivery struct {
+	ID        string
+	WebhookID string
+	EventType EventType
+	Payload   string
+
+	Status   deliveryStatus
+	Attempts int
+	// ResponseCode and Error describe the last attempt
+	ResponseCode int
+	Error        string
+
+	Created     time.Time
+	NextAttempt time.Time
+}
+
+// maxDeliveryAttempts is the number of attempts after which a delivery fails
+const maxDeliveryAttempts = 8
+
+// deliveryBackoff returns the delay before the next attempt after the given number of failed attempts
+func deliveryBackoff(attempts int) time.Duration {
+	return 30 * time.Second << uint(attempts-1)
+}
+
+// WebhookManager manages the webhook subscriptions and delivers the events to them
+type WebhookManager interface {
+	// CreateWebhook(), UpdateWebhook() and DeleteWebhook() record the changes in the audit trail
+	// that is passed with them. A change fails and is rolled back if it cannot be recorded.
+	CreateWebhook(*Webhook, *auditTrail) error
+	ReadWebhook(string) (*Webhook, bool)
+	UpdateWebhook(*Webhook, *auditTrail) error
+	DeleteWebhook(string, *auditTrail) error
+	Webhooks() []*Webhook
+
+	// Deliveries() returns the latest deliveries to a webhook, the latest are first
+	Deliveries(string) []*Delivery
+
+	// Publish() queues the delivery of an event to the webhooks subscribed to it.
+	// It can be added as a listener of the warehouse.
+	Publish(Event)
+
+	// Start() starts delivering the queued events in the background, Stop() stops it
+	Start()
+	Stop()
+}
+
+type defaultWebhookManager struct {
+	database *sql.DB
+	client   *http.Client
+	backoff  func(int) time.Duration
+
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewWebhookManager creates a webhook manager that keeps the subscriptions and the delivery log
+// in sqlite3 tables inside the db that is passed as an argument
+func NewWebhookManager(db *sql.DB) WebhookManager {
+	m := &defaultWebhookManager{
+		database: db,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		backoff:  deliveryBackoff,
+		wake:     make(chan struct{}, 1),
+	}
+
+	m.initWebhooksTables()
+
+	return m
+}
+
+func (m *defaultWebhookManager) initWebhooksTables() {
+	webhooksTables := `
+	CREATE TABLE IF NOT EXISTS
+		webhooks (
+			id TEXT NOT NULL PRIMARY KEY,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL,
+			active BOOLEAN NOT NULL,
+			created DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS
+		webhook_deliveries (
+			id TEXT NOT NULL PRIMARY KEY,
+			webhook_id TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			response_code INTEGER NOT NULL,
+			error TEXT NOT NULL,
+			created DATETIME NOT NULL,
+			next_attempt DATETIME NOT NULL,
+			FOREIGN KEY (webhook_id) REFERENCES webhooks (id)
+	);
+	CREATE INDEX IF NOT EXISTS
+		webhook_deliveries_pending ON webhook_deliveries (status, next_attempt);
+	CREATE INDEX IF NOT EXISTS
+		webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, created);
+	`
+	_, err := m.database.Exec(webhooksTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func joinEventTypes(events []EventType) string {
+	s := make([]string, len(events))
+	for i, et := range events {
+		s[i] = string(et)
+	}
+	return strings.Join(s, ",")
+}
+
+func splitEventTypes(s string) []EventType {
+	events := make([]EventType, 0)
+	for _, et := range strings.Split(s, ",") {
+		if et != "" {
+			events = append(events, EventType(et))
+		}
+	}
+	return events
+}
+
+// newWebhookSecret generates a random secret for webhooks created without one
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (m *defaultWebhookManager) CreateWebhook(wh *Webhook, trail *auditTrail) error {
+	if err := wh.validate(); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	if wh.Secret == "" {
+		if wh.Secret, err = newWebhookSecret(); err != nil {
+			return err
+		}
+	}
+	wh.ID = id
+	wh.Created = time.Now().UTC()
+
+	tx, err := m.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			webhooks (
+				id,
+				url,
+				secret,
+				events,
+				active,
+				created)
+		VALUES(?, ?, ?, ?, ?, ?)
+	`, wh.ID, wh.URL, wh.Secret, joinEventTypes(wh.Events), wh.Active, wh.Created)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := trail.recordTx(tx, AUDIT_WEBHOOK, wh.ID, nil, newWebhookDTO(wh)); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+const webhookColumns = `
+	id,
+	url,
+	secret,
+	events,
+	active,
+	created
+`
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var (
+		wh     = &Webhook{}
+		events string
+	)
+	err := row.Scan(&wh.ID, &wh.URL, &wh.Secret, &events, &wh.Active, &wh.Created)
+	wh.Events = splitEventTypes(events)
+	return wh, err
+}
+
+func (m *defaultWebhookManager) ReadWebhook(id string) (*Webhook, bool) {
+	return readWebhook(m.database, id)
+}
+
+func readWebhook(q querier, id string) (*Webhook, bool) {
+	wh, err := scanWebhook(q.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, false
+	case err != nil:
+		panic(err)
+	}
+	return wh, true
+}
+
+// UpdateWebhook changes the URL, the event filter and the active flag of the webhook.
+// The secret is changed only if a new one is set.
+func (m *defaultWebhookManager) UpdateWebhook(wh *Webhook, trail *auditTrail) error {
+	if err := wh.validate(); err != nil {
+		return err
+	}
+
+	tx, err := m.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	before, ok := readWebhook(tx, wh.ID)
+	if !ok {
+		return errors.New("no such webhook")
+	}
+
+	_, err = tx.Exec(`
+		UPDATE
+			webhooks
+		SET
+			url = ?,
+			secret = CASE WHEN ? = '' THEN secret ELSE ? END,
+			events = ?,
+			active = ?
+		WHERE
+			id = ?
+	`, wh.URL, wh.Secret, wh.Secret, joinEventTypes(wh.Events), wh.Active, wh.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	after, _ := readWebhook(tx, wh.ID)
+	if err := trail.recordTx(tx, AUDIT_WEBHOOK, wh.ID, newWebhookDTO(before), newWebhookDTO(after)); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// DeleteWebhook removes the webhook and its delivery log
+func (m *defaultWebhookManager) DeleteWebhook(id string, trail *auditTrail) error {
+	tx, err := m.database.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	before, ok := readWebhook(tx, id)
+	if !ok {
+		return nil
+	}
+
+	for _, query := range []string{
+		`DELETE FROM webhook_deliveries WHERE webhook_id = ?`,
+		`DELETE FROM webhooks WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := trail.recordTx(tx, AUDIT_WEBHOOK, id, newWebhookDTO(before), nil); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+func (m *defaultWebhookManager) Webhooks() []*Webhook {
+	rows, err := m.database.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY created`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	webhooks := make([]*Webhook, 0)
+	for rows.Next() {
+		wh, err := scanWebhook(rows)
+		if err != nil {
+			panic(err)
+		}
+		webhooks = append(webhooks, wh)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return webhooks
+}
+
+const deliveryColumns = `
+	id,
+	webhook_id,
+	event_type,
+	payload,
+	status,
+	attempts,
+	response_code,
+	error,
+	created,
+	next_attempt
+`
+
+func (m *defaultWebhookManager) queryDeliveries(query string, args ...interface{}) []*Delivery {
+	rows, err := m.database.Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]*Delivery, 0)
+	for rows.Next() {
+		d := &Delivery{}
+		err = rows.Scan(
+			&d.ID,
+			&d.WebhookID,
+			&d.EventType,
+			&d.Payload,
+			&d.Status,
+			&d.Attempts,
+			&d.ResponseCode,
+			&d.Error,
+			&d.Created,
+			&d.NextAttempt)
+		if err != nil {
+			panic(err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	return deliveries
+}
+
+func (m *defaultWebhookManager) Deliveries(webhookID string) []*Delivery {
+	return m.queryDeliveries(`
+		SELECT `+deliveryColumns+`
+		FROM
+			webhook_deliveries
+		WHERE
+			webhook_id = ?
+		ORDER BY
+			created DESC, rowid DESC
+		LIMIT 100
+	`, webhookID)
+}
+
+// webhookPayload is the JSON body posted to the webhooks
+type webhookPayload struct {
+	ID   string      `json:"id"`
+	Type EventType   `json:"type"`
+	Time string      `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+func (m *defaultWebhookManager) Publish(e Event) {
+	for _, wh := range m.Webhooks() {
+		if !wh.wants(e.Type) {
+			continue
+		}
+
+		id, err := newUUID()
+		if err != nil {
+			log.Printf("Error in queueing webhook delivery: %s", err)
+			continue
+		}
+
+		payload, err := json.Marshal(&webhookPayload{
+			ID:   id,
+			Type: e.Type,
+			Time: e.Time.UTC().Format(dateLayout),
+			Data: e.Data,
+		})
+		if err != nil {
+			log.Printf("Error in marshaling webhook payload: %s", err)
+			continue
+		}
+
+		_, err = m.database.Exec(`
+			INSERT INTO
+				webhook_deliveries (
+					id,
+					webhook_id,
+					event_type,
+					payload,
+					status,
+					attempts,
+					response_code,
+					error,
+					created,
+					next_attempt)
+			VALUES(?, ?, ?, ?, ?, 0, 0, '', ?, ?)
+		`, id, wh.ID, e.Type, string(payload), PENDING, e.Time.UTC(), e.Time.UTC())
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// signature returns the value of the X-Madmin-Signature header of a payload
+func signature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts the payload of the delivery to the webhook and records the outcome of the attempt
+func (m *defaultWebhookManager) deliver(d *Delivery) {
+	var (
+		status       = PENDING
+		responseCode = 0
+		errMsg       = ""
+		now          = time.Now().UTC()
+	)
+
+	wh, ok := m.ReadWebhook(d.WebhookID)
+	if !ok {
+		return
+	}
+
+	err := func() error {
+		req, err := http.NewRequest("POST", wh.URL, bytes.NewReader([]byte(d.Payload)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Madmin-Event", string(d.EventType))
+		req.Header.Set("X-Madmin-Delivery", d.ID)
+		req.Header.Set("X-Madmin-Signature", signature(wh.Secret, []byte(d.Payload)))
+
+		resp, err := m.client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+
+		responseCode = resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unexpected response status %s", resp.Status)
+		}
+		return nil
+	}()
+
+	attempts := d.Attempts + 1
+	nextAttempt := now
+	switch {
+	case err == nil:
+		status = DELIVERED
+	case attempts >= maxDeliveryAttempts:
+		status = FAILED
+		errMsg = err.Error()
+	default:
+		errMsg = err.Error()
+		nextAttempt = now.Add(m.backoff(attempts))
+	}
+
+	_, err = m.database.Exec(`
+		UPDATE
+			webhook_deliveries
+		SET
+			status = ?,
+			attempts = ?,
+			response_code = ?,
+			error = ?,
+			next_attempt = ?
+		WHERE
+			id = ?
+	`, status, attempts, responseCode, errMsg, nextAttempt, d.ID)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// deliverPending makes an attempt for every pending delivery that is due
+// and returns the time of the earliest pending delivery that is not due yet
+func (m *defaultWebhookManager) deliverPending(now time.Time) time.Time {
+	due := m.queryDeliveries(`
+		SELECT `+deliveryColumns+`
+		FROM
+			webhook_deliveries
+		WHERE
+			status = ? AND next_attempt <= ?
+		ORDER BY
+			created, rowid
+	`, PENDING, now.UTC())
+	for _, d := range due {
+		m.deliver(d)
+	}
+
+	var next time.Time
+	err := m.database.QueryRow(`
+		SELECT
+			next_attempt
+		FROM
+			webhook_deliveries
+		WHERE
+			status = ?
+		ORDER BY
+			next_attempt
+		LIMIT 1
+	`, PENDING).Scan(&next)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	return next
+}
+
+// maxDeliveryWait is the longest time the delivery worker sleeps without checking the queue
+const maxDeliveryWait = time.Minute
+
+func (m *defaultWebhookManager) Start() {
+	if m.stop != nil {
+		return
+	}
+	m.stop = make(chan struct{})
+
+	m.wg.Add(1)
+	go func(stop chan struct{}) {
+		defer m.wg.Done()
+		for {
+			wait := maxDeliveryWait
+			if next := m.deliverPending(time.Now()); !next.IsZero() && time.Until(next) < wait {
+				wait = time.Until(next)
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-m.wake:
+				timer.Stop()
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}(m.stop)
+}
+
+// Stop stops the delivery worker and waits for the current attempts to finish
+func (m *defaultWebhookManager) Stop() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	m.stop = nil
+
+	m.wg.Wait()
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (m *madminHandler) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.listWebhooksHandler(w, r)
+	case "POST":
+		m.addWebhookHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+func (m *madminHandler) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.getWebhookHandler(w, r)
+	case "DELETE":
+		m.removeWebhookHandler(w, r)
+	case "PUT":
+		m.updateWebhookHandler(w, r)
+	default:
+		respondMethodNotAllowed(w, r)
+	}
+}
+
+// Handler for GET /webhooks/
+//
+// Lists the webhook subscriptions.
+func (m *madminHandler) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks := m.webhooks.Webhooks()
+
+	resp := &CollectionResponseDTO{"List of webhooks", make([]string, 0, len(webhooks))}
+	for _, wh := range webhooks {
+		resp.URLs = append(resp.URLs, fmt.Sprintf("/data/webhooks/%s", wh.ID))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// Handler for POST /webhooks/
+//
+// Adds a webhook subscription and returns it with its secret.
+// A random secret is generated if none is set.
+func (m *madminHandler) addWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	dto := &WebhookDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+
+	wh := dto.webhook()
+	err := m.webhooks.CreateWebhook(wh, m.auditTrail(r))
+	if err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	resp := newWebhookDTO(wh)
+	resp.Secret = wh.Secret
+	respondJSON(w, http.StatusCreated, resp)
+}
+
+// Handler for GET /webhooks/<id>
+//
+// Returns JSON with data for the webhook with the given id.
+func (m *madminHandler) getWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	wh, ok := m.webhooks.ReadWebhook(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newWebhookDTO(wh))
+}
+
+// Handler for PUT /webhooks/<id>
+//
+// Updates the webhook with <id>. The secret is kept if no new one is set.
+func (m *madminHandler) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	dto := &WebhookDTO{}
+	if !decodeJSONBody(w, r, dto) {
+		return
+	}
+	dto.ID = id
+
+	if _, ok := m.webhooks.ReadWebhook(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err := m.webhooks.UpdateWebhook(dto.webhook(), m.auditTrail(r))
+	if err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Handler for DELETE /webhooks/<id>
+//
+// Removes the webhook with <id> and its delivery log.
+func (m *madminHandler) removeWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := m.webhooks.DeleteWebhook(id, m.auditTrail(r)); err != nil {
+		respondChangeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for GET /webhooks/<id>/deliveries
+//
+// Lists the latest deliveries to the webhook with <id>, the latest are first.
+func (m *madminHandler) webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := m.webhooks.ReadWebhook(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	deliveries := m.webhooks.Deliveries(id)
+
+	resp := make([]*DeliveryDTO, 0, len(deliveries))
+	for _, d := range deliveries {
+		resp = append(resp, newDeliveryDTO(d))
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
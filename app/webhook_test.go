@@ -0,0 +1,93 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestWebhooks(t *testing.T) {
+	dbPath := "./test_database.sqlite"
+	db := newDB(dbPath)
+	defer cleanupDatabase(t, db, dbPath)
+
+	var (
+		mutex    sync.Mutex
+		received = make([]webhookPayload, 0)
+		failNext = true
+	)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Madmin-Signature") != signature("secret", body) {
+			t.Errorf(`Invalid signature of webhook payload`)
+		}
+		if failNext {
+			failNext = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		payload := webhookPayload{}
+		json.Unmarshal(body, &payload)
+		received = append(received, payload)
+	}))
+	defer target.Close()
+
+	var (
+		wh       = NewWarehouse(db)
+		webhooks = NewWebhookManager(db).(*defaultWebhookManager)
+	)
+	webhooks.backoff = func(int) time.Duration { return 0 }
+	wh.AddListener(webhooks.Publish)
+
+	if err := webhooks.CreateWebhook(&Webhook{URL: "ftp://example.com", Active: true}, nil); err == nil {
+		t.Fatalf(`CreateWebhook accepts a non-HTTP URL`)
+	}
+	if err := webhooks.CreateWebhook(&Webhook{URL: target.URL, Events: []EventType{"stock.eaten"}, Active: true}, nil); err == nil {
+		t.Fatalf(`CreateWebhook accepts an unknown event type`)
+	}
+	hook := &Webhook{URL: target.URL, Secret: "secret", Events: []EventType{STOCK_CREATED, STOCK_DISPENSED}, Active: true}
+	if err := webhooks.CreateWebhook(hook, nil); err != nil {
+		t.Fatalf(`CreateWebhook returns an error for a valid webhook: %s`, err)
+	}
+
+	item, _ := defaultUnexpirableStockItem(ACCESSORY)
+	item.SetQuantity(decimal.New(5, 0))
+	wh.CreateStock(item, nil)
+	wh.UpdateStock(item, "", nil)
+	wh.RecordMovement(&Movement{StockID: item.ID(), Kind: DISPENSE, Quantity: decimal.New(1, 0)})
+
+	deliveries := webhooks.Deliveries(hook.ID)
+	if len(deliveries) != 2 || deliveries[0].EventType != STOCK_DISPENSED || deliveries[1].EventType != STOCK_CREATED {
+		t.Fatalf(`Unexpected deliveries %+v`, deliveries)
+	}
+
+	// the first attempt fails and is retried
+	webhooks.deliverPending(time.Now())
+	webhooks.deliverPending(time.Now())
+
+	for _, d := range webhooks.Deliveries(hook.ID) {
+		if d.Status != DELIVERED {
+			t.Fatalf(`Delivery is not delivered: %+v`, d)
+		}
+		if d.EventType == STOCK_CREATED && d.Attempts != 2 {
+			t.Fatalf(`Expected 2 attempts, got %d`, d.Attempts)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	// the failed delivery is retried after the next one is delivered
+	if len(received) != 2 || received[0].Type != STOCK_DISPENSED || received[1].Type != STOCK_CREATED {
+		t.Fatalf(`Unexpected received payloads %+v`, received)
+	}
+}